@@ -0,0 +1,501 @@
+package image_archive_lambda
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"shared"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Archives larger than this are stored in S3 when ARCHIVE_INLINE_LIMIT is not
+// set. API Gateway caps responses at 10MB and the body grows by a third when
+// base64 encoded.
+const defaultInlineLimit = 4 * 1024 * 1024
+
+// Most images accepted in a single archive. Large archives are streamed to S3
+// in parts, so only one image is held in memory at a time.
+const maxArchiveImages = 500
+
+// How long the link to a stored archive stays valid
+const presignExpiry = time.Hour
+
+// ArchiveRequest is the structure of the request body. Either Names or Prefix
+// selects the images. Params transform every image as the same query
+// parameters would transform one in a GET, including presets and rotate=true.
+type ArchiveRequest struct {
+	Names  []string          `json:"names"`
+	Prefix string            `json:"prefix"`
+	Params map[string]string `json:"params"`
+}
+
+// ArchiveResponse is the body returned when the archive is too large to return directly.
+type ArchiveResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// GET parameters that pick out or describe a single image, which have no
+// meaning for an archive
+var unsupportedParams = map[string]bool{"name": true, "info": true, "rendition": true, "frame": true, shared.SignatureParam: true, shared.ExpiresParam: true}
+
+// The transformation applied to every image in an archive
+type archiveTransform struct {
+	// The preset asked for, if any, which only a caption may follow
+	preset *shared.Preset
+	// The fixed rotate and resize of rotate=true, which only a caption may follow
+	rotate   bool
+	pipeline shared.Pipeline
+	options  shared.EncodeOptions
+	// Whether images must be encoded again even with nothing to apply
+	reencode bool
+}
+
+var s3Client shared.S3ObjectAPI
+var presignClient shared.S3PresignAPI
+var multipartClient shared.S3MultipartAPI
+var watermarks shared.WatermarkPolicy
+var presets shared.Presets
+var quality shared.QualityPolicy
+var metadataPolicies shared.MetadataPolicies
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+	presignClient, err = shared.NewS3PresignClient()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 presign client: %v", err)
+	}
+	multipartClient, err = shared.NewS3MultipartClient()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 multipart client: %v", err)
+	}
+	watermarks, err = shared.ParseWatermarkPolicy(os.Getenv("MANDATORY_WATERMARKS"))
+	if err != nil {
+		log.Fatalf("Invalid MANDATORY_WATERMARKS: %v", err)
+	}
+	presets, err = shared.LoadPresets(os.Getenv("PRESETS_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load presets: %v", err)
+	}
+	quality, err = shared.LoadQualityPolicy()
+	if err != nil {
+		log.Fatalf("Invalid JPEG quality settings: %v", err)
+	}
+	metadataPolicies, err = shared.ParseMetadataPolicies(os.Getenv("METADATA_POLICIES"))
+	if err != nil {
+		log.Fatalf("Invalid METADATA_POLICIES: %v", err)
+	}
+}
+
+func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var archiveRequest ArchiveRequest
+	if err := json.Unmarshal([]byte(request.Body), &archiveRequest); err != nil {
+		log.Printf("Error unmarshaling request body: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body"}`,
+		}, nil
+	}
+
+	// Exactly one of names or prefix must be given
+	if (len(archiveRequest.Names) == 0) == (archiveRequest.Prefix == "") {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body structure"}`,
+		}, nil
+	}
+
+	for param := range archiveRequest.Params {
+		if unsupportedParams[param] {
+			body, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Unsupported archive parameter %s", param)})
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       string(body),
+			}, nil
+		}
+	}
+
+	// A request body can't be signed, so callers who need a signed URL to
+	// transform an image get archives of the images as stored, and callers
+	// restricted to presets may only ask for one
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+	if len(archiveRequest.Params) > 0 && shared.TenantListed("SIGNED_URLS_ONLY_TENANTS", tenant) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 403,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Transformations are not allowed in archives"}`,
+		}, nil
+	}
+	if shared.TenantListed("PRESETS_ONLY_TENANTS", tenant) {
+		for param := range archiveRequest.Params {
+			if param != "preset" {
+				return events.APIGatewayProxyResponse{
+					StatusCode: 403,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       fmt.Sprintf(`{"message": "Parameter %s is not allowed, use a preset"}`, param),
+				}, nil
+			}
+		}
+	}
+
+	// Check the transformation before fetching anything
+	transform, err := parseTransform(archiveRequest.Params)
+	if err != nil {
+		return invalidTransformation(err), nil
+	}
+
+	names := archiveRequest.Names
+	if archiveRequest.Prefix != "" {
+		names, err = shared.ListImageNames(context.TODO(), s3Client, os.Getenv("S3_BUCKET_NAME"), archiveRequest.Prefix)
+		if err != nil {
+			log.Printf("Error listing images in S3: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to list objects in S3"}`,
+			}, err
+		}
+		if len(names) == 0 {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       fmt.Sprintf(`{"message": "No images found with prefix %s"}`, archiveRequest.Prefix),
+			}, nil
+		}
+	}
+
+	if len(names) > maxArchiveImages {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 413,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Archive contains more than %d images"}`, maxArchiveImages),
+		}, nil
+	}
+
+	// The archive is written out as it is built, so only one image is held in memory at a time
+	sink := &archiveSink{ctx: context.TODO(), limit: inlineLimit()}
+	err = buildArchive(context.TODO(), s3Client, sink, names, transform, tenant)
+	if err == nil {
+		err = sink.Close()
+	}
+	if err != nil {
+		sink.Abort()
+	}
+	var notFound *imageNotFoundError
+	if errors.Is(err, shared.ErrCropOutsideImage) {
+		return invalidTransformation(err), nil
+	}
+	if errors.As(err, &notFound) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Image with name %s not found in S3"}`, notFound.name),
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Error building archive: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to build archive"}`,
+		}, err
+	}
+
+	if sink.upload == nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers: map[string]string{
+				"Content-Type":        "application/zip",
+				"Content-Disposition": `attachment; filename="images.zip"`,
+			},
+			Body:            base64.StdEncoding.EncodeToString(sink.buf.Bytes()),
+			IsBase64Encoded: true,
+		}, nil
+	}
+
+	// Too large to return through API Gateway, so it was stored and a link is handed back instead
+	archiveResponse, err := presignArchive(context.TODO(), presignClient, sink.key)
+	if err != nil {
+		log.Printf("Error presigning archive link: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to store archive in S3"}`,
+		}, err
+	}
+
+	body, _ := json.Marshal(archiveResponse)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Reports which of the requested images is missing
+type imageNotFoundError struct {
+	name string
+}
+
+func (e *imageNotFoundError) Error() string {
+	return fmt.Sprintf("image %s not found", e.name)
+}
+
+// Work out the transformation asked for by the params, as a GET would from its
+// query parameters
+func parseTransform(params map[string]string) (archiveTransform, error) {
+	options, err := quality.OptionsFromParams(params)
+	if err != nil {
+		return archiveTransform{}, err
+	}
+	transform := archiveTransform{options: presets.RequestOptions(params, options)}
+	_, hasQuality := params["quality"]
+	_, hasMaxBytes := params["maxBytes"]
+	transform.reencode = hasQuality || hasMaxBytes
+
+	transform.pipeline, err = shared.QueryPipeline(params)
+	if err != nil {
+		return archiveTransform{}, err
+	}
+
+	if name, ok := params["preset"]; ok {
+		preset, ok := presets[name]
+		if !ok {
+			return archiveTransform{}, fmt.Errorf("unknown preset %q", name)
+		}
+		transform.preset = &preset
+	}
+	transform.rotate = params["rotate"] == "true"
+
+	return transform, nil
+}
+
+// Fetch and optionally transform each image, writing them into a ZIP archive.
+// Names under internal prefixes are treated as missing, and the tenant's
+// mandatory watermark and metadata policy are applied to every image, as they
+// would be to the image on its own.
+func buildArchive(ctx context.Context, s3Client shared.S3ObjectAPI, sink io.Writer, names []string, transform archiveTransform, tenant string) error {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	var watermark shared.Pipeline
+	if step, ok := watermarks.For(tenant); ok {
+		var err error
+		watermark, err = shared.LoadWatermarkLogos(ctx, s3Client, bucketName, shared.Pipeline{step})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Stored images keep what the uploader's policy allowed, which the reader's may narrow
+	policy := metadataPolicies.For(tenant)
+
+	writer := zip.NewWriter(sink)
+	entries := map[string]bool{}
+
+	for _, name := range names {
+		if shared.IsHiddenName(name) {
+			return &imageNotFoundError{name: name}
+		}
+
+		output, err := shared.GetImageObject(ctx, s3Client, bucketName, name)
+		if shared.IsNotFound(err) {
+			return &imageNotFoundError{name: name}
+		}
+		if err != nil {
+			return err
+		}
+
+		body, err := io.ReadAll(output.Body)
+		output.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		body, err = transformImage(ctx, s3Client, name, shared.StripMetadata(body, policy), transform, watermark)
+		if err != nil {
+			return err
+		}
+
+		// Images are already compressed, so store them as they are
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: entryName(name, entries), Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(body); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// The name to store an image under in the archive. S3 keys may hold "..",
+// leading slashes or backslashes, which would let an entry land outside the
+// folder it is extracted to, so the name is made relative. Names that end up
+// the same are told apart with a "-N" suffix.
+func entryName(name string, entries map[string]bool) string {
+	clean := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+	if clean == "" {
+		clean = "image"
+	}
+
+	entry := clean
+	ext := path.Ext(clean)
+	for i := 1; entries[entry]; i++ {
+		entry = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(clean, ext), i, ext)
+	}
+	entries[entry] = true
+
+	return entry
+}
+
+// Apply the archive's transformation and then the watermark to one image. The
+// reader's metadata policy has already been applied, so what is left of the
+// original's metadata is all carried over.
+func transformImage(ctx context.Context, s3Client shared.S3ObjectAPI, name string, body []byte, transform archiveTransform, watermark shared.Pipeline) ([]byte, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+	pipeline := transform.pipeline
+	original := body
+
+	if transform.preset != nil {
+		steps := transform.preset.Pipeline
+		pipeline = append(steps[:len(steps):len(steps)], pipeline...)
+		var err error
+		if pipeline, err = shared.FocusPipeline(ctx, s3Client, bucketName, name, pipeline); err != nil {
+			return nil, err
+		}
+		if pipeline, err = shared.LoadWatermarkLogos(ctx, s3Client, bucketName, pipeline); err != nil {
+			return nil, err
+		}
+	}
+
+	// Everything is applied before the image is encoded, once
+	pipeline = append(pipeline[:len(pipeline):len(pipeline)], watermark...)
+	var err error
+	switch {
+	case transform.rotate:
+		body, err = shared.RotateAndResizeWith(body, pipeline, transform.options)
+	case len(pipeline) > 0 || transform.reencode:
+		body, err = shared.ApplyPipeline(body, pipeline, transform.options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if transform.rotate || len(pipeline) > 0 || transform.reencode {
+		body = shared.CopyMetadata(body, original, shared.MetadataPreserve)
+	}
+
+	return body, nil
+}
+
+// Reject a request whose transformation parameters don't make sense
+func invalidTransformation(err error) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{"message": "Invalid transformation: " + err.Error()})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
+
+// Receives the archive as it is written. It is held in memory while it is
+// small enough to return inline, then streamed to S3 once it grows past that.
+type archiveSink struct {
+	ctx    context.Context
+	limit  int
+	buf    bytes.Buffer
+	key    string
+	upload *shared.MultipartWriter
+}
+
+func (s *archiveSink) Write(p []byte) (int, error) {
+	if s.upload == nil && s.buf.Len()+len(p) <= s.limit {
+		return s.buf.Write(p)
+	}
+
+	if s.upload == nil {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return 0, err
+		}
+		key := shared.ArchiveKey(hex.EncodeToString(id))
+
+		upload, err := shared.NewMultipartWriter(s.ctx, multipartClient, os.Getenv("S3_BUCKET_NAME"), key, "application/zip")
+		if err != nil {
+			return 0, err
+		}
+		s.key, s.upload = key, upload
+		if _, err := s.upload.Write(s.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		s.buf = bytes.Buffer{}
+	}
+
+	return s.upload.Write(p)
+}
+
+// Finish the stored archive, if it grew too large to return inline
+func (s *archiveSink) Close() error {
+	if s.upload == nil {
+		return nil
+	}
+
+	return s.upload.Close()
+}
+
+// Discard whatever was streamed to S3 of an archive that failed
+func (s *archiveSink) Abort() {
+	if s.upload == nil {
+		return
+	}
+	if err := s.upload.Abort(); err != nil {
+		log.Printf("Error aborting archive upload: %v", err)
+	}
+}
+
+// Presign a link to download a stored archive
+func presignArchive(ctx context.Context, presignClient shared.S3PresignAPI, key string) (*ArchiveResponse, error) {
+	presigned, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(presignExpiry))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveResponse{URL: presigned.URL, ExpiresAt: time.Now().Add(presignExpiry).UTC()}, nil
+}
+
+// Read the ARCHIVE_INLINE_LIMIT size in bytes
+func inlineLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("ARCHIVE_INLINE_LIMIT"))
+	if err != nil || limit < 0 {
+		return defaultInlineLimit
+	}
+
+	return limit
+}
@@ -0,0 +1,371 @@
+package image_archive_lambda
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"shared"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type mockPresignAPI func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+
+// PresignGetObject implements shared.S3PresignAPI.
+func (m mockPresignAPI) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return m(ctx, params, optFns...)
+}
+
+func newArchiveClient(t *testing.T) *shared.MockS3Client {
+	client := shared.NewMockS3Client()
+	for _, name := range []string{"shoot/a.jpg", "shoot/b.jpg", "other/c.jpg"} {
+		client.Objects[name] = &shared.MockS3Object{Body: shared.GenerateJPG(t), ContentType: "image/jpeg"}
+	}
+	client.Objects[shared.RecordKey("shoot/a.jpg")] = &shared.MockS3Object{Body: []byte("{}")}
+
+	return client
+}
+
+// Read the names and contents of the entries in a ZIP archive
+func readArchive(t *testing.T, archive []byte) map[string][]byte {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string][]byte{}
+	for _, file := range reader.File {
+		entry, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[file.Name], _ = io.ReadAll(entry)
+		entry.Close()
+	}
+
+	return entries
+}
+
+func TestHandleRequest(t *testing.T) {
+	rotated, _ := shared.RotateAndResize(shared.GenerateJPG(t), shared.EncodeOptions{})
+	resized, _ := shared.ApplyPipeline(shared.GenerateJPG(t), shared.Pipeline{{Op: "resize", Params: map[string]string{"width": "100"}}}, shared.EncodeOptions{})
+	turned, _ := shared.ApplyPipeline(shared.GenerateJPG(t), shared.Pipeline{{Op: "rotate", Params: map[string]string{"angle": "90"}}}, shared.EncodeOptions{})
+	avatar, _ := shared.ApplyPipeline(shared.GenerateJPG(t), presets["avatar"].Pipeline, presets.RequestOptions(map[string]string{"preset": "avatar"}, shared.EncodeOptions{}))
+
+	tests := []struct {
+		name           string
+		request        any
+		expectStatus   int
+		expectEntries  map[string][]byte
+		expectResponse string
+	}{
+		{
+			name:         "Archive by names",
+			request:      ArchiveRequest{Names: []string{"shoot/a.jpg", "other/c.jpg"}},
+			expectStatus: 200,
+			expectEntries: map[string][]byte{
+				"shoot/a.jpg": shared.GenerateJPG(t),
+				"other/c.jpg": shared.GenerateJPG(t),
+			},
+		},
+		{
+			name:         "Archive by prefix with rotate",
+			request:      ArchiveRequest{Prefix: "shoot/", Params: map[string]string{"rotate": "true"}},
+			expectStatus: 200,
+			expectEntries: map[string][]byte{
+				"shoot/a.jpg": rotated,
+				"shoot/b.jpg": rotated,
+			},
+		},
+		{
+			name:          "Resize",
+			request:       ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"width": "100"}},
+			expectStatus:  200,
+			expectEntries: map[string][]byte{"shoot/a.jpg": resized},
+		},
+		{
+			name:          "Rotate by angle",
+			request:       ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"rotate": "90"}},
+			expectStatus:  200,
+			expectEntries: map[string][]byte{"shoot/a.jpg": turned},
+		},
+		{
+			name:          "Preset",
+			request:       ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"preset": "avatar"}},
+			expectStatus:  200,
+			expectEntries: map[string][]byte{"shoot/a.jpg": avatar},
+		},
+		{
+			name:           "Unknown preset",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"preset": "poster"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: unknown preset \"poster\""}`,
+		},
+		{
+			name:           "Preset with other transformations",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"preset": "avatar", "width": "10"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: preset can only be combined with a caption"}`,
+		},
+		{
+			name:           "Invalid transformation",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"blur": "NaN"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: step 0: blur: amount must be a number between 0.1 and 50"}`,
+		},
+		{
+			name:           "Single image parameter",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: map[string]string{"rendition": "thumb"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Unsupported archive parameter rendition"}`,
+		},
+		{
+			name:           "Unknown name",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg", "missing.jpg"}},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name missing.jpg not found in S3"}`,
+		},
+		{
+			name:           "Internal object",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg", shared.RecordKey("shoot/a.jpg")}},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name ` + shared.RecordKey("shoot/a.jpg") + ` not found in S3"}`,
+		},
+		{
+			name:           "Empty prefix",
+			request:        ArchiveRequest{Prefix: "missing/"},
+			expectStatus:   404,
+			expectResponse: `{"message": "No images found with prefix missing/"}`,
+		},
+		{
+			name:           "Names and prefix",
+			request:        ArchiveRequest{Names: []string{"shoot/a.jpg"}, Prefix: "shoot/"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body structure"}`,
+		},
+		{
+			name:           "Invalid request body",
+			request:        "Invalid",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s3Client = newArchiveClient(t)
+
+			bodyJSON, _ := json.Marshal(tc.request)
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if tc.expectEntries != nil {
+				archive, err := base64.StdEncoding.DecodeString(response.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries := readArchive(t, archive)
+				if len(entries) != len(tc.expectEntries) {
+					t.Errorf("Expected %d entries, got: %d", len(tc.expectEntries), len(entries))
+				}
+				for name, expected := range tc.expectEntries {
+					if !bytes.Equal(entries[name], expected) {
+						t.Errorf("Unexpected contents for entry %s", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveTransformPolicy(t *testing.T) {
+	s3Client = newArchiveClient(t)
+	watermarks = nil
+
+	testCases := []struct {
+		name          string
+		presetsOnly   string
+		requireSigned string
+		tenant        string
+		params        map[string]string
+		expectStatus  int
+	}{
+		{name: "Allowed", presetsOnly: "public", tenant: "acme", params: map[string]string{"rotate": "true"}, expectStatus: 200},
+		{name: "PresetsOnlyTenant", presetsOnly: "public", params: map[string]string{"rotate": "true"}, expectStatus: 403},
+		{name: "PresetsOnlyWithoutParams", presetsOnly: "public", expectStatus: 200},
+		{name: "PresetsOnlyWithPreset", presetsOnly: "public", params: map[string]string{"preset": "avatar"}, expectStatus: 200},
+		{name: "SignedURLsOnlyWithPreset", requireSigned: "acme", tenant: "acme", params: map[string]string{"preset": "avatar"}, expectStatus: 403},
+		{name: "SignedURLsOnlyTenant", requireSigned: "acme", tenant: "acme", params: map[string]string{"rotate": "true"}, expectStatus: 403},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PRESETS_ONLY_TENANTS", tc.presetsOnly)
+			t.Setenv("SIGNED_URLS_ONLY_TENANTS", tc.requireSigned)
+
+			bodyJSON, _ := json.Marshal(ArchiveRequest{Names: []string{"shoot/a.jpg"}, Params: tc.params})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				Body:           string(bodyJSON),
+			})
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d %s", tc.expectStatus, response.StatusCode, response.Body)
+			}
+		})
+	}
+}
+
+func TestArchiveMetadataPolicy(t *testing.T) {
+	client := shared.NewMockS3Client()
+	client.Objects["photo.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPGWithMetadata(t), ContentType: "image/jpeg"}
+	s3Client = client
+
+	metadataPolicies = shared.MetadataPolicies{"default": shared.MetadataStripGPS, "archive": shared.MetadataPreserve}
+	defer func() { metadataPolicies = nil }()
+
+	testCases := []struct {
+		name      string
+		tenant    string
+		params    map[string]string
+		expectGPS bool
+	}{
+		{name: "OriginalNarrowed", tenant: "other"},
+		{name: "OriginalPreserved", tenant: "archive", expectGPS: true},
+		{name: "DerivativeNarrowed", tenant: "other", params: map[string]string{"width": "32"}},
+		{name: "DerivativePreserved", tenant: "archive", params: map[string]string{"width": "32"}, expectGPS: true},
+		{name: "Rotated", tenant: "archive", params: map[string]string{"rotate": "true"}, expectGPS: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bodyJSON, _ := json.Marshal(ArchiveRequest{Names: []string{"photo.jpg"}, Params: tc.params})
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				Body:           string(bodyJSON),
+			})
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("Expected 200, got: %d %s %v", response.StatusCode, response.Body, err)
+			}
+
+			archive, _ := base64.StdEncoding.DecodeString(response.Body)
+			metadata := shared.ReadMetadata(readArchive(t, archive)["photo.jpg"])
+			if metadata.EXIF == nil {
+				t.Fatal("Expected the EXIF data to be kept")
+			}
+			if (metadata.EXIF.GPS != nil) != tc.expectGPS {
+				t.Errorf("Expected GPS %v, got: %+v", tc.expectGPS, metadata.EXIF)
+			}
+		})
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	entries := map[string]bool{}
+
+	testCases := []struct {
+		name        string
+		expectEntry string
+	}{
+		{name: "shoot/a.jpg", expectEntry: "shoot/a.jpg"},
+		{name: "../../etc/cron.d/job.jpg", expectEntry: "etc/cron.d/job.jpg"},
+		{name: "/abs/b.jpg", expectEntry: "abs/b.jpg"},
+		{name: `..\..\win\c.jpg`, expectEntry: "win/c.jpg"},
+		{name: "shoot/../shoot/a.jpg", expectEntry: "shoot/a-1.jpg"},
+		{name: "..", expectEntry: "image"},
+	}
+
+	for _, tc := range testCases {
+		if entry := entryName(tc.name, entries); entry != tc.expectEntry {
+			t.Errorf("Expected %s to be stored as %s, got: %s", tc.name, tc.expectEntry, entry)
+		}
+	}
+}
+
+func TestLargeArchiveIsStored(t *testing.T) {
+	t.Setenv("ARCHIVE_INLINE_LIMIT", "1024")
+
+	client := newArchiveClient(t)
+	s3Client = client
+	multipartClient = client
+
+	var presignedKey string
+	presignClient = mockPresignAPI(func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+		presignedKey = aws.ToString(params.Key)
+		return &v4.PresignedHTTPRequest{URL: "https://example.com/" + presignedKey}, nil
+	})
+
+	bodyJSON, _ := json.Marshal(ArchiveRequest{Prefix: "shoot/"})
+	response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+	if err != nil {
+		t.Errorf("Handler returned an error: %v", err)
+	}
+
+	var archiveResponse ArchiveResponse
+	if err := json.Unmarshal([]byte(response.Body), &archiveResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(presignedKey, "archives/") || archiveResponse.URL != "https://example.com/"+presignedKey {
+		t.Errorf("Unexpected archive link %s for key %s", archiveResponse.URL, presignedKey)
+	}
+
+	stored, ok := client.Objects[presignedKey]
+	if !ok {
+		t.Fatalf("Expected archive to be stored at %s", presignedKey)
+	}
+	if entries := readArchive(t, stored.Body); len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got: %d", len(entries))
+	}
+	if len(client.Uploads) != 0 {
+		t.Errorf("Expected the upload to be completed, got: %d open", len(client.Uploads))
+	}
+}
+
+func TestMandatoryWatermark(t *testing.T) {
+	s3Client = newArchiveClient(t)
+
+	var err error
+	watermarks, err = shared.ParseWatermarkPolicy(`{"partner": {"text": "PROOF", "color": "ff0000", "opacity": "1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watermarks = nil }()
+
+	for tenant, expectWatermark := range map[string]bool{"partner": true, "internal": false} {
+		t.Run(tenant, func(t *testing.T) {
+			bodyJSON, _ := json.Marshal(ArchiveRequest{Names: []string{"shoot/a.jpg"}})
+			request := events.APIGatewayProxyRequest{
+				Body:           string(bodyJSON),
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tenant}},
+			}
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("Expected status code 200, got: %d (%v)", response.StatusCode, err)
+			}
+
+			archive, _ := base64.StdEncoding.DecodeString(response.Body)
+			entry := readArchive(t, archive)["shoot/a.jpg"]
+			if watermarked := !bytes.Equal(entry, shared.GenerateJPG(t)); watermarked != expectWatermark {
+				t.Errorf("Expected watermarked: %v, got: %v", expectWatermark, watermarked)
+			}
+		})
+	}
+}
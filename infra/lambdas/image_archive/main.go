@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_archive/image_archive_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_archive_lambda.HandleRequest)
+}
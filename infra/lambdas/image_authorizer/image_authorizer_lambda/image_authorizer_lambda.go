@@ -0,0 +1,54 @@
+package image_authorizer_lambda
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"shared"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var tenantKeys shared.TenantKeys
+
+func init() {
+	var err error
+	tenantKeys, err = shared.ParseTenantKeys(os.Getenv("TENANT_API_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid TENANT_API_KEYS: %v", err)
+	}
+}
+
+// HandleRequest works out the tenant behind a request from the API key in
+// its "Authorization: Bearer" header and hands it to the handlers as the
+// tenantId in the request context. Requests without a key belong to the
+// anonymous tenant, and requests with a key we don't know are refused.
+func HandleRequest(ctx context.Context, request events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	tenant := shared.AnonymousTenant
+
+	if authorization, ok := shared.HeaderValue(request.Headers, "Authorization"); ok {
+		key, isBearer := strings.CutPrefix(authorization, "Bearer ")
+		owner, known := tenantKeys.Tenant(strings.TrimSpace(key))
+		if !isBearer || !known {
+			log.Printf("Refusing request to %s with an unknown API key", request.MethodArn)
+			// API Gateway answers 401 for exactly this error
+			return events.APIGatewayCustomAuthorizerResponse{}, errors.New("Unauthorized")
+		}
+		tenant = owner
+	}
+
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: tenant,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{{
+				Action:   []string{"execute-api:Invoke"},
+				Effect:   "Allow",
+				Resource: []string{request.MethodArn},
+			}},
+		},
+		Context: map[string]interface{}{"tenantId": tenant},
+	}, nil
+}
@@ -0,0 +1,59 @@
+package image_authorizer_lambda
+
+import (
+	"context"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHandleRequest(t *testing.T) {
+	var err error
+	// The SHA-256 hash of "secret-key"
+	tenantKeys, err = shared.ParseTenantKeys(`{"acme": "85dbe15d75ef9308c7ae0f33c7a324cc6f4bf519a2ed2f3027bd33c140a4f9aa"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		headers      map[string]string
+		expectTenant string
+		expectError  bool
+	}{
+		{name: "Key", headers: map[string]string{"Authorization": "Bearer secret-key"}, expectTenant: "acme"},
+		{name: "LowerCaseHeader", headers: map[string]string{"authorization": "Bearer secret-key"}, expectTenant: "acme"},
+		{name: "NoKey", headers: map[string]string{}, expectTenant: shared.AnonymousTenant},
+		// Callers can't pick their tenant any more
+		{name: "TenantHeader", headers: map[string]string{"X-Tenant-Id": "acme"}, expectTenant: shared.AnonymousTenant},
+		{name: "UnknownKey", headers: map[string]string{"Authorization": "Bearer other-key"}, expectError: true},
+		{name: "NotBearer", headers: map[string]string{"Authorization": "Basic secret-key"}, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := HandleRequest(context.TODO(), events.APIGatewayCustomAuthorizerRequestTypeRequest{
+				MethodArn: "arn:aws:execute-api:eu-west-2:123456789012:api/dev/GET/images",
+				Headers:   tc.headers,
+			})
+			if tc.expectError {
+				if err == nil || err.Error() != "Unauthorized" {
+					t.Errorf("Expected Unauthorized, got: %+v %v", response, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tenant := shared.Tenant(response.Context); tenant != tc.expectTenant {
+				t.Errorf("Expected tenant %s, got: %s", tc.expectTenant, tenant)
+			}
+			statement := response.PolicyDocument.Statement
+			if len(statement) != 1 || statement[0].Effect != "Allow" || statement[0].Resource[0] != "arn:aws:execute-api:eu-west-2:123456789012:api/dev/GET/images" {
+				t.Errorf("Expected the method to be allowed, got: %+v", response.PolicyDocument)
+			}
+		})
+	}
+}
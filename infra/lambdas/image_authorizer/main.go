@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_authorizer/image_authorizer_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_authorizer_lambda.HandleRequest)
+}
@@ -0,0 +1,99 @@
+package image_get_lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestGetAnimation(t *testing.T) {
+	client := shared.NewMockS3Client()
+	client.Objects["reaction.gif"] = &shared.MockS3Object{Body: shared.GenerateAnimatedGIF(t), ContentType: "image/gif"}
+	s3Client = client
+
+	testCases := []struct {
+		name              string
+		params            map[string]string
+		expectStatus      int
+		expectContentType string
+		expectAnimated    bool
+	}{
+		{
+			name:              "Original",
+			params:            map[string]string{"name": "reaction.gif"},
+			expectStatus:      200,
+			expectContentType: "image/gif",
+			expectAnimated:    true,
+		},
+		{
+			name:              "Resized",
+			params:            map[string]string{"name": "reaction.gif", "width": "20"},
+			expectStatus:      200,
+			expectContentType: "image/gif",
+			expectAnimated:    true,
+		},
+		{
+			name:              "Preset",
+			params:            map[string]string{"name": "reaction.gif", "preset": "avatar"},
+			expectStatus:      200,
+			expectContentType: "image/gif",
+			expectAnimated:    true,
+		},
+		{
+			name:              "Poster",
+			params:            map[string]string{"name": "reaction.gif", "frame": "1"},
+			expectStatus:      200,
+			expectContentType: "image/jpeg",
+		},
+		{
+			name:              "ResizedPoster",
+			params:            map[string]string{"name": "reaction.gif", "frame": "2", "width": "20"},
+			expectStatus:      200,
+			expectContentType: "image/jpeg",
+		},
+		{
+			name:              "Quality",
+			params:            map[string]string{"name": "reaction.gif", "width": "20", "quality": "50"},
+			expectStatus:      400,
+			expectContentType: "application/json",
+		},
+		{
+			name:              "PosterQuality",
+			params:            map[string]string{"name": "reaction.gif", "frame": "1", "quality": "50"},
+			expectStatus:      200,
+			expectContentType: "image/jpeg",
+		},
+		{
+			name:              "FrameOutOfRange",
+			params:            map[string]string{"name": "reaction.gif", "frame": "3"},
+			expectStatus:      400,
+			expectContentType: "application/json",
+		},
+		{
+			name:              "FrameNotANumber",
+			params:            map[string]string{"name": "reaction.gif", "frame": "last"},
+			expectStatus:      400,
+			expectContentType: "application/json",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{QueryStringParameters: tc.params})
+			if response.StatusCode != tc.expectStatus || response.Headers["Content-Type"] != tc.expectContentType {
+				t.Fatalf("Expected %d %s, got: %d %s %s", tc.expectStatus, tc.expectContentType, response.StatusCode, response.Headers["Content-Type"], response.Body)
+			}
+			if tc.expectStatus != 200 {
+				return
+			}
+
+			body, _ := base64.StdEncoding.DecodeString(response.Body)
+			if animated := shared.IsAnimated(body); animated != tc.expectAnimated {
+				t.Errorf("Expected animated %v, got %v", tc.expectAnimated, animated)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package image_get_lambda
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"net/http"
 	"os"
 	"shared"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -22,7 +25,14 @@ type RequestBody struct {
 	Name string `json:"name"`
 }
 
+// Query parameters callers restricted to presets may still use
+var presetParams = map[string]bool{"name": true, "preset": true, "rendition": true, "frame": true, "info": true}
+
 var s3Client shared.S3ObjectAPI
+var presets shared.Presets
+var quality shared.QualityPolicy
+var watermarks shared.WatermarkPolicy
+var metadataPolicies shared.MetadataPolicies
 
 func init() {
 	var err error
@@ -30,8 +40,25 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to initialize S3 client: %v", err)
 	}
+	presets, err = shared.LoadPresets(os.Getenv("PRESETS_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load presets: %v", err)
+	}
+	quality, err = shared.LoadQualityPolicy()
+	if err != nil {
+		log.Fatalf("Invalid JPEG quality settings: %v", err)
+	}
+	watermarks, err = shared.ParseWatermarkPolicy(os.Getenv("MANDATORY_WATERMARKS"))
+	if err != nil {
+		log.Fatalf("Invalid MANDATORY_WATERMARKS: %v", err)
+	}
+	metadataPolicies, err = shared.ParseMetadataPolicies(os.Getenv("METADATA_POLICIES"))
+	if err != nil {
+		log.Fatalf("Invalid METADATA_POLICIES: %v", err)
+	}
 }
 
+// Serve the image, transformed as the query parameters ask
 func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Get the 'name' parameter from the URL path
 	name := request.QueryStringParameters["name"]
@@ -44,6 +71,74 @@ func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		}, nil
 	}
 
+	// Records, webhook secrets and other internal objects share the bucket but are never images
+	if shared.IsHiddenName(name) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Image with name %s not found in S3"}`, name),
+		}, nil
+	}
+
+	// A signed URL must be used exactly as it was signed, and before it expires
+	signed, err := checkSignedURL(request.QueryStringParameters, shared.Tenant(request.RequestContext.Authorizer))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 403,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Signed URL rejected: %v"}`, err),
+		}, nil
+	}
+
+	// Some tenants may only ask for presets, not arbitrary transformations,
+	// unless we signed the URL for them
+	if !signed && shared.TenantListed("PRESETS_ONLY_TENANTS", shared.Tenant(request.RequestContext.Authorizer)) {
+		for param := range request.QueryStringParameters {
+			if !presetParams[param] {
+				return events.APIGatewayProxyResponse{
+					StatusCode: 403,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       fmt.Sprintf(`{"message": "Parameter %s is not allowed, use a preset"}`, param),
+				}, nil
+			}
+		}
+	}
+
+	// Stored images keep what the uploader's policy allowed, which the reader's may narrow
+	policy := metadataPolicies.For(shared.Tenant(request.RequestContext.Authorizer))
+
+	// The image's size, colours and metadata may be asked for instead of the image itself
+	if infoRequested(request.QueryStringParameters) {
+		return getImageInfo(context.TODO(), s3Client, name, policy)
+	}
+
+	// Quality and size budget apply to whatever image is encoded for this request
+	options, err := requestOptions(request.QueryStringParameters)
+	if err != nil {
+		return invalidTransformation(err), nil
+	}
+
+	// Transformations picked per request
+	pipeline, err := shared.QueryPipeline(request.QueryStringParameters)
+	if err != nil {
+		return invalidTransformation(err), nil
+	}
+	caption := shared.CaptionPipeline(request.QueryStringParameters)
+
+	// Some tenants must only ever be sent watermarked images, whatever they asked for.
+	// The watermark is the last step of whichever pipeline produces the image, so
+	// the image is still encoded only once.
+	watermark, err := mandatoryWatermark(context.TODO(), shared.Tenant(request.RequestContext.Authorizer))
+	if err != nil {
+		log.Printf("Error loading watermark: %v", err)
+		return watermarkFailed(), err
+	}
+
+	// Renditions are generated at upload, so they are served as stored
+	if rendition, ok := request.QueryStringParameters["rendition"]; ok {
+		return getRendition(context.TODO(), s3Client, name, rendition, policy, watermark, options)
+	}
+
 	output, err := getImageFromS3(context.TODO(), s3Client, name)
 	if err != nil {
 		// Check if the error represents a "Not Found" condition
@@ -74,12 +169,44 @@ func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 			Body:       `{"message": "Failed to read object content"}`,
 		}, readErr
 	}
+	body = shared.StripMetadata(body, policy)
+
+	// One frame of an animation may be asked for as a still poster, which is then treated like any image
+	if value, ok := request.QueryStringParameters["frame"]; ok {
+		frame, err := strconv.Atoi(value)
+		if err != nil {
+			err = errors.New("frame must be a whole number")
+		} else {
+			body, err = shared.ExtractFrame(body, frame)
+		}
+		if err != nil {
+			message, _ := json.Marshal(map[string]string{"message": "Invalid frame: " + err.Error()})
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       string(message),
+			}, nil
+		}
+	}
+
+	// Animations are written as GIFs, which have no quality to set
+	if shared.IsAnimated(body) {
+		for _, param := range []string{"quality", "maxBytes"} {
+			if _, ok := request.QueryStringParameters[param]; ok {
+				return invalidTransformation(errors.New("quality and maxBytes don't apply to animated GIFs")), nil
+			}
+		}
+	}
+
+	if preset, ok := request.QueryStringParameters["preset"]; ok {
+		return applyPreset(context.TODO(), s3Client, body, name, preset, append(caption, watermark...), options)
+	}
 
 	// Check if the 'rotate' query parameter is present and set to "true"
 	rotateParam, ok := request.QueryStringParameters["rotate"]
 	if ok && rotateParam == "true" {
 		log.Println("Rotating image by 180 degrees")
-		rotatedImageBytes, err := shared.RotateAndResize(body)
+		rotatedImageBytes, err := shared.RotateAndResizeWith(body, append(caption, watermark...), options)
 		if err != nil {
 			log.Printf("Error rotating and resizing: %v", err)
 			return events.APIGatewayProxyResponse{
@@ -88,18 +215,29 @@ func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 				Body:       `{"message": "Failed to rotate and resize"}`,
 			}, err
 		}
+		rotatedImageBytes = withMetadata(rotatedImageBytes, body)
 
 		return events.APIGatewayProxyResponse{
 			StatusCode: 200,
-			Headers:    map[string]string{"Content-Type": "image/jpeg"},
+			Headers:    map[string]string{"Content-Type": http.DetectContentType(rotatedImageBytes)},
 			Body:       base64.StdEncoding.EncodeToString(rotatedImageBytes),
 		}, nil
 	}
 
+	if len(pipeline) > 0 || len(watermark) > 0 || reencode(request.QueryStringParameters) {
+		return applyTransformations(body, append(pipeline, watermark...), options)
+	}
+
+	// Images with transparency may have been stored as PNG
+	contentType := aws.ToString(output.ContentType)
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+
 	// Build the response
 	response := events.APIGatewayProxyResponse{
 		StatusCode: 200,
-		Headers:    map[string]string{"Content-Type": "image/jpeg"},
+		Headers:    map[string]string{"Content-Type": contentType},
 		Body:       base64.StdEncoding.EncodeToString(body),
 	}
 
@@ -111,10 +249,151 @@ func getImageFromS3(ctx context.Context, s3Client shared.S3ObjectAPI, name strin
 	bucketName := os.Getenv("S3_BUCKET_NAME")
 	log.Printf("bucketName: %s", bucketName)
 
+	return shared.GetImageObject(ctx, s3Client, bucketName, name)
+}
+
+// Serve a stored rendition of an image, with its metadata narrowed to the
+// policy. A mandatory watermark is drawn onto it, which is the only time it is
+// encoded again.
+func getRendition(ctx context.Context, s3Client shared.S3ObjectAPI, name string, rendition string, policy shared.MetadataPolicy, watermark shared.Pipeline, options shared.EncodeOptions) (events.APIGatewayProxyResponse, error) {
+	if !shared.IsValidRenditionName(rendition) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid rendition name"}`,
+		}, nil
+	}
+
 	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(name),
+		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
+		Key:    aws.String(shared.RenditionKey(name, rendition)),
 	})
+	if shared.IsNotFound(err) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Rendition %s of image %s not found in S3"}`, rendition, name),
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving rendition from S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve object from S3"}`,
+		}, err
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		log.Printf("Error reading rendition content: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to read object content"}`,
+		}, err
+	}
+
+	body = shared.StripMetadata(body, policy)
+	if len(watermark) > 0 {
+		return applyTransformations(body, watermark, options)
+	}
+
+	// Renditions of animations are GIFs
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": http.DetectContentType(body)},
+		Body:       base64.StdEncoding.EncodeToString(body),
+	}, nil
+}
+
+// Whether the request asks for the image's info as JSON rather than the image
+func infoRequested(params map[string]string) bool {
+	return params["info"] == "true"
+}
+
+// The encoding options for a request: the quality and byte budget asked for,
+// and for a preset its format and, unless the request sets one, its quality
+func requestOptions(params map[string]string) (shared.EncodeOptions, error) {
+	options, err := quality.OptionsFromParams(params)
+	if err != nil {
+		return options, err
+	}
+
+	return presets.RequestOptions(params, options), nil
+}
+
+// Transform the image with a named preset, followed by any caption asked for
+// and the mandatory watermark
+func applyPreset(ctx context.Context, s3Client shared.S3ObjectAPI, body []byte, name string, preset string, trailing shared.Pipeline, options shared.EncodeOptions) (events.APIGatewayProxyResponse, error) {
+	selected, ok := presets[preset]
+	if !ok {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Unknown preset"}`,
+		}, nil
+	}
+
+	pipeline, err := shared.FocusPipeline(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), name, selected.Pipeline)
+	if err == nil {
+		pipeline, err = shared.LoadWatermarkLogos(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), pipeline)
+	}
+	if err != nil {
+		log.Printf("Error retrieving record from S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve object from S3"}`,
+		}, err
+	}
+	// The watermark's logo is already loaded
+	pipeline = append(pipeline[:len(pipeline):len(pipeline)], trailing...)
+
+	transformed, err := shared.ApplyPipeline(body, pipeline, options)
+	if errors.Is(err, shared.ErrCropOutsideImage) {
+		return invalidTransformation(err), nil
+	}
+	if err != nil {
+		log.Printf("Error applying preset %s: %v", preset, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to apply preset"}`,
+		}, err
+	}
+
+	transformed = withMetadata(transformed, body)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": http.DetectContentType(transformed)},
+		Body:       base64.StdEncoding.EncodeToString(transformed),
+	}, nil
+}
+
+// Verify the signature of a signed URL, reporting whether the URL was signed.
+// Tenants listed in SIGNED_URLS_ONLY_TENANTS must use a signed URL for any
+// transformation.
+func checkSignedURL(params map[string]string, tenant string) (bool, error) {
+	_, hasSignature := params[shared.SignatureParam]
+	_, hasExpiry := params[shared.ExpiresParam]
+
+	if !hasSignature && !hasExpiry {
+		if shared.TenantListed("SIGNED_URLS_ONLY_TENANTS", tenant) && len(params) > 1 {
+			return false, errors.New("transformations require a signed URL")
+		}
+		return false, nil
+	}
+
+	secret := os.Getenv("URL_SIGNING_SECRET")
+	if secret == "" {
+		return false, shared.ErrSignatureInvalid
+	}
+	if err := shared.VerifySignedParams(secret, params, time.Now()); err != nil {
+		return false, err
+	}
 
-	return output, err
+	return true, nil
 }
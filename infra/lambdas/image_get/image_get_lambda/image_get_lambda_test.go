@@ -5,9 +5,13 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"image"
+	_ "image/jpeg"
 	"io"
+	"net/url"
 	"shared"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -20,6 +24,16 @@ func (mockGetObjectAPI) PutObject(ctx context.Context, params *s3.PutObjectInput
 	panic("unimplemented")
 }
 
+// ListObjectsV2 implements shared.S3ObjectAPI.
+func (mockGetObjectAPI) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	panic("unimplemented")
+}
+
+// HeadObject implements shared.S3ObjectAPI.
+func (mockGetObjectAPI) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	panic("unimplemented")
+}
+
 func (m mockGetObjectAPI) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
 	return m(ctx, params, optFns...)
 }
@@ -60,7 +74,7 @@ func TestGetFromS3(t *testing.T) {
 }
 
 func TestHandleRequest(t *testing.T) {
-	rotatedResponse, _ := shared.RotateAndResize(shared.GenerateJPG(t))
+	rotatedResponse, _ := shared.RotateAndResize(shared.GenerateJPG(t), shared.EncodeOptions{})
 
 	tests := []struct {
 		name            string
@@ -93,12 +107,40 @@ func TestHandleRequest(t *testing.T) {
 			expectResponse: `{"message": "Failed to rotate and resize"}`,
 			expectError:    true,
 		},
+		{
+			name:           "Rotate with other transformations",
+			pathParams:     map[string]string{"name": "example.jpg", "rotate": "true", "flip": "vertical"},
+			expectStatus:   400,
+			s3Response:     shared.GenerateJPG(t),
+			expectResponse: `{"message":"Invalid transformation: rotate=true can only be combined with a caption"}`,
+		},
+		{
+			name:           "Preset with other transformations",
+			pathParams:     map[string]string{"name": "example.jpg", "preset": "avatar", "blur": "2"},
+			expectStatus:   400,
+			s3Response:     shared.GenerateJPG(t),
+			expectResponse: `{"message":"Invalid transformation: preset can only be combined with a caption"}`,
+		},
 		{
 			name:           "Missing 'name' parameter in path",
 			pathParams:     map[string]string{"invalid": "invalid"},
 			expectStatus:   400,
 			expectResponse: `{"message": "Missing 'name' parameter in the URL path"}`,
 		},
+		{
+			name:           "Internal object",
+			pathParams:     map[string]string{"name": "webhooks/acme/subscriptions/sub.json"},
+			expectStatus:   404,
+			s3Response:     []byte(`[{"secret": "s3cret"}]`),
+			expectResponse: `{"message": "Image with name webhooks/acme/subscriptions/sub.json not found in S3"}`,
+		},
+		{
+			name:           "Internal object info",
+			pathParams:     map[string]string{"name": "records/example.jpg.json", "info": "true"},
+			expectStatus:   404,
+			s3Response:     []byte("{}"),
+			expectResponse: `{"message": "Image with name records/example.jpg.json not found in S3"}`,
+		},
 		{
 			name:            "Failed to retrieve object from S3",
 			pathParams:      map[string]string{"name": "example.jpg"},
@@ -143,3 +185,360 @@ func TestHandleRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestGetDeduplicatedImage(t *testing.T) {
+	image := shared.GenerateJPG(t)
+	hash := shared.ContentHash(image)
+
+	client := shared.NewMockS3Client()
+	client.Objects[shared.ContentKey(hash)] = &shared.MockS3Object{Body: image}
+	client.Objects["example.jpg"] = &shared.MockS3Object{
+		Body:     []byte(hash),
+		Metadata: map[string]string{shared.ContentHashMetadataKey: hash},
+	}
+	s3Client = client
+
+	request := events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"name": "example.jpg"},
+	}
+
+	response, err := HandleRequest(context.Background(), request)
+	if err != nil {
+		t.Errorf("Handler returned an error: %v", err)
+	}
+
+	if response.Body != base64.StdEncoding.EncodeToString(image) {
+		t.Error("Expected the content-addressed image bytes")
+	}
+}
+
+func TestGetRendition(t *testing.T) {
+	thumb := shared.GenerateJPG(t)
+
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	client.Objects[shared.RenditionKey("example.jpg", "thumb")] = &shared.MockS3Object{Body: thumb}
+	s3Client = client
+
+	testCases := []struct {
+		name           string
+		rendition      string
+		expectStatus   int
+		expectResponse string
+	}{
+		{
+			name:           "StoredRendition",
+			rendition:      "thumb",
+			expectStatus:   200,
+			expectResponse: base64.StdEncoding.EncodeToString(thumb),
+		},
+		{
+			name:           "MissingRendition",
+			rendition:      "preview",
+			expectStatus:   404,
+			expectResponse: `{"message": "Rendition preview of image example.jpg not found in S3"}`,
+		},
+		{
+			name:           "InvalidRendition",
+			rendition:      "../thumb",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid rendition name"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{
+				QueryStringParameters: map[string]string{"name": "example.jpg", "rendition": tc.rendition},
+			}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+			if response.StatusCode != tc.expectStatus || response.Body != tc.expectResponse {
+				t.Errorf("Expected %d %.80s, got: %d %.80s", tc.expectStatus, tc.expectResponse, response.StatusCode, response.Body)
+			}
+		})
+	}
+}
+
+func TestGetPreset(t *testing.T) {
+	t.Setenv("PRESETS_ONLY_TENANTS", "public")
+
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	s3Client = client
+
+	testCases := []struct {
+		name           string
+		tenant         string
+		params         map[string]string
+		expectStatus   int
+		expectSize     image.Point
+		expectResponse string
+	}{
+		{
+			name:         "Preset",
+			params:       map[string]string{"preset": "avatar"},
+			expectStatus: 200,
+			expectSize:   image.Pt(128, 128),
+		},
+		{
+			name:         "PresetWithCaption",
+			tenant:       "internal",
+			params:       map[string]string{"preset": "card", "text": "Hello world"},
+			expectStatus: 200,
+			expectSize:   image.Pt(400, 300),
+		},
+		{
+			name:           "UnknownPreset",
+			params:         map[string]string{"preset": "poster"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Unknown preset"}`,
+		},
+		{
+			name:         "RestrictedTenantPreset",
+			tenant:       "public",
+			params:       map[string]string{"preset": "card"},
+			expectStatus: 200,
+			expectSize:   image.Pt(400, 300),
+		},
+		{
+			name:           "RestrictedTenantArbitraryParameter",
+			tenant:         "public",
+			params:         map[string]string{"rotate": "true"},
+			expectStatus:   403,
+			expectResponse: `{"message": "Parameter rotate is not allowed, use a preset"}`,
+		},
+		{
+			name:           "NoAPIKeyArbitraryParameter",
+			params:         map[string]string{"rotate": "true"},
+			expectStatus:   403,
+			expectResponse: `{"message": "Parameter rotate is not allowed, use a preset"}`,
+		},
+		{
+			name:         "UnrestrictedTenantArbitraryParameter",
+			tenant:       "internal",
+			params:       map[string]string{"rotate": "true"},
+			expectStatus: 200,
+			expectSize:   image.Pt(1280, 720),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := map[string]string{"name": "example.jpg"}
+			for key, value := range tc.params {
+				params[key] = value
+			}
+			request := events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				QueryStringParameters: params,
+			}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+			if tc.expectStatus != 200 {
+				return
+			}
+
+			body, _ := base64.StdEncoding.DecodeString(response.Body)
+			config, _, err := image.DecodeConfig(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Pt(config.Width, config.Height) != tc.expectSize {
+				t.Errorf("Expected size %v, got: %dx%d", tc.expectSize, config.Width, config.Height)
+			}
+		})
+	}
+}
+
+func TestGetSignedURL(t *testing.T) {
+	t.Setenv("URL_SIGNING_SECRET", "secret")
+	t.Setenv("SIGNED_URLS_ONLY_TENANTS", "public")
+
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	s3Client = client
+
+	signedParams := func(params map[string]string, expires time.Time) map[string]string {
+		signed, err := shared.SignURL("https://example.com/dev/images", "secret", "example.jpg", params, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, _ := url.Parse(signed)
+		query := map[string]string{}
+		for key := range u.Query() {
+			query[key] = u.Query().Get(key)
+		}
+		return query
+	}
+
+	tampered := signedParams(map[string]string{"preset": "avatar"}, time.Now().Add(time.Hour))
+	tampered["preset"] = "hero"
+
+	testCases := []struct {
+		name           string
+		tenant         string
+		params         map[string]string
+		expectStatus   int
+		expectResponse string
+	}{
+		{
+			name:         "Signed",
+			params:       signedParams(map[string]string{"preset": "avatar"}, time.Now().Add(time.Hour)),
+			expectStatus: 200,
+		},
+		{
+			name:         "OriginalNeedsNoSignature",
+			params:       map[string]string{"name": "example.jpg"},
+			expectStatus: 200,
+		},
+		{
+			name:           "Tampered",
+			params:         tampered,
+			expectStatus:   403,
+			expectResponse: `{"message": "Signed URL rejected: signature is invalid"}`,
+		},
+		{
+			name:           "Expired",
+			params:         signedParams(map[string]string{"preset": "avatar"}, time.Now().Add(-time.Minute)),
+			expectStatus:   403,
+			expectResponse: `{"message": "Signed URL rejected: signed URL has expired"}`,
+		},
+		{
+			name:           "Unsigned",
+			params:         map[string]string{"name": "example.jpg", "preset": "avatar"},
+			expectStatus:   403,
+			expectResponse: `{"message": "Signed URL rejected: transformations require a signed URL"}`,
+		},
+		{
+			name:         "UnsignedWithAPIKey",
+			tenant:       "acme",
+			params:       map[string]string{"name": "example.jpg", "preset": "avatar"},
+			expectStatus: 200,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				QueryStringParameters: tc.params,
+			})
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+		})
+	}
+}
+
+func TestGetPresetEncoding(t *testing.T) {
+	original := shared.GenerateJPG(t)
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: original}
+	s3Client = client
+
+	fill := shared.Pipeline{{Op: "fill", Params: map[string]string{"width": "64", "height": "64"}}}
+	defer func(loaded shared.Presets) { presets = loaded }(presets)
+	presets = shared.Presets{
+		"icon":  {Pipeline: fill, Format: "png"},
+		"small": {Pipeline: fill, Quality: 20},
+	}
+
+	encoded := func(options shared.EncodeOptions) string {
+		body, err := shared.ApplyPipeline(original, fill, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(body)
+	}
+
+	testCases := []struct {
+		name              string
+		params            map[string]string
+		expectContentType string
+		expectBody        string
+	}{
+		{
+			name:              "PresetFormat",
+			params:            map[string]string{"preset": "icon"},
+			expectContentType: "image/png",
+			expectBody:        encoded(shared.EncodeOptions{Format: "png"}),
+		},
+		{
+			name:              "PresetQuality",
+			params:            map[string]string{"preset": "small"},
+			expectContentType: "image/jpeg",
+			expectBody:        encoded(shared.EncodeOptions{Quality: 20}),
+		},
+		{
+			name:              "RequestQualityOverridesPreset",
+			params:            map[string]string{"preset": "small", "quality": "90"},
+			expectContentType: "image/jpeg",
+			expectBody:        encoded(shared.EncodeOptions{Quality: 90}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := map[string]string{"name": "example.jpg"}
+			for key, value := range tc.params {
+				params[key] = value
+			}
+
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": "internal"}},
+				QueryStringParameters: params,
+			})
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("Expected status code 200, got: %d %.80s %v", response.StatusCode, response.Body, err)
+			}
+			if response.Headers["Content-Type"] != tc.expectContentType {
+				t.Errorf("Expected %s, got: %s", tc.expectContentType, response.Headers["Content-Type"])
+			}
+			if response.Body != tc.expectBody {
+				t.Errorf("Expected the image encoded with the preset's settings")
+			}
+		})
+	}
+}
+
+func TestGetPresetCropOutsideImage(t *testing.T) {
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	s3Client = client
+
+	defer func(loaded shared.Presets) { presets = loaded }(presets)
+	presets = shared.Presets{
+		"corner": {Pipeline: shared.Pipeline{{Op: "crop", Params: map[string]string{"x": "4000", "y": "3000", "width": "100", "height": "100"}}}},
+	}
+
+	response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"name": "example.jpg", "preset": "corner"},
+	})
+	if err != nil {
+		t.Errorf("Handler returned an error: %v", err)
+	}
+	expected := `{"message":"Invalid transformation: crop lies outside the image"}`
+	if response.StatusCode != 400 || response.Body != expected {
+		t.Errorf("Expected 400 %s, got: %d %s", expected, response.StatusCode, response.Body)
+	}
+}
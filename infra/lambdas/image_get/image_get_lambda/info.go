@@ -0,0 +1,105 @@
+package image_get_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ImageInfo is the body returned for ?info=true, enough to draw a placeholder
+// before the image loads, along with the metadata read from it at upload.
+type ImageInfo struct {
+	Name string `json:"name"`
+	shared.ImageColors
+	Metadata *shared.ImageMetadata `json:"metadata,omitempty"`
+}
+
+// Describe the image's size and colours from its record, and its metadata as
+// far as the reader's policy allows. Images recorded before colours were worked
+// out at upload have them worked out now.
+func getImageInfo(ctx context.Context, s3Client shared.S3ObjectAPI, name string, policy shared.MetadataPolicy) (events.APIGatewayProxyResponse, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	record, err := shared.GetImageRecord(ctx, s3Client, bucketName, name)
+	if shared.IsNotFound(err) {
+		record = &shared.ImageRecord{Name: name}
+	} else if err != nil {
+		log.Printf("Error retrieving image record from S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve object from S3"}`,
+		}, err
+	}
+
+	colors := record.ImageColors
+	if colors.BlurHash == "" {
+		output, err := getImageFromS3(ctx, s3Client, name)
+		if shared.IsNotFound(err) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       fmt.Sprintf(`{"message": "Image with name %s not found in S3"}`, name),
+			}, nil
+		}
+		if err != nil {
+			log.Printf("Error retrieving image from S3: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to retrieve object from S3"}`,
+			}, err
+		}
+		defer output.Body.Close()
+
+		body, err := io.ReadAll(output.Body)
+		if err != nil {
+			log.Printf("Error reading image content: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to read object content"}`,
+			}, err
+		}
+
+		if colors, err = shared.AnalyzeColors(body); err != nil {
+			log.Printf("Error analyzing image colors: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to analyze image"}`,
+			}, err
+		}
+	}
+
+	// The sidecar holds what the uploader's policy kept, which the reader's may narrow
+	var metadata *shared.ImageMetadata
+	stored, err := shared.GetImageMetadata(ctx, s3Client, bucketName, name)
+	if err != nil && !shared.IsNotFound(err) {
+		log.Printf("Error retrieving image metadata from S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve object from S3"}`,
+		}, err
+	}
+	if stored != nil {
+		if narrowed := stored.WithPolicy(policy); !reflect.DeepEqual(narrowed, shared.ImageMetadata{}) {
+			metadata = &narrowed
+		}
+	}
+
+	body, _ := json.Marshal(ImageInfo{Name: name, ImageColors: colors, Metadata: metadata})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
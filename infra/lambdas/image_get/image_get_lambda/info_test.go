@@ -0,0 +1,146 @@
+package image_get_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestImageInfo(t *testing.T) {
+	t.Setenv("PRESETS_ONLY_TENANTS", "public")
+
+	recorded := shared.ImageColors{
+		Width:    640,
+		Height:   480,
+		Palette:  []shared.PaletteColor{{Color: "#336699", Weight: 0.8}, {Color: "#ffffff", Weight: 0.2}},
+		BlurHash: "LEHV6nWB2yk8pyo0adR*.7kCMdnj",
+	}
+	// What the black test image works out as when it has no colours recorded
+	computed, err := shared.AnalyzeColors(shared.GenerateJPG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := shared.NewMockS3Client()
+	client.Objects["recorded.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	client.Objects["unrecorded.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	client.Objects["older.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	for _, record := range []shared.ImageRecord{{Name: "recorded.jpg", ImageColors: recorded}, {Name: "older.jpg"}} {
+		if err := shared.PutImageRecord(context.TODO(), client, "", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s3Client = client
+
+	// Info is JSON, so tenants who are sent watermarked images get it as is
+	watermarks, err = shared.ParseWatermarkPolicy(`{"partner": {"logo": "acme"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watermarks = nil }()
+
+	testCases := []struct {
+		name         string
+		tenant       string
+		imageName    string
+		expectStatus int
+		expectColors shared.ImageColors
+	}{
+		{name: "Recorded", imageName: "recorded.jpg", expectStatus: 200, expectColors: recorded},
+		{name: "NoRecord", imageName: "unrecorded.jpg", expectStatus: 200, expectColors: computed},
+		{name: "RecordedBeforeColors", imageName: "older.jpg", expectStatus: 200, expectColors: computed},
+		{name: "PresetsOnlyTenant", tenant: "public", imageName: "recorded.jpg", expectStatus: 200, expectColors: recorded},
+		{name: "WatermarkedTenant", tenant: "partner", imageName: "recorded.jpg", expectStatus: 200, expectColors: recorded},
+		{name: "NotFound", imageName: "missing.jpg", expectStatus: 404},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				QueryStringParameters: map[string]string{"name": tc.imageName, "info": "true"},
+			})
+			if err != nil || response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d %s %v", tc.expectStatus, response.StatusCode, response.Body, err)
+			}
+			if tc.expectStatus != 200 {
+				return
+			}
+			if response.Headers["Content-Type"] != "application/json" {
+				t.Errorf("Expected JSON, got: %s", response.Headers["Content-Type"])
+			}
+
+			var info ImageInfo
+			if err := json.Unmarshal([]byte(response.Body), &info); err != nil {
+				t.Fatal(err)
+			}
+			if info.Name != tc.imageName || !reflect.DeepEqual(info.ImageColors, tc.expectColors) {
+				t.Errorf("Expected %s with %+v, got: %+v", tc.imageName, tc.expectColors, info)
+			}
+		})
+	}
+}
+
+func TestImageInfoMetadata(t *testing.T) {
+	original := shared.GenerateJPGWithMetadata(t)
+	client := shared.NewMockS3Client()
+	client.Objects["photo.jpg"] = &shared.MockS3Object{Body: original}
+	client.Objects["bare.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+	if err := shared.PutImageMetadata(context.TODO(), client, "", "photo.jpg", shared.ReadMetadata(original)); err != nil {
+		t.Fatal(err)
+	}
+	s3Client = client
+
+	defer func() { metadataPolicies = nil }()
+	metadataPolicies = shared.MetadataPolicies{
+		"default": shared.MetadataStripGPS,
+		"private": shared.MetadataStripAll,
+		"archive": shared.MetadataPreserve,
+	}
+
+	testCases := []struct {
+		name           string
+		tenant         string
+		imageName      string
+		expectMetadata bool
+		expectGPS      bool
+	}{
+		{name: "Preserve", tenant: "archive", imageName: "photo.jpg", expectMetadata: true, expectGPS: true},
+		{name: "StripGPS", tenant: "default", imageName: "photo.jpg", expectMetadata: true},
+		{name: "StripAll", tenant: "private", imageName: "photo.jpg"},
+		{name: "NoSidecar", tenant: "archive", imageName: "bare.jpg"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				QueryStringParameters: map[string]string{"name": tc.imageName, "info": "true"},
+			})
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("Expected status code 200, got: %d %s %v", response.StatusCode, response.Body, err)
+			}
+
+			var info ImageInfo
+			if err := json.Unmarshal([]byte(response.Body), &info); err != nil {
+				t.Fatal(err)
+			}
+			if (info.Metadata != nil) != tc.expectMetadata {
+				t.Fatalf("Expected metadata %v, got: %+v", tc.expectMetadata, info.Metadata)
+			}
+			if info.Metadata == nil {
+				return
+			}
+			if info.Metadata.EXIF == nil || info.Metadata.EXIF.Make != "Canon" {
+				t.Errorf("Expected the camera, got: %+v", info.Metadata.EXIF)
+			}
+			if (info.Metadata.EXIF != nil && info.Metadata.EXIF.GPS != nil) != tc.expectGPS {
+				t.Errorf("Expected GPS %v, got: %+v", tc.expectGPS, info.Metadata.EXIF)
+			}
+		})
+	}
+}
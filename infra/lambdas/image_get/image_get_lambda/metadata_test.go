@@ -0,0 +1,54 @@
+package image_get_lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestGetMetadataPolicy(t *testing.T) {
+	client := shared.NewMockS3Client()
+	client.Objects["photo.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPGWithMetadata(t), ContentType: "image/jpeg"}
+	s3Client = client
+
+	metadataPolicies = shared.MetadataPolicies{"default": shared.MetadataStripGPS, "archive": shared.MetadataPreserve}
+	defer func() { metadataPolicies = nil }()
+
+	testCases := []struct {
+		name       string
+		tenant     string
+		params     map[string]string
+		expectEXIF bool
+		expectGPS  bool
+	}{
+		{name: "OriginalNarrowed", tenant: "other", params: map[string]string{"name": "photo.jpg"}, expectEXIF: true},
+		{name: "OriginalPreserved", tenant: "archive", params: map[string]string{"name": "photo.jpg"}, expectEXIF: true, expectGPS: true},
+		{name: "DerivativeNarrowed", tenant: "other", params: map[string]string{"name": "photo.jpg", "width": "32"}, expectEXIF: true},
+		{name: "DerivativePreserved", tenant: "archive", params: map[string]string{"name": "photo.jpg", "width": "32"}, expectEXIF: true, expectGPS: true},
+		{name: "Rotated", tenant: "archive", params: map[string]string{"name": "photo.jpg", "rotate": "true"}, expectEXIF: true, expectGPS: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				QueryStringParameters: tc.params,
+			})
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("Expected 200, got: %d %s %v", response.StatusCode, response.Body, err)
+			}
+
+			body, _ := base64.StdEncoding.DecodeString(response.Body)
+			metadata := shared.ReadMetadata(body)
+			if (metadata.EXIF != nil) != tc.expectEXIF {
+				t.Errorf("Expected EXIF %v, got: %+v", tc.expectEXIF, metadata.EXIF)
+			}
+			if (metadata.EXIF != nil && metadata.EXIF.GPS != nil) != tc.expectGPS {
+				t.Errorf("Expected GPS %v, got: %+v", tc.expectGPS, metadata.EXIF)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package image_get_lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Whether the original must be encoded again to honour the requested quality,
+// size or poster frame
+func reencode(params map[string]string) bool {
+	_, hasQuality := params["quality"]
+	_, hasMaxBytes := params["maxBytes"]
+	_, hasFrame := params["frame"]
+	return hasQuality || hasMaxBytes || hasFrame
+}
+
+// Transform the image with the pipeline built from the query parameters
+func applyTransformations(body []byte, pipeline shared.Pipeline, options shared.EncodeOptions) (events.APIGatewayProxyResponse, error) {
+	transformed, err := shared.ApplyPipeline(body, pipeline, options)
+	if errors.Is(err, shared.ErrCropOutsideImage) {
+		return invalidTransformation(err), nil
+	}
+	if err != nil {
+		log.Printf("Error transforming image: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to transform image"}`,
+		}, err
+	}
+
+	transformed = withMetadata(transformed, body)
+
+	// Animations stay GIFs
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": http.DetectContentType(transformed)},
+		Body:       base64.StdEncoding.EncodeToString(transformed),
+	}, nil
+}
+
+// Carry the metadata of the image being served over to a derivative of it.
+// The original has already had the reader's policy applied, so all of it is kept.
+func withMetadata(derivative []byte, original []byte) []byte {
+	return shared.CopyMetadata(derivative, original, shared.MetadataPreserve)
+}
+
+// Reject a request whose transformation parameters don't make sense
+func invalidTransformation(err error) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{"message": "Invalid transformation: " + err.Error()})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
@@ -0,0 +1,147 @@
+package image_get_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestGetTransformed(t *testing.T) {
+	// A landscape image, so that orientation changes show in the size
+	var landscape bytes.Buffer
+	if err := jpeg.Encode(&landscape, image.NewRGBA(image.Rect(0, 0, 600, 400)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: landscape.Bytes()}
+	s3Client = client
+
+	testCases := []struct {
+		name           string
+		params         map[string]string
+		expectStatus   int
+		expectSize     image.Point
+		expectResponse string
+	}{
+		{
+			name:         "RotateRightAngle",
+			params:       map[string]string{"rotate": "90"},
+			expectStatus: 200,
+			expectSize:   image.Pt(400, 600),
+		},
+		{
+			name:         "RotateArbitraryAngle",
+			params:       map[string]string{"rotate": "30", "background": "000000"},
+			expectStatus: 200,
+			expectSize:   image.Pt(720, 646),
+		},
+		{
+			name:         "FlipBoth",
+			params:       map[string]string{"flip": "both"},
+			expectStatus: 200,
+			expectSize:   image.Pt(600, 400),
+		},
+		{
+			name:         "Transverse",
+			params:       map[string]string{"transverse": "true"},
+			expectStatus: 200,
+			expectSize:   image.Pt(400, 600),
+		},
+		{
+			name:         "QualityOnly",
+			params:       map[string]string{"quality": "30", "maxBytes": "20000"},
+			expectStatus: 200,
+			expectSize:   image.Pt(600, 400),
+		},
+		{
+			name:           "InvalidQuality",
+			params:         map[string]string{"quality": "0"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: quality must be an integer between 1 and 100"}`,
+		},
+		{
+			name:         "ResizeWithFilters",
+			params:       map[string]string{"rotate": "90", "width": "200", "grayscale": "true", "contrast": "20", "sepia": "true", "blur": "1.5"},
+			expectStatus: 200,
+			expectSize:   image.Pt(200, 300),
+		},
+		{
+			name:           "InvalidFilterAmount",
+			params:         map[string]string{"brightness": "bright"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: step 0: brightness: amount must be a number between -100 and 100"}`,
+		},
+		{
+			name:           "InvalidFlag",
+			params:         map[string]string{"invert": "50"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: invert must be true or false"}`,
+		},
+		{
+			name:         "Caption",
+			params:       map[string]string{"text": "Hello world", "textSize": "24", "textBox": "00000080", "textPosition": "north"},
+			expectStatus: 200,
+			expectSize:   image.Pt(600, 400),
+		},
+		{
+			name:           "InvalidCaption",
+			params:         map[string]string{"text": "Hello world", "textFont": "comic"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: step 0: text: unknown font \"comic\""}`,
+		},
+		{
+			name:           "InvalidFlip",
+			params:         map[string]string{"flip": "sideways"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: step 0: flip: direction must be horizontal or vertical, not \"sideways\""}`,
+		},
+		{
+			name:           "InvalidBackground",
+			params:         map[string]string{"rotate": "10", "background": "blue"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: step 0: rotate: background: invalid colour \"blue\", expected rrggbb or rrggbbaa"}`,
+		},
+		{
+			name:           "InvalidTranspose",
+			params:         map[string]string{"transpose": "yes"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid transformation: transpose must be true or false"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := map[string]string{"name": "example.jpg"}
+			for key, value := range tc.params {
+				params[key] = value
+			}
+
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{QueryStringParameters: params})
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+			if tc.expectStatus != 200 {
+				return
+			}
+
+			body, _ := base64.StdEncoding.DecodeString(response.Body)
+			config, _, err := image.DecodeConfig(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Pt(config.Width, config.Height) != tc.expectSize {
+				t.Errorf("Expected size %v, got: %dx%d", tc.expectSize, config.Width, config.Height)
+			}
+		})
+	}
+}
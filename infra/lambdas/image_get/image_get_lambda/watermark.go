@@ -0,0 +1,27 @@
+package image_get_lambda
+
+import (
+	"context"
+	"os"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The tenant's mandatory watermark with its logo loaded, or nothing if the tenant has none
+func mandatoryWatermark(ctx context.Context, tenant string) (shared.Pipeline, error) {
+	step, ok := watermarks.For(tenant)
+	if !ok {
+		return nil, nil
+	}
+
+	return shared.LoadWatermarkLogos(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), shared.Pipeline{step})
+}
+
+func watermarkFailed() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: 500,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       `{"message": "Failed to apply watermark"}`,
+	}
+}
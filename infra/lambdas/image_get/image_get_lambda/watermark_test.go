@@ -0,0 +1,161 @@
+package image_get_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestMandatoryWatermark(t *testing.T) {
+	// A solid red logo, to find on the black image
+	logo := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	var logoPNG bytes.Buffer
+	if err := png.Encode(&logoPNG, logo); err != nil {
+		t.Fatal(err)
+	}
+
+	original := shared.GenerateJPG(t)
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: original}
+	client.Objects[shared.RenditionKey("example.jpg", "thumb")] = &shared.MockS3Object{Body: original}
+	client.Objects[shared.WatermarkKey("acme")] = &shared.MockS3Object{Body: logoPNG.Bytes()}
+	s3Client = client
+
+	var err error
+	watermarks, err = shared.ParseWatermarkPolicy(`{
+		"partner": {"logo": "acme", "opacity": "1"},
+		"reseller": {"logo": "missing"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watermarks = nil }()
+
+	testCases := []struct {
+		name            string
+		tenant          string
+		params          map[string]string
+		expectStatus    int
+		expectUnchanged bool
+	}{
+		{
+			name:            "OtherTenant",
+			tenant:          "internal",
+			expectStatus:    200,
+			expectUnchanged: true,
+		},
+		{
+			name:         "Original",
+			tenant:       "partner",
+			expectStatus: 200,
+		},
+		{
+			name:         "Rendition",
+			tenant:       "partner",
+			params:       map[string]string{"rendition": "thumb"},
+			expectStatus: 200,
+		},
+		{
+			name:         "Transformed",
+			tenant:       "partner",
+			params:       map[string]string{"flip": "vertical"},
+			expectStatus: 200,
+		},
+		{
+			name:         "FixedRotate",
+			tenant:       "partner",
+			params:       map[string]string{"rotate": "true"},
+			expectStatus: 200,
+		},
+		{
+			name:         "MissingLogo",
+			tenant:       "reseller",
+			expectStatus: 500,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := map[string]string{"name": "example.jpg"}
+			for key, value := range tc.params {
+				params[key] = value
+			}
+
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				QueryStringParameters: params,
+			})
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectStatus != 200 {
+				return
+			}
+
+			unchanged := response.Body == base64.StdEncoding.EncodeToString(original)
+			if unchanged != tc.expectUnchanged {
+				t.Errorf("Expected the image unchanged: %v, got: %v", tc.expectUnchanged, unchanged)
+			}
+			if unchanged {
+				return
+			}
+
+			// The logo sits in the bottom right corner
+			body, _ := base64.StdEncoding.DecodeString(response.Body)
+			img, _, err := image.Decode(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := img.Bounds()
+			if r, _, _, _ := img.At(bounds.Max.X*9/10, bounds.Max.Y*9/10).RGBA(); r < 0xc000 {
+				t.Errorf("Expected the watermark in the bottom right corner")
+			}
+		})
+	}
+}
+
+func TestMandatoryWatermarkEncodedOnce(t *testing.T) {
+	original := shared.GenerateJPG(t)
+	client := shared.NewMockS3Client()
+	client.Objects["example.jpg"] = &shared.MockS3Object{Body: original}
+	s3Client = client
+
+	var err error
+	watermarks, err = shared.ParseWatermarkPolicy(`{"partner": {"text": "PROOF", "color": "ff0000", "opacity": "1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watermarks = nil }()
+
+	params := map[string]string{"name": "example.jpg", "flip": "vertical", "quality": "40"}
+	response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+		RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": "partner"}},
+		QueryStringParameters: params,
+	})
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, got: %d (%s)", response.StatusCode, response.Body)
+	}
+
+	// The flip and the watermark are applied together, at the quality asked for
+	step, _ := watermarks.For("partner")
+	options, err := requestOptions(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := shared.ApplyPipeline(original, shared.Pipeline{{Op: "flip", Params: map[string]string{"direction": "vertical"}}, step}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Body != base64.StdEncoding.EncodeToString(withMetadata(expected, original)) {
+		t.Errorf("Expected the image to be encoded once with the requested quality")
+	}
+}
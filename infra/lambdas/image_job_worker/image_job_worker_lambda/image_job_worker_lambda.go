@@ -0,0 +1,191 @@
+package image_job_worker_lambda
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"shared"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var s3Client shared.S3ObjectAPI
+var webhookQueue shared.WebhookQueue
+var quality shared.QualityPolicy
+var metadataPolicies shared.MetadataPolicies
+var watermarks shared.WatermarkPolicy
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+	sqsClient, err := shared.NewSQSClient()
+	if err != nil {
+		log.Fatalf("Failed to initialize SQS client: %v", err)
+	}
+	webhookQueue = shared.NewSQSWebhookQueue(sqsClient, os.Getenv("WEBHOOK_QUEUE_URL"))
+	quality, err = shared.LoadQualityPolicy()
+	if err != nil {
+		log.Fatalf("Invalid JPEG quality settings: %v", err)
+	}
+	metadataPolicies, err = shared.ParseMetadataPolicies(os.Getenv("METADATA_POLICIES"))
+	if err != nil {
+		log.Fatalf("Invalid METADATA_POLICIES: %v", err)
+	}
+	watermarks, err = shared.ParseWatermarkPolicy(os.Getenv("MANDATORY_WATERMARKS"))
+	if err != nil {
+		log.Fatalf("Invalid MANDATORY_WATERMARKS: %v", err)
+	}
+}
+
+// Run each queued job. Messages whose jobs hit a transient error are reported
+// back so that SQS redelivers them; jobs that can never succeed are marked failed.
+func HandleRequest(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	var response events.SQSEventResponse
+
+	for _, message := range event.Records {
+		if err := processJob(ctx, s3Client, message.Body); err != nil {
+			log.Printf("Error processing job %s: %v", message.Body, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+		}
+	}
+
+	return response, nil
+}
+
+// Run a single job, recording its progress as it goes
+func processJob(ctx context.Context, s3Client shared.S3ObjectAPI, id string) error {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	job, err := shared.GetJob(ctx, s3Client, bucketName, id)
+	if shared.IsNotFound(err) {
+		log.Printf("Dropping unknown job %s", id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// SQS delivers at least once, so a finished job may arrive again
+	if job.Status == shared.JobSucceeded || job.Status == shared.JobFailed {
+		log.Printf("Job %s has already %s", id, job.Status)
+		return nil
+	}
+
+	if err := updateJob(ctx, s3Client, job, shared.JobRunning, ""); err != nil {
+		return err
+	}
+
+	output, err := shared.GetImageObject(ctx, s3Client, bucketName, job.SourceKey)
+	if shared.IsNotFound(err) {
+		return updateJob(ctx, s3Client, job, shared.JobFailed, "source image not found")
+	}
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(output.Body)
+	output.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	pipeline, err := shared.FocusPipeline(ctx, s3Client, bucketName, job.SourceKey, job.Pipeline)
+	if err != nil {
+		return err
+	}
+	// The tenant's mandatory watermark goes on last, so nothing in the job can crop or cover it
+	if step, ok := watermarks.For(job.Tenant); ok {
+		pipeline = append(pipeline, step)
+	}
+	pipeline, err = shared.LoadWatermarkLogos(ctx, s3Client, bucketName, pipeline)
+	if shared.IsNotFound(err) {
+		return updateJob(ctx, s3Client, job, shared.JobFailed, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	// Jobs are written at the server's default quality
+	options, _ := quality.Options(0, 0)
+	result, err := shared.ApplyPipeline(body, pipeline, options)
+	if err != nil {
+		return updateJob(ctx, s3Client, job, shared.JobFailed, err.Error())
+	}
+	// Jobs without a tenant get the default tenant's policy
+	result = shared.CopyMetadata(result, body, metadataPolicies.For(job.Tenant))
+
+	if job.OutputKey == "" {
+		job.OutputKey = shared.JobOutputKey(job.ID, shared.SniffFormat(result))
+	}
+
+	// The output name was free when the job was queued, but an image may
+	// have been uploaded to it since. Jobs only ever create images, so check
+	// again just before writing; as with uploads, the check and the write
+	// are not atomic. An earlier delivery of this job may have written it.
+	existing, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(job.OutputKey),
+	})
+	if err == nil && existing.Metadata[shared.JobMetadataKey] != job.ID {
+		return updateJob(ctx, s3Client, job, shared.JobFailed, "output image already exists")
+	}
+	if err != nil && !shared.IsNotFound(err) {
+		return err
+	}
+
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(job.OutputKey),
+		Body:        bytes.NewReader(result),
+		ContentType: aws.String(http.DetectContentType(result)),
+		Metadata:    map[string]string{shared.JobMetadataKey: job.ID},
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("Job %s wrote %s", id, job.OutputKey)
+
+	return updateJob(ctx, s3Client, job, shared.JobSucceeded, "")
+}
+
+// Record a change in the status of a job, telling webhooks once it has finished
+func updateJob(ctx context.Context, s3Client shared.S3ObjectAPI, job *shared.Job, status shared.JobStatus, message string) error {
+	job.Status = status
+	job.Error = message
+	job.UpdatedAt = time.Now().UTC()
+
+	if err := shared.PutJob(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), *job); err != nil {
+		return err
+	}
+
+	var eventType string
+	switch status {
+	case shared.JobSucceeded:
+		eventType = shared.EventImageProcessed
+	case shared.JobFailed:
+		eventType = shared.EventImageFailed
+	default:
+		return nil
+	}
+
+	tenant := job.Tenant
+	if tenant == "" {
+		tenant = shared.DefaultTenant
+	}
+	data := shared.ImageEventData{Name: job.OutputKey, JobID: job.ID, Error: job.Error}
+	if err := shared.QueueWebhookEvent(ctx, webhookQueue, tenant, eventType, data); err != nil {
+		log.Printf("Error queueing webhook event %s: %v", eventType, err)
+	}
+
+	return nil
+}
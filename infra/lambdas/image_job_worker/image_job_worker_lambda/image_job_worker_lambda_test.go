@@ -0,0 +1,244 @@
+package image_job_worker_lambda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"shared"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Fails every write to the output key, standing in for a transient S3 outage
+type failingOutputClient struct {
+	*shared.MockS3Client
+}
+
+func (c failingOutputClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if *params.Key == "out.jpg" {
+		return nil, errors.New("S3 upload failed")
+	}
+	return c.MockS3Client.PutObject(ctx, params, optFns...)
+}
+
+func TestHandleRequest(t *testing.T) {
+	resize := shared.Pipeline{{Op: "resize", Params: map[string]string{"width": "200"}}}
+
+	tests := []struct {
+		name          string
+		job           shared.Job
+		source        []byte
+		existing      *shared.MockS3Object
+		failOutput    bool
+		expectStatus  shared.JobStatus
+		expectRetry   bool
+		expectOutput  bool
+		expectMessage string
+		expectEvent   string
+	}{
+		{
+			name:         "Successful job",
+			job:          shared.Job{ID: "ok", SourceKey: "image.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobQueued},
+			source:       shared.GenerateJPG(t),
+			expectStatus: shared.JobSucceeded,
+			expectOutput: true,
+			expectEvent:  shared.EventImageProcessed,
+		},
+		{
+			name:          "Invalid source image",
+			job:           shared.Job{ID: "bad", SourceKey: "image.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobQueued},
+			source:        []byte("fake image content"),
+			expectStatus:  shared.JobFailed,
+			expectMessage: "error decoding image",
+			expectEvent:   shared.EventImageFailed,
+		},
+		{
+			name:          "Crop outside the image",
+			job:           shared.Job{ID: "crop", SourceKey: "image.jpg", Pipeline: shared.Pipeline{{Op: "crop", Params: map[string]string{"x": "4000", "y": "3000", "width": "100", "height": "100"}}}, OutputKey: "out.jpg", Status: shared.JobQueued},
+			source:        shared.GenerateJPG(t),
+			expectStatus:  shared.JobFailed,
+			expectMessage: "crop lies outside the image",
+			expectEvent:   shared.EventImageFailed,
+		},
+		{
+			name:          "Missing source image",
+			job:           shared.Job{ID: "missing", SourceKey: "missing.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobQueued},
+			source:        shared.GenerateJPG(t),
+			expectStatus:  shared.JobFailed,
+			expectMessage: "source image not found",
+			expectEvent:   shared.EventImageFailed,
+		},
+		{
+			name:         "Transient failure is retried",
+			job:          shared.Job{ID: "retry", SourceKey: "image.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobQueued},
+			source:       shared.GenerateJPG(t),
+			failOutput:   true,
+			expectStatus: shared.JobRunning,
+			expectRetry:  true,
+		},
+		{
+			name:          "Output uploaded since the job was queued",
+			job:           shared.Job{ID: "taken", SourceKey: "image.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobQueued},
+			source:        shared.GenerateJPG(t),
+			existing:      &shared.MockS3Object{Body: []byte("uploaded")},
+			expectStatus:  shared.JobFailed,
+			expectOutput:  true,
+			expectMessage: "output image already exists",
+			expectEvent:   shared.EventImageFailed,
+		},
+		{
+			name:         "Output written by an earlier delivery",
+			job:          shared.Job{ID: "redelivered", SourceKey: "image.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobRunning},
+			source:       shared.GenerateJPG(t),
+			existing:     &shared.MockS3Object{Body: []byte("partial"), Metadata: map[string]string{shared.JobMetadataKey: "redelivered"}},
+			expectStatus: shared.JobSucceeded,
+			expectOutput: true,
+			expectEvent:  shared.EventImageProcessed,
+		},
+		{
+			name:         "Finished job is not rerun",
+			job:          shared.Job{ID: "done", SourceKey: "image.jpg", Pipeline: resize, OutputKey: "out.jpg", Status: shared.JobSucceeded},
+			source:       shared.GenerateJPG(t),
+			expectStatus: shared.JobSucceeded,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := shared.NewMemoryWebhookQueue()
+			webhookQueue = queue
+
+			mock := shared.NewMockS3Client()
+			mock.Objects["image.jpg"] = &shared.MockS3Object{Body: tc.source}
+			if tc.existing != nil {
+				mock.Objects["out.jpg"] = tc.existing
+			}
+
+			tc.job.Tenant = "acme"
+			if err := shared.PutJob(context.TODO(), mock, "", tc.job); err != nil {
+				t.Fatal(err)
+			}
+			s3Client = mock
+			if tc.failOutput {
+				s3Client = failingOutputClient{mock}
+			}
+
+			event := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-1", Body: tc.job.ID}}}
+			response, err := HandleRequest(context.Background(), event)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if retried := len(response.BatchItemFailures) == 1; retried != tc.expectRetry {
+				t.Errorf("Expected retry: %v, got: %v", tc.expectRetry, response.BatchItemFailures)
+			}
+
+			job, err := shared.GetJob(context.TODO(), mock, "", tc.job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.Status != tc.expectStatus || job.Error != tc.expectMessage {
+				t.Errorf("Expected status %s (%q), got: %s (%q)", tc.expectStatus, tc.expectMessage, job.Status, job.Error)
+			}
+
+			if _, ok := mock.Objects["out.jpg"]; ok != tc.expectOutput {
+				t.Errorf("Expected output written: %v, got: %v", tc.expectOutput, ok)
+			}
+			if tc.existing != nil && tc.expectStatus == shared.JobFailed && string(mock.Objects["out.jpg"].Body) != "uploaded" {
+				t.Error("Expected the uploaded image to be left alone")
+			}
+
+			queued := queue.Drain()
+			if tc.expectEvent == "" && len(queued) != 0 {
+				t.Errorf("Expected no events, got: %+v", queued)
+			}
+			if tc.expectEvent != "" && (len(queued) != 1 || queued[0].Type != tc.expectEvent || queued[0].Tenant != "acme") {
+				t.Errorf("Expected an %s event for acme, got: %+v", tc.expectEvent, queued)
+			}
+		})
+	}
+}
+
+func TestUnknownJobIsDropped(t *testing.T) {
+	s3Client = shared.NewMockS3Client()
+
+	event := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-1", Body: "unknown"}}}
+	response, err := HandleRequest(context.Background(), event)
+	if err != nil || len(response.BatchItemFailures) != 0 {
+		t.Errorf("Expected the message to be dropped, got: %v, %v", response.BatchItemFailures, err)
+	}
+}
+
+func TestMandatoryWatermark(t *testing.T) {
+	var err error
+	watermarks, err = shared.ParseWatermarkPolicy(`{"partner": {"text": "PROOF", "color": "ff0000", "opacity": "1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watermarks = nil }()
+	webhookQueue = shared.NewMemoryWebhookQueue()
+
+	outputs := map[string][]byte{}
+	for _, tenant := range []string{"partner", "internal"} {
+		mock := shared.NewMockS3Client()
+		mock.Objects["image.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+		job := shared.Job{ID: tenant, Tenant: tenant, SourceKey: "image.jpg", Pipeline: shared.Pipeline{{Op: "resize", Params: map[string]string{"width": "200"}}}, OutputKey: "out.jpg", Status: shared.JobQueued}
+		if err := shared.PutJob(context.TODO(), mock, "", job); err != nil {
+			t.Fatal(err)
+		}
+		s3Client = mock
+
+		HandleRequest(context.Background(), events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-1", Body: job.ID}}})
+		output, ok := mock.Objects["out.jpg"]
+		if !ok {
+			t.Fatalf("Expected output for tenant %s", tenant)
+		}
+		outputs[tenant] = output.Body
+	}
+
+	if bytes.Equal(outputs["partner"], outputs["internal"]) {
+		t.Error("Expected the partner's output to be watermarked")
+	}
+}
+
+func TestDefaultOutputKey(t *testing.T) {
+	webhookQueue = shared.NewMemoryWebhookQueue()
+	resize := shared.Pipeline{{Op: "resize", Params: map[string]string{"width": "5"}}}
+
+	testCases := []struct {
+		name         string
+		source       []byte
+		expectOutput string
+	}{
+		{name: "JPEG", source: shared.GenerateJPG(t), expectOutput: "outputs/jpeg.jpg"},
+		{name: "GIF", source: shared.GenerateAnimatedGIF(t), expectOutput: "outputs/gif.gif"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := shared.NewMockS3Client()
+			mock.Objects["image"] = &shared.MockS3Object{Body: tc.source}
+			job := shared.Job{ID: strings.ToLower(tc.name), SourceKey: "image", Pipeline: resize, Status: shared.JobQueued}
+			if err := shared.PutJob(context.TODO(), mock, "", job); err != nil {
+				t.Fatal(err)
+			}
+			s3Client = mock
+
+			HandleRequest(context.Background(), events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-1", Body: job.ID}}})
+
+			stored, err := shared.GetJob(context.TODO(), mock, "", job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != shared.JobSucceeded || stored.OutputKey != tc.expectOutput {
+				t.Errorf("Expected output %s, got: %+v", tc.expectOutput, stored)
+			}
+			if _, err := shared.GetImageObject(context.TODO(), mock, "", stored.OutputKey); err != nil {
+				t.Errorf("Expected %s to be served as an image: %v", tc.expectOutput, err)
+			}
+		})
+	}
+}
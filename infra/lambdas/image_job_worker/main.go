@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_job_worker/image_job_worker_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_job_worker_lambda.HandleRequest)
+}
@@ -0,0 +1,230 @@
+package image_jobs_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"shared"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// JobRequest is the structure of the request body for a new job.
+type JobRequest struct {
+	Name       string          `json:"name"`
+	Pipeline   shared.Pipeline `json:"pipeline"`
+	OutputName string          `json:"outputName"`
+}
+
+var s3Client shared.S3ObjectAPI
+var jobQueue shared.JobQueue
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+	sqsClient, err := shared.NewSQSClient()
+	if err != nil {
+		log.Fatalf("Failed to initialize SQS client: %v", err)
+	}
+	jobQueue = shared.NewSQSJobQueue(sqsClient, os.Getenv("JOB_QUEUE_URL"))
+}
+
+// POST enqueues a new job, GET ?id= reports the status of an existing one
+func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "POST" {
+		return createJob(ctx, request)
+	}
+
+	return getJobStatus(ctx, request)
+}
+
+// Validate the job request, store the job and queue it for the worker
+func createJob(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	var jobRequest JobRequest
+	if err := json.Unmarshal([]byte(request.Body), &jobRequest); err != nil {
+		log.Printf("Error unmarshaling request body: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body"}`,
+		}, nil
+	}
+
+	if jobRequest.Name == "" || len(jobRequest.Pipeline) == 0 {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body structure"}`,
+		}, nil
+	}
+
+	// A job is nothing but a transformation, which callers who need a preset
+	// or a signed URL can't ask for in a request body
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+	if shared.TransformationsRestricted(tenant) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 403,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Transformations are not allowed in jobs"}`,
+		}, nil
+	}
+
+	if shared.IsReservedName(jobRequest.OutputName) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Image name %s is reserved"}`, jobRequest.OutputName),
+		}, nil
+	}
+
+	if err := jobRequest.Pipeline.Validate(); err != nil {
+		body, _ := json.Marshal(map[string]string{"message": "Invalid pipeline: " + err.Error()})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+
+	// Fail fast rather than queueing a job that can never succeed. Internal
+	// objects are never images, so they can't be the source.
+	_, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(jobRequest.Name),
+	})
+	if shared.IsHiddenName(jobRequest.Name) || shared.IsNotFound(err) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Image with name %s not found in S3"}`, jobRequest.Name),
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Error checking for image in S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve object from S3"}`,
+		}, err
+	}
+
+	// Jobs write their output directly, so they may only create new images
+	if jobRequest.OutputName != "" {
+		_, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(jobRequest.OutputName),
+		})
+		if err == nil {
+			body, _ := json.Marshal(map[string]string{"message": "Image with name " + jobRequest.OutputName + " already exists"})
+			return events.APIGatewayProxyResponse{
+				StatusCode: 409,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       string(body),
+			}, nil
+		}
+		if !shared.IsNotFound(err) {
+			log.Printf("Error checking for image in S3: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Error checking for existing image in S3"}`,
+			}, err
+		}
+	}
+
+	id, err := shared.NewJobID()
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to create job"}`,
+		}, err
+	}
+
+	now := time.Now().UTC()
+	job := shared.Job{
+		ID:        id,
+		Tenant:    tenant,
+		SourceKey: jobRequest.Name,
+		Pipeline:  jobRequest.Pipeline,
+		OutputKey: jobRequest.OutputName,
+		Status:    shared.JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := shared.PutJob(context.TODO(), s3Client, bucketName, job); err != nil {
+		log.Printf("Error storing job in S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to create job"}`,
+		}, err
+	}
+
+	if err := jobQueue.Enqueue(context.TODO(), id); err != nil {
+		log.Printf("Error queueing job %s: %v", id, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to queue job"}`,
+		}, err
+	}
+
+	log.Printf("Queued job %s for image %s", id, jobRequest.Name)
+
+	body, _ := json.Marshal(job)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 202,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Report the stored state of a job
+func getJobStatus(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := request.QueryStringParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Missing 'id' parameter in the URL path"}`,
+		}, nil
+	}
+
+	job, err := shared.GetJob(context.TODO(), s3Client, os.Getenv("S3_BUCKET_NAME"), id)
+	if shared.IsNotFound(err) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Job with id %s not found"}`, id),
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving job from S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve job"}`,
+		}, err
+	}
+
+	body, _ := json.Marshal(job)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
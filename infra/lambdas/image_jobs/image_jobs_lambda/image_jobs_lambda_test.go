@@ -0,0 +1,228 @@
+package image_jobs_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestCreateJob(t *testing.T) {
+	resize := shared.Pipeline{{Op: "resize", Params: map[string]string{"width": "200"}}}
+
+	tests := []struct {
+		name           string
+		request        any
+		expectStatus   int
+		expectOutput   string
+		expectResponse string
+	}{
+		{
+			name:         "Queue job",
+			request:      JobRequest{Name: "image.jpg", Pipeline: resize},
+			expectStatus: 202,
+		},
+		{
+			name:         "Queue job with output name",
+			request:      JobRequest{Name: "image.jpg", Pipeline: resize, OutputName: "thumbs/image.jpg"},
+			expectStatus: 202,
+			expectOutput: "thumbs/image.jpg",
+		},
+		{
+			name:           "Output name taken",
+			request:        JobRequest{Name: "image.jpg", Pipeline: resize, OutputName: "image.jpg"},
+			expectStatus:   409,
+			expectResponse: `{"message":"Image with name image.jpg already exists"}`,
+		},
+		{
+			name:           "Invalid pipeline",
+			request:        JobRequest{Name: "image.jpg", Pipeline: shared.Pipeline{{Op: "explode"}}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid pipeline: step 0: unknown operation \"explode\""}`,
+		},
+		{
+			name:           "Missing pipeline",
+			request:        JobRequest{Name: "image.jpg"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body structure"}`,
+		},
+		{
+			name:           "Reserved output name",
+			request:        JobRequest{Name: "image.jpg", Pipeline: resize, OutputName: "records/x"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Image name records/x is reserved"}`,
+		},
+		{
+			name:           "Unknown image",
+			request:        JobRequest{Name: "missing.jpg", Pipeline: resize},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name missing.jpg not found in S3"}`,
+		},
+		{
+			name:           "Internal object",
+			request:        JobRequest{Name: "webhooks/acme/subscriptions/sub.json", Pipeline: resize},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name webhooks/acme/subscriptions/sub.json not found in S3"}`,
+		},
+		{
+			name:           "Invalid request body",
+			request:        "Invalid",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := shared.NewMockS3Client()
+			client.Objects["image.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+			client.Objects["webhooks/acme/subscriptions/sub.json"] = &shared.MockS3Object{Body: []byte("[]")}
+			s3Client = client
+			queue := shared.NewMemoryJobQueue()
+			jobQueue = queue
+
+			bodyJSON, _ := json.Marshal(tc.request)
+			request := events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: string(bodyJSON)}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			queued := queue.Drain()
+			if tc.expectStatus != 202 {
+				if len(queued) != 0 {
+					t.Errorf("Expected no queued jobs, got: %v", queued)
+				}
+				return
+			}
+
+			var job shared.Job
+			if err := json.Unmarshal([]byte(response.Body), &job); err != nil {
+				t.Fatal(err)
+			}
+			if job.Status != shared.JobQueued || len(queued) != 1 || queued[0] != job.ID {
+				t.Errorf("Expected job %s to be queued, got: %+v, %v", job.ID, job, queued)
+			}
+
+			// Without an output name, the worker picks the key once it knows the format
+			if job.OutputKey != tc.expectOutput {
+				t.Errorf("Expected output key %q, got: %q", tc.expectOutput, job.OutputKey)
+			}
+
+			if _, ok := client.Objects[shared.JobKey(job.ID)]; !ok {
+				t.Error("Expected the job to be stored")
+			}
+		})
+	}
+}
+
+func TestJobTransformPolicy(t *testing.T) {
+	resize := shared.Pipeline{{Op: "resize", Params: map[string]string{"width": "200"}}}
+
+	testCases := []struct {
+		name          string
+		presetsOnly   string
+		requireSigned string
+		tenant        string
+		expectStatus  int
+	}{
+		{name: "Allowed", presetsOnly: "public", requireSigned: "public", tenant: "acme", expectStatus: 202},
+		{name: "PresetsOnlyTenant", presetsOnly: "public", expectStatus: 403},
+		{name: "SignedURLsOnlyTenant", requireSigned: "acme", tenant: "acme", expectStatus: 403},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PRESETS_ONLY_TENANTS", tc.presetsOnly)
+			t.Setenv("SIGNED_URLS_ONLY_TENANTS", tc.requireSigned)
+			client := shared.NewMockS3Client()
+			client.Objects["image.jpg"] = &shared.MockS3Object{Body: shared.GenerateJPG(t)}
+			s3Client = client
+			queue := shared.NewMemoryJobQueue()
+			jobQueue = queue
+
+			bodyJSON, _ := json.Marshal(JobRequest{Name: "image.jpg", Pipeline: resize})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				HTTPMethod:     "POST",
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				Body:           string(bodyJSON),
+			})
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d %s", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectStatus == 403 && len(queue.Drain()) != 0 {
+				t.Error("Expected no job to be queued")
+			}
+		})
+	}
+}
+
+func TestGetJobStatus(t *testing.T) {
+	client := shared.NewMockS3Client()
+	shared.PutJob(context.TODO(), client, "", shared.Job{ID: "abc", Status: shared.JobRunning})
+	s3Client = client
+
+	tests := []struct {
+		name           string
+		queryParams    map[string]string
+		expectStatus   int
+		expectResponse string
+	}{
+		{
+			name:         "Known job",
+			queryParams:  map[string]string{"id": "abc"},
+			expectStatus: 200,
+		},
+		{
+			name:           "Unknown job",
+			queryParams:    map[string]string{"id": "xyz"},
+			expectStatus:   404,
+			expectResponse: `{"message": "Job with id xyz not found"}`,
+		},
+		{
+			name:           "Missing 'id' parameter",
+			expectStatus:   400,
+			expectResponse: `{"message": "Missing 'id' parameter in the URL path"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{HTTPMethod: "GET", QueryStringParameters: tc.queryParams}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if tc.expectStatus == 200 {
+				var job shared.Job
+				if err := json.Unmarshal([]byte(response.Body), &job); err != nil {
+					t.Fatal(err)
+				}
+				if job.Status != shared.JobRunning {
+					t.Errorf("Expected status %s, got: %s", shared.JobRunning, job.Status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_jobs/image_jobs_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_jobs_lambda.HandleRequest)
+}
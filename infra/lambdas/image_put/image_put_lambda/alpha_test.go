@@ -0,0 +1,80 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadTransparentImage(t *testing.T) {
+	// A transparent PNG with an opaque square in the middle, like a logo
+	logo := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 16; y < 48; y++ {
+		for x := 16; x < 48; x++ {
+			logo.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+	var logoPNG bytes.Buffer
+	if err := png.Encode(&logoPNG, logo); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name              string
+		policy            shared.AlphaPolicy
+		imageData         []byte
+		expectContentType string
+		expectDiscarded   bool
+	}{
+		{
+			name:              "Flatten",
+			policy:            shared.AlphaFlatten,
+			imageData:         logoPNG.Bytes(),
+			expectContentType: "image/jpeg",
+			expectDiscarded:   true,
+		},
+		{
+			name:              "Keep",
+			policy:            shared.AlphaKeep,
+			imageData:         logoPNG.Bytes(),
+			expectContentType: "image/png",
+		},
+		{
+			name:              "KeepOpaque",
+			policy:            shared.AlphaKeep,
+			imageData:         shared.GenerateJPG(t),
+			expectContentType: "image/jpeg",
+		},
+	}
+
+	defer func() { alphaPolicy = shared.AlphaFlatten }()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			alphaPolicy = tc.policy
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(ImageRequest{ImageData: tc.imageData, ImageName: "logo"})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if response.StatusCode != 200 {
+				t.Fatalf("Upload failed: %d %s", response.StatusCode, response.Body)
+			}
+
+			var imageResponse ImageResponse
+			json.Unmarshal([]byte(response.Body), &imageResponse)
+			if imageResponse.TransparencyDiscarded != tc.expectDiscarded {
+				t.Errorf("Expected transparency discarded %v, got: %s", tc.expectDiscarded, response.Body)
+			}
+			if object := client.Objects["logo"]; object.ContentType != tc.expectContentType {
+				t.Errorf("Expected the image to be stored as %s, got: %s", tc.expectContentType, object.ContentType)
+			}
+		})
+	}
+}
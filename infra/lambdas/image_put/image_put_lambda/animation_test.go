@@ -0,0 +1,82 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadAnimatedGIF(t *testing.T) {
+	client := shared.NewMockS3Client()
+	s3Client = client
+
+	animation := shared.GenerateAnimatedGIF(t)
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: animation, ImageName: "reaction.gif"})
+	response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+	if response.StatusCode != 200 {
+		t.Fatalf("Upload failed: %d %s", response.StatusCode, response.Body)
+	}
+
+	var imageResponse ImageResponse
+	json.Unmarshal([]byte(response.Body), &imageResponse)
+	if !imageResponse.Animated {
+		t.Errorf("Expected the response to report an animation, got: %s", response.Body)
+	}
+
+	object := client.Objects["reaction.gif"]
+	if object.ContentType != "image/gif" || !bytes.Equal(object.Body, animation) {
+		t.Errorf("Expected the animation stored as uploaded, got %s", object.ContentType)
+	}
+}
+
+func TestUploadUnsupportedEncoding(t *testing.T) {
+	testCases := []struct {
+		name           string
+		request        ImageRequest
+		expectStatus   int
+		expectResponse string
+	}{
+		{
+			name:           "AnimationQuality",
+			request:        ImageRequest{ImageData: shared.GenerateAnimatedGIF(t), ImageName: "reaction.gif", Quality: 50},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid encoding options: quality and maxBytes don't apply to animated GIFs, which are stored as uploaded"}`,
+		},
+		{
+			name:           "Progressive",
+			request:        ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "photo.jpg", Progressive: true},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid encoding options: progressive JPEG encoding is not supported"}`,
+		},
+		{
+			name:           "ChromaSubsampling",
+			request:        ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "photo.jpg", ChromaSubsampling: "4:4:4"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid encoding options: only 4:2:0 chroma subsampling is supported"}`,
+		},
+		{
+			name:         "DefaultSubsampling",
+			request:      ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "photo.jpg", ChromaSubsampling: "4:2:0"},
+			expectStatus: 200,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s3Client = shared.NewMockS3Client()
+
+			bodyJSON, _ := json.Marshal(tc.request)
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status %d, got %d %s", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response %s, got %s", tc.expectResponse, response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"os"
+	"shared"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Images processed at once when BATCH_CONCURRENCY is not set
+const defaultBatchConcurrency = 8
+
+// Most images accepted in a single batch request
+const maxBatchSize = 100
+
+// BatchItemResult is the outcome for one image of a batch upload.
+type BatchItemResult struct {
+	Index      int             `json:"index"`
+	ImageName  string          `json:"imageName"`
+	StatusCode int             `json:"statusCode"`
+	Response   json.RawMessage `json:"response"`
+}
+
+// BatchResponse is the body returned for a batch upload.
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+}
+
+// A batch is either a JSON array of ImageRequests or a multipart form with a part per image
+func isBatchRequest(request events.APIGatewayProxyRequest) bool {
+	if strings.HasPrefix(strings.TrimSpace(request.Body), "[") {
+		return true
+	}
+
+	contentType, _ := shared.HeaderValue(request.Headers, "Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	return mediaType == "multipart/form-data"
+}
+
+// Upload every image in the batch, reporting the outcome of each individually
+func handleBatchUpload(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// Conditions are about a single stored image, so they can't be honoured for a whole batch
+	for _, header := range []string{"If-Match", "If-None-Match"} {
+		if _, ok := shared.HeaderValue(request.Headers, header); ok {
+			body, _ := json.Marshal(map[string]string{"message": header + " is not supported for batch uploads"})
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       string(body),
+			}, nil
+		}
+	}
+
+	imageRequests, err := parseBatchRequest(request)
+	if err != nil {
+		log.Printf("Error parsing batch request body: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body"}`,
+		}, nil
+	}
+
+	if len(imageRequests) == 0 {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body structure"}`,
+		}, nil
+	}
+
+	if len(imageRequests) > maxBatchSize {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 413,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Batch contains more than %d images"}`, maxBatchSize),
+		}, nil
+	}
+
+	// Every image belongs to the tenant making the request
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+
+	// Fan the images out over a bounded pool of workers
+	results := make([]BatchItemResult, len(imageRequests))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(batchConcurrency(), len(imageRequests)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = uploadBatchItem(ctx, index, imageRequests[index], tenant)
+			}
+		}()
+	}
+	for index := range imageRequests {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	body, err := json.Marshal(BatchResponse{Results: results})
+	if err != nil {
+		log.Printf("Error marshaling batch response: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to build batch response"}`,
+		}, err
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Upload one image of a batch. Failures are reported in the result rather than failing the batch.
+func uploadBatchItem(ctx context.Context, index int, imageRequest ImageRequest, tenant string) BatchItemResult {
+	response := events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Body:       `{"message": "Invalid request body structure"}`,
+	}
+
+	if len(imageRequest.ImageData) > 0 && imageRequest.ImageName != "" {
+		var err error
+		response, err = uploadImage(ctx, imageRequest, nil, tenant)
+		if err != nil {
+			log.Printf("Error uploading batch item %d: %v", index, err)
+		}
+	}
+
+	// The result embeds the item's body as JSON, so anything else is wrapped as a message
+	body := json.RawMessage(response.Body)
+	if !json.Valid(body) {
+		body, _ = json.Marshal(map[string]string{"message": response.Body})
+	}
+
+	return BatchItemResult{
+		Index:      index,
+		ImageName:  imageRequest.ImageName,
+		StatusCode: response.StatusCode,
+		Response:   body,
+	}
+}
+
+// Read the images out of a JSON array or multipart form body
+func parseBatchRequest(request events.APIGatewayProxyRequest) ([]ImageRequest, error) {
+	var imageRequests []ImageRequest
+
+	contentType, _ := shared.HeaderValue(request.Headers, "Content-Type")
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType != "multipart/form-data" {
+		err := json.Unmarshal([]byte(request.Body), &imageRequests)
+		return imageRequests, err
+	}
+
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reader := multipart.NewReader(strings.NewReader(string(body)), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return imageRequests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		name := part.FileName()
+		if name == "" {
+			name = part.FormName()
+		}
+		imageRequests = append(imageRequests, ImageRequest{ImageData: data, ImageName: name, ContentType: part.Header.Get("Content-Type")})
+	}
+}
+
+// Read the BATCH_CONCURRENCY worker count
+func batchConcurrency() int {
+	concurrency, err := strconv.Atoi(os.Getenv("BATCH_CONCURRENCY"))
+	if err != nil || concurrency <= 0 {
+		return defaultBatchConcurrency
+	}
+
+	return concurrency
+}
@@ -0,0 +1,150 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func multipartBody(t *testing.T, images map[string][]byte) (string, string) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for name, data := range images {
+		part, err := writer.CreateFormFile("image", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	writer.Close()
+
+	return buf.String(), writer.FormDataContentType()
+}
+
+func TestBatchUpload(t *testing.T) {
+	mixedJSON, _ := json.Marshal([]ImageRequest{
+		{ImageData: shared.GenerateJPG(t), ImageName: "first.jpg"},
+		{ImageData: []byte{0x01, 0x02, 0x03}, ImageName: "broken.jpg"},
+		{ImageData: shared.GenerateJPG(t), ImageName: ""},
+		{ImageData: shared.GenerateJPG(t), ImageName: "second.jpg"},
+	})
+	quotedJSON, _ := json.Marshal([]ImageRequest{
+		{ImageData: shared.GenerateJPG(t), ImageName: `records/"quoted".jpg`},
+		{ImageData: shared.GenerateJPG(t), ImageName: `say "cheese".jpg`},
+	})
+	tooMany := make([]ImageRequest, maxBatchSize+1)
+	tooManyJSON, _ := json.Marshal(tooMany)
+	formBody, formContentType := multipartBody(t, map[string][]byte{"form.jpg": shared.GenerateJPG(t)})
+
+	testCases := []struct {
+		name           string
+		body           string
+		headers        map[string]string
+		expectStatus   int
+		expectResults  []int
+		expectResponse string
+		expectStored   []string
+	}{
+		{
+			name:          "JSONArray",
+			body:          string(mixedJSON),
+			expectStatus:  200,
+			expectResults: []int{200, 400, 400, 200},
+			expectStored:  []string{"first.jpg", "second.jpg"},
+		},
+		{
+			name:          "Multipart",
+			body:          formBody,
+			headers:       map[string]string{"content-type": formContentType},
+			expectStatus:  200,
+			expectResults: []int{200},
+			expectStored:  []string{"form.jpg"},
+		},
+		{
+			name:          "QuotedNames",
+			body:          string(quotedJSON),
+			expectStatus:  200,
+			expectResults: []int{400, 200},
+			expectStored:  []string{`say "cheese".jpg`},
+		},
+		{
+			name:           "EmptyBatch",
+			body:           "[]",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body structure"}`,
+		},
+		{
+			name:           "InvalidBatch",
+			body:           "[1, 2]",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body"}`,
+		},
+		{
+			name:           "ConditionalHeader",
+			body:           string(mixedJSON),
+			headers:        map[string]string{"if-none-match": "*"},
+			expectStatus:   400,
+			expectResponse: `{"message":"If-None-Match is not supported for batch uploads"}`,
+		},
+		{
+			name:           "BatchTooLarge",
+			body:           string(tooManyJSON),
+			expectStatus:   413,
+			expectResponse: fmt.Sprintf(`{"message": "Batch contains more than %d images"}`, maxBatchSize),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("BATCH_CONCURRENCY", "2")
+
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			request := events.APIGatewayProxyRequest{
+				Body:    tc.body,
+				Headers: tc.headers,
+			}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if tc.expectResults != nil {
+				var batchResponse BatchResponse
+				if err := json.Unmarshal([]byte(response.Body), &batchResponse); err != nil {
+					t.Fatal(err)
+				}
+				if len(batchResponse.Results) != len(tc.expectResults) {
+					t.Fatalf("Expected %d results, got: %d", len(tc.expectResults), len(batchResponse.Results))
+				}
+				for i, result := range batchResponse.Results {
+					if result.Index != i || result.StatusCode != tc.expectResults[i] {
+						t.Errorf("Expected result %d to have status %d, got: %+v", i, tc.expectResults[i], result)
+					}
+				}
+			}
+
+			for _, name := range tc.expectStored {
+				if _, ok := client.Objects[name]; !ok {
+					t.Errorf("Expected %s to be stored", name)
+				}
+			}
+		})
+	}
+}
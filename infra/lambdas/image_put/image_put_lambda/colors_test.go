@@ -0,0 +1,42 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadColors(t *testing.T) {
+	client := shared.NewMockS3Client()
+	s3Client = client
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Upload failed: %d %s %v", response.StatusCode, response.Body, err)
+	}
+
+	record, err := shared.GetImageRecord(context.TODO(), client, "", "image.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Width != 1080 || record.Height != 1080 {
+		t.Errorf("Expected a 1080x1080 image, got: %dx%d", record.Width, record.Height)
+	}
+	if expected := []shared.PaletteColor{{Color: "#000000", Weight: 1}}; !reflect.DeepEqual(record.Palette, expected) {
+		t.Errorf("Expected palette %+v, got: %+v", expected, record.Palette)
+	}
+
+	// The colours are those of the image as stored
+	stored, err := shared.AnalyzeColors(client.Objects["image.jpg"].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.BlurHash == "" || record.BlurHash != stored.BlurHash {
+		t.Errorf("Expected BlurHash %s, got: %s", stored.BlurHash, record.BlurHash)
+	}
+}
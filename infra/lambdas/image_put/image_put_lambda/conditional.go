@@ -0,0 +1,129 @@
+package image_put_lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"shared"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Values for the NAME_CONFLICT_POLICY environment variable, applied when an
+// upload without conditional headers targets a name that already exists
+const (
+	conflictOverwrite = "overwrite"
+	conflictReject    = "reject"
+	conflictSuffix    = "suffix"
+)
+
+// maxNameSuffix bounds how many "-N" suffixes are tried before giving up
+const maxNameSuffix = 100
+
+var (
+	errImageExists        = errors.New("image already exists")
+	errPreconditionFailed = errors.New("precondition failed")
+)
+
+// Decide which key the upload should be written to, honouring the If-Match
+// and If-None-Match request headers and the server side conflict policy.
+//
+// S3 offers no conditional PutObject in the SDK version we use, so the check
+// and the write are not atomic; this narrows the window for clobbering but
+// cannot close it entirely.
+func resolveImageName(ctx context.Context, s3Client shared.S3ObjectAPI, name string, headers map[string]string) (string, error) {
+	ifMatch, hasIfMatch := shared.HeaderValue(headers, "If-Match")
+	ifNoneMatch, hasIfNoneMatch := shared.HeaderValue(headers, "If-None-Match")
+	policy := os.Getenv("NAME_CONFLICT_POLICY")
+
+	if !hasIfMatch && !hasIfNoneMatch && (policy == "" || policy == conflictOverwrite) {
+		return name, nil
+	}
+
+	etag, exists, err := headImage(ctx, s3Client, name)
+	if err != nil {
+		return "", err
+	}
+
+	// Replace only if the stored image is unchanged
+	if hasIfMatch {
+		if !exists || !etagMatches(ifMatch, etag) {
+			return "", errPreconditionFailed
+		}
+		return name, nil
+	}
+
+	// Create only
+	if hasIfNoneMatch {
+		if exists && (strings.TrimSpace(ifNoneMatch) == "*" || etagMatches(ifNoneMatch, etag)) {
+			return "", errPreconditionFailed
+		}
+		return name, nil
+	}
+
+	if !exists {
+		return name, nil
+	}
+
+	switch policy {
+	case conflictReject:
+		return "", errImageExists
+	case conflictSuffix:
+		return nextFreeName(ctx, s3Client, name)
+	default:
+		log.Printf("Unknown NAME_CONFLICT_POLICY %q, overwriting", policy)
+		return name, nil
+	}
+}
+
+// Find the first "name-N.ext" that is not already taken
+func nextFreeName(ctx context.Context, s3Client shared.S3ObjectAPI, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 1; i <= maxNameSuffix; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		_, exists, err := headImage(ctx, s3Client, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+
+	return "", errImageExists
+}
+
+// Look up the ETag of an existing image, reporting whether it exists
+func headImage(ctx context.Context, s3Client shared.S3ObjectAPI, name string) (string, bool, error) {
+	output, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
+		Key:    aws.String(name),
+	})
+	if shared.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return aws.ToString(output.ETag), true, nil
+}
+
+// Check an If-Match style list of entity tags against an ETag
+func etagMatches(condition string, etag string) bool {
+	for _, candidate := range strings.Split(condition, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+
+	return false
+}
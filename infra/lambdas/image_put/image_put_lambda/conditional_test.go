@@ -0,0 +1,150 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestConditionalUpload(t *testing.T) {
+	testCases := []struct {
+		name           string
+		policy         string
+		headers        map[string]string
+		existing       []string
+		expectStatus   int
+		expectName     string
+		expectResponse string
+	}{
+		{
+			name:         "OverwriteByDefault",
+			existing:     []string{"image.jpg"},
+			expectStatus: 200,
+			expectName:   "image.jpg",
+		},
+		{
+			name:         "IfNoneMatchNewImage",
+			headers:      map[string]string{"if-none-match": "*"},
+			expectStatus: 200,
+			expectName:   "image.jpg",
+		},
+		{
+			name:           "IfNoneMatchExistingImage",
+			headers:        map[string]string{"If-None-Match": "*"},
+			existing:       []string{"image.jpg"},
+			expectStatus:   412,
+			expectResponse: `{"message":"Precondition failed for image with name image.jpg"}`,
+		},
+		{
+			name:           "IfMatchStaleETag",
+			headers:        map[string]string{"If-Match": `"stale"`},
+			existing:       []string{"image.jpg"},
+			expectStatus:   412,
+			expectResponse: `{"message":"Precondition failed for image with name image.jpg"}`,
+		},
+		{
+			name:           "IfMatchMissingImage",
+			headers:        map[string]string{"If-Match": "*"},
+			expectStatus:   412,
+			expectResponse: `{"message":"Precondition failed for image with name image.jpg"}`,
+		},
+		{
+			name:           "RejectPolicy",
+			policy:         conflictReject,
+			existing:       []string{"image.jpg"},
+			expectStatus:   409,
+			expectResponse: `{"message":"Image with name image.jpg already exists"}`,
+		},
+		{
+			name:         "RejectPolicyNewImage",
+			policy:       conflictReject,
+			expectStatus: 200,
+			expectName:   "image.jpg",
+		},
+		{
+			name:         "SuffixPolicy",
+			policy:       conflictSuffix,
+			existing:     []string{"image.jpg", "image-1.jpg"},
+			expectStatus: 200,
+			expectName:   "image-2.jpg",
+		},
+		{
+			name:           "SuffixPolicyWithIfNoneMatch",
+			policy:         conflictSuffix,
+			headers:        map[string]string{"If-None-Match": "*"},
+			existing:       []string{"image.jpg"},
+			expectStatus:   412,
+			expectResponse: `{"message":"Precondition failed for image with name image.jpg"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("NAME_CONFLICT_POLICY", tc.policy)
+
+			client := shared.NewMockS3Client()
+			for _, name := range tc.existing {
+				client.Objects[name] = &shared.MockS3Object{Body: []byte("existing"), ETag: `"existing"`}
+			}
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+			request := events.APIGatewayProxyRequest{
+				Body:    string(bodyJSON),
+				Headers: tc.headers,
+			}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if tc.expectName != "" {
+				var imageResponse ImageResponse
+				if err := json.Unmarshal([]byte(response.Body), &imageResponse); err != nil {
+					t.Fatal(err)
+				}
+				if imageResponse.Name != tc.expectName {
+					t.Errorf("Expected image name %s, got: %s", tc.expectName, imageResponse.Name)
+				}
+				if string(client.Objects[tc.expectName].Body) == "existing" {
+					t.Errorf("Expected %s to hold the uploaded image", tc.expectName)
+				}
+				if response.Headers["ETag"] != client.Objects[tc.expectName].ETag {
+					t.Errorf("Expected ETag %s, got: %s", client.Objects[tc.expectName].ETag, response.Headers["ETag"])
+				}
+			}
+		})
+	}
+}
+
+func TestIfMatchCurrentETag(t *testing.T) {
+	client := shared.NewMockS3Client()
+	s3Client = client
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	first, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+
+	request := events.APIGatewayProxyRequest{
+		Body:    string(bodyJSON),
+		Headers: map[string]string{"If-Match": first.Headers["ETag"]},
+	}
+	response, err := HandleRequest(context.Background(), request)
+	if err != nil {
+		t.Errorf("Handler returned an error: %v", err)
+	}
+	if response.StatusCode != 200 {
+		t.Errorf("Expected status code 200, got: %d", response.StatusCode)
+	}
+}
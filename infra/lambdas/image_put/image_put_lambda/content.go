@@ -0,0 +1,46 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"shared"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Whether DEDUPLICATE_UPLOADS asks for content-addressed storage
+func deduplicateUploads() bool {
+	return os.Getenv("DEDUPLICATE_UPLOADS") == "true"
+}
+
+// Store the image bytes once under their content hash and point name at them,
+// reporting whether the bytes were already stored by an earlier upload
+func uploadImageByContent(ctx context.Context, s3Client shared.S3ObjectAPI, imageData []byte, name string) (*s3.PutObjectOutput, string, bool, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+	hash := shared.ContentHash(imageData)
+
+	_, duplicate, err := headImage(ctx, s3Client, shared.ContentKey(hash))
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if !duplicate {
+		if _, err := uploadImageToS3(ctx, s3Client, imageData, shared.ContentKey(hash)); err != nil {
+			return nil, "", false, err
+		}
+	}
+
+	// The pointer's body is the hash so that its ETag changes with the content
+	output, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(name),
+		Body:        bytes.NewReader([]byte(hash)),
+		ContentType: aws.String(http.DetectContentType(imageData)),
+		Metadata:    map[string]string{shared.ContentHashMetadataKey: hash},
+	})
+
+	return output, hash, duplicate, err
+}
@@ -0,0 +1,86 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"shared"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestDeduplicatedUpload(t *testing.T) {
+	t.Setenv("DEDUPLICATE_UPLOADS", "true")
+
+	client := shared.NewMockS3Client()
+	s3Client = client
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	copyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "copy.jpg"})
+
+	testCases := []struct {
+		name            string
+		body            []byte
+		expectDuplicate bool
+	}{
+		{name: "FirstUpload", body: bodyJSON, expectDuplicate: false},
+		{name: "SameImageNewName", body: copyJSON, expectDuplicate: true},
+	}
+
+	var hashes []string
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(tc.body)})
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+			if response.StatusCode != 200 {
+				t.Errorf("Expected status code 200, got: %d", response.StatusCode)
+			}
+
+			var imageResponse ImageResponse
+			if err := json.Unmarshal([]byte(response.Body), &imageResponse); err != nil {
+				t.Fatal(err)
+			}
+			if imageResponse.Duplicate != tc.expectDuplicate {
+				t.Errorf("Expected duplicate: %v, got: %v", tc.expectDuplicate, imageResponse.Duplicate)
+			}
+
+			pointer := client.Objects[imageResponse.Name]
+			if pointer == nil || pointer.Metadata[shared.ContentHashMetadataKey] != imageResponse.ContentHash {
+				t.Errorf("Expected %s to point at content %s", imageResponse.Name, imageResponse.ContentHash)
+			}
+			hashes = append(hashes, imageResponse.ContentHash)
+		})
+	}
+
+	if hashes[0] != hashes[1] {
+		t.Errorf("Expected identical images to share a content hash, got: %v", hashes)
+	}
+
+	stored := 0
+	for key := range client.Objects {
+		if strings.HasPrefix(key, shared.ContentKey("")) {
+			stored++
+		}
+	}
+	if stored != 1 {
+		t.Errorf("Expected image bytes to be stored once, got: %d copies", stored)
+	}
+}
+
+func TestReservedImageName(t *testing.T) {
+	s3Client = shared.NewMockS3Client()
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: shared.ContentKey("abc")})
+	response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+	if err != nil {
+		t.Errorf("Handler returned an error: %v", err)
+	}
+
+	expectResponse := `{"message":"Image name content/abc is reserved"}`
+	if response.StatusCode != 400 || response.Body != expectResponse {
+		t.Errorf("Expected 400 %s, got: %d %s", expectResponse, response.StatusCode, response.Body)
+	}
+}
@@ -0,0 +1,61 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadFormats(t *testing.T) {
+	testCases := []struct {
+		name           string
+		format         string
+		accepted       string
+		expectStatus   int
+		expectResponse string
+	}{
+		{name: "WebP", format: "webp", expectStatus: 200},
+		{name: "TIFF", format: "tiff", expectStatus: 200},
+		{name: "BMP", format: "bmp", expectStatus: 200},
+		{
+			name:           "NotAccepted",
+			format:         "bmp",
+			accepted:       "jpeg,webp",
+			expectStatus:   400,
+			expectResponse: `{"message":"Image format bmp is not accepted, accepted formats are jpeg, webp","acceptedFormats":["jpeg","webp"]}`,
+		},
+	}
+
+	defer func() { acceptedFormats = shared.AcceptedFormats(shared.SupportedFormats) }()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			if acceptedFormats, err = shared.ParseAcceptedFormats(tc.accepted); err != nil {
+				t.Fatal(err)
+			}
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateImage(t, tc.format), ImageName: "upload"})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status %d, got %d %s", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectStatus != 200 {
+				if response.Body != tc.expectResponse {
+					t.Errorf("Expected response %s, got %s", tc.expectResponse, response.Body)
+				}
+				return
+			}
+
+			// Every format is stored as JPEG
+			if contentType := http.DetectContentType(client.Objects["upload"].Body); contentType != "image/jpeg" {
+				t.Errorf("Expected the upload stored as JPEG, got %s", contentType)
+			}
+		})
+	}
+}
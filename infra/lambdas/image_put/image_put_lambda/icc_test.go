@@ -0,0 +1,74 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadICCProfile(t *testing.T) {
+	testCases := []struct {
+		name          string
+		embed         string
+		expectProfile bool
+		expectRed     uint32
+	}{
+		{name: "Convert", embed: "false", expectRed: 215},
+		{name: "Embed", embed: "true", expectProfile: true, expectRed: 200},
+	}
+
+	defer func() { renditions = nil }()
+	renditions = shared.Renditions{"small": {{Op: "resize", Params: map[string]string{"width": "32"}}}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("EMBED_ICC_PROFILES", tc.embed)
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(ImageRequest{
+				ImageData: shared.GenerateJPGWithICCProfile(t, color.NRGBA{200, 100, 50, 255}),
+				ImageName: "p3.jpg",
+			})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if response.StatusCode != 200 {
+				t.Fatalf("Upload failed: %d %s", response.StatusCode, response.Body)
+			}
+
+			original := client.Objects["p3.jpg"].Body
+			if (shared.ReadICCProfile(original) != nil) != tc.expectProfile {
+				t.Errorf("Expected the original to carry a profile %v", tc.expectProfile)
+			}
+			if red := redAt(t, original); red < tc.expectRed-3 || red > tc.expectRed+3 {
+				t.Errorf("Expected the original's red near %d, got %d", tc.expectRed, red)
+			}
+
+			// Renditions are always sRGB, whichever way the original was stored
+			rendition := client.Objects[shared.RenditionKey("p3.jpg", "small")].Body
+			if shared.ReadICCProfile(rendition) != nil {
+				t.Error("Expected the rendition to carry no profile")
+			}
+			if red := redAt(t, rendition); red < 212 || red > 218 {
+				t.Errorf("Expected the rendition converted to sRGB, got red %d", red)
+			}
+		})
+	}
+}
+
+// The 8 bit red of the middle pixel of an encoded image
+func redAt(t *testing.T, data []byte) uint32 {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := img.Bounds()
+	r, _, _, _ := img.At(bounds.Dx()/2, bounds.Dy()/2).RGBA()
+
+	return r >> 8
+}
@@ -0,0 +1,156 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"shared"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// How long a result is replayed for when IDEMPOTENCY_TTL is not set
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// How long a key is held while its request runs. This is longer than the
+// lambda's timeout, so a claim left behind by an attempt that died frees the
+// key once that attempt can no longer be running.
+const pendingIdempotencyTTL = 2 * time.Minute
+
+// Longest Idempotency-Key header value accepted
+const maxIdempotencyKeyLength = 255
+
+// Replay the recorded result for a retried request, or run the upload and
+// record its result. Keys belong to the tenant, so tenants can't replay each
+// other's results. The key is claimed before the upload runs, and attempts
+// arriving meanwhile are told to retry later rather than run it again, which
+// under NAME_CONFLICT_POLICY=suffix would store the image twice.
+func handleIdempotentUpload(ctx context.Context, request events.APIGatewayProxyRequest, key string) (events.APIGatewayProxyResponse, error) {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid Idempotency-Key header"}`,
+		}, nil
+	}
+
+	payloadHash := idempotencyPayloadHash(request)
+	tenantKey := shared.Tenant(request.RequestContext.Authorizer) + "/" + key
+
+	record, err := idempotencyStore.Get(ctx, tenantKey)
+	if err != nil {
+		log.Printf("Error reading idempotency record: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Error reading idempotency record"}`,
+		}, err
+	}
+
+	if record != nil {
+		if record.PayloadHash != payloadHash {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 422,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Idempotency-Key has already been used with a different request"}`,
+			}, nil
+		}
+
+		if record.Pending {
+			return idempotencyInProgress(), nil
+		}
+
+		log.Printf("Replaying result for idempotency key %s", key)
+		headers := map[string]string{"Idempotent-Replayed": "true"}
+		for name, value := range record.Headers {
+			headers[name] = value
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: record.StatusCode,
+			Headers:    headers,
+			Body:       record.Body,
+		}, nil
+	}
+
+	claimed, err := idempotencyStore.Claim(ctx, shared.IdempotencyRecord{
+		Key:         tenantKey,
+		PayloadHash: payloadHash,
+		ExpiresAt:   time.Now().Add(pendingIdempotencyTTL),
+	})
+	if err != nil {
+		log.Printf("Error claiming idempotency key: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Error recording idempotency record"}`,
+		}, err
+	}
+	if !claimed {
+		return idempotencyInProgress(), nil
+	}
+
+	response, err := handleUpload(ctx, request)
+
+	// Server errors are not recorded so that the retry gets another go
+	if err != nil || response.StatusCode >= 500 {
+		if err := idempotencyStore.Release(ctx, tenantKey); err != nil {
+			log.Printf("Error releasing idempotency key: %v", err)
+		}
+		return response, err
+	}
+
+	var imageResponse ImageResponse
+	_ = json.Unmarshal([]byte(response.Body), &imageResponse)
+
+	if err := idempotencyStore.Put(ctx, shared.IdempotencyRecord{
+		Key:         tenantKey,
+		PayloadHash: payloadHash,
+		StatusCode:  response.StatusCode,
+		Headers:     response.Headers,
+		Body:        response.Body,
+		Name:        imageResponse.Name,
+		ExpiresAt:   time.Now().Add(idempotencyTTL()),
+	}); err != nil {
+		// The upload has happened, so report it rather than failing the request
+		log.Printf("Error recording idempotency record: %v", err)
+	}
+
+	return response, nil
+}
+
+// Another attempt with the same key is running, and its result will be
+// replayed once it has finished
+func idempotencyInProgress() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: 409,
+		Headers:    map[string]string{"Content-Type": "application/json", "Retry-After": "1"},
+		Body:       `{"message": "A request with this Idempotency-Key is already in progress"}`,
+	}
+}
+
+// Hash what decides the upload's result: the body and the conditional headers.
+// Header values can't hold a newline, so the parts can't run into each other.
+func idempotencyPayloadHash(request events.APIGatewayProxyRequest) string {
+	payload := ""
+	for _, header := range []string{"If-Match", "If-None-Match"} {
+		if value, ok := shared.HeaderValue(request.Headers, header); ok {
+			payload += header + ": " + value
+		}
+		payload += "\n"
+	}
+
+	return shared.ContentHash([]byte(payload + request.Body))
+}
+
+// Read the IDEMPOTENCY_TTL duration, e.g. "24h"
+func idempotencyTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultIdempotencyTTL
+	}
+
+	return ttl
+}
@@ -0,0 +1,186 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"shared"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestIdempotentUpload(t *testing.T) {
+	t.Setenv("NAME_CONFLICT_POLICY", conflictSuffix)
+
+	client := shared.NewMockS3Client()
+	s3Client = client
+	idempotencyStore = shared.NewMemoryIdempotencyStore()
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	otherJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "other.jpg"})
+
+	testCases := []struct {
+		name           string
+		key            string
+		tenant         string
+		headers        map[string]string
+		inProgress     bool
+		body           []byte
+		expectStatus   int
+		expectName     string
+		expectReplayed bool
+		expectResponse string
+	}{
+		{
+			name:         "FirstAttempt",
+			key:          "upload-1",
+			body:         bodyJSON,
+			expectStatus: 200,
+			expectName:   "image.jpg",
+		},
+		{
+			name:           "Retry",
+			key:            "upload-1",
+			body:           bodyJSON,
+			expectStatus:   200,
+			expectName:     "image.jpg",
+			expectReplayed: true,
+		},
+		{
+			name:           "MismatchedPayload",
+			key:            "upload-1",
+			body:           otherJSON,
+			expectStatus:   422,
+			expectResponse: `{"message": "Idempotency-Key has already been used with a different request"}`,
+		},
+		{
+			name:           "MismatchedConditionalHeader",
+			key:            "upload-1",
+			body:           bodyJSON,
+			headers:        map[string]string{"If-None-Match": "*"},
+			expectStatus:   422,
+			expectResponse: `{"message": "Idempotency-Key has already been used with a different request"}`,
+		},
+		{
+			name:         "SameKeyOtherTenant",
+			key:          "upload-1",
+			tenant:       "acme",
+			body:         bodyJSON,
+			expectStatus: 200,
+			expectName:   "image-1.jpg",
+		},
+		{
+			name:         "NewKey",
+			key:          "upload-2",
+			body:         bodyJSON,
+			expectStatus: 200,
+			expectName:   "image-2.jpg",
+		},
+		{
+			name:           "InProgress",
+			key:            "upload-3",
+			inProgress:     true,
+			body:           bodyJSON,
+			expectStatus:   409,
+			expectResponse: `{"message": "A request with this Idempotency-Key is already in progress"}`,
+		},
+		{
+			name:           "EmptyKey",
+			key:            "",
+			body:           bodyJSON,
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid Idempotency-Key header"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string]string{"Idempotency-Key": tc.key}
+			for name, value := range tc.headers {
+				headers[name] = value
+			}
+			request := events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				Body:           string(tc.body),
+				Headers:        headers,
+			}
+
+			// Another attempt holds the key while it uploads
+			if tc.inProgress {
+				record := shared.IdempotencyRecord{Key: shared.AnonymousTenant + "/" + tc.key, PayloadHash: idempotencyPayloadHash(request), ExpiresAt: time.Now().Add(time.Minute)}
+				if _, err := idempotencyStore.Claim(context.TODO(), record); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+			if tc.inProgress && response.Headers["Retry-After"] == "" {
+				t.Error("Expected a Retry-After header")
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if replayed := response.Headers["Idempotent-Replayed"] == "true"; replayed != tc.expectReplayed {
+				t.Errorf("Expected replayed: %v, got: %v", tc.expectReplayed, replayed)
+			}
+
+			if tc.expectName != "" {
+				var imageResponse ImageResponse
+				if err := json.Unmarshal([]byte(response.Body), &imageResponse); err != nil {
+					t.Fatal(err)
+				}
+				if imageResponse.Name != tc.expectName {
+					t.Errorf("Expected image name %s, got: %s", tc.expectName, imageResponse.Name)
+				}
+			}
+		})
+	}
+
+	// The retry must not have written another copy under a suffixed name
+	if _, ok := client.Objects["image-3.jpg"]; ok {
+		t.Error("Expected the retry not to upload the image again")
+	}
+}
+
+func TestConcurrentIdempotentUploads(t *testing.T) {
+	t.Setenv("NAME_CONFLICT_POLICY", conflictSuffix)
+
+	client := shared.NewMockS3Client()
+	s3Client = client
+	idempotencyStore = shared.NewMemoryIdempotencyStore()
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	request := events.APIGatewayProxyRequest{Body: string(bodyJSON), Headers: map[string]string{"Idempotency-Key": "upload-1"}}
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 4)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, _ := HandleRequest(context.Background(), request)
+			statuses[i] = response.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	for _, status := range statuses {
+		if status != 200 && status != 409 {
+			t.Errorf("Expected the upload or an in progress conflict, got: %v", statuses)
+		}
+	}
+	if _, ok := client.Objects["image-1.jpg"]; ok {
+		t.Error("Expected the image to be stored once")
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
 	"log"
+	"net/http"
 	"os"
 	"shared"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,11 +19,45 @@ import (
 )
 
 type ImageRequest struct {
-	ImageData []byte `json:"imageData"`
-	ImageName string `json:"imageName"`
+	ImageData  []byte             `json:"imageData"`
+	ImageName  string             `json:"imageName"`
+	FocalPoint *shared.FocalPoint `json:"focalPoint,omitempty"`
+	// JPEG quality to store the image at, within the server's bounds
+	Quality int `json:"quality,omitempty"`
+	// Largest size in bytes to store the image at, lowering quality to fit
+	MaxBytes int `json:"maxBytes,omitempty"`
+	// Only baseline JPEGs with 4:2:0 chroma subsampling can be written, so
+	// progressive=true or any other subsampling is rejected
+	Progressive       bool   `json:"progressive,omitempty"`
+	ChromaSubsampling string `json:"chromaSubsampling,omitempty"`
+	// Content type the client says the image is, checked against the data
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// ImageResponse is the body returned for a successful upload.
+type ImageResponse struct {
+	Message     string            `json:"message"`
+	Name        string            `json:"name"`
+	ContentHash string            `json:"contentHash,omitempty"`
+	Duplicate   bool              `json:"duplicate,omitempty"`
+	Renditions  map[string]string `json:"renditions,omitempty"`
+	// Set when transparent pixels were flattened onto the background colour
+	TransparencyDiscarded bool `json:"transparencyDiscarded,omitempty"`
+	// Set when the image is an animated GIF, stored with all its frames
+	Animated bool `json:"animated,omitempty"`
+	// Format the uploaded data was found to be in, whatever its name or content type said
+	Format string `json:"format,omitempty"`
 }
 
 var s3Client shared.S3ObjectAPI
+var idempotencyStore shared.IdempotencyStore
+var webhookQueue shared.WebhookQueue
+var renditions shared.Renditions
+var quality shared.QualityPolicy
+var alphaPolicy shared.AlphaPolicy
+var background color.Color = shared.DefaultBackground
+var metadataPolicies shared.MetadataPolicies
+var acceptedFormats = shared.AcceptedFormats(shared.SupportedFormats)
 
 func init() {
 	var err error
@@ -26,9 +65,55 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to initialize S3 client: %v", err)
 	}
+	idempotencyStore = shared.NewS3IdempotencyStore(s3Client, os.Getenv("S3_BUCKET_NAME"))
+	sqsClient, err := shared.NewSQSClient()
+	if err != nil {
+		log.Fatalf("Failed to initialize SQS client: %v", err)
+	}
+	webhookQueue = shared.NewSQSWebhookQueue(sqsClient, os.Getenv("WEBHOOK_QUEUE_URL"))
+	renditions, err = shared.ParseRenditions(os.Getenv("RENDITIONS"))
+	if err != nil {
+		log.Fatalf("Invalid RENDITIONS: %v", err)
+	}
+	quality, err = shared.LoadQualityPolicy()
+	if err != nil {
+		log.Fatalf("Invalid JPEG quality settings: %v", err)
+	}
+	alphaPolicy, err = shared.ParseAlphaPolicy(os.Getenv("ALPHA_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid ALPHA_POLICY: %v", err)
+	}
+	if value := os.Getenv("ALPHA_BACKGROUND"); value != "" {
+		if background, err = shared.ParseHexColor(value); err != nil {
+			log.Fatalf("Invalid ALPHA_BACKGROUND: %v", err)
+		}
+	}
+	metadataPolicies, err = shared.ParseMetadataPolicies(os.Getenv("METADATA_POLICIES"))
+	if err != nil {
+		log.Fatalf("Invalid METADATA_POLICIES: %v", err)
+	}
+	acceptedFormats, err = shared.ParseAcceptedFormats(os.Getenv("ACCEPTED_FORMATS"))
+	if err != nil {
+		log.Fatalf("Invalid ACCEPTED_FORMATS: %v", err)
+	}
 }
 
 func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// Retried requests carrying an Idempotency-Key are answered from the first result
+	if key, ok := shared.HeaderValue(request.Headers, "Idempotency-Key"); ok {
+		return handleIdempotentUpload(ctx, request, key)
+	}
+
+	return handleUpload(ctx, request)
+}
+
+// Validate and store the uploaded image
+func handleUpload(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// Several images may be sent at once as a JSON array or multipart form
+	if isBatchRequest(request) {
+		return handleBatchUpload(ctx, request)
+	}
+
 	// Unmarshal the request body into an ImageRequest struct
 	var imageRequest ImageRequest
 	if err := json.Unmarshal([]byte(request.Body), &imageRequest); err != nil {
@@ -49,18 +134,137 @@ func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		}, nil
 	}
 
-	// Check if image can be converted to jpeg
-	jpeg, err := shared.TryConvertToJPEG(imageRequest.ImageData)
+	return uploadImage(ctx, imageRequest, request.Headers, shared.Tenant(request.RequestContext.Authorizer))
+}
+
+// Validate a single image, store it for the tenant, and build the response for it.
+// The headers may carry If-Match or If-None-Match conditions.
+func uploadImage(ctx context.Context, imageRequest ImageRequest, headers map[string]string, tenant string) (events.APIGatewayProxyResponse, error) {
+	// Names under internal prefixes would overwrite stored content
+	if shared.IsReservedName(imageRequest.ImageName) {
+		body, _ := json.Marshal(map[string]string{"message": "Image name " + imageRequest.ImageName + " is reserved"})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+
+	// Crops with "focal" gravity keep this point of the image
+	if imageRequest.FocalPoint != nil {
+		if err := imageRequest.FocalPoint.Validate(); err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Invalid focal point"}`,
+			}, nil
+		}
+	}
+
+	options, err := quality.Options(imageRequest.Quality, imageRequest.MaxBytes)
+	if err == nil {
+		err = shared.CheckJPEGSettings(imageRequest.Progressive, imageRequest.ChromaSubsampling)
+	}
+	if err == nil && (imageRequest.Quality != 0 || imageRequest.MaxBytes != 0) && shared.IsAnimated(imageRequest.ImageData) {
+		err = errors.New("quality and maxBytes don't apply to animated GIFs, which are stored as uploaded")
+	}
 	if err != nil {
-		log.Printf("Error converting image to JPEG: %v", err)
+		body, _ := json.Marshal(map[string]string{"message": "Invalid encoding options: " + err.Error()})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+
+	// Only formats on the accepted list are stored, going by the data rather than the name
+	format := shared.SniffFormat(imageRequest.ImageData)
+	if format == "" {
+		return invalidImage("Invalid image"), nil
+	}
+	if !acceptedFormats.Accepts(format) {
+		return invalidImage(fmt.Sprintf("Image format %s is not accepted", format)), nil
+	}
+	if err := shared.CheckDeclaredFormat(format, imageRequest.ImageName, imageRequest.ContentType); err != nil {
+		body, _ := json.Marshal(map[string]string{"message": "Image format doesn't match: " + err.Error()})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+
+	// Data after the end of the image could let the upload pass as another kind of file
+	trimmed, err := shared.TrimTrailingData(imageRequest.ImageData, format)
+	if err != nil {
+		log.Printf("Rejecting image %s: %v", imageRequest.ImageName, err)
+		body, _ := json.Marshal(map[string]string{"message": "Invalid image: " + err.Error()})
 		return events.APIGatewayProxyResponse{
 			StatusCode: 400,
 			Headers:    map[string]string{"Content-Type": "application/json"},
-			Body:       `{"message": "Invalid image"}`,
+			Body:       string(body),
+		}, nil
+	}
+	if dropped := len(imageRequest.ImageData) - len(trimmed); dropped > 0 {
+		log.Printf("Dropping %d bytes after the end of image %s", dropped, imageRequest.ImageName)
+		imageRequest.ImageData = trimmed
+	}
+
+	// Check if image can be converted to jpeg, or kept as PNG for its transparency
+	options.Background = background
+	options.KeepICCProfile = os.Getenv("EMBED_ICC_PROFILES") == "true"
+	converted, err := shared.ConvertImage(imageRequest.ImageData, options, alphaPolicy)
+	if err != nil {
+		log.Printf("Error converting image to JPEG: %v", err)
+		return invalidImage("Invalid image"), nil
+	}
+
+	// Re-encoding drops metadata, so put back what the tenant's policy keeps
+	policy := metadataPolicies.For(tenant)
+	converted.Data = shared.CopyMetadata(converted.Data, imageRequest.ImageData, policy)
+
+	// Work out where the image should go without clobbering anyone else's upload
+	name, err := resolveImageName(context.TODO(), s3Client, imageRequest.ImageName, headers)
+	if errors.Is(err, errImageExists) {
+		body, _ := json.Marshal(map[string]string{"message": "Image with name " + imageRequest.ImageName + " already exists"})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 409,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+	if errors.Is(err, errPreconditionFailed) {
+		body, _ := json.Marshal(map[string]string{"message": "Precondition failed for image with name " + imageRequest.ImageName})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 412,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
 		}, nil
 	}
+	if err != nil {
+		log.Printf("Error checking for existing image in S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Error checking for existing image in S3"}`,
+		}, err
+	}
+
+	imageResponse := ImageResponse{
+		Message:               "Image received, is valid, and has been uploaded to S3.",
+		Name:                  name,
+		TransparencyDiscarded: converted.TransparencyDiscarded,
+		Animated:              converted.Animated,
+		Format:                format,
+	}
 
-	if err := uploadImageToS3(context.TODO(), s3Client, jpeg, imageRequest.ImageName); err != nil {
+	var output *s3.PutObjectOutput
+	if deduplicateUploads() {
+		output, imageResponse.ContentHash, imageResponse.Duplicate, err = uploadImageByContent(context.TODO(), s3Client, converted.Data, name)
+	} else {
+		output, err = uploadImageToS3(context.TODO(), s3Client, converted.Data, name)
+	}
+	if err != nil {
 		log.Printf("Error uploading image to S3: %v", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
@@ -71,24 +275,107 @@ func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 
 	log.Println("Image successfully uploaded to S3.")
 
+	// Keep a record of the upload so it can be searched for later
+	if err := recordImageUpload(context.TODO(), s3Client, converted.Data, name, imageRequest.FocalPoint); err != nil {
+		log.Printf("Error recording image in S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Error recording image in S3"}`,
+		}, err
+	}
+
+	// The camera, time and location details are kept alongside, as far as the policy allows
+	metadata := shared.ReadMetadata(imageRequest.ImageData).WithPolicy(policy)
+	if err := shared.PutImageMetadata(context.TODO(), s3Client, os.Getenv("S3_BUCKET_NAME"), name, metadata); err != nil {
+		log.Printf("Error storing image metadata in S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Error storing image metadata in S3"}`,
+		}, err
+	}
+
+	// Derivatives are made now so that reads can serve them straight from storage
+	imageResponse.Renditions, err = storeRenditions(context.TODO(), s3Client, converted.Data, name, imageRequest.FocalPoint, policy)
+	if err != nil {
+		log.Printf("Error generating renditions: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Error generating renditions"}`,
+		}, err
+	}
+
+	notifyWebhooks(ctx, tenant, shared.EventImageUploaded, shared.ImageEventData{
+		Name:        name,
+		ContentHash: shared.ContentHash(converted.Data),
+	})
+
+	body, _ := json.Marshal(imageResponse)
+
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
-		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       `{"message": "Image received, is valid, and has been uploaded to S3."}`,
+		Headers:    map[string]string{"Content-Type": "application/json", "ETag": aws.ToString(output.ETag)},
+		Body:       string(body),
 	}, nil
 }
 
+// Reject an upload that isn't an image in an accepted format, listing the formats that are
+func invalidImage(message string) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(struct {
+		Message         string   `json:"message"`
+		AcceptedFormats []string `json:"acceptedFormats"`
+	}{
+		Message:         fmt.Sprintf("%s, accepted formats are %s", message, acceptedFormats),
+		AcceptedFormats: acceptedFormats,
+	})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
+
 // Upload the image to Amazon S3
-func uploadImageToS3(ctx context.Context, s3Client shared.S3ObjectAPI, imageData []byte, name string) error {
+func uploadImageToS3(ctx context.Context, s3Client shared.S3ObjectAPI, imageData []byte, name string) (*s3.PutObjectOutput, error) {
 	bucketName := os.Getenv("S3_BUCKET_NAME")
 	log.Printf("bucketName: %s", bucketName)
 
-	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+	output, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(bucketName),
 		Key:         aws.String(name),
 		Body:        bytes.NewReader(imageData),
-		ContentType: aws.String("image/jpeg"),
+		ContentType: aws.String(http.DetectContentType(imageData)),
+	})
+
+	return output, err
+}
+
+// Store the record describing an uploaded image
+func recordImageUpload(ctx context.Context, s3Client shared.S3ObjectAPI, imageData []byte, name string, focalPoint *shared.FocalPoint) error {
+	perceptualHash, err := shared.PerceptualHash(imageData)
+	if err != nil {
+		return err
+	}
+	colors, err := shared.AnalyzeColors(imageData)
+	if err != nil {
+		return err
+	}
+
+	return shared.PutImageRecord(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), shared.ImageRecord{
+		Name:           name,
+		ContentHash:    shared.ContentHash(imageData),
+		PerceptualHash: perceptualHash,
+		FocalPoint:     focalPoint,
+		UploadedAt:     time.Now().UTC(),
+		ImageColors:    colors,
 	})
+}
 
-	return err
+// Queue an event for the tenant's webhooks. Queueing problems never fail the request.
+func notifyWebhooks(ctx context.Context, tenant string, eventType string, data shared.ImageEventData) {
+	if err := shared.QueueWebhookEvent(ctx, webhookQueue, tenant, eventType, data); err != nil {
+		log.Printf("Error queueing webhook event %s: %v", eventType, err)
+	}
 }
@@ -11,10 +11,24 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+func init() {
+	webhookQueue = shared.NewMemoryWebhookQueue()
+}
+
 type mockPutObjectAPI func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 
-// GetObject implements shared.S3ObjectAPI.
+// GetObject implements shared.S3ObjectAPI. Nothing can be read back, so every key is missing.
 func (mockPutObjectAPI) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return nil, shared.NotFoundError()
+}
+
+// ListObjectsV2 implements shared.S3ObjectAPI.
+func (mockPutObjectAPI) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	panic("unimplemented")
+}
+
+// HeadObject implements shared.S3ObjectAPI.
+func (mockPutObjectAPI) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
 	panic("unimplemented")
 }
 
@@ -53,7 +67,7 @@ func TestUploadImageToS3(t *testing.T) {
 				})
 			}
 
-			err := uploadImageToS3(context.TODO(), client(), tc.imageData, "image.jpg")
+			_, err := uploadImageToS3(context.TODO(), client(), tc.imageData, "image.jpg")
 			if (err != nil) != tc.expectErr {
 				t.Errorf("Expected error: %v, got: %v", tc.expectErr, err)
 			}
@@ -71,11 +85,23 @@ func TestHandler(t *testing.T) {
 	}{
 		{
 			name:            "ValidImageRequest",
-			requestBody:     ImageRequest{shared.GenerateJPG(t), "image.jpg"},
+			requestBody:     ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"},
 			expectStatus:    200,
-			expectResponse:  `{"message": "Image received, is valid, and has been uploaded to S3."}`,
+			expectResponse:  `{"message":"Image received, is valid, and has been uploaded to S3.","name":"image.jpg","format":"jpeg"}`,
 			s3ResponseError: nil,
 		},
+		{
+			name:           "ValidImageRequestWithQuality",
+			requestBody:    ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg", Quality: 95, MaxBytes: 50000},
+			expectStatus:   200,
+			expectResponse: `{"message":"Image received, is valid, and has been uploaded to S3.","name":"image.jpg","format":"jpeg"}`,
+		},
+		{
+			name:           "QualityOutOfBounds",
+			requestBody:    ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg", Quality: 101},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid encoding options: quality must be from 1 to 100"}`,
+		},
 		{
 			name:           "InvalidRequestBody",
 			requestBody:    "Invalid",
@@ -96,13 +122,13 @@ func TestHandler(t *testing.T) {
 		},
 		{
 			name:           "InvalidImage",
-			requestBody:    ImageRequest{[]byte{0x01, 0x02, 0x03, 0x04, 0x05}, "image.jpg"},
+			requestBody:    ImageRequest{ImageData: []byte{0x01, 0x02, 0x03, 0x04, 0x05}, ImageName: "image.jpg"},
 			expectStatus:   400,
-			expectResponse: `{"message": "Invalid image"}`,
+			expectResponse: `{"message":"Invalid image, accepted formats are jpeg, png, gif, webp, tiff, bmp","acceptedFormats":["jpeg","png","gif","webp","tiff","bmp"]}`,
 		},
 		{
 			name:            "s3Error",
-			requestBody:     ImageRequest{shared.GenerateJPG(t), "image.jpg"},
+			requestBody:     ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"},
 			expectStatus:    500,
 			expectResponse:  `{"message": "Error uploading image to S3"}`,
 			s3ResponseError: errors.New("S3 upload failed"),
@@ -141,3 +167,24 @@ func TestHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestUploadQueuesWebhookEvent(t *testing.T) {
+	s3Client = shared.NewMockS3Client()
+	queue := shared.NewMemoryWebhookQueue()
+	webhookQueue = queue
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	request := events.APIGatewayProxyRequest{
+		Body:           string(bodyJSON),
+		RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": "acme"}},
+	}
+	response, err := HandleRequest(context.Background(), request)
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Upload failed: %d %s %v", response.StatusCode, response.Body, err)
+	}
+
+	queued := queue.Drain()
+	if len(queued) != 1 || queued[0].Type != shared.EventImageUploaded || queued[0].Tenant != "acme" {
+		t.Errorf("Expected an %s event for acme, got: %+v", shared.EventImageUploaded, queued)
+	}
+}
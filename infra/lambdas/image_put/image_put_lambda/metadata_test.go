@@ -0,0 +1,65 @@
+package image_put_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadMetadataPolicy(t *testing.T) {
+	testCases := []struct {
+		name       string
+		tenant     string
+		expectEXIF bool
+		expectGPS  bool
+	}{
+		{name: "StripAll", tenant: "private"},
+		{name: "StripGPS", tenant: "default", expectEXIF: true},
+		{name: "Preserve", tenant: "archive", expectEXIF: true, expectGPS: true},
+	}
+
+	defer func() { metadataPolicies = nil }()
+	metadataPolicies = shared.MetadataPolicies{
+		"default": shared.MetadataStripGPS,
+		"private": shared.MetadataStripAll,
+		"archive": shared.MetadataPreserve,
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPGWithMetadata(t), ImageName: "photo.jpg"})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				Body:           string(bodyJSON),
+			})
+			if response.StatusCode != 200 {
+				t.Fatalf("Upload failed: %d %s", response.StatusCode, response.Body)
+			}
+
+			stored := shared.ReadMetadata(client.Objects["photo.jpg"].Body)
+			if (stored.EXIF != nil) != tc.expectEXIF {
+				t.Errorf("Expected EXIF in the stored image %v, got: %+v", tc.expectEXIF, stored.EXIF)
+			}
+			if (stored.EXIF != nil && stored.EXIF.GPS != nil) != tc.expectGPS {
+				t.Errorf("Expected GPS in the stored image %v, got: %+v", tc.expectGPS, stored.EXIF)
+			}
+
+			// The sidecar keeps no more than the stored image does
+			sidecar, err := shared.GetImageMetadata(context.Background(), client, "", "photo.jpg")
+			if err != nil {
+				t.Fatalf("Expected a metadata sidecar: %v", err)
+			}
+			if (sidecar.EXIF != nil && sidecar.EXIF.Make == "Canon" && sidecar.IPTC != nil) != tc.expectEXIF {
+				t.Errorf("Expected camera and IPTC in the sidecar %v, got: %+v", tc.expectEXIF, sidecar)
+			}
+			if (sidecar.EXIF != nil && sidecar.EXIF.GPS != nil) != tc.expectGPS {
+				t.Errorf("Expected GPS in the sidecar %v, got: %+v", tc.expectGPS, sidecar.EXIF)
+			}
+		})
+	}
+}
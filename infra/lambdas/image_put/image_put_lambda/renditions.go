@@ -0,0 +1,44 @@
+package image_put_lambda
+
+import (
+	"context"
+	"os"
+	"shared"
+)
+
+// Generate and store every configured rendition of an uploaded image,
+// returning the key each one was stored under. Each carries the metadata the policy keeps.
+func storeRenditions(ctx context.Context, s3Client shared.S3ObjectAPI, imageData []byte, name string, focalPoint *shared.FocalPoint, policy shared.MetadataPolicy) (map[string]string, error) {
+	if len(renditions) == 0 {
+		return nil, nil
+	}
+
+	focused := shared.Renditions{}
+	for rendition, pipeline := range renditions {
+		pipeline, err := shared.LoadWatermarkLogos(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), pipeline)
+		if err != nil {
+			return nil, err
+		}
+		focused[rendition] = pipeline.WithFocalPoint(focalPoint)
+	}
+
+	// Renditions are written at the server's default quality, whatever the upload asked for
+	options, _ := quality.Options(0, 0)
+	options.Background = background
+	results, err := shared.GenerateRenditions(imageData, focused, options)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]string{}
+	for _, rendition := range renditions.Names() {
+		key := shared.RenditionKey(name, rendition)
+		data := shared.CopyMetadata(results[rendition], imageData, policy)
+		if _, err := uploadImageToS3(ctx, s3Client, data, key); err != nil {
+			return nil, err
+		}
+		keys[rendition] = key
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,124 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	_ "image/jpeg"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadRenditions(t *testing.T) {
+	var err error
+	renditions, err = shared.ParseRenditions(`{
+		"thumb": [{"op": "fill", "params": {"width": "200", "height": "200"}}],
+		"preview": [{"op": "fit", "params": {"width": "640", "height": "360"}}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { renditions = nil }()
+
+	client := shared.NewMockS3Client()
+	s3Client = client
+
+	bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg"})
+	response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Upload failed: %d %s %v", response.StatusCode, response.Body, err)
+	}
+
+	var imageResponse ImageResponse
+	if err := json.Unmarshal([]byte(response.Body), &imageResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	expectSizes := map[string]image.Point{"thumb": image.Pt(200, 200), "preview": image.Pt(360, 360)}
+	if len(imageResponse.Renditions) != len(expectSizes) {
+		t.Fatalf("Expected renditions %v, got: %v", expectSizes, imageResponse.Renditions)
+	}
+	for rendition, size := range expectSizes {
+		key := imageResponse.Renditions[rendition]
+		if key != shared.RenditionKey("image.jpg", rendition) {
+			t.Errorf("Expected %s at %s, got: %s", rendition, shared.RenditionKey("image.jpg", rendition), key)
+		}
+
+		object, ok := client.Objects[key]
+		if !ok {
+			t.Fatalf("Expected %s to be stored", key)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(object.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.Pt(config.Width, config.Height) != size {
+			t.Errorf("Expected %s to be %v, got: %dx%d", rendition, size, config.Width, config.Height)
+		}
+	}
+}
+
+func TestUploadFocalPoint(t *testing.T) {
+	var err error
+	renditions, err = shared.ParseRenditions(`{"square": [{"op": "crop", "params": {"width": "100", "height": "100", "gravity": "focal"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { renditions = nil }()
+
+	testCases := []struct {
+		name           string
+		focalPoint     *shared.FocalPoint
+		expectStatus   int
+		expectResponse string
+	}{
+		{
+			name:         "FocalPoint",
+			focalPoint:   &shared.FocalPoint{X: 0.25, Y: 0.75},
+			expectStatus: 200,
+		},
+		{
+			name:         "NoFocalPoint",
+			expectStatus: 200,
+		},
+		{
+			name:           "InvalidFocalPoint",
+			focalPoint:     &shared.FocalPoint{X: 2, Y: 0.5},
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid focal point"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(ImageRequest{ImageData: shared.GenerateJPG(t), ImageName: "image.jpg", FocalPoint: tc.focalPoint})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectResponse != "" {
+				if response.Body != tc.expectResponse {
+					t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+				}
+				return
+			}
+
+			record, err := shared.GetImageRecord(context.TODO(), client, "", "image.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (record.FocalPoint == nil) != (tc.focalPoint == nil) || (record.FocalPoint != nil && *record.FocalPoint != *tc.focalPoint) {
+				t.Errorf("Expected focal point %v, got: %v", tc.focalPoint, record.FocalPoint)
+			}
+			if _, ok := client.Objects[shared.RenditionKey("image.jpg", "square")]; !ok {
+				t.Error("Expected the focal point rendition to be stored")
+			}
+		})
+	}
+}
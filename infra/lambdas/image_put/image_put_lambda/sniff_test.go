@@ -0,0 +1,108 @@
+package image_put_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUploadSniffing(t *testing.T) {
+	// A JPEG with a zip archive after its end, readable as either
+	zipHeader := []byte("PK\x03\x04\x14\x00\x00\x00\x08\x00")
+	polyglot := append(shared.GenerateImage(t, "jpeg"), zipHeader...)
+	// Ultra HDR and MPF photos keep a second JPEG, like a gain or depth map, after the first
+	withSecondImage := append(shared.GenerateImage(t, "jpeg"), shared.GenerateJPG(t)...)
+	jpeg := shared.GenerateImage(t, "jpeg")
+	truncated := jpeg[:len(jpeg)/2]
+
+	testCases := []struct {
+		name           string
+		request        ImageRequest
+		expectStatus   int
+		expectFormat   string
+		expectResponse string
+	}{
+		{
+			name:         "Matching",
+			request:      ImageRequest{ImageData: shared.GenerateImage(t, "png"), ImageName: "photo.png", ContentType: "image/png"},
+			expectStatus: 200,
+			expectFormat: "png",
+		},
+		{
+			name:         "WebPWithoutExtension",
+			request:      ImageRequest{ImageData: shared.GenerateImage(t, "webp"), ImageName: "photo"},
+			expectStatus: 200,
+			expectFormat: "webp",
+		},
+		{
+			name:           "WrongExtension",
+			request:        ImageRequest{ImageData: shared.GenerateImage(t, "png"), ImageName: "photo.jpg"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Image format doesn't match: the name photo.jpg says jpeg but the data is png"}`,
+		},
+		{
+			name:           "WrongContentType",
+			request:        ImageRequest{ImageData: shared.GenerateImage(t, "gif"), ImageName: "photo", ContentType: "image/jpeg"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Image format doesn't match: the content type image/jpeg says jpeg but the data is gif"}`,
+		},
+		{
+			name:         "Polyglot",
+			request:      ImageRequest{ImageData: polyglot, ImageName: "photo.jpg"},
+			expectStatus: 200,
+			expectFormat: "jpeg",
+		},
+		{
+			name:         "SecondImage",
+			request:      ImageRequest{ImageData: withSecondImage, ImageName: "photo.jpg"},
+			expectStatus: 200,
+			expectFormat: "jpeg",
+		},
+		{
+			name:           "Truncated",
+			request:        ImageRequest{ImageData: truncated, ImageName: "photo.jpg"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid image: image data ends early"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := shared.NewMockS3Client()
+			s3Client = client
+
+			bodyJSON, _ := json.Marshal(tc.request)
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status %d, got %d %s", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectStatus != 200 {
+				if response.Body != tc.expectResponse {
+					t.Errorf("Expected response %s, got %s", tc.expectResponse, response.Body)
+				}
+				if _, ok := client.Objects[tc.request.ImageName]; ok {
+					t.Error("Expected nothing to be stored")
+				}
+				return
+			}
+
+			var imageResponse ImageResponse
+			if err := json.Unmarshal([]byte(response.Body), &imageResponse); err != nil {
+				t.Fatal(err)
+			}
+			if imageResponse.Format != tc.expectFormat {
+				t.Errorf("Expected format %s, got %s", tc.expectFormat, imageResponse.Format)
+			}
+
+			// Only the image itself is stored
+			stored := client.Objects[tc.request.ImageName].Body
+			if bytes.Contains(stored, zipHeader) || (tc.expectFormat == "jpeg" && bytes.Count(stored, []byte{0xFF, 0xD8, 0xFF}) != 1) {
+				t.Error("Expected the data after the image to be dropped")
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+module image_search
+
+go 1.21.3
+
+require github.com/aws/aws-lambda-go v1.41.0
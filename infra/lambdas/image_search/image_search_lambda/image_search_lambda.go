@@ -0,0 +1,201 @@
+package image_search_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"shared"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Hamming distance (out of 64 bits) used when the caller doesn't give one
+const defaultThreshold = 10
+
+// SearchRequest is the structure of the request body when searching with a probe image.
+type SearchRequest struct {
+	ImageData []byte `json:"imageData"`
+	Threshold *int   `json:"threshold"`
+}
+
+// Match is a stored image that is perceptually close to the probe.
+type Match struct {
+	Name     string `json:"name"`
+	Distance int    `json:"distance"`
+}
+
+// SearchResponse is the body returned for a successful search.
+type SearchResponse struct {
+	Matches []Match `json:"matches"`
+}
+
+var s3Client shared.S3ObjectAPI
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+}
+
+// Find stored images near to either a stored image (GET ?name=) or an uploaded probe image (POST)
+func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	var probeHash, excludeName string
+	threshold := defaultThreshold
+
+	if request.HTTPMethod == "POST" {
+		var searchRequest SearchRequest
+		if err := json.Unmarshal([]byte(request.Body), &searchRequest); err != nil || len(searchRequest.ImageData) == 0 {
+			log.Printf("Error unmarshaling request body: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Invalid request body"}`,
+			}, nil
+		}
+
+		hash, err := shared.PerceptualHash(searchRequest.ImageData)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Invalid image"}`,
+			}, nil
+		}
+		probeHash = hash
+
+		if searchRequest.Threshold != nil {
+			threshold = *searchRequest.Threshold
+		}
+	} else {
+		name := request.QueryStringParameters["name"]
+		if name == "" {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Missing 'name' parameter in the URL path"}`,
+			}, nil
+		}
+
+		record, err := shared.GetImageRecord(context.TODO(), s3Client, bucketName, name)
+		if shared.IsNotFound(err) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       fmt.Sprintf(`{"message": "Image with name %s not found in S3"}`, name),
+			}, nil
+		}
+		if err != nil {
+			log.Printf("Error retrieving image record from S3: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to retrieve image record from S3"}`,
+			}, err
+		}
+		probeHash = record.PerceptualHash
+		excludeName = name
+
+		if param, ok := request.QueryStringParameters["threshold"]; ok {
+			threshold, err = strconv.Atoi(param)
+			if err != nil {
+				return events.APIGatewayProxyResponse{
+					StatusCode: 400,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       `{"message": "Invalid 'threshold' parameter"}`,
+				}, nil
+			}
+		}
+	}
+
+	if threshold < 0 || threshold > 64 {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Threshold must be between 0 and 64"}`,
+		}, nil
+	}
+
+	matches, err := findSimilarImages(context.TODO(), s3Client, probeHash, threshold, excludeName)
+	if err != nil {
+		log.Printf("Error searching image records: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to search images"}`,
+		}, err
+	}
+
+	body, _ := json.Marshal(SearchResponse{Matches: matches})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Compare the perceptual hash index against the probe hash, closest first.
+// Images stored before the index existed are indexed first, so they are found
+// as well. Only images within the threshold have their record fetched, to drop
+// entries left behind by an image since replaced with different content.
+func findSimilarImages(ctx context.Context, s3Client shared.S3ObjectAPI, probeHash string, threshold int, excludeName string) ([]Match, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	entries, err := shared.ListPerceptualIndex(ctx, s3Client, bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		indexed[entry.Name] = true
+	}
+	unindexed, err := shared.IndexUnindexedRecords(ctx, s3Client, bucketName, indexed)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, unindexed...)
+
+	matches := []Match{}
+	for _, entry := range entries {
+		if entry.Name == excludeName {
+			continue
+		}
+
+		distance, err := shared.HammingDistance(probeHash, entry.PerceptualHash)
+		if err != nil {
+			log.Printf("Skipping index entry %s with invalid hash: %v", entry.Name, err)
+			continue
+		}
+		if distance > threshold {
+			continue
+		}
+
+		record, err := shared.GetImageRecord(ctx, s3Client, bucketName, entry.Name)
+		if shared.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.PerceptualHash != entry.PerceptualHash {
+			continue
+		}
+
+		matches = append(matches, Match{Name: entry.Name, Distance: distance})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+
+	return matches, nil
+}
@@ -0,0 +1,167 @@
+package image_search_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"shared"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func newSearchClient(t *testing.T) *shared.MockS3Client {
+	client := shared.NewMockS3Client()
+
+	probeHash, err := shared.PerceptualHash(shared.GenerateJPG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []shared.ImageRecord{
+		{Name: "original.jpg", PerceptualHash: probeHash},
+		{Name: "near.jpg", PerceptualHash: "0000000000000007"},
+		{Name: "exact.jpg", PerceptualHash: probeHash},
+		{Name: "far.jpg", PerceptualHash: "ffffffffffffffff"},
+	}
+	for _, record := range records {
+		record.UploadedAt = time.Now()
+		if err := shared.PutImageRecord(context.TODO(), client, "", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Once matched the probe, but has since been replaced with different content
+	for _, hash := range []string{probeHash, "f0f0f0f0f0f0f0f0"} {
+		if err := shared.PutImageRecord(context.TODO(), client, "", shared.ImageRecord{Name: "replaced.jpg", PerceptualHash: hash}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stored before the index existed, so only its record is there
+	legacy, _ := json.Marshal(shared.ImageRecord{Name: "legacy.jpg", PerceptualHash: probeHash, UploadedAt: time.Now()})
+	client.Objects[shared.RecordKey("legacy.jpg")] = &shared.MockS3Object{Body: legacy}
+
+	return client
+}
+
+func TestHandleRequest(t *testing.T) {
+	probeJSON, _ := json.Marshal(SearchRequest{ImageData: shared.GenerateJPG(t)})
+	threshold := 0
+	exactJSON, _ := json.Marshal(SearchRequest{ImageData: shared.GenerateJPG(t), Threshold: &threshold})
+	invalidJSON, _ := json.Marshal(SearchRequest{ImageData: []byte("This is not an image")})
+
+	tests := []struct {
+		name           string
+		method         string
+		queryParams    map[string]string
+		body           string
+		expectStatus   int
+		expectMatches  []Match
+		expectResponse string
+	}{
+		{
+			name:          "Search by stored image",
+			method:        "GET",
+			queryParams:   map[string]string{"name": "original.jpg"},
+			expectStatus:  200,
+			expectMatches: []Match{{"exact.jpg", 0}, {"legacy.jpg", 0}, {"near.jpg", 3}},
+		},
+		{
+			name:          "Search by stored image with threshold",
+			method:        "GET",
+			queryParams:   map[string]string{"name": "original.jpg", "threshold": "64"},
+			expectStatus:  200,
+			expectMatches: []Match{{"exact.jpg", 0}, {"legacy.jpg", 0}, {"near.jpg", 3}, {"replaced.jpg", 32}, {"far.jpg", 64}},
+		},
+		{
+			name:          "Search by probe image",
+			method:        "POST",
+			body:          string(probeJSON),
+			expectStatus:  200,
+			expectMatches: []Match{{"exact.jpg", 0}, {"original.jpg", 0}, {"legacy.jpg", 0}, {"near.jpg", 3}},
+		},
+		{
+			name:          "Search by probe image for exact matches",
+			method:        "POST",
+			body:          string(exactJSON),
+			expectStatus:  200,
+			expectMatches: []Match{{"exact.jpg", 0}, {"original.jpg", 0}, {"legacy.jpg", 0}},
+		},
+		{
+			name:           "Invalid probe image",
+			method:         "POST",
+			body:           string(invalidJSON),
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid image"}`,
+		},
+		{
+			name:           "Invalid request body",
+			method:         "POST",
+			body:           "Invalid",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body"}`,
+		},
+		{
+			name:           "Missing 'name' parameter",
+			method:         "GET",
+			queryParams:    map[string]string{"invalid": "invalid"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Missing 'name' parameter in the URL path"}`,
+		},
+		{
+			name:           "Invalid threshold",
+			method:         "GET",
+			queryParams:    map[string]string{"name": "original.jpg", "threshold": "65"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Threshold must be between 0 and 64"}`,
+		},
+		{
+			name:           "Unknown image",
+			method:         "GET",
+			queryParams:    map[string]string{"name": "missing.jpg"},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name missing.jpg not found in S3"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s3Client = newSearchClient(t)
+
+			request := events.APIGatewayProxyRequest{
+				HTTPMethod:            tc.method,
+				QueryStringParameters: tc.queryParams,
+				Body:                  tc.body,
+			}
+
+			response, err := HandleRequest(context.Background(), request)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d", tc.expectStatus, response.StatusCode)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if tc.expectMatches != nil {
+				var searchResponse SearchResponse
+				if err := json.Unmarshal([]byte(response.Body), &searchResponse); err != nil {
+					t.Fatal(err)
+				}
+				if len(searchResponse.Matches) != len(tc.expectMatches) {
+					t.Fatalf("Expected matches %v, got: %v", tc.expectMatches, searchResponse.Matches)
+				}
+				for i, match := range tc.expectMatches {
+					if searchResponse.Matches[i] != match {
+						t.Errorf("Expected matches %v, got: %v", tc.expectMatches, searchResponse.Matches)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_search/image_search_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_search_lambda.HandleRequest)
+}
@@ -0,0 +1,241 @@
+package image_sheets_lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// SheetRequest is the structure of the request body. Either Names or Prefix
+// selects the images, which are laid out in the order given or listed.
+type SheetRequest struct {
+	Names  []string `json:"names"`
+	Prefix string   `json:"prefix"`
+	// "sheet" returns the grid as an image, "sprite" returns it as JSON with
+	// where each image is on it. Sprites are PNG unless a format is given.
+	Mode string `json:"mode"`
+	shared.SheetOptions
+}
+
+// SpriteResponse is the body returned in sprite mode.
+type SpriteResponse struct {
+	// The base64 encoded sprite image
+	Image       string                      `json:"image"`
+	ContentType string                      `json:"contentType"`
+	Width       int                         `json:"width"`
+	Height      int                         `json:"height"`
+	Sprites     map[string]shared.SheetCell `json:"sprites"`
+}
+
+var s3Client shared.S3ObjectAPI
+var watermarks shared.WatermarkPolicy
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+	watermarks, err = shared.ParseWatermarkPolicy(os.Getenv("MANDATORY_WATERMARKS"))
+	if err != nil {
+		log.Fatalf("Invalid MANDATORY_WATERMARKS: %v", err)
+	}
+}
+
+func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var sheetRequest SheetRequest
+	if err := json.Unmarshal([]byte(request.Body), &sheetRequest); err != nil {
+		log.Printf("Error unmarshaling request body: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body"}`,
+		}, nil
+	}
+
+	// Exactly one of names or prefix must be given
+	if (len(sheetRequest.Names) == 0) == (sheetRequest.Prefix == "") {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body structure"}`,
+		}, nil
+	}
+
+	// Every cell is scaled to the size asked for, which callers who need a
+	// preset or a signed URL can't ask for in a request body
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+	if shared.TransformationsRestricted(tenant) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 403,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Transformations are not allowed in sheets"}`,
+		}, nil
+	}
+
+	switch sheetRequest.Mode {
+	case "", "sheet":
+	case "sprite":
+		if sheetRequest.Format == "" {
+			sheetRequest.Format = "png"
+		}
+	default:
+		return invalidSheet(fmt.Errorf("unknown mode %q, expected sheet or sprite", sheetRequest.Mode)), nil
+	}
+
+	names := sheetRequest.Names
+	if sheetRequest.Prefix != "" {
+		var err error
+		names, err = shared.ListImageNames(context.TODO(), s3Client, os.Getenv("S3_BUCKET_NAME"), sheetRequest.Prefix)
+		if err != nil {
+			log.Printf("Error listing images in S3: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to list objects in S3"}`,
+			}, err
+		}
+		if len(names) == 0 {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       fmt.Sprintf(`{"message": "No images found with prefix %s"}`, sheetRequest.Prefix),
+			}, nil
+		}
+	}
+
+	if len(names) > shared.MaxSheetImages {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 413,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Sheet contains more than %d images"}`, shared.MaxSheetImages),
+		}, nil
+	}
+
+	// Check the layout before fetching anything
+	if err := sheetRequest.SheetOptions.Validate(names); err != nil {
+		return invalidSheet(err), nil
+	}
+
+	// Tenants who must only see watermarked images get the watermark on every cell
+	var steps shared.Pipeline
+	if step, ok := watermarks.For(tenant); ok {
+		var err error
+		steps, err = shared.LoadWatermarkLogos(context.TODO(), s3Client, os.Getenv("S3_BUCKET_NAME"), shared.Pipeline{step})
+		if err != nil {
+			log.Printf("Error loading watermark: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "Failed to apply watermark"}`,
+			}, err
+		}
+	}
+
+	images, err := fetchImages(context.TODO(), s3Client, names)
+	var notFound *imageNotFoundError
+	if errors.As(err, &notFound) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       fmt.Sprintf(`{"message": "Image with name %s not found in S3"}`, notFound.name),
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving images from S3: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to retrieve object from S3"}`,
+		}, err
+	}
+
+	sheet, err := shared.ComposeSheet(images, sheetRequest.SheetOptions, steps)
+	if err != nil {
+		log.Printf("Error composing sheet: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Failed to compose sheet"}`,
+		}, err
+	}
+
+	if sheetRequest.Mode != "sprite" {
+		return events.APIGatewayProxyResponse{
+			StatusCode:      200,
+			Headers:         map[string]string{"Content-Type": sheet.ContentType},
+			Body:            base64.StdEncoding.EncodeToString(sheet.Data),
+			IsBase64Encoded: true,
+		}, nil
+	}
+
+	body, _ := json.Marshal(SpriteResponse{
+		Image:       base64.StdEncoding.EncodeToString(sheet.Data),
+		ContentType: sheet.ContentType,
+		Width:       sheet.Width,
+		Height:      sheet.Height,
+		Sprites:     sheet.Cells,
+	})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Reports which of the requested images is missing
+type imageNotFoundError struct {
+	name string
+}
+
+func (e *imageNotFoundError) Error() string {
+	return fmt.Sprintf("image %s not found", e.name)
+}
+
+// Fetch each image by name, in order. Names under internal prefixes are treated as missing.
+func fetchImages(ctx context.Context, s3Client shared.S3ObjectAPI, names []string) ([]shared.SheetImage, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	images := make([]shared.SheetImage, 0, len(names))
+	for _, name := range names {
+		if shared.IsHiddenName(name) {
+			return nil, &imageNotFoundError{name: name}
+		}
+
+		output, err := shared.GetImageObject(ctx, s3Client, bucketName, name)
+		if shared.IsNotFound(err) {
+			return nil, &imageNotFoundError{name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(output.Body)
+		output.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, shared.SheetImage{Name: name, Data: data})
+	}
+
+	return images, nil
+}
+
+// Reject a request whose layout doesn't make sense
+func invalidSheet(err error) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{"message": "Invalid sheet: " + err.Error()})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
@@ -0,0 +1,263 @@
+package image_sheets_lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func newSheetClient(t *testing.T) *shared.MockS3Client {
+	client := shared.NewMockS3Client()
+	for _, name := range []string{"shoot/a.jpg", "shoot/b.jpg", "shoot/c.jpg", "other/d.jpg"} {
+		client.Objects[name] = &shared.MockS3Object{Body: shared.GenerateJPG(t), ContentType: "image/jpeg"}
+	}
+	client.Objects[shared.RecordKey("shoot/a.jpg")] = &shared.MockS3Object{Body: []byte("{}")}
+
+	return client
+}
+
+func TestHandleRequest(t *testing.T) {
+	tests := []struct {
+		name              string
+		request           any
+		expectStatus      int
+		expectContentType string
+		expectSize        image.Point
+		expectResponse    string
+	}{
+		{
+			name:              "Sheet by names",
+			request:           SheetRequest{Names: []string{"shoot/a.jpg", "other/d.jpg"}},
+			expectStatus:      200,
+			expectContentType: "image/jpeg",
+			expectSize:        image.Pt(430, 220),
+		},
+		{
+			name: "Sheet by prefix with labels",
+			request: SheetRequest{Prefix: "shoot/", SheetOptions: shared.SheetOptions{
+				CellWidth: 100, CellHeight: 100, Columns: 3, Labels: true, Format: "png",
+			}},
+			expectStatus:      200,
+			expectContentType: "image/png",
+			expectSize:        image.Pt(340, 145),
+		},
+		{
+			name:           "Unknown name",
+			request:        SheetRequest{Names: []string{"shoot/a.jpg", "missing.jpg"}},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name missing.jpg not found in S3"}`,
+		},
+		{
+			name:           "Reserved name",
+			request:        SheetRequest{Names: []string{shared.RecordKey("shoot/a.jpg")}},
+			expectStatus:   404,
+			expectResponse: `{"message": "Image with name records/shoot/a.jpg.json not found in S3"}`,
+		},
+		{
+			name:           "Empty prefix",
+			request:        SheetRequest{Prefix: "missing/"},
+			expectStatus:   404,
+			expectResponse: `{"message": "No images found with prefix missing/"}`,
+		},
+		{
+			name:           "Invalid layout",
+			request:        SheetRequest{Prefix: "shoot/", SheetOptions: shared.SheetOptions{Format: "gif"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid sheet: unknown format \"gif\", expected jpeg or png"}`,
+		},
+		{
+			name:           "Invalid background",
+			request:        SheetRequest{Names: []string{"missing.jpg"}, SheetOptions: shared.SheetOptions{Background: "nope"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid sheet: background: invalid colour \"nope\", expected rrggbb or rrggbbaa"}`,
+		},
+		{
+			name:           "Duplicate names",
+			request:        SheetRequest{Names: []string{"shoot/a.jpg", "shoot/a.jpg"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid sheet: image shoot/a.jpg is listed more than once"}`,
+		},
+		{
+			name:           "Unknown mode",
+			request:        SheetRequest{Prefix: "shoot/", Mode: "poster"},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid sheet: unknown mode \"poster\", expected sheet or sprite"}`,
+		},
+		{
+			name:           "Names and prefix",
+			request:        SheetRequest{Names: []string{"shoot/a.jpg"}, Prefix: "shoot/"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body structure"}`,
+		},
+		{
+			name:           "Invalid request body",
+			request:        "Invalid",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s3Client = newSheetClient(t)
+
+			bodyJSON, _ := json.Marshal(tc.request)
+			response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+
+			if tc.expectContentType != "" {
+				if contentType := response.Headers["Content-Type"]; contentType != tc.expectContentType || !response.IsBase64Encoded {
+					t.Errorf("Expected a base64 encoded %s, got: %s", tc.expectContentType, contentType)
+				}
+				data, _ := base64.StdEncoding.DecodeString(response.Body)
+				img, _, err := image.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if size := img.Bounds().Size(); size != tc.expectSize {
+					t.Errorf("Expected a %v sheet, got: %v", tc.expectSize, size)
+				}
+			}
+		})
+	}
+}
+
+func TestSheetTransformPolicy(t *testing.T) {
+	testCases := []struct {
+		name          string
+		presetsOnly   string
+		requireSigned string
+		tenant        string
+		expectStatus  int
+	}{
+		{name: "Allowed", presetsOnly: "public", requireSigned: "public", tenant: "acme", expectStatus: 200},
+		{name: "PresetsOnlyTenant", presetsOnly: "public", expectStatus: 403},
+		{name: "SignedURLsOnlyTenant", requireSigned: "acme", tenant: "acme", expectStatus: 403},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PRESETS_ONLY_TENANTS", tc.presetsOnly)
+			t.Setenv("SIGNED_URLS_ONLY_TENANTS", tc.requireSigned)
+			s3Client = newSheetClient(t)
+
+			bodyJSON, _ := json.Marshal(SheetRequest{Prefix: "shoot/"})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tc.tenant}},
+				Body:           string(bodyJSON),
+			})
+			if response.StatusCode != tc.expectStatus {
+				t.Errorf("Expected status code %d, got: %d %s", tc.expectStatus, response.StatusCode, response.Body)
+			}
+		})
+	}
+}
+
+func TestSpriteMode(t *testing.T) {
+	s3Client = newSheetClient(t)
+
+	spacing := 0
+	bodyJSON, _ := json.Marshal(SheetRequest{
+		Names:        []string{"shoot/b.jpg", "shoot/a.jpg"},
+		Mode:         "sprite",
+		SheetOptions: shared.SheetOptions{CellWidth: 32, CellHeight: 32, Spacing: &spacing, Columns: 2},
+	})
+	response, err := HandleRequest(context.Background(), events.APIGatewayProxyRequest{Body: string(bodyJSON)})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Expected a sprite, got: %d %s %v", response.StatusCode, response.Body, err)
+	}
+
+	var sprite SpriteResponse
+	if err := json.Unmarshal([]byte(response.Body), &sprite); err != nil {
+		t.Fatal(err)
+	}
+	if sprite.ContentType != "image/png" || sprite.Width != 64 || sprite.Height != 32 {
+		t.Errorf("Unexpected sprite: %s %dx%d", sprite.ContentType, sprite.Width, sprite.Height)
+	}
+
+	// Images are placed in the order they were asked for
+	expected := map[string]shared.SheetCell{
+		"shoot/b.jpg": {X: 0, Y: 0, Width: 32, Height: 32},
+		"shoot/a.jpg": {X: 32, Y: 0, Width: 32, Height: 32},
+	}
+	for name, cell := range expected {
+		if sprite.Sprites[name] != cell {
+			t.Errorf("Expected %s at %+v, got: %+v", name, cell, sprite.Sprites[name])
+		}
+	}
+
+	data, _ := base64.StdEncoding.DecodeString(sprite.Image)
+	if config, err := png.DecodeConfig(bytes.NewReader(data)); err != nil || config.Width != 64 {
+		t.Errorf("Expected the sprite image, got: %+v %v", config, err)
+	}
+}
+
+func TestSheetWatermark(t *testing.T) {
+	// A solid red logo, tiled over every cell of the black images
+	logo := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	var logoPNG bytes.Buffer
+	if err := png.Encode(&logoPNG, logo); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newSheetClient(t)
+	client.Objects[shared.WatermarkKey("acme")] = &shared.MockS3Object{Body: logoPNG.Bytes()}
+	s3Client = client
+
+	var err error
+	watermarks, err = shared.ParseWatermarkPolicy(`{"partner": {"logo": "acme", "opacity": "1", "tile": "true"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watermarks = nil }()
+
+	for tenant, expectRed := range map[string]bool{"partner": true, "internal": false} {
+		t.Run(tenant, func(t *testing.T) {
+			bodyJSON, _ := json.Marshal(SheetRequest{Prefix: "shoot/", Mode: "sprite"})
+			response, _ := HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tenant}},
+				Body:           string(bodyJSON),
+			})
+			if response.StatusCode != 200 {
+				t.Fatalf("Expected a sprite, got: %d %s", response.StatusCode, response.Body)
+			}
+
+			var sprite SpriteResponse
+			if err := json.Unmarshal([]byte(response.Body), &sprite); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := base64.StdEncoding.DecodeString(sprite.Image)
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, cell := range sprite.Sprites {
+				r, _, _, _ := img.At(cell.X+cell.Width/2, cell.Y+cell.Height/2).RGBA()
+				if (r > 0x8000) != expectRed {
+					t.Errorf("Expected %s watermarked %v", name, expectRed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_sheets/image_sheets_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_sheets_lambda.HandleRequest)
+}
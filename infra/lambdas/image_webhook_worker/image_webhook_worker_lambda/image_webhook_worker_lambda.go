@@ -0,0 +1,48 @@
+package image_webhook_worker_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var s3Client shared.S3ObjectAPI
+var webhooks *shared.WebhookDispatcher
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+	webhooks = shared.NewWebhookDispatcher()
+}
+
+// Deliver each queued event to the subscriptions that want it. A receiver that
+// keeps failing is recorded against its delivery rather than retried by SQS;
+// only events hit by a storage error are reported back for redelivery, so
+// receivers should use the event ID to ignore any they have already seen.
+func HandleRequest(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	var response events.SQSEventResponse
+
+	for _, message := range event.Records {
+		var webhookEvent shared.WebhookEvent
+		if err := json.Unmarshal([]byte(message.Body), &webhookEvent); err != nil {
+			log.Printf("Dropping malformed webhook event %s: %v", message.MessageId, err)
+			continue
+		}
+
+		if err := webhooks.Deliver(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), webhookEvent); err != nil {
+			log.Printf("Error delivering webhook event %s: %v", webhookEvent.ID, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,110 @@
+package image_webhook_worker_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shared"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Fails every read, standing in for a transient S3 outage
+type failingClient struct {
+	*shared.MockS3Client
+}
+
+func (c failingClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return nil, errors.New("S3 unavailable")
+}
+
+func (c failingClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return nil, errors.New("S3 unavailable")
+}
+
+func TestHandleRequest(t *testing.T) {
+	var mu sync.Mutex
+	var received []shared.WebhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event shared.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	webhooks = &shared.WebhookDispatcher{HTTPClient: server.Client(), MaxAttempts: 1, BaseBackoff: time.Millisecond}
+
+	uploaded, _ := shared.NewWebhookEvent("acme", shared.EventImageUploaded, shared.ImageEventData{Name: "image.jpg"})
+	uploadedJSON, _ := json.Marshal(uploaded)
+
+	tests := []struct {
+		name          string
+		body          string
+		failStorage   bool
+		expectRetry   bool
+		expectEvents  int
+		subscriptions []shared.WebhookSubscription
+	}{
+		{
+			name: "Delivered to every subscription",
+			body: string(uploadedJSON),
+			subscriptions: []shared.WebhookSubscription{
+				{ID: "first", URL: server.URL, Events: []string{shared.EventImageUploaded}},
+				{ID: "second", URL: server.URL, Events: []string{shared.EventImageUploaded}},
+				{ID: "other", URL: server.URL, Events: []string{shared.EventImageFailed}},
+			},
+			expectEvents: 2,
+		},
+		{
+			name: "Malformed event is dropped",
+			body: "not json",
+		},
+		{
+			name:        "Storage failure is retried",
+			body:        string(uploadedJSON),
+			failStorage: true,
+			expectRetry: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			received = nil
+
+			mock := shared.NewMockS3Client()
+			for _, subscription := range tc.subscriptions {
+				shared.PutWebhookSubscription(context.TODO(), mock, "", "acme", subscription)
+			}
+			s3Client = mock
+			if tc.failStorage {
+				s3Client = failingClient{mock}
+			}
+
+			event := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-1", Body: tc.body}}}
+			response, err := HandleRequest(context.Background(), event)
+			if err != nil {
+				t.Errorf("Handler returned an error: %v", err)
+			}
+
+			if retried := len(response.BatchItemFailures) == 1; retried != tc.expectRetry {
+				t.Errorf("Expected retry: %v, got: %v", tc.expectRetry, response.BatchItemFailures)
+			}
+
+			if len(received) != tc.expectEvents {
+				t.Fatalf("Expected %d deliveries, got: %d", tc.expectEvents, len(received))
+			}
+			for _, event := range received {
+				if event.ID != uploaded.ID || event.Type != shared.EventImageUploaded || event.Tenant != "acme" {
+					t.Errorf("Unexpected event: %+v", event)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package image_webhooks_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"shared"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// WebhookRequest is the structure of the request body for a new subscription.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhookResponse describes a subscription along with its recent deliveries.
+type WebhookResponse struct {
+	shared.WebhookSubscription
+	Deliveries []shared.WebhookDelivery `json:"deliveries"`
+}
+
+var s3Client shared.S3ObjectAPI
+var deleteClient shared.S3DeleteObjectAPI
+var resolver shared.HostResolver = net.DefaultResolver
+
+func init() {
+	var err error
+	s3Client, err = shared.NewS3Client()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 client: %v", err)
+	}
+	deleteClient, err = shared.NewS3DeleteClient()
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 delete client: %v", err)
+	}
+}
+
+// POST registers a webhook, GET lists them or reports one with ?id=, DELETE ?id= removes one.
+// Subscriptions belong to the tenant making the request, so callers without an API key can't have any.
+func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if shared.Tenant(request.RequestContext.Authorizer) == shared.AnonymousTenant {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 401,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Webhooks need an API key"}`,
+		}, nil
+	}
+
+	switch request.HTTPMethod {
+	case "POST":
+		return createWebhook(ctx, request)
+	case "DELETE":
+		return deleteWebhook(ctx, request)
+	default:
+		if request.QueryStringParameters["id"] != "" {
+			return getWebhook(ctx, request)
+		}
+		return listWebhooks(ctx, request)
+	}
+}
+
+// Validate and store a new subscription. The signing secret is only ever returned here.
+func createWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var webhookRequest WebhookRequest
+	if err := json.Unmarshal([]byte(request.Body), &webhookRequest); err != nil {
+		log.Printf("Error unmarshaling request body: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body"}`,
+		}, nil
+	}
+
+	if webhookRequest.URL == "" || len(webhookRequest.Events) == 0 {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Invalid request body structure"}`,
+		}, nil
+	}
+
+	// Deliveries come from inside AWS, so they mustn't reach anything internal
+	if err := shared.CheckWebhookURL(ctx, resolver, webhookRequest.URL); err != nil {
+		body, _ := json.Marshal(map[string]string{"message": "Invalid webhook URL: " + err.Error()})
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+
+	for _, event := range webhookRequest.Events {
+		if !isEventType(event) {
+			body, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Unknown event type %q", event)})
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       string(body),
+			}, nil
+		}
+	}
+
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+	id, err := shared.NewWebhookID()
+	if err != nil {
+		return webhookStoreError(err)
+	}
+	secret, err := shared.NewWebhookID()
+	if err != nil {
+		return webhookStoreError(err)
+	}
+
+	subscription := shared.WebhookSubscription{
+		ID:        id,
+		URL:       webhookRequest.URL,
+		Events:    webhookRequest.Events,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := shared.PutWebhookSubscription(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), tenant, subscription); err != nil {
+		return webhookStoreError(err)
+	}
+
+	log.Printf("Registered webhook %s for tenant %s", id, tenant)
+
+	body, _ := json.Marshal(subscription)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// List the subscriptions of the tenant without their secrets
+func listWebhooks(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	subscriptions, err := shared.GetWebhookSubscriptions(ctx, s3Client, os.Getenv("S3_BUCKET_NAME"), shared.Tenant(request.RequestContext.Authorizer))
+	if err != nil {
+		return webhookStoreError(err)
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	body, _ := json.Marshal(subscriptions)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Report a single subscription along with the record of every delivery made to it
+func getWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+	id := request.QueryStringParameters["id"]
+
+	subscription, err := shared.GetWebhookSubscription(ctx, s3Client, bucketName, tenant, id)
+	if shared.IsNotFound(err) {
+		return webhookNotFound(id)
+	}
+	if err != nil {
+		return webhookStoreError(err)
+	}
+
+	deliveries, err := shared.ListWebhookDeliveries(ctx, s3Client, bucketName, tenant, id)
+	if err != nil {
+		return webhookStoreError(err)
+	}
+
+	subscription.Secret = ""
+	body, _ := json.Marshal(WebhookResponse{WebhookSubscription: subscription, Deliveries: deliveries})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// Remove a subscription. Its delivery records are kept.
+func deleteWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+	tenant := shared.Tenant(request.RequestContext.Authorizer)
+	id := request.QueryStringParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Missing 'id' parameter in the URL path"}`,
+		}, nil
+	}
+
+	if _, err := shared.GetWebhookSubscription(ctx, s3Client, bucketName, tenant, id); shared.IsNotFound(err) {
+		return webhookNotFound(id)
+	} else if err != nil {
+		return webhookStoreError(err)
+	}
+
+	if err := shared.DeleteWebhookSubscription(ctx, deleteClient, bucketName, tenant, id); err != nil {
+		return webhookStoreError(err)
+	}
+
+	log.Printf("Removed webhook %s for tenant %s", id, tenant)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       fmt.Sprintf(`{"message": "Webhook %s deleted"}`, id),
+	}, nil
+}
+
+func isEventType(event string) bool {
+	for _, eventType := range shared.WebhookEventTypes {
+		if event == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func webhookNotFound(id string) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: 404,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       fmt.Sprintf(`{"message": "Webhook with id %s not found"}`, id),
+	}, nil
+}
+
+func webhookStoreError(err error) (events.APIGatewayProxyResponse, error) {
+	log.Printf("Error accessing webhooks in S3: %v", err)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 500,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       `{"message": "Failed to access webhooks"}`,
+	}, err
+}
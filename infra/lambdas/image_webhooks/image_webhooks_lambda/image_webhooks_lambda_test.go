@@ -0,0 +1,168 @@
+package image_webhooks_lambda
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The request context the authorizer gives a tenant's requests
+func tenantContext(tenant string) events.APIGatewayProxyRequestContext {
+	return events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantId": tenant}}
+}
+
+// Resolves host names from a fixed table, so tests don't need DNS
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	address, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(address)}}, nil
+}
+
+func init() {
+	resolver = fakeResolver{"example.com": "93.184.215.14", "internal.example.com": "10.0.0.5"}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        any
+		tenant         string
+		expectStatus   int
+		expectResponse string
+	}{
+		{
+			name:         "Register webhook",
+			request:      WebhookRequest{URL: "https://example.com/hook", Events: []string{shared.EventImageUploaded}},
+			expectStatus: 201,
+		},
+		{
+			name:           "Missing events",
+			request:        WebhookRequest{URL: "https://example.com/hook"},
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body structure"}`,
+		},
+		{
+			name:           "Relative URL",
+			request:        WebhookRequest{URL: "/hook", Events: []string{shared.EventImageUploaded}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid webhook URL: webhook URL must be an absolute http or https URL"}`,
+		},
+		{
+			name:           "Metadata service",
+			request:        WebhookRequest{URL: "http://169.254.169.254/latest/meta-data/", Events: []string{shared.EventImageUploaded}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid webhook URL: webhook URL must resolve to a public address"}`,
+		},
+		{
+			name:           "Private host",
+			request:        WebhookRequest{URL: "https://internal.example.com/hook", Events: []string{shared.EventImageUploaded}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Invalid webhook URL: webhook URL must resolve to a public address"}`,
+		},
+		{
+			name:           "Unknown event",
+			request:        WebhookRequest{URL: "https://example.com/hook", Events: []string{"image.exploded"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Unknown event type \"image.exploded\""}`,
+		},
+		{
+			name:           "Event nothing sends",
+			request:        WebhookRequest{URL: "https://example.com/hook", Events: []string{"image.deleted"}},
+			expectStatus:   400,
+			expectResponse: `{"message":"Unknown event type \"image.deleted\""}`,
+		},
+		{
+			name:           "Invalid body",
+			request:        "Invalid",
+			expectStatus:   400,
+			expectResponse: `{"message": "Invalid request body"}`,
+		},
+		{
+			name:           "No API key",
+			request:        WebhookRequest{URL: "https://example.com/hook", Events: []string{shared.EventImageUploaded}},
+			tenant:         shared.AnonymousTenant,
+			expectStatus:   401,
+			expectResponse: `{"message": "Webhooks need an API key"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s3Client = shared.NewMockS3Client()
+
+			bodyJSON, _ := json.Marshal(tc.request)
+			tenant := tc.tenant
+			if tenant == "" {
+				tenant = "acme"
+			}
+			request := events.APIGatewayProxyRequest{HTTPMethod: "POST", RequestContext: tenantContext(tenant), Body: string(bodyJSON)}
+
+			response, _ := HandleRequest(context.TODO(), request)
+			if response.StatusCode != tc.expectStatus {
+				t.Fatalf("Expected status code %d, got: %d (%s)", tc.expectStatus, response.StatusCode, response.Body)
+			}
+			if tc.expectResponse != "" && response.Body != tc.expectResponse {
+				t.Errorf("Expected response body: %s, got: %s", tc.expectResponse, response.Body)
+			}
+			if tc.expectStatus != 201 {
+				return
+			}
+
+			var subscription shared.WebhookSubscription
+			json.Unmarshal([]byte(response.Body), &subscription)
+			if subscription.ID == "" || subscription.Secret == "" {
+				t.Errorf("Expected an id and secret, got: %+v", subscription)
+			}
+		})
+	}
+}
+
+func TestWebhookLifecycle(t *testing.T) {
+	client := shared.NewMockS3Client()
+	s3Client, deleteClient = client, client
+	acme := tenantContext("acme")
+
+	body, _ := json.Marshal(WebhookRequest{URL: "https://example.com/hook", Events: []string{shared.EventImageFailed}})
+	response, _ := HandleRequest(context.TODO(), events.APIGatewayProxyRequest{HTTPMethod: "POST", RequestContext: acme, Body: string(body)})
+	var created shared.WebhookSubscription
+	json.Unmarshal([]byte(response.Body), &created)
+
+	// Other tenants can't see the subscription
+	response, _ = HandleRequest(context.TODO(), events.APIGatewayProxyRequest{HTTPMethod: "GET", RequestContext: tenantContext("other")})
+	if response.Body != "[]" {
+		t.Errorf("Expected no webhooks for another tenant, got: %s", response.Body)
+	}
+
+	response, _ = HandleRequest(context.TODO(), events.APIGatewayProxyRequest{HTTPMethod: "GET", RequestContext: acme})
+	var listed []shared.WebhookSubscription
+	json.Unmarshal([]byte(response.Body), &listed)
+	if len(listed) != 1 || listed[0].ID != created.ID || listed[0].Secret != "" {
+		t.Errorf("Unexpected webhooks: %s", response.Body)
+	}
+
+	query := map[string]string{"id": created.ID}
+	response, _ = HandleRequest(context.TODO(), events.APIGatewayProxyRequest{HTTPMethod: "GET", RequestContext: acme, QueryStringParameters: query})
+	var webhook WebhookResponse
+	json.Unmarshal([]byte(response.Body), &webhook)
+	if response.StatusCode != 200 || webhook.ID != created.ID || webhook.Deliveries == nil {
+		t.Errorf("Unexpected webhook: %s", response.Body)
+	}
+
+	response, _ = HandleRequest(context.TODO(), events.APIGatewayProxyRequest{HTTPMethod: "DELETE", RequestContext: acme, QueryStringParameters: query})
+	if response.StatusCode != 200 {
+		t.Errorf("Expected status code 200, got: %d", response.StatusCode)
+	}
+
+	response, _ = HandleRequest(context.TODO(), events.APIGatewayProxyRequest{HTTPMethod: "DELETE", RequestContext: acme, QueryStringParameters: query})
+	if response.StatusCode != 404 {
+		t.Errorf("Expected status code 404, got: %d", response.StatusCode)
+	}
+}
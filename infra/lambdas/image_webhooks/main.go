@@ -0,0 +1,11 @@
+package main
+
+import (
+	"image_webhooks/image_webhooks_lambda"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.Start(image_webhooks_lambda.HandleRequest)
+}
@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// AlphaPolicy says what an upload with transparent pixels is stored as.
+type AlphaPolicy string
+
+const (
+	// Flatten transparent pixels onto the background colour and store JPEG
+	AlphaFlatten AlphaPolicy = "flatten"
+	// Store images with transparent pixels as PNG, and everything else as JPEG
+	AlphaKeep AlphaPolicy = "keep"
+)
+
+// Colour transparent pixels are flattened onto when none is configured
+var DefaultBackground = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+// Parse an alpha policy, where an empty string means AlphaFlatten
+func ParseAlphaPolicy(value string) (AlphaPolicy, error) {
+	switch policy := AlphaPolicy(value); policy {
+	case "":
+		return AlphaFlatten, nil
+	case AlphaFlatten, AlphaKeep:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown alpha policy %q, expected flatten or keep", value)
+	}
+}
+
+// Whether any pixel of the image is not fully opaque
+func HasTransparency(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Composite the image over a solid background, leaving no transparency
+func flatten(img image.Image, background color.Color) image.Image {
+	if background == nil {
+		background = DefaultBackground
+	}
+
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	return flat
+}
@@ -0,0 +1,107 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// A logo-like image: an opaque square in the middle of a transparent canvas
+func generateLogo(opaque bool) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{0, 0, 255, 0}
+			if opaque || (x >= 16 && x < 48 && y >= 16 && y < 48) {
+				c.A = 255
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func TestConvertImageAlpha(t *testing.T) {
+	testCases := []struct {
+		name              string
+		opaque            bool
+		policy            AlphaPolicy
+		background        color.Color
+		expectContentType string
+		expectDiscarded   bool
+		expectCorner      color.NRGBA
+	}{
+		{
+			name:              "FlattenOntoDefault",
+			policy:            AlphaFlatten,
+			expectContentType: "image/jpeg",
+			expectDiscarded:   true,
+			expectCorner:      DefaultBackground,
+		},
+		{
+			name:              "FlattenOntoBackground",
+			policy:            AlphaFlatten,
+			background:        color.NRGBA{255, 0, 0, 255},
+			expectContentType: "image/jpeg",
+			expectDiscarded:   true,
+			expectCorner:      color.NRGBA{255, 0, 0, 255},
+		},
+		{
+			name:              "KeepTransparency",
+			policy:            AlphaKeep,
+			expectContentType: "image/png",
+			expectCorner:      color.NRGBA{0, 0, 255, 0},
+		},
+		{
+			name:              "KeepOpaqueAsJPEG",
+			opaque:            true,
+			policy:            AlphaKeep,
+			expectContentType: "image/jpeg",
+			expectCorner:      color.NRGBA{0, 0, 255, 255},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := encode(t, generateLogo(tc.opaque), imaging.PNG)
+			converted, err := ConvertImage(data, EncodeOptions{Quality: 100, Background: tc.background}, tc.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if converted.ContentType != tc.expectContentType || converted.TransparencyDiscarded != tc.expectDiscarded {
+				t.Errorf("Expected %s with transparency discarded %v, got: %s %v",
+					tc.expectContentType, tc.expectDiscarded, converted.ContentType, converted.TransparencyDiscarded)
+			}
+
+			img, err := imaging.Decode(bytes.NewReader(converted.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			corner := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+			if !closeColor(corner, tc.expectCorner) {
+				t.Errorf("Expected corner %v, got: %v", tc.expectCorner, corner)
+			}
+		})
+	}
+}
+
+// Whether two colours are the same, allowing for JPEG's lossy encoding
+func closeColor(a color.NRGBA, b color.NRGBA) bool {
+	near := func(x uint8, y uint8) bool { return int(x)-int(y) < 8 && int(y)-int(x) < 8 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && a.A == b.A
+}
+
+func TestParseAlphaPolicy(t *testing.T) {
+	for value, expect := range map[string]AlphaPolicy{"": AlphaFlatten, "flatten": AlphaFlatten, "keep": AlphaKeep} {
+		if policy, err := ParseAlphaPolicy(value); err != nil || policy != expect {
+			t.Errorf("Expected %q to parse as %s, got: %s %v", value, expect, policy, err)
+		}
+	}
+	if _, err := ParseAlphaPolicy("drop"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,225 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+)
+
+// Decode data as an animation, or return nil when it isn't a GIF with more than one frame
+func decodeAnimation(data []byte) *gif.GIF {
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil
+	}
+
+	animation, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(animation.Image) < 2 {
+		return nil
+	}
+
+	return animation
+}
+
+// IsAnimated reports whether data is a GIF with more than one frame
+func IsAnimated(data []byte) bool {
+	return decodeAnimation(data) != nil
+}
+
+// Apply the transforms to every frame of an animation, keeping each frame's
+// delay and disposal. Every frame is transformed on a transparent canvas the
+// size of the whole animation so that the frames still line up afterwards.
+func transformAnimation(animation *gif.GIF, transforms []func(image.Image) image.Image) ([]byte, error) {
+	canvas := image.Rect(0, 0, animation.Config.Width, animation.Config.Height)
+	result := &gif.GIF{
+		Delay:           animation.Delay,
+		Disposal:        animation.Disposal,
+		LoopCount:       animation.LoopCount,
+		BackgroundIndex: animation.BackgroundIndex,
+	}
+
+	for _, frame := range animation.Image {
+		var img image.Image = image.NewNRGBA(canvas)
+		draw.Draw(img.(draw.Image), frame.Bounds(), frame, frame.Bounds().Min, draw.Src)
+
+		// The area the frame covers goes through the same transforms, so the
+		// frame keeps to its own area and its disposal clears no more than before
+		var area image.Image = image.NewNRGBA(canvas)
+		draw.Draw(area.(draw.Image), frame.Bounds(), image.White, image.Point{}, draw.Src)
+
+		for _, transform := range transforms {
+			img = transform(img)
+			area = transform(area)
+		}
+		if img.Bounds().Empty() {
+			return nil, ErrCropOutsideImage
+		}
+
+		result.Image = append(result.Image, paletteFrame(img, opaqueBounds(area), frame.Palette))
+		result.Config.Width, result.Config.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, result); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// The smallest rectangle holding every pixel at least half opaque. Resampling
+// softens the edges of an area, and GIF frames can only cover a pixel or not.
+func opaqueBounds(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	found := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a >= 0x8000 {
+				found = found.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	// Every frame needs at least one pixel
+	if found.Empty() {
+		return image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+1, bounds.Min.Y+1)
+	}
+
+	return found
+}
+
+// Draw the part of a transformed frame within bounds back onto the frame's
+// palette. GIF transparency is all or nothing, so pixels less than half
+// opaque become transparent.
+func paletteFrame(img image.Image, bounds image.Rectangle, palette color.Palette) *image.Paletted {
+	palette = append(color.Palette(nil), palette...)
+	transparent := -1
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent = i
+			break
+		}
+	}
+	if transparent < 0 {
+		if len(palette) == 256 {
+			palette = palette[:255]
+		}
+		palette = append(palette, color.Transparent)
+		transparent = len(palette) - 1
+	}
+
+	// Opaque pixels take the closest of the other entries
+	var opaque color.Palette
+	var opaqueIndexes []uint8
+	for i, c := range palette {
+		if i != transparent {
+			opaque = append(opaque, c)
+			opaqueIndexes = append(opaqueIndexes, uint8(i))
+		}
+	}
+	if len(opaque) == 0 {
+		palette = append(palette, color.Black)
+		opaque, opaqueIndexes = color.Palette{color.Black}, []uint8{uint8(len(palette) - 1)}
+	}
+
+	// Finding the closest palette entry is slow, and frames repeat colours a lot
+	indexes := map[color.NRGBA]uint8{}
+	result := image.NewPaletted(bounds, palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				result.SetColorIndex(x, y, uint8(transparent))
+				continue
+			}
+
+			c.A = 255
+			index, ok := indexes[c]
+			if !ok {
+				index = opaqueIndexes[opaque.Index(c)]
+				indexes[c] = index
+			}
+			result.SetColorIndex(x, y, index)
+		}
+	}
+
+	return result
+}
+
+// ExtractFrame returns frame n of an animation, drawn as it appears when
+// played, as a PNG. Frame 0 of a still image is the image itself.
+func ExtractFrame(data []byte, n int) ([]byte, error) {
+	animation := decodeAnimation(data)
+	if animation == nil {
+		if n != 0 {
+			return nil, errors.New("a still image only has frame 0")
+		}
+		return data, nil
+	}
+	if n < 0 || n >= len(animation.Image) {
+		return nil, fmt.Errorf("frame must be from 0 to %d", len(animation.Image)-1)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, composeFrame(animation, n)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Play an animation up to frame n, applying each earlier frame's disposal
+func composeFrame(animation *gif.GIF, n int) *image.NRGBA {
+	canvas := image.NewNRGBA(image.Rect(0, 0, animation.Config.Width, animation.Config.Height))
+	for i, frame := range animation.Image[:n+1] {
+		disposal := byte(gif.DisposalNone)
+		if i < len(animation.Disposal) {
+			disposal = animation.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == n {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return canvas
+}
+
+// A copy of the pipeline suited to animations. A smart crop would choose a
+// different region for every frame, so animations are cropped from the centre.
+func (p Pipeline) forAnimation() Pipeline {
+	result := make(Pipeline, len(p))
+	for i, step := range p {
+		result[i] = step
+		if step.Params["gravity"] != "smart" {
+			continue
+		}
+
+		params := map[string]string{}
+		for key, value := range step.Params {
+			params[key] = value
+		}
+		params["gravity"] = "center"
+		result[i].Params = params
+	}
+
+	return result
+}
@@ -0,0 +1,156 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPipelineAnimation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		pipeline     Pipeline
+		expectSize   image.Point
+		expectSquare image.Rectangle
+	}{
+		{
+			name:         "Resize",
+			pipeline:     Pipeline{{Op: "resize", Params: map[string]string{"width": "20"}}},
+			expectSize:   image.Pt(20, 15),
+			expectSquare: image.Rect(5, 5, 10, 10),
+		},
+		{
+			name:         "Rotate",
+			pipeline:     Pipeline{{Op: "rotate", Params: map[string]string{"angle": "90"}}},
+			expectSize:   image.Pt(30, 40),
+			expectSquare: image.Rect(10, 20, 20, 30),
+		},
+		{
+			name:         "SmartCrop",
+			pipeline:     Pipeline{{Op: "crop", Params: map[string]string{"width": "20", "height": "20", "gravity": "smart"}}},
+			expectSize:   image.Pt(20, 20),
+			expectSquare: image.Rect(0, 5, 10, 15),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := ApplyPipeline(GenerateAnimatedGIF(t), tc.pipeline, EncodeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			animation, err := gif.DecodeAll(bytes.NewReader(result))
+			if err != nil {
+				t.Fatalf("Expected a GIF: %v", err)
+			}
+			if len(animation.Image) != 3 {
+				t.Fatalf("Expected 3 frames, got %d", len(animation.Image))
+			}
+			if !reflect.DeepEqual(animation.Delay, []int{10, 20, 30}) {
+				t.Errorf("Expected the delays kept, got %v", animation.Delay)
+			}
+			if !reflect.DeepEqual(animation.Disposal, []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone}) {
+				t.Errorf("Expected the disposal kept, got %v", animation.Disposal)
+			}
+			if size := image.Pt(animation.Config.Width, animation.Config.Height); size != tc.expectSize {
+				t.Errorf("Expected %v, got %v", tc.expectSize, size)
+			}
+
+			// The cleared square keeps to its own area so its disposal clears no more
+			if bounds := animation.Image[1].Bounds(); bounds != tc.expectSquare {
+				t.Errorf("Expected the square's frame at %v, got %v", tc.expectSquare, bounds)
+			}
+			middle := tc.expectSquare.Min.Add(tc.expectSquare.Size().Div(2))
+			if got := color.NRGBAModel.Convert(animation.Image[1].At(middle.X, middle.Y)).(color.NRGBA); got != (color.NRGBA{0, 0, 255, 255}) {
+				t.Errorf("Expected the square to stay blue, got %v", got)
+			}
+		})
+	}
+}
+
+func TestExtractFrame(t *testing.T) {
+	red, blue, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 255, 0, 255}
+
+	testCases := []struct {
+		name        string
+		frame       int
+		expect      map[image.Point]color.NRGBA
+		expectError bool
+	}{
+		{name: "First", frame: 0, expect: map[image.Point]color.NRGBA{{15, 15}: red}},
+		{name: "Square", frame: 1, expect: map[image.Point]color.NRGBA{{15, 15}: blue, {30, 25}: red}},
+		{name: "AfterDisposal", frame: 2, expect: map[image.Point]color.NRGBA{{15, 15}: {}, {5, 5}: green, {30, 25}: red}},
+		{name: "OutOfRange", frame: 3, expectError: true},
+		{name: "Negative", frame: -1, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poster, err := ExtractFrame(GenerateAnimatedGIF(t), tc.frame)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected the frame to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := png.Decode(bytes.NewReader(poster))
+			if err != nil {
+				t.Fatalf("Expected a PNG: %v", err)
+			}
+			for point, want := range tc.expect {
+				if got := color.NRGBAModel.Convert(img.At(point.X, point.Y)).(color.NRGBA); got != want {
+					t.Errorf("Expected %v at %v, got %v", want, point, got)
+				}
+			}
+		})
+	}
+
+	still := GenerateJPG(t)
+	if poster, err := ExtractFrame(still, 0); err != nil || !bytes.Equal(poster, still) {
+		t.Errorf("Expected frame 0 of a still image to be the image, got %v", err)
+	}
+	if _, err := ExtractFrame(still, 1); err == nil {
+		t.Error("Expected frame 1 of a still image to be rejected")
+	}
+}
+
+func TestConvertAnimation(t *testing.T) {
+	animation := GenerateAnimatedGIF(t)
+	converted, err := ConvertImage(animation, EncodeOptions{}, AlphaFlatten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !converted.Animated || converted.ContentType != "image/gif" || !bytes.Equal(converted.Data, animation) {
+		t.Errorf("Expected the animation stored as uploaded, got %s animated %v", converted.ContentType, converted.Animated)
+	}
+
+	// A GIF of one frame is just an image
+	var single bytes.Buffer
+	if err := gif.Encode(&single, generateFlat(color.NRGBA{255, 0, 0, 255}), nil); err != nil {
+		t.Fatal(err)
+	}
+	converted, err = ConvertImage(single.Bytes(), EncodeOptions{}, AlphaFlatten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.Animated || converted.ContentType != "image/jpeg" {
+		t.Errorf("Expected a still GIF converted to JPEG, got %s", converted.ContentType)
+	}
+
+	renditions, err := GenerateRenditions(animation, Renditions{"thumb": {{Op: "fill", Params: map[string]string{"width": "10", "height": "10"}}}}, EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAnimated(renditions["thumb"]) {
+		t.Error("Expected the rendition of an animation to be animated")
+	}
+}
@@ -0,0 +1,127 @@
+package shared
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// The BlurHash is worked out from a copy of the image shrunk to fit this many pixels across
+const blurHashSampleSize = 32
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes the image as a BlurHash (https://blurha.sh) of xComponents
+// by yComponents cosine terms, each from 1 to 9. Transparent areas are blended
+// with the default background first.
+func BlurHash(img image.Image, xComponents int, yComponents int) string {
+	xComponents = max(1, min(9, xComponents))
+	yComponents = max(1, min(9, yComponents))
+
+	small := imaging.Fit(img, blurHashSampleSize, blurHashSampleSize, imaging.Box)
+	if HasTransparency(small) {
+		small = imaging.Clone(flatten(small, DefaultBackground))
+	}
+	width, height := small.Bounds().Dx(), small.Bounds().Dy()
+
+	linear := make([][3]float64, width*height)
+	for i := range linear {
+		for channel := 0; channel < 3; channel++ {
+			linear[i][channel] = srgbToLinear(small.Pix[i*4+channel])
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for y := 0; y < yComponents; y++ {
+		for x := 0; x < xComponents; x++ {
+			normalisation := 2.0
+			if x == 0 && y == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for py := 0; py < height; py++ {
+				for px := 0; px < width; px++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(x*px)/float64(width)) *
+						math.Cos(math.Pi*float64(y*py)/float64(height))
+					for channel, value := range linear[py*width+px] {
+						factor[channel] += basis * value
+					}
+				}
+			}
+			for channel := range factor {
+				factor[channel] /= float64(width * height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	// The AC terms are scaled by the largest of them, which is stored first
+	maximum := 1.0
+	if len(factors) > 1 {
+		largest := 0.0
+		for _, factor := range factors[1:] {
+			for _, value := range factor {
+				largest = max(largest, math.Abs(value))
+			}
+		}
+		quantised := int(max(0, min(82, math.Floor(largest*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash.WriteString(encodeBase83(quantised, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		value := 0
+		for _, component := range factor {
+			quantised := int(max(0, min(18, math.Floor(signPow(component/maximum, 0.5)*9+9.5))))
+			value = value*19 + quantised
+		}
+		hash.WriteString(encodeBase83(value, 2))
+	}
+
+	return hash.String()
+}
+
+func encodeBase83(value int, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = base83Characters[value%83]
+		value /= 83
+	}
+
+	return string(encoded)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := max(0, min(1, value))
+	if v <= 0.0031308 {
+		return int(math.Round(v * 12.92 * 255))
+	}
+
+	return int(math.Round((1.055*math.Pow(v, 1/2.4) - 0.055) * 255))
+}
+
+// Raise the magnitude to the power, keeping the sign
+func signPow(value float64, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
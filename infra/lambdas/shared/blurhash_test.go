@@ -0,0 +1,109 @@
+package shared
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Rebuild an image from a BlurHash, following the reference decoder
+func decodeBlurHash(t *testing.T, hash string, width int, height int) *image.NRGBA {
+	decode := func(s string) int {
+		value := 0
+		for _, c := range s {
+			value = value*83 + strings.IndexRune(base83Characters, c)
+		}
+		return value
+	}
+
+	sizeFlag := decode(hash[:1])
+	xComponents, yComponents := sizeFlag%9+1, sizeFlag/9+1
+	if len(hash) != 4+2*xComponents*yComponents {
+		t.Fatalf("BlurHash %s is the wrong length for %dx%d components", hash, xComponents, yComponents)
+	}
+	maximum := float64(decode(hash[1:2])+1) / 166
+
+	dc := decode(hash[2:6])
+	factors := [][3]float64{{srgbToLinear(uint8(dc >> 16)), srgbToLinear(uint8(dc >> 8)), srgbToLinear(uint8(dc))}}
+	for i := 1; i < xComponents*yComponents; i++ {
+		value := decode(hash[4+2*i : 6+2*i])
+		factors = append(factors, [3]float64{
+			signPow(float64(value/(19*19)-9)/9, 2) * maximum,
+			signPow(float64(value/19%19-9)/9, 2) * maximum,
+			signPow(float64(value%19-9)/9, 2) * maximum,
+		})
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var pixel [3]float64
+			for j := 0; j < yComponents; j++ {
+				for i := 0; i < xComponents; i++ {
+					basis := math.Cos(math.Pi*float64(x*i)/float64(width)) * math.Cos(math.Pi*float64(y*j)/float64(height))
+					for channel := range pixel {
+						pixel[channel] += factors[j*xComponents+i][channel] * basis
+					}
+				}
+			}
+			img.Set(x, y, color.NRGBA{uint8(linearToSRGB(pixel[0])), uint8(linearToSRGB(pixel[1])), uint8(linearToSRGB(pixel[2])), 255})
+		}
+	}
+
+	return img
+}
+
+func TestBlurHash(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+	testCases := []struct {
+		name        string
+		img         image.Image
+		xComponents int
+		yComponents int
+		// The size flag, then the average colour
+		expectedPrefix string
+		expectedLength int
+	}{
+		{name: "Default", img: twoTone(40, 30, 0, white, white), xComponents: 4, yComponents: 3, expectedPrefix: "L", expectedLength: 28},
+		{name: "DCOnly", img: twoTone(40, 30, 0, white, white), xComponents: 1, yComponents: 1, expectedPrefix: "00TSUA", expectedLength: 6},
+		{name: "ComponentsClamped", img: twoTone(40, 30, 0, white, white), xComponents: 20, yComponents: 0, expectedPrefix: "8", expectedLength: 22},
+		{name: "Red", img: twoTone(40, 30, 40, color.NRGBA{R: 255, A: 255}, white), xComponents: 1, yComponents: 1, expectedPrefix: "00TI:j", expectedLength: 6},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hash := BlurHash(tc.img, tc.xComponents, tc.yComponents)
+			if !strings.HasPrefix(hash, tc.expectedPrefix) || len(hash) != tc.expectedLength {
+				t.Errorf("Expected %d characters starting %s, got: %s", tc.expectedLength, tc.expectedPrefix, hash)
+			}
+		})
+	}
+}
+
+func TestBlurHashTransparency(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	transparent := BlurHash(twoTone(40, 30, 0, color.NRGBA{}, color.NRGBA{}), 4, 3)
+	if expected := BlurHash(twoTone(40, 30, 0, white, white), 4, 3); transparent != expected {
+		t.Errorf("Expected transparency to be blended with white, got: %s instead of %s", transparent, expected)
+	}
+}
+
+func TestBlurHashRoundTrip(t *testing.T) {
+	// Black on the left and white on the right should come back darker on the
+	// left, and much the same from top to bottom. A few components blur the edge a lot.
+	hash := BlurHash(twoTone(40, 30, 20, color.Black, color.White), 4, 3)
+	decoded := decodeBlurHash(t, hash, 32, 24)
+
+	for _, y := range []int{0, 12, 23} {
+		left, right := decoded.NRGBAAt(2, y), decoded.NRGBAAt(29, y)
+		if int(left.R)+96 > int(right.R) {
+			t.Errorf("Expected dark left and light right at row %d, got: %v and %v", y, left, right)
+		}
+		if top := decoded.NRGBAAt(29, 0); int(top.R)-int(right.R) > 24 {
+			t.Errorf("Expected little change down the image, got: %v at the top and %v at row %d", top, right, y)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ContentHashMetadataKey is the S3 user metadata key set on a name that points
+// at content-addressed image bytes. S3 returns metadata keys in lower case.
+const ContentHashMetadataKey = "content-sha256"
+
+// Key prefixes used internally which user supplied image names may not start with
+var reservedPrefixes = []string{
+	"content/",
+	recordPrefix,
+	idempotencyPrefix,
+	"archives/",
+	jobPrefix,
+	jobOutputPrefix,
+	webhookPrefix,
+	renditionPrefix, watermarkPrefix,
+	metadataPrefix,
+	perceptualIndexPrefix,
+}
+
+// Compute the SHA-256 of the image data as a hex string
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// The key holding the bytes for a content hash
+func ContentKey(hash string) string {
+	return "content/" + hash
+}
+
+// The key holding a generated ZIP archive
+func ArchiveKey(id string) string {
+	return "archives/" + id + ".zip"
+}
+
+// IsReservedName reports whether name collides with a key prefix used internally
+func IsReservedName(name string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsHiddenName reports whether name is an internal object that is never served
+// as an image. Job outputs are reserved so that uploads can't replace them, but
+// are still images.
+func IsHiddenName(name string) bool {
+	return IsReservedName(name) && !strings.HasPrefix(name, jobOutputPrefix)
+}
+
+// List the user visible image names under a prefix
+func ListImageNames(ctx context.Context, s3Client S3ObjectAPI, bucketName string, prefix string) ([]string, error) {
+	var names []string
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			if name := aws.ToString(object.Key); !IsHiddenName(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// Get the object for an image name, following the pointer left by a
+// deduplicated upload to the content-addressed bytes. Internal objects share
+// the bucket, so hidden names are never found.
+func GetImageObject(ctx context.Context, s3Client S3ObjectAPI, bucketName string, name string) (*s3.GetObjectOutput, error) {
+	if IsHiddenName(name) {
+		return nil, NotFoundError()
+	}
+
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(name),
+	})
+	if err != nil || output == nil {
+		return output, err
+	}
+
+	if hash := output.Metadata[ContentHashMetadataKey]; hash != "" {
+		output.Body.Close()
+		return s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(ContentKey(hash)),
+		})
+	}
+
+	return output, nil
+}
@@ -0,0 +1,68 @@
+package shared
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	// SHA-256 of the empty string
+	expected := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if hash := ContentHash([]byte{}); hash != expected {
+		t.Errorf("Expected hash %s, got: %s", expected, hash)
+	}
+
+	if ContentHash([]byte("a")) == ContentHash([]byte("b")) {
+		t.Error("Expected different data to hash differently")
+	}
+}
+
+func TestIsReservedName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reserved bool
+	}{
+		{name: "image.jpg", reserved: false},
+		{name: "photos/content/image.jpg", reserved: false},
+		{name: ContentKey("abc"), reserved: true},
+		{name: JobOutputKey("abc", "png"), reserved: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if IsReservedName(tc.name) != tc.reserved {
+				t.Errorf("Expected reserved: %v for %s", tc.reserved, tc.name)
+			}
+		})
+	}
+}
+
+func TestIsHiddenName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hidden bool
+	}{
+		{name: "image.jpg", hidden: false},
+		{name: JobOutputKey("abc", "png"), hidden: false},
+		{name: JobKey("abc"), hidden: true},
+		{name: ContentKey("abc"), hidden: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if IsHiddenName(tc.name) != tc.hidden {
+				t.Errorf("Expected hidden: %v for %s", tc.hidden, tc.name)
+			}
+		})
+	}
+}
+
+func TestGetImageObjectReservedName(t *testing.T) {
+	client := NewMockS3Client()
+	client.Objects["webhooks/acme/subscriptions/sub.json"] = &MockS3Object{Body: []byte("[]")}
+
+	_, err := GetImageObject(context.TODO(), client, "bucket", "webhooks/acme/subscriptions/sub.json")
+	if !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got: %v", err)
+	}
+}
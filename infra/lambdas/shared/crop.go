@@ -0,0 +1,254 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Largest side of the copy of an image analysed for a smart crop
+const smartCropAnalysisSize = 128
+
+// FocalPoint is the point of an image that crops should keep, as fractions
+// of the width and height measured from the top left.
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Check the focal point lies within the image
+func (f FocalPoint) Validate() error {
+	if f.X < 0 || f.X > 1 || f.Y < 0 || f.Y > 1 {
+		return errors.New("focal point coordinates must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// A cropper cuts a width x height region, no larger than the image, out of an image
+type cropper func(img image.Image, width int, height int) image.Image
+
+var gravities = map[string]imaging.Anchor{
+	"center":    imaging.Center,
+	"north":     imaging.Top,
+	"northeast": imaging.TopRight,
+	"east":      imaging.Right,
+	"southeast": imaging.BottomRight,
+	"south":     imaging.Bottom,
+	"southwest": imaging.BottomLeft,
+	"west":      imaging.Left,
+	"northwest": imaging.TopLeft,
+}
+
+// Crop either the rectangle at x, y of width x height, or a width x height
+// region chosen by gravity: a compass direction, "focal" or "smart"
+func cropOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	width, err := intParam(params, "width", 0, 1, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+	height, err := intParam(params, "height", 0, 1, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+	if width == 0 || height == 0 {
+		return nil, errors.New("width and height are required")
+	}
+
+	_, hasX := params["x"]
+	_, hasY := params["y"]
+	if hasX || hasY {
+		if _, ok := params["gravity"]; ok {
+			return nil, errors.New("gravity can't be combined with x and y")
+		}
+		x, err := intParam(params, "x", 0, 0, maxDimension)
+		if err != nil {
+			return nil, err
+		}
+		y, err := intParam(params, "y", 0, 0, maxDimension)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(img image.Image) image.Image {
+			origin := img.Bounds().Min
+			return imaging.Crop(img, image.Rect(x, y, x+width, y+height).Add(origin))
+		}, nil
+	}
+
+	crop, err := gravityParam(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image) image.Image {
+		size := img.Bounds().Size()
+		return crop(img, min(width, size.X), min(height, size.Y))
+	}, nil
+}
+
+// Read the gravity parameter of crop and fill, defaulting to the centre
+func gravityParam(params map[string]string) (cropper, error) {
+	gravity, ok := params["gravity"]
+	if !ok {
+		gravity = "center"
+	}
+
+	switch gravity {
+	case "smart":
+		return smartCrop, nil
+	case "focal":
+		x, err := floatParam(params, "focusX", 0.5, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		y, err := floatParam(params, "focusY", 0.5, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return focalCrop(FocalPoint{X: x, Y: y}), nil
+	}
+
+	anchor, ok := gravities[gravity]
+	if !ok {
+		return nil, fmt.Errorf("unknown gravity %q", gravity)
+	}
+
+	return func(img image.Image, width int, height int) image.Image {
+		return imaging.CropAnchor(img, width, height, anchor)
+	}, nil
+}
+
+// Crop centred on the focal point, shifted as little as needed to stay inside the image
+func focalCrop(focus FocalPoint) cropper {
+	return func(img image.Image, width int, height int) image.Image {
+		bounds := img.Bounds()
+		x := clamp(int(focus.X*float64(bounds.Dx()))-width/2, 0, bounds.Dx()-width)
+		y := clamp(int(focus.Y*float64(bounds.Dy()))-height/2, 0, bounds.Dy()-height)
+
+		return imaging.Crop(img, image.Rect(x, y, x+width, y+height).Add(bounds.Min))
+	}
+}
+
+// Crop the region with the most edge energy, which is where the detail, and
+// usually the subject, of a photo is. Ties go to the region nearest the centre.
+func smartCrop(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+
+	scale := 1.0
+	if longest := max(bounds.Dx(), bounds.Dy()); longest > smartCropAnalysisSize {
+		scale = float64(smartCropAnalysisSize) / float64(longest)
+	}
+	small := imaging.Grayscale(imaging.Resize(img,
+		max(1, int(math.Round(float64(bounds.Dx())*scale))),
+		max(1, int(math.Round(float64(bounds.Dy())*scale))),
+		imaging.Box))
+	sums := edgeEnergySums(small)
+
+	smallSize := small.Bounds().Size()
+	windowWidth := clamp(int(math.Round(float64(width)*scale)), 1, smallSize.X)
+	windowHeight := clamp(int(math.Round(float64(height)*scale)), 1, smallSize.Y)
+
+	bestX, bestY := 0, 0
+	bestEnergy, bestDistance := int64(-1), math.Inf(1)
+	for y := 0; y+windowHeight <= smallSize.Y; y++ {
+		for x := 0; x+windowWidth <= smallSize.X; x++ {
+			energy := sums.sum(x, y, x+windowWidth, y+windowHeight)
+			distance := math.Hypot(float64(2*x+windowWidth-smallSize.X), float64(2*y+windowHeight-smallSize.Y))
+			if energy > bestEnergy || (energy == bestEnergy && distance < bestDistance) {
+				bestX, bestY, bestEnergy, bestDistance = x, y, energy, distance
+			}
+		}
+	}
+
+	x := clamp(int(math.Round(float64(bestX)/scale)), 0, bounds.Dx()-width)
+	y := clamp(int(math.Round(float64(bestY)/scale)), 0, bounds.Dy()-height)
+
+	return imaging.Crop(img, image.Rect(x, y, x+width, y+height).Add(bounds.Min))
+}
+
+// A summed-area table, so the total of any rectangle can be read in constant time
+type summedArea struct {
+	width  int
+	values []int64
+}
+
+func (s summedArea) sum(x0 int, y0 int, x1 int, y1 int) int64 {
+	at := func(x, y int) int64 { return s.values[y*(s.width+1)+x] }
+	return at(x1, y1) - at(x0, y1) - at(x1, y0) + at(x0, y0)
+}
+
+// Sum the gradient magnitude of a grayscale image
+func edgeEnergySums(gray *image.NRGBA) summedArea {
+	size := gray.Bounds().Size()
+	luma := func(x, y int) int64 {
+		x, y = clamp(x, 0, size.X-1), clamp(y, 0, size.Y-1)
+		return int64(gray.Pix[y*gray.Stride+x*4])
+	}
+	abs := func(v int64) int64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+
+	sums := summedArea{width: size.X, values: make([]int64, (size.X+1)*(size.Y+1))}
+	for y := 0; y < size.Y; y++ {
+		var row int64
+		for x := 0; x < size.X; x++ {
+			row += abs(luma(x+1, y)-luma(x-1, y)) + abs(luma(x, y+1)-luma(x, y-1))
+			sums.values[(y+1)*(size.X+1)+x+1] = sums.values[y*(size.X+1)+x+1] + row
+		}
+	}
+
+	return sums
+}
+
+// Fill in the focal point of every step cropping with "focal" gravity that
+// doesn't give its own. The point is in the coordinates of the source image.
+func (p Pipeline) WithFocalPoint(focus *FocalPoint) Pipeline {
+	if focus == nil {
+		return p
+	}
+
+	result := make(Pipeline, len(p))
+	for i, step := range p {
+		result[i] = step
+		if step.Params["gravity"] != "focal" {
+			continue
+		}
+
+		params := map[string]string{}
+		for key, value := range step.Params {
+			params[key] = value
+		}
+		if _, ok := params["focusX"]; !ok {
+			params["focusX"] = strconv.FormatFloat(focus.X, 'f', -1, 64)
+		}
+		if _, ok := params["focusY"]; !ok {
+			params["focusY"] = strconv.FormatFloat(focus.Y, 'f', -1, 64)
+		}
+		result[i].Params = params
+	}
+
+	return result
+}
+
+// Whether any step crops around a stored focal point
+func (p Pipeline) UsesFocalPoint() bool {
+	for _, step := range p {
+		if step.Params["gravity"] == "focal" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func clamp(value int, low int, high int) int {
+	return max(low, min(value, high))
+}
@@ -0,0 +1,199 @@
+package shared
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// A flat grey image with a black and white checkerboard patch at the given rectangle
+func generatePatch(width int, height int, patch image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{128, 128, 128, 255}
+			if (image.Point{x, y}).In(patch) {
+				c = color.NRGBA{0, 0, 0, 255}
+				if (x/4+y/4)%2 == 0 {
+					c = color.NRGBA{255, 255, 255, 255}
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// The fraction of pixels that are not flat grey
+func patchCoverage(img image.Image) float64 {
+	bounds := img.Bounds()
+	patched := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA); c.R != 128 {
+				patched++
+			}
+		}
+	}
+
+	return float64(patched) / float64(bounds.Dx()*bounds.Dy())
+}
+
+func TestCropOperations(t *testing.T) {
+	patch := image.Rect(300, 200, 380, 280)
+	source := generatePatch(400, 300, patch)
+
+	testCases := []struct {
+		name        string
+		op          string
+		params      map[string]string
+		expectSize  image.Point
+		minCoverage float64
+		maxCoverage float64
+		expectErr   bool
+	}{
+		{
+			name:        "Rectangle",
+			op:          "crop",
+			params:      map[string]string{"x": "300", "y": "200", "width": "80", "height": "80"},
+			expectSize:  image.Pt(80, 80),
+			minCoverage: 1,
+			maxCoverage: 1,
+		},
+		{
+			name:        "RectanglePastEdge",
+			op:          "crop",
+			params:      map[string]string{"x": "350", "y": "250", "width": "100", "height": "100"},
+			expectSize:  image.Pt(50, 50),
+			minCoverage: 0.3,
+			maxCoverage: 0.4,
+		},
+		{
+			name:        "GravityCenterMissesPatch",
+			op:          "crop",
+			params:      map[string]string{"width": "80", "height": "80"},
+			expectSize:  image.Pt(80, 80),
+			minCoverage: 0,
+			maxCoverage: 0,
+		},
+		{
+			name:        "GravitySoutheast",
+			op:          "crop",
+			params:      map[string]string{"width": "100", "height": "100", "gravity": "southeast"},
+			expectSize:  image.Pt(100, 100),
+			minCoverage: 0.6,
+			maxCoverage: 0.7,
+		},
+		{
+			name:        "FocalPoint",
+			op:          "crop",
+			params:      map[string]string{"width": "80", "height": "80", "gravity": "focal", "focusX": "0.85", "focusY": "0.8"},
+			expectSize:  image.Pt(80, 80),
+			minCoverage: 1,
+			maxCoverage: 1,
+		},
+		{
+			name:        "Smart",
+			op:          "crop",
+			params:      map[string]string{"width": "80", "height": "80", "gravity": "smart"},
+			expectSize:  image.Pt(80, 80),
+			minCoverage: 0.9,
+			maxCoverage: 1,
+		},
+		{
+			name:        "LargerThanImage",
+			op:          "crop",
+			params:      map[string]string{"width": "1000", "height": "1000", "gravity": "smart"},
+			expectSize:  image.Pt(400, 300),
+			minCoverage: 0.04,
+			maxCoverage: 0.07,
+		},
+		{
+			name:        "FillSmart",
+			op:          "fill",
+			params:      map[string]string{"width": "50", "height": "100", "gravity": "smart"},
+			expectSize:  image.Pt(50, 100),
+			minCoverage: 0.1,
+			maxCoverage: 1,
+		},
+		{
+			name:        "FillGravity",
+			op:          "fill",
+			params:      map[string]string{"width": "50", "height": "100", "gravity": "west"},
+			expectSize:  image.Pt(50, 100),
+			minCoverage: 0,
+			maxCoverage: 0,
+		},
+		{
+			name:      "UnknownGravity",
+			op:        "crop",
+			params:    map[string]string{"width": "80", "height": "80", "gravity": "up"},
+			expectErr: true,
+		},
+		{
+			name:      "RectangleWithGravity",
+			op:        "crop",
+			params:    map[string]string{"x": "0", "y": "0", "width": "80", "height": "80", "gravity": "north"},
+			expectErr: true,
+		},
+		{
+			name:      "FocalPointOutOfRange",
+			op:        "crop",
+			params:    map[string]string{"width": "80", "height": "80", "gravity": "focal", "focusX": "1.5"},
+			expectErr: true,
+		},
+		{
+			name:      "MissingSize",
+			op:        "crop",
+			params:    map[string]string{"width": "80"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transform, err := operations[tc.op](tc.params)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			cropped := transform(source)
+			if cropped.Bounds().Size() != tc.expectSize {
+				t.Fatalf("Expected size %v, got: %v", tc.expectSize, cropped.Bounds().Size())
+			}
+			if coverage := patchCoverage(cropped); coverage < tc.minCoverage || coverage > tc.maxCoverage {
+				t.Errorf("Expected %.2f-%.2f of the crop to be the patch, got: %.2f", tc.minCoverage, tc.maxCoverage, coverage)
+			}
+		})
+	}
+}
+
+func TestWithFocalPoint(t *testing.T) {
+	pipeline := Pipeline{
+		{Op: "crop", Params: map[string]string{"width": "10", "height": "10", "gravity": "focal"}},
+		{Op: "crop", Params: map[string]string{"width": "10", "height": "10", "gravity": "focal", "focusX": "0.1"}},
+		{Op: "resize", Params: map[string]string{"width": "10"}},
+	}
+
+	if !pipeline.UsesFocalPoint() {
+		t.Error("Expected the pipeline to use a focal point")
+	}
+
+	result := pipeline.WithFocalPoint(&FocalPoint{X: 0.25, Y: 0.75})
+	if result[0].Params["focusX"] != "0.25" || result[0].Params["focusY"] != "0.75" {
+		t.Errorf("Expected the focal point to be filled in, got: %v", result[0].Params)
+	}
+	if result[1].Params["focusX"] != "0.1" || result[1].Params["focusY"] != "0.75" {
+		t.Errorf("Expected an explicit focal point to be kept, got: %v", result[1].Params)
+	}
+	if _, ok := pipeline[0].Params["focusX"]; ok {
+		t.Error("Expected the original pipeline to be unchanged")
+	}
+	if len(pipeline.WithFocalPoint(nil)) != len(pipeline) {
+		t.Error("Expected a nil focal point to leave the pipeline alone")
+	}
+}
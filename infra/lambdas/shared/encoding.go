@@ -0,0 +1,198 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strconv"
+)
+
+// Quality the imaging package encodes JPEGs with when none is asked for
+const imagingQuality = 95
+
+// EncodeOptions controls how an image is written as JPEG. The zero value
+// keeps the quality each encoder has always used.
+type EncodeOptions struct {
+	// "jpeg" or "png" for images transformed by a pipeline, JPEG when empty.
+	// Animations stay GIFs.
+	Format string `json:"format,omitempty"`
+	// JPEG quality from 1 to 100
+	Quality int `json:"quality,omitempty"`
+	// When set, the highest quality from MinQuality up to Quality whose
+	// output fits in this many bytes is used
+	MaxBytes int `json:"maxBytes,omitempty"`
+	// Lowest quality the MaxBytes search may go down to
+	MinQuality int `json:"-"`
+	// Colour transparent pixels are flattened onto, DefaultBackground when nil
+	Background color.Color `json:"-"`
+	// Keep an uploaded image's colours in its ICC profile's space and embed
+	// the profile, rather than converting to sRGB. Derivatives are always sRGB.
+	KeepICCProfile bool `json:"-"`
+}
+
+// QualityPolicy holds the server-side default and bounds for JPEG quality.
+// A zero Default keeps the encoder's own default.
+type QualityPolicy struct {
+	Default int
+	Min     int
+	Max     int
+}
+
+// Read the quality policy from JPEG_QUALITY, JPEG_MIN_QUALITY and JPEG_MAX_QUALITY
+func LoadQualityPolicy() (QualityPolicy, error) {
+	policy := QualityPolicy{Min: 1, Max: 100}
+	for name, value := range map[string]*int{
+		"JPEG_QUALITY":     &policy.Default,
+		"JPEG_MIN_QUALITY": &policy.Min,
+		"JPEG_MAX_QUALITY": &policy.Max,
+	} {
+		setting := os.Getenv(name)
+		if setting == "" {
+			continue
+		}
+
+		quality, err := strconv.Atoi(setting)
+		if err != nil || quality < 1 || quality > 100 {
+			return QualityPolicy{}, fmt.Errorf("%s must be a quality from 1 to 100", name)
+		}
+		*value = quality
+	}
+
+	if policy.Min > policy.Max {
+		return QualityPolicy{}, errors.New("JPEG_MIN_QUALITY is above JPEG_MAX_QUALITY")
+	}
+	if policy.Default != 0 && (policy.Default < policy.Min || policy.Default > policy.Max) {
+		return QualityPolicy{}, errors.New("JPEG_QUALITY is outside JPEG_MIN_QUALITY to JPEG_MAX_QUALITY")
+	}
+
+	return policy, nil
+}
+
+// Build the options for a request, where a zero quality or byte budget means none was asked for
+func (p QualityPolicy) Options(quality int, maxBytes int) (EncodeOptions, error) {
+	if quality == 0 {
+		quality = p.Default
+	} else if quality < p.Min || quality > p.Max {
+		return EncodeOptions{}, fmt.Errorf("quality must be from %d to %d", p.Min, p.Max)
+	}
+	if maxBytes < 0 {
+		return EncodeOptions{}, errors.New("maxBytes must not be negative")
+	}
+
+	// A byte budget searches down from the highest allowed quality unless told otherwise
+	if maxBytes > 0 && quality == 0 {
+		quality = p.Max
+	}
+
+	return EncodeOptions{Quality: quality, MaxBytes: maxBytes, MinQuality: p.Min}, nil
+}
+
+// CheckJPEGSettings rejects JPEG settings the encoder can't honour. The
+// standard library only writes baseline JPEGs with 4:2:0 chroma subsampling,
+// so asking for anything else is refused rather than silently ignored.
+func CheckJPEGSettings(progressive bool, chromaSubsampling string) error {
+	if progressive {
+		return errors.New("progressive JPEG encoding is not supported")
+	}
+	if chromaSubsampling != "" && chromaSubsampling != "4:2:0" {
+		return errors.New("only 4:2:0 chroma subsampling is supported")
+	}
+
+	return nil
+}
+
+// Build the options from "quality" and "maxBytes" parameters, refusing
+// "progressive" and "chromaSubsampling" values the encoder can't honour
+func (p QualityPolicy) OptionsFromParams(params map[string]string) (EncodeOptions, error) {
+	progressive := false
+	if value, ok := params["progressive"]; ok {
+		var err error
+		if progressive, err = strconv.ParseBool(value); err != nil {
+			return EncodeOptions{}, errors.New("progressive must be true or false")
+		}
+	}
+	if err := CheckJPEGSettings(progressive, params["chromaSubsampling"]); err != nil {
+		return EncodeOptions{}, err
+	}
+
+	quality, err := intParam(params, "quality", 0, 1, 100)
+	if err != nil {
+		return EncodeOptions{}, err
+	}
+	maxBytes, err := intParam(params, "maxBytes", 0, 1, 1<<30)
+	if err != nil {
+		return EncodeOptions{}, err
+	}
+
+	return p.Options(quality, maxBytes)
+}
+
+// Encode the image in the format the options ask for, JPEG unless told otherwise
+func encodeFormat(img image.Image, options EncodeOptions, defaultQuality int) ([]byte, error) {
+	if options.Format != "png" {
+		return encodeJPEG(img, options, defaultQuality)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encode the image as JPEG, using defaultQuality when the options don't set one
+func encodeJPEG(img image.Image, options EncodeOptions, defaultQuality int) ([]byte, error) {
+	// JPEG has no alpha channel, and the encoder would otherwise turn transparent pixels black
+	if HasTransparency(img) {
+		img = flatten(img, options.Background)
+	}
+
+	quality := options.Quality
+	if quality == 0 {
+		quality = defaultQuality
+	}
+	if options.MaxBytes == 0 {
+		return encodeJPEGQuality(img, quality)
+	}
+
+	// Binary search for the highest quality that fits the budget. If even the
+	// lowest allowed quality is too big, that smallest attempt is returned.
+	low := max(options.MinQuality, 1)
+	high := quality
+	var best []byte
+	for low <= high {
+		mid := (low + high) / 2
+		encoded, err := encodeJPEGQuality(img, mid)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(encoded) <= options.MaxBytes {
+			best = encoded
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if best == nil {
+		return encodeJPEGQuality(img, max(options.MinQuality, 1))
+	}
+
+	return best, nil
+}
+
+func encodeJPEGQuality(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
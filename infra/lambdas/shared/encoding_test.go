@@ -0,0 +1,174 @@
+package shared
+
+import (
+	"image"
+	"testing"
+)
+
+func TestEncodeJPEG(t *testing.T) {
+	source := generatePatch(400, 300, image.Rect(0, 0, 400, 300))
+	sizeAt := func(quality int) int {
+		encoded, err := encodeJPEGQuality(source, quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(encoded)
+	}
+
+	testCases := []struct {
+		name      string
+		options   EncodeOptions
+		minLength int
+		maxLength int
+	}{
+		{
+			name:      "DefaultQuality",
+			options:   EncodeOptions{},
+			minLength: sizeAt(imagingQuality),
+			maxLength: sizeAt(imagingQuality),
+		},
+		{
+			name:      "LowQuality",
+			options:   EncodeOptions{Quality: 20},
+			minLength: sizeAt(20),
+			maxLength: sizeAt(20),
+		},
+		{
+			name:      "FitsBudget",
+			options:   EncodeOptions{Quality: 100, MaxBytes: sizeAt(60), MinQuality: 10},
+			minLength: sizeAt(50),
+			maxLength: sizeAt(60),
+		},
+		{
+			name:      "BudgetAboveQuality",
+			options:   EncodeOptions{Quality: 40, MaxBytes: sizeAt(100)},
+			minLength: sizeAt(40),
+			maxLength: sizeAt(40),
+		},
+		{
+			name:      "BudgetUnreachable",
+			options:   EncodeOptions{Quality: 90, MaxBytes: 100, MinQuality: 10},
+			minLength: sizeAt(10),
+			maxLength: sizeAt(10),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := encodeJPEG(source, tc.options, imagingQuality)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(encoded) < tc.minLength || len(encoded) > tc.maxLength {
+				t.Errorf("Expected %d-%d bytes, got: %d", tc.minLength, tc.maxLength, len(encoded))
+			}
+		})
+	}
+}
+
+func TestQualityPolicy(t *testing.T) {
+	testCases := []struct {
+		name          string
+		env           map[string]string
+		params        map[string]string
+		expectOptions EncodeOptions
+		expectErr     bool
+	}{
+		{
+			name:          "NothingAskedFor",
+			expectOptions: EncodeOptions{MinQuality: 1},
+		},
+		{
+			name:          "ServerDefault",
+			env:           map[string]string{"JPEG_QUALITY": "80"},
+			expectOptions: EncodeOptions{Quality: 80, MinQuality: 1},
+		},
+		{
+			name:          "RequestedQuality",
+			env:           map[string]string{"JPEG_QUALITY": "80"},
+			params:        map[string]string{"quality": "95"},
+			expectOptions: EncodeOptions{Quality: 95, MinQuality: 1},
+		},
+		{
+			name:          "BudgetSearchesFromMax",
+			env:           map[string]string{"JPEG_MIN_QUALITY": "30", "JPEG_MAX_QUALITY": "90"},
+			params:        map[string]string{"maxBytes": "50000"},
+			expectOptions: EncodeOptions{Quality: 90, MaxBytes: 50000, MinQuality: 30},
+		},
+		{
+			name:      "QualityOutOfBounds",
+			env:       map[string]string{"JPEG_MAX_QUALITY": "90"},
+			params:    map[string]string{"quality": "95"},
+			expectErr: true,
+		},
+		{
+			name:      "InvalidQuality",
+			params:    map[string]string{"quality": "best"},
+			expectErr: true,
+		},
+		{
+			name:      "InvalidBudget",
+			params:    map[string]string{"maxBytes": "0"},
+			expectErr: true,
+		},
+		{
+			name:          "BaselineAskedFor",
+			params:        map[string]string{"progressive": "false", "chromaSubsampling": "4:2:0"},
+			expectOptions: EncodeOptions{MinQuality: 1},
+		},
+		{
+			name:      "Progressive",
+			params:    map[string]string{"progressive": "true"},
+			expectErr: true,
+		},
+		{
+			name:      "InvalidProgressive",
+			params:    map[string]string{"progressive": "yes please"},
+			expectErr: true,
+		},
+		{
+			name:      "ChromaSubsampling",
+			params:    map[string]string{"chromaSubsampling": "4:4:4"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			policy, err := LoadQualityPolicy()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			options, err := policy.OptionsFromParams(tc.params)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if options != tc.expectOptions {
+				t.Errorf("Expected options %+v, got: %+v", tc.expectOptions, options)
+			}
+		})
+	}
+}
+
+func TestLoadQualityPolicyInvalid(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"JPEG_QUALITY": "high"},
+		{"JPEG_MIN_QUALITY": "0"},
+		{"JPEG_MIN_QUALITY": "80", "JPEG_MAX_QUALITY": "60"},
+		{"JPEG_QUALITY": "95", "JPEG_MAX_QUALITY": "90"},
+	} {
+		for name, value := range env {
+			t.Setenv(name, value)
+		}
+		if _, err := LoadQualityPolicy(); err == nil {
+			t.Errorf("Expected an error for %v", env)
+		}
+		for name := range env {
+			t.Setenv(name, "")
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package shared
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// EXIF tags read from the first image directory
+const (
+	tagMake        = 0x010F
+	tagModel       = 0x0110
+	tagOrientation = 0x0112
+	tagSoftware    = 0x0131
+	tagDateTime    = 0x0132
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+)
+
+// EXIF tags read from the Exif sub-directory
+const (
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagFocalLength       = 0x920A
+	tagLensModel         = 0xA434
+)
+
+// EXIF tags read from the GPS sub-directory
+const (
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSAltitudeRef  = 5
+	tagGPSAltitude     = 6
+)
+
+// Bytes taken by one value of each TIFF field type
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+var errBadEXIF = errors.New("malformed EXIF data")
+
+// EXIFMetadata holds the camera, time and location fields read from EXIF
+type EXIFMetadata struct {
+	Make         string       `json:"make,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	LensModel    string       `json:"lensModel,omitempty"`
+	Software     string       `json:"software,omitempty"`
+	Orientation  int          `json:"orientation,omitempty"`
+	ExposureTime float64      `json:"exposureTime,omitempty"`
+	FNumber      float64      `json:"fNumber,omitempty"`
+	ISO          int          `json:"iso,omitempty"`
+	FocalLength  float64      `json:"focalLength,omitempty"`
+	TakenAt      string       `json:"takenAt,omitempty"`
+	DigitizedAt  string       `json:"digitizedAt,omitempty"`
+	ModifiedAt   string       `json:"modifiedAt,omitempty"`
+	GPS          *GPSMetadata `json:"gps,omitempty"`
+}
+
+// GPSMetadata is where an image was taken, in signed decimal degrees and metres
+type GPSMetadata struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// A TIFF structure as found in an EXIF segment, after the "Exif\0\0" header
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// One 12 byte directory entry
+type ifdEntry struct {
+	position int
+	tag      uint16
+	kind     uint16
+	count    uint32
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, errBadEXIF
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errBadEXIF
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, 0, errBadEXIF
+	}
+
+	return &tiffReader{data: data, order: order}, order.Uint32(data[4:]), nil
+}
+
+// Read the entries of the directory at offset
+func (r *tiffReader) readIFD(offset uint32) ([]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return nil, errBadEXIF
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(r.data) {
+		return nil, errBadEXIF
+	}
+
+	entries := make([]ifdEntry, count)
+	for i := range entries {
+		position := start + i*12
+		entries[i] = ifdEntry{
+			position: position,
+			tag:      r.order.Uint16(r.data[position:]),
+			kind:     r.order.Uint16(r.data[position+2:]),
+			count:    r.order.Uint32(r.data[position+4:]),
+		}
+	}
+
+	return entries, nil
+}
+
+// Where an entry's value starts and how long it is. Values of four bytes or
+// less are held in the entry itself, longer ones at the offset it holds.
+func (r *tiffReader) valueRange(e ifdEntry) (int, int, error) {
+	size, ok := tiffTypeSizes[e.kind]
+	if !ok || uint64(e.count)*uint64(size) > uint64(len(r.data)) {
+		return 0, 0, errBadEXIF
+	}
+	length := int(e.count) * size
+
+	start := e.position + 8
+	if length > 4 {
+		start = int(r.order.Uint32(r.data[e.position+8:]))
+	}
+	if start < 0 || start+length > len(r.data) {
+		return 0, 0, errBadEXIF
+	}
+
+	return start, length, nil
+}
+
+func (r *tiffReader) value(e ifdEntry) []byte {
+	start, length, err := r.valueRange(e)
+	if err != nil {
+		return nil
+	}
+
+	return r.data[start : start+length]
+}
+
+func (r *tiffReader) ascii(e ifdEntry) string {
+	if e.kind != 2 {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(r.value(e)), "\x00"))
+}
+
+// The first value of a SHORT or LONG entry
+func (r *tiffReader) uint(e ifdEntry) uint32 {
+	value := r.value(e)
+	switch {
+	case e.kind == 3 && len(value) >= 2:
+		return uint32(r.order.Uint16(value))
+	case e.kind == 4 && len(value) >= 4:
+		return r.order.Uint32(value)
+	}
+
+	return 0
+}
+
+// Every value of an unsigned RATIONAL entry
+func (r *tiffReader) rationals(e ifdEntry) []float64 {
+	if e.kind != 5 {
+		return nil
+	}
+
+	value := r.value(e)
+	var result []float64
+	for i := 0; i+8 <= len(value); i += 8 {
+		denominator := r.order.Uint32(value[i+4:])
+		if denominator == 0 {
+			return nil
+		}
+		result = append(result, float64(r.order.Uint32(value[i:]))/float64(denominator))
+	}
+
+	return result
+}
+
+func (r *tiffReader) rational(e ifdEntry) float64 {
+	values := r.rationals(e)
+	if len(values) == 0 {
+		return 0
+	}
+
+	return values[0]
+}
+
+// Read the fields we keep from the TIFF structure of an EXIF segment
+func parseEXIF(data []byte) (*EXIFMetadata, error) {
+	r, offset, err := newTIFFReader(data)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EXIFMetadata{}
+	for _, e := range entries {
+		switch e.tag {
+		case tagMake:
+			result.Make = r.ascii(e)
+		case tagModel:
+			result.Model = r.ascii(e)
+		case tagSoftware:
+			result.Software = r.ascii(e)
+		case tagOrientation:
+			result.Orientation = int(r.uint(e))
+		case tagDateTime:
+			result.ModifiedAt = exifTime(r.ascii(e))
+		case tagExifIFD:
+			if err := parseExifIFD(r, r.uint(e), result); err != nil {
+				return nil, err
+			}
+		case tagGPSIFD:
+			gps, err := parseGPSIFD(r, r.uint(e))
+			if err != nil {
+				return nil, err
+			}
+			result.GPS = gps
+		}
+	}
+
+	return result, nil
+}
+
+func parseExifIFD(r *tiffReader, offset uint32, result *EXIFMetadata) error {
+	entries, err := r.readIFD(offset)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		switch e.tag {
+		case tagExposureTime:
+			result.ExposureTime = r.rational(e)
+		case tagFNumber:
+			result.FNumber = r.rational(e)
+		case tagISO:
+			result.ISO = int(r.uint(e))
+		case tagFocalLength:
+			result.FocalLength = r.rational(e)
+		case tagDateTimeOriginal:
+			result.TakenAt = exifTime(r.ascii(e))
+		case tagDateTimeDigitized:
+			result.DigitizedAt = exifTime(r.ascii(e))
+		case tagLensModel:
+			result.LensModel = r.ascii(e)
+		}
+	}
+
+	return nil
+}
+
+// Read the position from the GPS directory, or nil when it has no latitude and longitude
+func parseGPSIFD(r *tiffReader, offset uint32) (*GPSMetadata, error) {
+	entries, err := r.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var latitude, longitude []float64
+	var latitudeRef, longitudeRef string
+	var altitude *float64
+	belowSeaLevel := false
+	for _, e := range entries {
+		switch e.tag {
+		case tagGPSLatitudeRef:
+			latitudeRef = r.ascii(e)
+		case tagGPSLatitude:
+			latitude = r.rationals(e)
+		case tagGPSLongitudeRef:
+			longitudeRef = r.ascii(e)
+		case tagGPSLongitude:
+			longitude = r.rationals(e)
+		case tagGPSAltitudeRef:
+			value := r.value(e)
+			belowSeaLevel = len(value) > 0 && value[0] == 1
+		case tagGPSAltitude:
+			if values := r.rationals(e); len(values) > 0 {
+				altitude = &values[0]
+			}
+		}
+	}
+	if len(latitude) != 3 || len(longitude) != 3 {
+		return nil, nil
+	}
+
+	result := &GPSMetadata{
+		Latitude:  degrees(latitude, latitudeRef == "S"),
+		Longitude: degrees(longitude, longitudeRef == "W"),
+		Altitude:  altitude,
+	}
+	if altitude != nil && belowSeaLevel {
+		*altitude = -*altitude
+	}
+
+	return result, nil
+}
+
+// Turn degrees, minutes and seconds into signed decimal degrees
+func degrees(dms []float64, negative bool) float64 {
+	value := dms[0] + dms[1]/60 + dms[2]/3600
+	value = math.Round(value*1e7) / 1e7
+	if negative {
+		return -value
+	}
+
+	return value
+}
+
+// Turn an EXIF "2006:01:02 15:04:05" time into "2006-01-02T15:04:05". EXIF
+// times carry no zone, so none is added.
+func exifTime(value string) string {
+	if len(value) != 19 || value[4] != ':' || value[7] != ':' || value[10] != ' ' {
+		return value
+	}
+
+	return value[:4] + "-" + value[5:7] + "-" + value[8:10] + "T" + value[11:]
+}
+
+// Return a copy of the TIFF structure with the GPS directory emptied and every
+// value it held zeroed, so no trace of the position is left in the bytes
+func removeEXIFGPS(data []byte) ([]byte, error) {
+	result := append([]byte(nil), data...)
+	r, offset, err := newTIFFReader(result)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.tag != tagGPSIFD {
+			continue
+		}
+
+		gpsOffset := r.uint(e)
+		gpsEntries, err := r.readIFD(gpsOffset)
+		if err != nil {
+			return nil, err
+		}
+		for _, gps := range gpsEntries {
+			start, length, err := r.valueRange(gps)
+			if err != nil {
+				return nil, fmt.Errorf("GPS tag %d: %w", gps.tag, err)
+			}
+			clear(result[start : start+length])
+			clear(result[gps.position : gps.position+12])
+		}
+		r.order.PutUint16(result[gpsOffset:], 0)
+	}
+
+	return result, nil
+}
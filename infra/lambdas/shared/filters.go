@@ -0,0 +1,90 @@
+package shared
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Build an operation that adjusts the image by a required "amount" between min and max
+func amountOperation(min float64, max float64, adjust func(img image.Image, amount float64) *image.NRGBA) operation {
+	return func(params map[string]string) (func(image.Image) image.Image, error) {
+		if _, ok := params["amount"]; !ok {
+			return nil, errors.New("amount is required")
+		}
+		amount, err := floatParam(params, "amount", 0, min, max)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(img image.Image) image.Image { return adjust(img, amount) }, nil
+	}
+}
+
+// Remove all colour
+func grayscaleOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	return func(img image.Image) image.Image { return imaging.Grayscale(img) }, nil
+}
+
+// Swap every colour for its opposite
+func invertOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	return func(img image.Image) image.Image { return imaging.Invert(img) }, nil
+}
+
+// Tone the image brown like an old photograph. An amount below 100 blends
+// the tone with the original colours.
+func sepiaOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	amount, err := floatParam(params, "amount", 100, 0, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	t := amount / 100
+	return func(img image.Image) image.Image {
+		return applyColorMatrix(img, [3][3]float64{
+			{1 - 0.607*t, 0.769 * t, 0.189 * t},
+			{0.349 * t, 1 - 0.314*t, 0.168 * t},
+			{0.272 * t, 0.534 * t, 1 - 0.869*t},
+		})
+	}, nil
+}
+
+// Rotate every colour's hue by amount degrees, keeping its luminance
+func hueOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	if _, ok := params["amount"]; !ok {
+		return nil, errors.New("amount is required")
+	}
+	degrees, err := floatParam(params, "amount", 0, -180, 180)
+	if err != nil {
+		return nil, err
+	}
+
+	cos, sin := math.Cos(degrees*math.Pi/180), math.Sin(degrees*math.Pi/180)
+	return func(img image.Image) image.Image {
+		return applyColorMatrix(img, [3][3]float64{
+			{0.213 + cos*0.787 - sin*0.213, 0.715 - cos*0.715 - sin*0.715, 0.072 - cos*0.072 + sin*0.928},
+			{0.213 - cos*0.213 + sin*0.143, 0.715 + cos*0.285 + sin*0.140, 0.072 - cos*0.072 - sin*0.283},
+			{0.213 - cos*0.213 - sin*0.787, 0.715 - cos*0.715 + sin*0.715, 0.072 + cos*0.928 + sin*0.072},
+		})
+	}, nil
+}
+
+// Multiply every pixel's red, green and blue by the matrix, leaving alpha alone
+func applyColorMatrix(img image.Image, m [3][3]float64) *image.NRGBA {
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+		return color.NRGBA{
+			R: clampChannel(m[0][0]*r + m[0][1]*g + m[0][2]*b),
+			G: clampChannel(m[1][0]*r + m[1][1]*g + m[1][2]*b),
+			B: clampChannel(m[2][0]*r + m[2][1]*g + m[2][2]*b),
+			A: c.A,
+		}
+	})
+}
+
+func clampChannel(v float64) uint8 {
+	return uint8(math.Round(max(0, min(v, 255))))
+}
@@ -0,0 +1,137 @@
+package shared
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// A small image filled with a single colour
+func generateFlat(c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func TestFilterOperations(t *testing.T) {
+	orange := color.NRGBA{200, 100, 50, 255}
+
+	testCases := []struct {
+		name        string
+		op          string
+		params      map[string]string
+		source      color.NRGBA
+		expectColor color.NRGBA
+		expectErr   bool
+	}{
+		{
+			name:        "Grayscale",
+			op:          "grayscale",
+			source:      orange,
+			expectColor: color.NRGBA{124, 124, 124, 255},
+		},
+		{
+			name:        "Invert",
+			op:          "invert",
+			source:      orange,
+			expectColor: color.NRGBA{55, 155, 205, 255},
+		},
+		{
+			name:        "Sepia",
+			op:          "sepia",
+			source:      orange,
+			expectColor: color.NRGBA{165, 147, 114, 255},
+		},
+		{
+			name:        "SepiaNone",
+			op:          "sepia",
+			params:      map[string]string{"amount": "0"},
+			source:      orange,
+			expectColor: orange,
+		},
+		{
+			name:        "Brightness",
+			op:          "brightness",
+			params:      map[string]string{"amount": "10"},
+			source:      orange,
+			expectColor: color.NRGBA{226, 126, 76, 255},
+		},
+		{
+			name:        "Hue",
+			op:          "hue",
+			params:      map[string]string{"amount": "120"},
+			source:      color.NRGBA{255, 0, 0, 255},
+			expectColor: color.NRGBA{0, 113, 0, 255},
+		},
+		{
+			name:        "HueKeepsAlpha",
+			op:          "hue",
+			params:      map[string]string{"amount": "0"},
+			source:      color.NRGBA{200, 100, 50, 128},
+			expectColor: color.NRGBA{200, 100, 50, 128},
+		},
+		{
+			name:        "BlurFlatImage",
+			op:          "blur",
+			params:      map[string]string{"amount": "2"},
+			source:      orange,
+			expectColor: orange,
+		},
+		{
+			name:      "MissingAmount",
+			op:        "contrast",
+			expectErr: true,
+		},
+		{
+			name:      "BrightnessOutOfRange",
+			op:        "brightness",
+			params:    map[string]string{"amount": "150"},
+			expectErr: true,
+		},
+		{
+			name:      "GammaZero",
+			op:        "gamma",
+			params:    map[string]string{"amount": "0"},
+			expectErr: true,
+		},
+		{
+			name:      "HueOutOfRange",
+			op:        "hue",
+			params:    map[string]string{"amount": "270"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transform, err := operations[tc.op](tc.params)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			result := transform(generateFlat(tc.source))
+			got := color.NRGBAModel.Convert(result.At(1, 1)).(color.NRGBA)
+			if !closeColor(got, tc.expectColor) {
+				t.Errorf("Expected %v, got: %v", tc.expectColor, got)
+			}
+		})
+	}
+}
+
+func TestFilterOperationsRejectNaN(t *testing.T) {
+	for _, op := range []string{"brightness", "contrast", "saturation", "gamma", "hue", "blur", "sharpen", "sepia"} {
+		for _, amount := range []string{"NaN", "Inf", "-Inf"} {
+			if _, err := operations[op](map[string]string{"amount": amount}); err == nil {
+				t.Errorf("Expected %s=%s to be rejected", op, amount)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package shared
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	// Formats uploads may be in, beyond JPEG, PNG and GIF from the standard library
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Every format an upload can be decoded from, by the name image.Decode gives it
+var SupportedFormats = []string{"jpeg", "png", "gif", "webp", "tiff", "bmp"}
+
+// AcceptedFormats are the formats uploads are allowed in.
+type AcceptedFormats []string
+
+// Parse a comma separated list of accepted formats, where an empty string means every supported format
+func ParseAcceptedFormats(config string) (AcceptedFormats, error) {
+	if strings.TrimSpace(config) == "" {
+		return AcceptedFormats(SupportedFormats), nil
+	}
+
+	var formats AcceptedFormats
+	for _, format := range strings.Split(config, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if !slices.Contains(SupportedFormats, format) {
+			return nil, fmt.Errorf("unknown format %q, expected some of %s", format, strings.Join(SupportedFormats, ", "))
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+
+	return formats, nil
+}
+
+// Whether uploads in the format are allowed
+func (a AcceptedFormats) Accepts(format string) bool {
+	return slices.Contains(a, format)
+}
+
+func (a AcceptedFormats) String() string {
+	return strings.Join(a, ", ")
+}
@@ -0,0 +1,39 @@
+package shared
+
+import "testing"
+
+func TestParseAcceptedFormats(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      string
+		expect      string
+		expectError bool
+	}{
+		{name: "Empty", config: "", expect: "jpeg, png, gif, webp, tiff, bmp"},
+		{name: "List", config: "JPG, png,webp,png", expect: "jpeg, png, webp"},
+		{name: "Unknown", config: "jpeg,heic", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			formats, err := ParseAcceptedFormats(tc.config)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected the config to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if formats.String() != tc.expect {
+				t.Errorf("Expected %s, got %s", tc.expect, formats)
+			}
+		})
+	}
+
+	formats, _ := ParseAcceptedFormats("jpeg,png")
+	if !formats.Accepts("png") || formats.Accepts("webp") {
+		t.Errorf("Expected only jpeg and png to be accepted, got %s", formats)
+	}
+}
@@ -0,0 +1,405 @@
+package shared
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// JPEGs carry their ICC profile in APP2 segments starting with this header,
+// split into numbered chunks when it doesn't fit in one
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+const markerAPP2 = 0xE2
+
+// Most profile bytes one APP2 segment can hold after its header and chunk numbers
+const maxICCChunk = 65535 - 2 - 14
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Largest ICC profile read from a PNG. A few kilobytes of iCCP chunk can
+// inflate to gigabytes, and real profiles are far smaller than this.
+const maxPNGICCProfile = 4 * 1024 * 1024
+
+// Linear XYZ under the D50 illuminant the profile connection space uses to linear sRGB
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// An RGB profile described by a tone curve per channel and a matrix to XYZ,
+// as Adobe RGB, Display P3 and most camera profiles are
+type iccProfile struct {
+	curves [3]func(float64) float64
+	toXYZ  [3][3]float64
+}
+
+// Read the ICC profile embedded in a JPEG or PNG, or nil when there is none
+func ReadICCProfile(data []byte) []byte {
+	if bytes.HasPrefix(data, pngSignature) {
+		return readPNGICCProfile(data)
+	}
+
+	segments, _, err := splitJPEG(data)
+	if err != nil {
+		return nil
+	}
+
+	// Chunks are numbered from 1 and may be stored out of order
+	type chunk struct {
+		number byte
+		data   []byte
+	}
+	var chunks []chunk
+	for _, segment := range segments {
+		if segment.marker == markerAPP2 && bytes.HasPrefix(segment.data, iccHeader) && len(segment.data) > len(iccHeader)+2 {
+			chunks = append(chunks, chunk{number: segment.data[len(iccHeader)], data: segment.data[len(iccHeader)+2:]})
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].number < chunks[j].number })
+
+	var profile []byte
+	for _, c := range chunks {
+		profile = append(profile, c.data...)
+	}
+
+	return profile
+}
+
+// Read the profile from a PNG's iCCP chunk
+func readPNGICCProfile(data []byte) []byte {
+	for i := len(pngSignature); i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) || kind == "IDAT" {
+			return nil
+		}
+
+		if kind == "iCCP" {
+			// A name, a zero byte, the compression method and the compressed profile
+			chunk := data[i+8 : i+8+length]
+			nameEnd := bytes.IndexByte(chunk, 0)
+			if nameEnd < 0 || nameEnd+2 > len(chunk) {
+				return nil
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(chunk[nameEnd+2:]))
+			if err != nil {
+				return nil
+			}
+			profile, err := io.ReadAll(io.LimitReader(reader, maxPNGICCProfile+1))
+			if err != nil || len(profile) > maxPNGICCProfile {
+				return nil
+			}
+			return profile
+		}
+		i += 12 + length
+	}
+
+	return nil
+}
+
+// Embed an ICC profile into a JPEG or PNG, replacing any it already has
+func EmbedICCProfile(data []byte, profile []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		return embedPNGICCProfile(data, profile)
+	}
+
+	segments, rest, err := splitJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []jpegSegment
+	for _, segment := range segments {
+		if segment.marker != markerAPP2 || !bytes.HasPrefix(segment.data, iccHeader) {
+			kept = append(kept, segment)
+		}
+	}
+
+	count := (len(profile) + maxICCChunk - 1) / maxICCChunk
+	if count > 255 {
+		return nil, errors.New("ICC profile is too large to embed")
+	}
+	var chunks []jpegSegment
+	for i := 0; i < count; i++ {
+		chunk := append([]byte(nil), iccHeader...)
+		chunk = append(chunk, byte(i+1), byte(count))
+		chunk = append(chunk, profile[i*maxICCChunk:min((i+1)*maxICCChunk, len(profile))]...)
+		chunks = append(chunks, jpegSegment{marker: markerAPP2, data: chunk})
+	}
+
+	return joinJPEG(insertAfterHeader(kept, chunks), rest), nil
+}
+
+// Put an iCCP chunk straight after a PNG's header chunk. Any colour space
+// chunks it had are dropped, as they would contradict the profile.
+func embedPNGICCProfile(data []byte, profile []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(profile)
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	iccp := append([]byte("iCCPICC Profile\x00\x00"), compressed.Bytes()...)
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("PNG chunk is truncated")
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("PNG chunk is truncated")
+		}
+
+		switch string(data[i+4 : i+8]) {
+		case "iCCP", "sRGB":
+		case "IHDR":
+			buf.Write(data[i:end])
+			binary.Write(&buf, binary.BigEndian, uint32(len(iccp)-4))
+			buf.Write(iccp)
+			binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(iccp))
+		default:
+			buf.Write(data[i:end])
+		}
+		i = end
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode an image, converting its colours to sRGB when it carries an ICC profile.
+// Profiles that can't be read leave the colours as they were decoded.
+func decodeSRGB(data []byte) (image.Image, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return inSRGB(img, ReadICCProfile(data)), nil
+}
+
+// Convert pixels described by the profile to sRGB, leaving them as they are
+// when there is no profile or it can't be read
+func inSRGB(img image.Image, profile []byte) image.Image {
+	if len(profile) == 0 {
+		return img
+	}
+
+	converted, err := convertToSRGB(img, profile)
+	if err != nil {
+		log.Printf("Leaving colours unconverted: %v", err)
+		return img
+	}
+
+	return converted
+}
+
+// Convert pixels described by the profile to sRGB
+func convertToSRGB(img image.Image, profile []byte) (image.Image, error) {
+	parsed, err := parseICCProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Straight from the profile's linear RGB to linear sRGB
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyzD50ToSRGB[i][k] * parsed.toXYZ[k][j]
+			}
+		}
+	}
+
+	// Each 8 bit channel value decoded once up front
+	var linear [3][256]float64
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			linear[c][v] = parsed.curves[c](float64(v) / 255)
+		}
+	}
+	if isSRGB(m, linear) {
+		return img, nil
+	}
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		r, g, b := linear[0][c.R], linear[1][c.G], linear[2][c.B]
+		return color.NRGBA{
+			R: encodeSRGB(m[0][0]*r + m[0][1]*g + m[0][2]*b),
+			G: encodeSRGB(m[1][0]*r + m[1][1]*g + m[1][2]*b),
+			B: encodeSRGB(m[2][0]*r + m[2][1]*g + m[2][2]*b),
+			A: c.A,
+		}
+	}), nil
+}
+
+// Whether a conversion would leave every value where it is, as for an sRGB profile
+func isSRGB(m [3][3]float64, linear [3][256]float64) bool {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if math.Abs(m[i][j]-want) > 0.01 {
+				return false
+			}
+		}
+	}
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			if encodeSRGB(linear[c][v]) != uint8(v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Apply the sRGB tone curve to a linear value
+func encodeSRGB(v float64) uint8 {
+	v = max(0, min(v, 1))
+	if v <= 0.0031308 {
+		return clampChannel(v * 12.92 * 255)
+	}
+
+	return clampChannel((1.055*math.Pow(v, 1/2.4) - 0.055) * 255)
+}
+
+// Read the tone curves and matrix of an RGB profile
+func parseICCProfile(profile []byte) (*iccProfile, error) {
+	if len(profile) < 132 {
+		return nil, errors.New("ICC profile is truncated")
+	}
+	if space := string(profile[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("ICC profile colour space %q is not RGB", space)
+	}
+	if pcs := string(profile[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("ICC profile connection space %q is not XYZ", pcs)
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + 12*i
+		if entry+12 > len(profile) {
+			return nil, errors.New("ICC tag table is truncated")
+		}
+		offset := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(profile) {
+			return nil, errors.New("ICC tag is out of range")
+		}
+		tags[string(profile[entry:entry+4])] = profile[offset : offset+size]
+	}
+
+	result := &iccProfile{}
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, ok := tags[name+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, fmt.Errorf("ICC profile has no %sXYZ colourant, only matrix profiles are supported", name)
+		}
+		for row := 0; row < 3; row++ {
+			result.toXYZ[row][c] = s15Fixed16(xyz[8+4*row:])
+		}
+
+		curve, err := parseICCCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, fmt.Errorf("%sTRC: %w", name, err)
+		}
+		result.curves[c] = curve
+	}
+
+	return result, nil
+}
+
+// Read a "curv" or "para" tone curve as a function from encoded to linear values in 0 to 1
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("missing tone curve")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*count {
+			return nil, errors.New("tone curve is truncated")
+		}
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			position := x * float64(count-1)
+			i := min(int(position), count-2)
+			return table[i] + (table[i+1]-table[i])*(position-float64(i))
+		}, nil
+
+	case "para":
+		// The number of parameters each function type takes
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		sizes := []int{1, 3, 4, 5, 7}
+		if kind >= len(sizes) || len(tag) < 12+4*sizes[kind] {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", kind)
+		}
+		var p [7]float64
+		for i := 0; i < sizes[kind]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		return func(x float64) float64 {
+			switch kind {
+			case 1:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			case 4:
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}
+			return math.Pow(x, g)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported tone curve type %q", tag[:4])
+}
+
+func s15Fixed16(data []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(data))) / 65536
+}
@@ -0,0 +1,192 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestConvertToSRGB(t *testing.T) {
+	testCases := []struct {
+		name        string
+		profile     []byte
+		expectColor color.NRGBA
+		expectError bool
+	}{
+		{
+			name:        "DisplayP3",
+			profile:     displayP3Profile(),
+			expectColor: color.NRGBA{215, 93, 31, 255},
+		},
+		{
+			name: "AdobeRGB",
+			profile: buildICCProfile([3][3]float64{
+				{0.6097, 0.2053, 0.1492},
+				{0.3111, 0.6257, 0.0632},
+				{0.0195, 0.0609, 0.7446},
+			}, gammaCurve(2.2)),
+			expectColor: color.NRGBA{227, 100, 42, 255},
+		},
+		{
+			name: "SRGB",
+			profile: buildICCProfile([3][3]float64{
+				{0.4361, 0.3851, 0.1431},
+				{0.2225, 0.7169, 0.0606},
+				{0.0139, 0.0971, 0.7141},
+			}, parametricCurve(2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)),
+			expectColor: color.NRGBA{200, 100, 50, 255},
+		},
+		{
+			name:        "Truncated",
+			profile:     displayP3Profile()[:100],
+			expectError: true,
+		},
+		{
+			name: "NotRGB",
+			profile: func() []byte {
+				profile := displayP3Profile()
+				copy(profile[16:], "CMYK")
+				return profile
+			}(),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			converted, err := convertToSRGB(generateFlat(color.NRGBA{200, 100, 50, 255}), tc.profile)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected the profile to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := color.NRGBAModel.Convert(converted.At(1, 1)).(color.NRGBA); got != tc.expectColor {
+				t.Errorf("Expected %v, got %v", tc.expectColor, got)
+			}
+		})
+	}
+}
+
+func TestICCProfileEmbedding(t *testing.T) {
+	// Big enough to need several APP2 segments
+	large := append(displayP3Profile(), make([]byte, 150000)...)
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, generateFlat(color.NRGBA{200, 100, 50, 255})); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		data    []byte
+		profile []byte
+	}{
+		{name: "JPEG", data: GenerateJPG(t), profile: displayP3Profile()},
+		{name: "JPEGChunked", data: GenerateJPG(t), profile: large},
+		{name: "PNG", data: pngBuf.Bytes(), profile: displayP3Profile()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if profile := ReadICCProfile(tc.data); profile != nil {
+				t.Fatalf("Expected no profile before embedding, got %d bytes", len(profile))
+			}
+
+			embedded, err := EmbedICCProfile(tc.data, tc.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(embedded)); err != nil {
+				t.Fatalf("Image doesn't decode with the profile: %v", err)
+			}
+			if !bytes.Equal(ReadICCProfile(embedded), tc.profile) {
+				t.Error("Expected to read back the embedded profile")
+			}
+
+			// Embedding again replaces the profile
+			again, err := EmbedICCProfile(embedded, displayP3Profile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ReadICCProfile(again), displayP3Profile()) {
+				t.Error("Expected the second profile to replace the first")
+			}
+		})
+	}
+}
+
+func TestPNGICCProfileTooLarge(t *testing.T) {
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, generateFlat(color.NRGBA{200, 100, 50, 255})); err != nil {
+		t.Fatal(err)
+	}
+
+	// Zeros compress to almost nothing, like a decompression bomb
+	embedded, err := EmbedICCProfile(pngBuf.Bytes(), make([]byte, maxPNGICCProfile+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embedded) > 64*1024 {
+		t.Fatalf("Expected the profile to compress, got %d bytes", len(embedded))
+	}
+
+	if profile := ReadICCProfile(embedded); profile != nil {
+		t.Errorf("Expected an oversized profile to be unreadable, got %d bytes", len(profile))
+	}
+}
+
+func TestICCDerivatives(t *testing.T) {
+	source := GenerateJPGWithICCProfile(t, color.NRGBA{200, 100, 50, 255})
+
+	transformed, err := ApplyPipeline(source, Pipeline{{Op: "resize", Params: map[string]string{"width": "32"}}}, EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := ReadICCProfile(transformed); profile != nil {
+		t.Error("Expected derivatives to carry no profile")
+	}
+	img, _ := imaging.Decode(bytes.NewReader(transformed))
+	if got := color.NRGBAModel.Convert(img.At(16, 12)).(color.NRGBA); !closeColor(got, color.NRGBA{215, 93, 31, 255}) {
+		t.Errorf("Expected the derivative converted to sRGB, got %v", got)
+	}
+}
+
+func TestConvertImageICCProfile(t *testing.T) {
+	source := GenerateJPGWithICCProfile(t, color.NRGBA{200, 100, 50, 255})
+
+	testCases := []struct {
+		name          string
+		keep          bool
+		expectColor   color.NRGBA
+		expectProfile bool
+	}{
+		{name: "Convert", expectColor: color.NRGBA{215, 93, 31, 255}},
+		{name: "Keep", keep: true, expectColor: color.NRGBA{200, 100, 50, 255}, expectProfile: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			converted, err := ConvertImage(source, EncodeOptions{KeepICCProfile: tc.keep}, AlphaFlatten)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (ReadICCProfile(converted.Data) != nil) != tc.expectProfile {
+				t.Errorf("Expected a profile %v", tc.expectProfile)
+			}
+
+			img, _ := imaging.Decode(bytes.NewReader(converted.Data))
+			if got := color.NRGBAModel.Convert(img.At(32, 24)).(color.NRGBA); !closeColor(got, tc.expectColor) {
+				t.Errorf("Expected %v, got %v", tc.expectColor, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package shared
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const idempotencyPrefix = "idempotency/"
+
+// IdempotencyRecord is the first response given for an idempotency key, or
+// while that request is still running, a pending claim on the key.
+type IdempotencyRecord struct {
+	Key         string            `json:"key"`
+	PayloadHash string            `json:"payloadHash"`
+	Pending     bool              `json:"pending,omitempty"`
+	Token       string            `json:"token,omitempty"`
+	StatusCode  int               `json:"statusCode"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	Name        string            `json:"name,omitempty"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+// IdempotencyStore keeps responses so that retried requests can be replayed.
+// Get returns nil without an error when the key is unknown or has expired.
+//
+// Claim stores the record as pending unless a live record already holds the
+// key, and reports whether the caller now holds it. Release gives up a claim
+// so that the next attempt runs the request again.
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) (*IdempotencyRecord, error)
+	Put(ctx context.Context, record IdempotencyRecord) error
+	Claim(ctx context.Context, record IdempotencyRecord) (bool, error)
+	Release(ctx context.Context, key string) error
+}
+
+type s3IdempotencyStore struct {
+	s3Client   S3ObjectAPI
+	bucketName string
+}
+
+// Create an IdempotencyStore that keeps records as JSON objects in S3
+func NewS3IdempotencyStore(s3Client S3ObjectAPI, bucketName string) IdempotencyStore {
+	return &s3IdempotencyStore{s3Client: s3Client, bucketName: bucketName}
+}
+
+// Keys are client supplied, so hash them to get a safe object key
+func idempotencyKey(key string) string {
+	return idempotencyPrefix + ContentHash([]byte(key)) + ".json"
+}
+
+func (s *s3IdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := getJSON(ctx, s.s3Client, s.bucketName, idempotencyKey(key), &record)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired records are left for a bucket lifecycle rule to clean up
+	if time.Now().After(record.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &record, nil
+}
+
+func (s *s3IdempotencyStore) Put(ctx context.Context, record IdempotencyRecord) error {
+	return putJSON(ctx, s.s3Client, s.bucketName, idempotencyKey(record.Key), record)
+}
+
+// S3 offers no conditional PutObject in the SDK version we use, so the claim
+// is checked and then marked with a random token that is read back. Of claims
+// that overlap, only the last to write sees its own token; two can both hold
+// the key only if one reads back before the other has even checked.
+func (s *s3IdempotencyStore) Claim(ctx context.Context, record IdempotencyRecord) (bool, error) {
+	existing, err := s.Get(ctx, record.Key)
+	if err != nil || existing != nil {
+		return false, err
+	}
+
+	record.Pending = true
+	if record.Token, err = newRandomID(); err != nil {
+		return false, err
+	}
+	if err := s.Put(ctx, record); err != nil {
+		return false, err
+	}
+
+	stored, err := s.Get(ctx, record.Key)
+	if err != nil {
+		return false, err
+	}
+
+	return stored != nil && stored.Token == record.Token, nil
+}
+
+// Objects can't be deleted through S3ObjectAPI, but an expired record is as
+// good as none
+func (s *s3IdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.Put(ctx, IdempotencyRecord{Key: key})
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+// Create an IdempotencyStore held in memory, for tests and local runs
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}}
+}
+
+func (s *memoryIdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok || time.Now().After(record.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &record, nil
+}
+
+func (s *memoryIdempotencyStore) Put(ctx context.Context, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[record.Key] = record
+
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Claim(ctx context.Context, record IdempotencyRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[record.Key]; ok && !time.Now().After(existing.ExpiresAt) {
+		return false, nil
+	}
+	record.Pending = true
+	s.records[record.Key] = record
+
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
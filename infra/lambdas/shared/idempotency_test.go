@@ -0,0 +1,64 @@
+package shared
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStores(t *testing.T) {
+	stores := map[string]IdempotencyStore{
+		"S3":     NewS3IdempotencyStore(NewMockS3Client(), "bucket"),
+		"Memory": NewMemoryIdempotencyStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			records := []IdempotencyRecord{
+				{Key: "live/key", PayloadHash: "abc", StatusCode: 200, Body: `{}`, ExpiresAt: time.Now().Add(time.Hour)},
+				{Key: "expired", PayloadHash: "abc", StatusCode: 200, Body: `{}`, ExpiresAt: time.Now().Add(-time.Hour)},
+			}
+			for _, record := range records {
+				if err := store.Put(context.TODO(), record); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			record, err := store.Get(context.TODO(), "live/key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record == nil || record.PayloadHash != "abc" || record.StatusCode != 200 {
+				t.Errorf("Unexpected record: %+v", record)
+			}
+
+			for _, key := range []string{"expired", "missing"} {
+				record, err := store.Get(context.TODO(), key)
+				if err != nil || record != nil {
+					t.Errorf("Expected no record for %s, got: %+v, %v", key, record, err)
+				}
+			}
+
+			pending := IdempotencyRecord{Key: "expired", PayloadHash: "def", ExpiresAt: time.Now().Add(time.Minute)}
+			for i, expectClaimed := range []bool{true, false} {
+				claimed, err := store.Claim(context.TODO(), pending)
+				if err != nil || claimed != expectClaimed {
+					t.Errorf("Expected claim %d to succeed: %v, got: %v, %v", i, expectClaimed, claimed, err)
+				}
+			}
+			if record, _ := store.Get(context.TODO(), "expired"); record == nil || !record.Pending || record.PayloadHash != "def" {
+				t.Errorf("Expected a pending record, got: %+v", record)
+			}
+			if claimed, _ := store.Claim(context.TODO(), IdempotencyRecord{Key: "live/key", ExpiresAt: time.Now().Add(time.Minute)}); claimed {
+				t.Error("Expected a key with a recorded response not to be claimed")
+			}
+
+			if err := store.Release(context.TODO(), "expired"); err != nil {
+				t.Fatal(err)
+			}
+			if claimed, err := store.Claim(context.TODO(), pending); err != nil || !claimed {
+				t.Errorf("Expected a released key to be claimed again, got: %v, %v", claimed, err)
+			}
+		})
+	}
+}
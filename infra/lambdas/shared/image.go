@@ -2,49 +2,140 @@ package shared
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
+	"image/png"
 	"log"
+	"strings"
 
 	"github.com/disintegration/imaging"
 )
 
 // Rotate the image by 180 degrees and resize
-func RotateAndResize(body []byte) ([]byte, error) {
-	img, err := imaging.Decode(bytes.NewReader(body))
+func RotateAndResize(body []byte, options EncodeOptions) ([]byte, error) {
+	return RotateAndResizeWith(body, nil, options)
+}
+
+// Rotate the image by 180 degrees and resize, then apply the pipeline, such
+// as a caption or watermark, before the image is encoded
+func RotateAndResizeWith(body []byte, pipeline Pipeline, options EncodeOptions) ([]byte, error) {
+	rotateAndResize := []func(image.Image) image.Image{
+		func(img image.Image) image.Image { return imaging.Rotate180(img) },
+		func(img image.Image) image.Image { return imaging.Resize(img, 1280, 720, imaging.ResampleFilter{}) },
+	}
+
+	if animation := decodeAnimation(body); animation != nil {
+		transforms, err := pipeline.forAnimation().transforms()
+		if err != nil {
+			return nil, err
+		}
+		return transformAnimation(animation, append(rotateAndResize, transforms...))
+	}
+
+	transforms, err := pipeline.transforms()
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeSRGB(body)
 	if err != nil {
 		log.Printf("Error decoding image: %v", err)
 		return nil, errors.New("error decoding image")
 	}
 
-	img = imaging.Rotate180(img)
-	img = imaging.Resize(img, 1280, 720, imaging.ResampleFilter{})
+	for _, transform := range append(rotateAndResize, transforms...) {
+		img = transform(img)
+	}
 
-	var rotatedImageBuffer bytes.Buffer
-	if err := imaging.Encode(&rotatedImageBuffer, img, imaging.JPEG); err != nil {
+	rotatedImage, err := encodeJPEG(img, options, imagingQuality)
+	if err != nil {
 		log.Printf("Error encoding rotated image: %v", err)
 		return nil, errors.New("error encoding rotated image")
 	}
 
-	return rotatedImageBuffer.Bytes(), nil
+	return rotatedImage, nil
 }
 
-// Try to convert the image data to JPEG format
-func TryConvertToJPEG(data []byte) ([]byte, error) {
-	// Decode the image
-	img, _, err := image.Decode(bytes.NewReader(data))
+// Parse a colour written as hex digits, "rrggbb" or "rrggbbaa", with an optional leading #
+func ParseHexColor(value string) (color.NRGBA, error) {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) == 6 {
+		value += "ff"
+	}
+
+	rgba, err := hex.DecodeString(value)
+	if err != nil || len(rgba) != 4 {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q, expected rrggbb or rrggbbaa", value)
+	}
+
+	return color.NRGBA{R: rgba[0], G: rgba[1], B: rgba[2], A: rgba[3]}, nil
+}
+
+// ConvertedImage is uploaded image data converted for storage.
+type ConvertedImage struct {
+	Data        []byte
+	ContentType string
+	// Whether transparent pixels were flattened onto the background colour
+	TransparencyDiscarded bool
+	// Whether the image is an animated GIF, stored as uploaded
+	Animated bool
+}
+
+// Try to convert the image data to JPEG format, flattening any transparency
+func TryConvertToJPEG(data []byte, options EncodeOptions) ([]byte, error) {
+	converted, err := ConvertImage(data, options, AlphaFlatten)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a buffer to hold the JPEG-encoded image
-	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, img, nil)
+	return converted.Data, nil
+}
+
+// Convert the image data to JPEG, or to PNG when it has transparency the policy keeps.
+// Animated GIFs are left as they are.
+func ConvertImage(data []byte, options EncodeOptions, alpha AlphaPolicy) (ConvertedImage, error) {
+	// Animations are kept as they are, as converting them would keep only the first frame
+	if IsAnimated(data) {
+		return ConvertedImage{Data: data, ContentType: "image/gif", Animated: true}, nil
+	}
+
+	// Decode the image
+	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return ConvertedImage{}, err
+	}
+
+	// Colours are either moved to sRGB or left as they are with the profile describing them
+	profile := ReadICCProfile(data)
+	if !options.KeepICCProfile {
+		img = inSRGB(img, profile)
+	}
+
+	converted := ConvertedImage{ContentType: "image/jpeg"}
+	transparent := HasTransparency(img)
+	if transparent && alpha == AlphaKeep {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return ConvertedImage{}, err
+		}
+		converted.Data, converted.ContentType = buf.Bytes(), "image/png"
+	} else {
+		// Encode as JPEG, at the standard library's default quality unless asked otherwise
+		if converted.Data, err = encodeJPEG(img, options, jpeg.DefaultQuality); err != nil {
+			return ConvertedImage{}, err
+		}
+		converted.TransparencyDiscarded = transparent
+	}
+
+	if options.KeepICCProfile && len(profile) > 0 {
+		if converted.Data, err = EmbedICCProfile(converted.Data, profile); err != nil {
+			return ConvertedImage{}, err
+		}
 	}
 
-	// Return the JPEG-encoded data
-	return buf.Bytes(), nil
+	return converted, nil
 }
@@ -51,7 +51,7 @@ func TestTryConvertToJPEG(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := TryConvertToJPEG(tc.imageData)
+			_, err := TryConvertToJPEG(tc.imageData, EncodeOptions{})
 			if tc.expectSuccess {
 				if err != nil {
 					t.Errorf("Expected successful conversion but got an error: %v", err)
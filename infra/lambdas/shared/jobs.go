@@ -0,0 +1,159 @@
+package shared
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	jobPrefix       = "jobs/"
+	jobOutputPrefix = "outputs/"
+)
+
+// JobMetadataKey is the S3 user metadata key set on a job's output to the ID
+// of the job that wrote it, so a redelivered job can tell its own output apart.
+const JobMetadataKey = "job-id"
+
+// JobStatus is the processing state of a Job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a transform pipeline to run against a stored image in the background.
+// Without an output name, the worker picks the OutputKey once it knows the output's format.
+type Job struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant,omitempty"`
+	SourceKey string    `json:"sourceKey"`
+	Pipeline  Pipeline  `json:"pipeline"`
+	OutputKey string    `json:"outputKey,omitempty"`
+	Status    JobStatus `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Generate a random job ID
+func NewJobID() (string, error) {
+	return newRandomID()
+}
+
+func newRandomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// The key holding the state of a job
+func JobKey(id string) string {
+	return jobPrefix + id + ".json"
+}
+
+// The key a job writes its output to when no output name is given, with an
+// extension for the format the output was written in. Uploads may not use
+// these names, but they are served like any other image.
+func JobOutputKey(id string, format string) string {
+	if format == "jpeg" || format == "" {
+		format = "jpg"
+	}
+
+	return jobOutputPrefix + id + "." + format
+}
+
+// Store the current state of a job
+func PutJob(ctx context.Context, s3Client S3ObjectAPI, bucketName string, job Job) error {
+	return putJSON(ctx, s3Client, bucketName, JobKey(job.ID), job)
+}
+
+// Fetch the current state of a job
+func GetJob(ctx context.Context, s3Client S3ObjectAPI, bucketName string, id string) (*Job, error) {
+	var job Job
+	if err := getJSON(ctx, s3Client, bucketName, JobKey(id), &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// JobQueue hands job IDs to the worker.
+type JobQueue interface {
+	Enqueue(ctx context.Context, jobID string) error
+}
+
+// SQSSendMessageAPI is an interface for sending Amazon SQS messages.
+type SQSSendMessageAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+type sqsJobQueue struct {
+	sqsClient SQSSendMessageAPI
+	queueURL  string
+}
+
+// Create a JobQueue that sends job IDs to an SQS queue
+func NewSQSJobQueue(sqsClient SQSSendMessageAPI, queueURL string) JobQueue {
+	return &sqsJobQueue{sqsClient: sqsClient, queueURL: queueURL}
+}
+
+func NewSQSClient() (SQSSendMessageAPI, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return sqs.NewFromConfig(cfg), nil
+}
+
+func (q *sqsJobQueue) Enqueue(ctx context.Context, jobID string) error {
+	_, err := q.sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(jobID),
+	})
+
+	return err
+}
+
+// MemoryJobQueue is a JobQueue held in memory, for tests and local runs.
+type MemoryJobQueue struct {
+	mu     sync.Mutex
+	jobIDs []string
+}
+
+func NewMemoryJobQueue() *MemoryJobQueue {
+	return &MemoryJobQueue{}
+}
+
+func (q *MemoryJobQueue) Enqueue(ctx context.Context, jobID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.jobIDs = append(q.jobIDs, jobID)
+
+	return nil
+}
+
+// Take every queued job ID, oldest first
+func (q *MemoryJobQueue) Drain() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobIDs := q.jobIDs
+	q.jobIDs = nil
+
+	return jobIDs
+}
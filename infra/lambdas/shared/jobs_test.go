@@ -0,0 +1,45 @@
+package shared
+
+import (
+	"context"
+	"testing"
+)
+
+func TestJobs(t *testing.T) {
+	client := NewMockS3Client()
+
+	id, err := NewJobID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := Job{ID: id, SourceKey: "image.jpg", Status: JobQueued}
+	if err := PutJob(context.TODO(), client, "bucket", job); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := GetJob(context.TODO(), client, "bucket", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SourceKey != "image.jpg" || stored.Status != JobQueued {
+		t.Errorf("Unexpected job: %+v", stored)
+	}
+
+	if _, err := GetJob(context.TODO(), client, "bucket", "missing"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got: %v", err)
+	}
+}
+
+func TestMemoryJobQueue(t *testing.T) {
+	queue := NewMemoryJobQueue()
+	queue.Enqueue(context.TODO(), "first")
+	queue.Enqueue(context.TODO(), "second")
+
+	if jobIDs := queue.Drain(); len(jobIDs) != 2 || jobIDs[0] != "first" {
+		t.Errorf("Unexpected job IDs: %v", jobIDs)
+	}
+	if jobIDs := queue.Drain(); len(jobIDs) != 0 {
+		t.Errorf("Expected an empty queue, got: %v", jobIDs)
+	}
+}
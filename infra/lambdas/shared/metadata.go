@@ -0,0 +1,399 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Parsed metadata is stored as a JSON sidecar under this prefix
+const metadataPrefix = "metadata/"
+
+// Headers identifying the kind of an APP segment
+var (
+	exifHeader         = []byte("Exif\x00\x00")
+	xmpHeader          = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtensionHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	photoshopHeader    = []byte("Photoshop 3.0\x00")
+)
+
+// JPEG markers
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP1 = 0xE1
+	markerAPPD = 0xED
+)
+
+var errNotJPEG = errors.New("not a JPEG")
+
+// MetadataPolicy decides which EXIF, IPTC and XMP metadata is kept when an
+// image is written.
+type MetadataPolicy string
+
+const (
+	// Keep no metadata at all
+	MetadataStripAll MetadataPolicy = "strip-all"
+	// Keep everything except where the image was taken. XMP can repeat the
+	// EXIF position in any form, so it is dropped too.
+	MetadataStripGPS MetadataPolicy = "strip-gps"
+	// Keep all metadata
+	MetadataPreserve MetadataPolicy = "preserve"
+)
+
+// ImageMetadata is what was read from an image's metadata at upload
+type ImageMetadata struct {
+	EXIF *EXIFMetadata `json:"exif,omitempty"`
+	IPTC *IPTCMetadata `json:"iptc,omitempty"`
+	XMP  string        `json:"xmp,omitempty"`
+}
+
+// IPTCMetadata holds the descriptive fields read from IPTC
+type IPTCMetadata struct {
+	Title     string   `json:"title,omitempty"`
+	Caption   string   `json:"caption,omitempty"`
+	Keywords  []string `json:"keywords,omitempty"`
+	Creator   string   `json:"creator,omitempty"`
+	Copyright string   `json:"copyright,omitempty"`
+	City      string   `json:"city,omitempty"`
+	Country   string   `json:"country,omitempty"`
+}
+
+// MetadataPolicies maps tenants to the metadata policy for images they write
+type MetadataPolicies map[string]MetadataPolicy
+
+// Parse a JSON object of tenant to policy, where an empty config means every
+// tenant has all metadata stripped
+func ParseMetadataPolicies(config string) (MetadataPolicies, error) {
+	policies := MetadataPolicies{}
+	if config == "" {
+		return policies, nil
+	}
+
+	if err := json.Unmarshal([]byte(config), &policies); err != nil {
+		return nil, fmt.Errorf("invalid metadata policies: %w", err)
+	}
+	for tenant, policy := range policies {
+		switch policy {
+		case MetadataStripAll, MetadataStripGPS, MetadataPreserve:
+		default:
+			return nil, fmt.Errorf("metadata policy for tenant %s: unknown policy %q", tenant, policy)
+		}
+	}
+
+	return policies, nil
+}
+
+// The policy for a tenant, falling back to the default tenant's and then to stripping everything
+func (m MetadataPolicies) For(tenant string) MetadataPolicy {
+	if policy, ok := m[tenant]; ok {
+		return policy
+	}
+	if policy, ok := m[DefaultTenant]; ok {
+		return policy
+	}
+
+	return MetadataStripAll
+}
+
+// The key holding the metadata sidecar for an image name
+func MetadataKey(name string) string {
+	return metadataPrefix + name + ".json"
+}
+
+// Store the metadata read from an image
+func PutImageMetadata(ctx context.Context, s3Client S3ObjectAPI, bucketName string, name string, metadata ImageMetadata) error {
+	return putJSON(ctx, s3Client, bucketName, MetadataKey(name), metadata)
+}
+
+// Fetch the metadata stored for an image name
+func GetImageMetadata(ctx context.Context, s3Client S3ObjectAPI, bucketName string, name string) (*ImageMetadata, error) {
+	var metadata ImageMetadata
+	if err := getJSON(ctx, s3Client, bucketName, MetadataKey(name), &metadata); err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// One marker segment before the image data, without its length bytes
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// Split a JPEG into the segments before its first scan and everything from
+// that scan to the end
+func splitJPEG(data []byte) ([]jpegSegment, []byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, nil, errNotJPEG
+	}
+
+	var segments []jpegSegment
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, nil, errNotJPEG
+		}
+		// Markers may be padded with any number of fill bytes
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i+2 >= len(data) {
+			return nil, nil, errNotJPEG
+		}
+
+		marker := data[i]
+		if marker == markerSOS {
+			return segments, data[i-1:], nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+1:]))
+		if length < 2 || i+1+length > len(data) {
+			return nil, nil, errNotJPEG
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[i+3 : i+1+length]})
+		i += 1 + length
+	}
+
+	return nil, nil, errNotJPEG
+}
+
+func joinJPEG(segments []jpegSegment, rest []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, markerSOI})
+	for _, segment := range segments {
+		buf.Write([]byte{0xFF, segment.marker})
+		binary.Write(&buf, binary.BigEndian, uint16(len(segment.data)+2))
+		buf.Write(segment.data)
+	}
+	buf.Write(rest)
+
+	return buf.Bytes()
+}
+
+// Whether a segment holds EXIF, IPTC or XMP metadata
+func isMetadataSegment(segment jpegSegment) bool {
+	switch segment.marker {
+	case markerAPP1:
+		return bytes.HasPrefix(segment.data, exifHeader) ||
+			bytes.HasPrefix(segment.data, xmpHeader) ||
+			bytes.HasPrefix(segment.data, xmpExtensionHeader)
+	case markerAPPD:
+		return bytes.HasPrefix(segment.data, photoshopHeader)
+	}
+
+	return false
+}
+
+// The metadata segments the policy lets through, with the GPS removed from
+// EXIF when it asks for that. EXIF that can't be cleaned is dropped whole.
+func allowedMetadata(segments []jpegSegment, policy MetadataPolicy) []jpegSegment {
+	var allowed []jpegSegment
+	for _, segment := range segments {
+		if !isMetadataSegment(segment) {
+			continue
+		}
+
+		switch policy {
+		case MetadataPreserve:
+			allowed = append(allowed, segment)
+		case MetadataStripGPS:
+			switch {
+			case bytes.HasPrefix(segment.data, exifHeader):
+				cleaned, err := removeEXIFGPS(segment.data[len(exifHeader):])
+				if err != nil {
+					continue
+				}
+				allowed = append(allowed, jpegSegment{marker: segment.marker, data: append(append([]byte(nil), exifHeader...), cleaned...)})
+			case segment.marker == markerAPPD:
+				allowed = append(allowed, segment)
+			}
+		}
+	}
+
+	return allowed
+}
+
+// Apply the policy to the metadata already in a JPEG. Data that isn't a JPEG
+// is returned unchanged.
+func StripMetadata(data []byte, policy MetadataPolicy) []byte {
+	segments, rest, err := splitJPEG(data)
+	if err != nil || policy == MetadataPreserve {
+		return data
+	}
+
+	var kept []jpegSegment
+	for _, segment := range segments {
+		if !isMetadataSegment(segment) {
+			kept = append(kept, segment)
+		}
+	}
+	if len(kept) == len(segments) {
+		return data
+	}
+
+	return joinJPEG(insertAfterHeader(kept, allowedMetadata(segments, policy)), rest)
+}
+
+// Copy the metadata the policy allows from source into a freshly encoded JPEG.
+// If either is not a JPEG, encoded is returned unchanged.
+func CopyMetadata(encoded []byte, source []byte, policy MetadataPolicy) []byte {
+	sourceSegments, _, err := splitJPEG(source)
+	if err != nil {
+		return encoded
+	}
+	segments, rest, err := splitJPEG(encoded)
+	if err != nil {
+		return encoded
+	}
+
+	metadata := allowedMetadata(sourceSegments, policy)
+	if len(metadata) == 0 {
+		return encoded
+	}
+
+	// Whatever the encoder wrote is replaced rather than repeated
+	var kept []jpegSegment
+	for _, segment := range segments {
+		if !isMetadataSegment(segment) {
+			kept = append(kept, segment)
+		}
+	}
+
+	return joinJPEG(insertAfterHeader(kept, metadata), rest)
+}
+
+// Place metadata after a leading JFIF segment, which has to come first
+func insertAfterHeader(segments []jpegSegment, metadata []jpegSegment) []jpegSegment {
+	at := 0
+	if len(segments) > 0 && segments[0].marker == 0xE0 {
+		at = 1
+	}
+
+	result := append([]jpegSegment(nil), segments[:at]...)
+	result = append(result, metadata...)
+	return append(result, segments[at:]...)
+}
+
+// Read the EXIF, IPTC and XMP metadata of a JPEG. Anything malformed is
+// skipped, so an image without readable metadata gives an empty result.
+func ReadMetadata(data []byte) ImageMetadata {
+	var metadata ImageMetadata
+	segments, _, err := splitJPEG(data)
+	if err != nil {
+		return metadata
+	}
+
+	for _, segment := range segments {
+		switch {
+		case segment.marker == markerAPP1 && bytes.HasPrefix(segment.data, exifHeader):
+			if exif, err := parseEXIF(segment.data[len(exifHeader):]); err == nil {
+				metadata.EXIF = exif
+			}
+		case segment.marker == markerAPP1 && bytes.HasPrefix(segment.data, xmpHeader):
+			if xmp := segment.data[len(xmpHeader):]; utf8.Valid(xmp) {
+				metadata.XMP = string(xmp)
+			}
+		case segment.marker == markerAPPD && bytes.HasPrefix(segment.data, photoshopHeader):
+			if iptc := parseIPTC(segment.data[len(photoshopHeader):]); iptc != nil {
+				metadata.IPTC = iptc
+			}
+		}
+	}
+
+	return metadata
+}
+
+// The metadata a policy allows to be kept, matching what it leaves in the image
+func (m ImageMetadata) WithPolicy(policy MetadataPolicy) ImageMetadata {
+	switch policy {
+	case MetadataPreserve:
+		return m
+	case MetadataStripAll:
+		return ImageMetadata{}
+	}
+
+	m.XMP = ""
+	if m.EXIF != nil {
+		exif := *m.EXIF
+		exif.GPS = nil
+		m.EXIF = &exif
+	}
+
+	return m
+}
+
+// Read the IPTC record from the Photoshop image resources of an APP13 segment
+func parseIPTC(data []byte) *IPTCMetadata {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(data[4:])
+
+		// The resource name is a Pascal string padded to an even length
+		nameLength := int(data[6]) + 1
+		nameLength += nameLength % 2
+		if 6+nameLength+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[6+nameLength:]))
+		start := 6 + nameLength + 4
+		if size < 0 || start+size > len(data) {
+			return nil
+		}
+
+		if id == 0x0404 {
+			return parseIPTCRecords(data[start : start+size])
+		}
+		data = data[start+size+size%2:]
+	}
+
+	return nil
+}
+
+// Read the fields we keep from the application record datasets
+func parseIPTCRecords(data []byte) *IPTCMetadata {
+	result := &IPTCMetadata{}
+	found := false
+	for len(data) >= 5 && data[0] == 0x1C {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:]))
+		// Extended lengths are only used for very large datasets, none of which we keep
+		if size&0x8000 != 0 || 5+size > len(data) {
+			break
+		}
+		value := string(data[5 : 5+size])
+		data = data[5+size:]
+		if record != 2 {
+			continue
+		}
+
+		switch dataset {
+		case 5:
+			result.Title = value
+		case 25:
+			result.Keywords = append(result.Keywords, value)
+		case 80:
+			result.Creator = value
+		case 90:
+			result.City = value
+		case 101:
+			result.Country = value
+		case 116:
+			result.Copyright = value
+		case 120:
+			result.Caption = value
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+
+	return result
+}
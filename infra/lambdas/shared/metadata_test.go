@@ -0,0 +1,175 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+func TestReadMetadata(t *testing.T) {
+	metadata := ReadMetadata(GenerateJPGWithMetadata(t))
+
+	exif := metadata.EXIF
+	if exif == nil {
+		t.Fatal("Expected EXIF metadata")
+	}
+	if exif.Make != "Canon" || exif.Model != "EOS R5" || exif.Orientation != 6 {
+		t.Errorf("Unexpected camera: %+v", exif)
+	}
+	if exif.ExposureTime != 0.008 || exif.FNumber != 2.8 || exif.ISO != 400 {
+		t.Errorf("Unexpected exposure: %+v", exif)
+	}
+	if exif.TakenAt != "2024-05-01T18:30:15" || exif.ModifiedAt != "2024-05-02T10:00:00" {
+		t.Errorf("Unexpected timestamps: %+v", exif)
+	}
+	if exif.GPS == nil || exif.GPS.Latitude != 51.5073333 || exif.GPS.Longitude != -0.1276667 ||
+		exif.GPS.Altitude == nil || *exif.GPS.Altitude != 35 {
+		t.Errorf("Unexpected GPS: %+v", exif.GPS)
+	}
+
+	iptc := metadata.IPTC
+	if iptc == nil || iptc.Title != "Tower Bridge" || iptc.Creator != "Jo Bloggs" || iptc.City != "London" ||
+		iptc.Copyright != "(c) Jo Bloggs" || len(iptc.Keywords) != 2 {
+		t.Errorf("Unexpected IPTC: %+v", iptc)
+	}
+	if !bytes.Contains([]byte(metadata.XMP), []byte("exif:GPSLatitude")) {
+		t.Errorf("Expected the XMP packet, got: %q", metadata.XMP)
+	}
+
+	if empty := ReadMetadata(GenerateJPG(t)); empty.EXIF != nil || empty.IPTC != nil || empty.XMP != "" {
+		t.Errorf("Expected no metadata from a plain JPEG, got: %+v", empty)
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	// The seconds of the latitude as a big-endian rational, 264/10
+	latitudeSeconds := []byte{0, 0, 1, 8, 0, 0, 0, 10}
+
+	testCases := []struct {
+		name       string
+		policy     MetadataPolicy
+		expectEXIF bool
+		expectGPS  bool
+		expectIPTC bool
+		expectXMP  bool
+	}{
+		{name: "StripAll", policy: MetadataStripAll},
+		{name: "StripGPS", policy: MetadataStripGPS, expectEXIF: true, expectIPTC: true},
+		{name: "Preserve", policy: MetadataPreserve, expectEXIF: true, expectGPS: true, expectIPTC: true, expectXMP: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stripped := StripMetadata(GenerateJPGWithMetadata(t), tc.policy)
+			if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Fatalf("Stripped image doesn't decode: %v", err)
+			}
+
+			metadata := ReadMetadata(stripped)
+			if (metadata.EXIF != nil) != tc.expectEXIF {
+				t.Errorf("Expected EXIF %v, got: %+v", tc.expectEXIF, metadata.EXIF)
+			}
+			if metadata.EXIF != nil && metadata.EXIF.Make != "Canon" {
+				t.Errorf("Expected the camera to be kept, got: %+v", metadata.EXIF)
+			}
+			if (metadata.EXIF != nil && metadata.EXIF.GPS != nil) != tc.expectGPS {
+				t.Errorf("Expected GPS %v, got: %+v", tc.expectGPS, metadata.EXIF)
+			}
+			if bytes.Contains(stripped, latitudeSeconds) != tc.expectGPS {
+				t.Errorf("Expected the raw GPS values present %v", tc.expectGPS)
+			}
+			if (metadata.IPTC != nil) != tc.expectIPTC {
+				t.Errorf("Expected IPTC %v, got: %+v", tc.expectIPTC, metadata.IPTC)
+			}
+			if (metadata.XMP != "") != tc.expectXMP {
+				t.Errorf("Expected XMP %v, got: %q", tc.expectXMP, metadata.XMP)
+			}
+
+			// The sidecar for the policy describes exactly what is left in the image
+			if sidecar := ReadMetadata(GenerateJPGWithMetadata(t)).WithPolicy(tc.policy); !reflect.DeepEqual(sidecar, metadata) {
+				t.Errorf("Expected the sidecar to match the stripped image, got: %+v and %+v", sidecar, metadata)
+			}
+		})
+	}
+}
+
+func TestCopyMetadata(t *testing.T) {
+	source := GenerateJPGWithMetadata(t)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 24)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := CopyMetadata(buf.Bytes(), source, MetadataStripGPS)
+	config, err := jpeg.DecodeConfig(bytes.NewReader(copied))
+	if err != nil || config.Width != 32 {
+		t.Fatalf("Expected the derivative's own pixels, got: %+v %v", config, err)
+	}
+	metadata := ReadMetadata(copied)
+	if metadata.EXIF == nil || metadata.EXIF.Model != "EOS R5" || metadata.EXIF.GPS != nil {
+		t.Errorf("Expected EXIF without GPS, got: %+v", metadata.EXIF)
+	}
+
+	// Copying twice replaces rather than repeats
+	twice := CopyMetadata(copied, source, MetadataStripGPS)
+	if len(twice) != len(copied) {
+		t.Errorf("Expected copying again to leave %d bytes, got %d", len(copied), len(twice))
+	}
+
+	if result := CopyMetadata(buf.Bytes(), source, MetadataStripAll); !bytes.Equal(result, buf.Bytes()) {
+		t.Error("Expected nothing to be copied when stripping everything")
+	}
+	if result := CopyMetadata(buf.Bytes(), []byte("not an image"), MetadataPreserve); !bytes.Equal(result, buf.Bytes()) {
+		t.Error("Expected a source that isn't a JPEG to be ignored")
+	}
+}
+
+func TestMalformedEXIF(t *testing.T) {
+	data := GenerateJPGWithMetadata(t)
+	segments, rest, err := splitJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the GPS directory past the end of the segment
+	for _, segment := range segments {
+		if bytes.HasPrefix(segment.data, exifHeader) {
+			tiff := segment.data[len(exifHeader):]
+			entry := 8 + 2 + 12*5
+			copy(tiff[entry+8:], []byte{0, 0, 0xFF, 0xFF})
+		}
+	}
+	broken := joinJPEG(segments, rest)
+
+	if metadata := ReadMetadata(broken); metadata.EXIF != nil || metadata.IPTC == nil {
+		t.Errorf("Expected unreadable EXIF to be skipped, got: %+v", metadata)
+	}
+	if metadata := ReadMetadata(StripMetadata(broken, MetadataStripGPS)); metadata.EXIF != nil {
+		t.Errorf("Expected EXIF that can't be cleaned to be dropped, got: %+v", metadata.EXIF)
+	}
+}
+
+func TestMetadataPolicies(t *testing.T) {
+	policies, err := ParseMetadataPolicies(`{"default": "strip-gps", "archive": "preserve"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy := policies.For("archive"); policy != MetadataPreserve {
+		t.Errorf("Expected the tenant's own policy, got %s", policy)
+	}
+	if policy := policies.For("other"); policy != MetadataStripGPS {
+		t.Errorf("Expected the default tenant's policy, got %s", policy)
+	}
+
+	empty, err := ParseMetadataPolicies("")
+	if err != nil || empty.For("other") != MetadataStripAll {
+		t.Errorf("Expected everything stripped without a config, got %s %v", empty.For("other"), err)
+	}
+
+	if _, err := ParseMetadataPolicies(`{"default": "keep-some"}`); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MultipartPartSize is the size of each part but the last. S3 needs parts of
+// at least 5MB, and each is held in memory until it is uploaded.
+const MultipartPartSize = 8 * 1024 * 1024
+
+// S3MultipartAPI is an interface for uploading Amazon S3 objects in parts.
+type S3MultipartAPI interface {
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+func NewS3MultipartClient() (S3MultipartAPI, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(cfg), nil
+}
+
+// MultipartWriter streams what is written to it into an S3 object, a part at
+// a time, so that objects far larger than memory can be written. Close
+// completes the object; after an error, Abort discards what was uploaded.
+type MultipartWriter struct {
+	ctx        context.Context
+	client     S3MultipartAPI
+	bucketName string
+	key        string
+	uploadID   *string
+	buf        bytes.Buffer
+	parts      []types.CompletedPart
+}
+
+// Start a multipart upload of an object
+func NewMultipartWriter(ctx context.Context, client S3MultipartAPI, bucketName string, key string, contentType string) (*MultipartWriter, error) {
+	output, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultipartWriter{ctx: ctx, client: client, bucketName: bucketName, key: key, uploadID: output.UploadId}, nil
+}
+
+func (w *MultipartWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for w.buf.Len() >= MultipartPartSize {
+		if err := w.uploadPart(w.buf.Next(MultipartPartSize)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Upload what is left as the last part and put the object together
+func (w *MultipartWriter) Close() error {
+	if w.buf.Len() > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+		w.buf.Reset()
+	}
+
+	_, err := w.client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucketName),
+		Key:             aws.String(w.key),
+		UploadId:        w.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+
+	return err
+}
+
+// Discard the parts uploaded so far, which S3 otherwise keeps and bills for
+func (w *MultipartWriter) Abort() error {
+	_, err := w.client.AbortMultipartUpload(w.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucketName),
+		Key:      aws.String(w.key),
+		UploadId: w.uploadID,
+	})
+
+	return err
+}
+
+func (w *MultipartWriter) uploadPart(part []byte) error {
+	number := int32(len(w.parts) + 1)
+	output, err := w.client.UploadPart(w.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(w.bucketName),
+		Key:        aws.String(w.key),
+		UploadId:   w.uploadID,
+		PartNumber: number,
+		Body:       bytes.NewReader(part),
+	})
+	if err != nil {
+		return err
+	}
+	if output.ETag == nil {
+		return errors.New("uploaded part has no ETag")
+	}
+	w.parts = append(w.parts, types.CompletedPart{ETag: output.ETag, PartNumber: number})
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestMultipartWriter(t *testing.T) {
+	// Two full parts and a little over
+	large := bytes.Repeat([]byte("0123456789"), MultipartPartSize/5+1)
+
+	testCases := []struct {
+		name        string
+		data        []byte
+		expectParts int
+	}{
+		{name: "Empty", data: nil, expectParts: 1},
+		{name: "OnePart", data: []byte("small"), expectParts: 1},
+		{name: "SeveralParts", data: large, expectParts: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewMockS3Client()
+			writer, err := NewMultipartWriter(context.TODO(), client, "bucket", "object", "application/zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Written in uneven pieces, as a ZIP writer would
+			for data := tc.data; len(data) > 0; {
+				n := min(len(data), 3*1024*1024+7)
+				if _, err := writer.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(writer.parts) != tc.expectParts {
+				t.Errorf("Expected %d parts, got: %d", tc.expectParts, len(writer.parts))
+			}
+			if object, ok := client.Objects["object"]; !ok || !bytes.Equal(object.Body, tc.data) {
+				t.Error("Expected the object to hold everything written")
+			}
+		})
+	}
+}
+
+func TestMultipartWriterAbort(t *testing.T) {
+	client := NewMockS3Client()
+	writer, err := NewMultipartWriter(context.TODO(), client, "bucket", "object", "application/zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(bytes.Repeat([]byte{1}, MultipartPartSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.Objects["object"]; ok || len(client.Uploads) != 0 {
+		t.Error("Expected nothing to be left of the upload")
+	}
+}
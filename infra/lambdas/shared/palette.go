@@ -0,0 +1,173 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// Colours in the palette worked out for each upload
+const paletteSize = 5
+
+// Images are shrunk to fit this many pixels across before their colours are clustered
+const paletteSampleSize = 64
+
+// Most rounds of k-means before settling for the clusters so far
+const paletteIterations = 20
+
+// ImageColors summarise an image for placeholders shown while it loads.
+type ImageColors struct {
+	Width    int            `json:"width,omitempty"`
+	Height   int            `json:"height,omitempty"`
+	Palette  []PaletteColor `json:"palette,omitempty"`
+	BlurHash string         `json:"blurHash,omitempty"`
+}
+
+// PaletteColor is one of an image's dominant colours and the share of the image it covers.
+type PaletteColor struct {
+	Color  string  `json:"color"`
+	Weight float64 `json:"weight"`
+}
+
+// AnalyzeColors works out the size, dominant colours and BlurHash of the image data
+func AnalyzeColors(data []byte) (ImageColors, error) {
+	img, err := decodeSRGB(data)
+	if err != nil {
+		log.Printf("Error decoding image: %v", err)
+		return ImageColors{}, errors.New("error decoding image")
+	}
+
+	size := img.Bounds().Size()
+	return ImageColors{
+		Width:    size.X,
+		Height:   size.Y,
+		Palette:  DominantColors(img, paletteSize),
+		BlurHash: BlurHash(img, 4, 3),
+	}, nil
+}
+
+// DominantColors clusters the colours of a downsampled copy of the image into
+// at most k groups with k-means, most common first. Mostly transparent pixels
+// are left out, and fewer colours are returned when the image has fewer.
+func DominantColors(img image.Image, k int) []PaletteColor {
+	small := imaging.Fit(img, paletteSampleSize, paletteSampleSize, imaging.Box)
+
+	var pixels [][3]float64
+	for i := 0; i < len(small.Pix); i += 4 {
+		if small.Pix[i+3] >= 128 {
+			pixels = append(pixels, [3]float64{float64(small.Pix[i]), float64(small.Pix[i+1]), float64(small.Pix[i+2])})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	centroids := initialCentroids(pixels, k)
+	assignments := make([]int, len(pixels))
+	for iteration := 0; iteration < paletteIterations; iteration++ {
+		changed := false
+		for i, p := range pixels {
+			if nearest := nearestCentroid(centroids, p); nearest != assignments[i] || iteration == 0 {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		// Move each centroid to the mean of its pixels
+		sums := make([][3]float64, len(centroids))
+		counts := make([]int, len(centroids))
+		for i, p := range pixels {
+			c := assignments[i]
+			counts[c]++
+			for channel := range p {
+				sums[c][channel] += p[channel]
+			}
+		}
+		for c := range centroids {
+			if counts[c] > 0 {
+				for channel := range sums[c] {
+					centroids[c][channel] = sums[c][channel] / float64(counts[c])
+				}
+			}
+		}
+	}
+
+	counts := make([]int, len(centroids))
+	for _, c := range assignments {
+		counts[c]++
+	}
+
+	var palette []PaletteColor
+	for c, centroid := range centroids {
+		if counts[c] == 0 {
+			continue
+		}
+		palette = append(palette, PaletteColor{
+			Color:  fmt.Sprintf("#%02x%02x%02x", uint8(math.Round(centroid[0])), uint8(math.Round(centroid[1])), uint8(math.Round(centroid[2]))),
+			Weight: math.Round(float64(counts[c])/float64(len(pixels))*1000) / 1000,
+		})
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Weight > palette[j].Weight })
+
+	return palette
+}
+
+// Pick starting centroids that are spread out, so the result is the same on
+// every run: the pixel nearest the mean, then each time the pixel furthest from
+// the centroids picked so far. Picking stops early once every pixel is a centroid.
+func initialCentroids(pixels [][3]float64, k int) [][3]float64 {
+	var mean [3]float64
+	for _, p := range pixels {
+		for channel := range p {
+			mean[channel] += p[channel] / float64(len(pixels))
+		}
+	}
+
+	first := pixels[0]
+	for _, p := range pixels {
+		if colorDistance(p, mean) < colorDistance(first, mean) {
+			first = p
+		}
+	}
+
+	centroids := [][3]float64{first}
+	for len(centroids) < k {
+		furthest, furthestDistance := pixels[0], 0.0
+		for _, p := range pixels {
+			if distance := colorDistance(p, centroids[nearestCentroid(centroids, p)]); distance > furthestDistance {
+				furthest, furthestDistance = p, distance
+			}
+		}
+		if furthestDistance == 0 {
+			break
+		}
+		centroids = append(centroids, furthest)
+	}
+
+	return centroids
+}
+
+func nearestCentroid(centroids [][3]float64, p [3]float64) int {
+	nearest := 0
+	for c := range centroids {
+		if colorDistance(p, centroids[c]) < colorDistance(p, centroids[nearest]) {
+			nearest = c
+		}
+	}
+
+	return nearest
+}
+
+// Squared distance between two colours
+func colorDistance(a [3]float64, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
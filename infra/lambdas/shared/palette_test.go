@@ -0,0 +1,107 @@
+package shared
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+// Fill the left part of an image with one colour and the rest with another
+func twoTone(width int, height int, split int, left color.Color, right color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(right), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, split, height), image.NewUniform(left), image.Point{}, draw.Src)
+
+	return img
+}
+
+func TestDominantColors(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	testCases := []struct {
+		name     string
+		img      image.Image
+		k        int
+		expected []PaletteColor
+	}{
+		{
+			name:     "MostCommonFirst",
+			img:      twoTone(128, 64, 32, blue, red),
+			k:        5,
+			expected: []PaletteColor{{Color: "#ff0000", Weight: 0.75}, {Color: "#0000ff", Weight: 0.25}},
+		},
+		{
+			name:     "LimitedToK",
+			img:      twoTone(128, 64, 32, blue, red),
+			k:        1,
+			expected: []PaletteColor{{Color: "#bf0040", Weight: 1}},
+		},
+		{
+			name:     "TransparentLeftOut",
+			img:      twoTone(128, 64, 32, color.NRGBA{}, blue),
+			k:        5,
+			expected: []PaletteColor{{Color: "#0000ff", Weight: 1}},
+		},
+		{
+			name: "FullyTransparent",
+			img:  twoTone(16, 16, 8, color.NRGBA{}, color.NRGBA{}),
+			k:    5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			palette := DominantColors(tc.img, tc.k)
+			if !reflect.DeepEqual(palette, tc.expected) {
+				t.Errorf("Expected %+v, got: %+v", tc.expected, palette)
+			}
+		})
+	}
+}
+
+func TestDominantColorsClusters(t *testing.T) {
+	// Two families of close shades should come out as one colour each
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			shade := uint8((x + y) % 8)
+			if x < 32 {
+				img.Set(x, y, color.NRGBA{R: 200 + shade, G: 40, B: 40, A: 255})
+			} else {
+				img.Set(x, y, color.NRGBA{R: 20, G: 120 + shade, B: 60, A: 255})
+			}
+		}
+	}
+
+	palette := DominantColors(img, 2)
+	if len(palette) != 2 || palette[0].Weight != 0.5 || palette[1].Weight != 0.5 {
+		t.Fatalf("Expected two even clusters, got: %+v", palette)
+	}
+	colors := map[string]bool{palette[0].Color: true, palette[1].Color: true}
+	if !colors["#cc2828"] || !colors["#147c3c"] {
+		t.Errorf("Expected the mean of each family, got: %+v", palette)
+	}
+}
+
+func TestAnalyzeColors(t *testing.T) {
+	colors, err := AnalyzeColors(GenerateImage(t, "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colors.Width != 16 || colors.Height != 16 {
+		t.Errorf("Expected a 16x16 image, got: %dx%d", colors.Width, colors.Height)
+	}
+	if expected := []PaletteColor{{Color: "#808080", Weight: 1}}; !reflect.DeepEqual(colors.Palette, expected) {
+		t.Errorf("Expected %+v, got: %+v", expected, colors.Palette)
+	}
+	if len(colors.BlurHash) != 28 {
+		t.Errorf("Expected a 4x3 BlurHash, got: %s", colors.BlurHash)
+	}
+
+	if _, err := AnalyzeColors([]byte("not an image")); err == nil {
+		t.Error("Expected an error for data that isn't an image")
+	}
+}
@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Compute a 64 bit difference hash (dHash) of the image, formatted as 16 hex
+// digits. Re-encoded, resized or lightly edited copies of an image hash to
+// values a small Hamming distance apart.
+func PerceptualHash(body []byte) (string, error) {
+	img, err := imaging.Decode(bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error decoding image: %v", err)
+		return "", errors.New("error decoding image")
+	}
+
+	// Shrink to 9x8 grayscale and compare each pixel with its right neighbour
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := small.Pix[small.PixOffset(x, y)]
+			right := small.Pix[small.PixOffset(x+1, y)]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// Count the bits that differ between two hashes from PerceptualHash
+func HammingDistance(a string, b string) (int, error) {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return bits.OnesCount64(x ^ y), nil
+}
@@ -0,0 +1,113 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Draw a diagonal gradient, optionally mirrored, so images have structure to hash
+func generateGradient(width int, height int, mirror bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x*255/width + y*255/height) / 2)
+			if mirror {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+
+	return img
+}
+
+func encode(t *testing.T, img image.Image, format imaging.Format) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case imaging.PNG:
+		err = png.Encode(&buf, img)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 50})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := generateGradient(640, 480, false)
+	originalHash, err := PerceptualHash(encode(t, original, imaging.PNG))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		imageData   []byte
+		maxDistance int
+		minDistance int
+	}{
+		{
+			name:        "ReencodedCopy",
+			imageData:   encode(t, original, imaging.JPEG),
+			maxDistance: 4,
+		},
+		{
+			name:        "ResizedCopy",
+			imageData:   encode(t, imaging.Resize(original, 160, 120, imaging.Lanczos), imaging.JPEG),
+			maxDistance: 4,
+		},
+		{
+			name:        "DifferentImage",
+			imageData:   encode(t, generateGradient(640, 480, true), imaging.PNG),
+			minDistance: 20,
+			maxDistance: 64,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, err := PerceptualHash(tc.imageData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			distance, err := HammingDistance(originalHash, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if distance < tc.minDistance || distance > tc.maxDistance {
+				t.Errorf("Expected distance between %d and %d, got: %d", tc.minDistance, tc.maxDistance, distance)
+			}
+		})
+	}
+}
+
+func TestPerceptualHashInvalidImage(t *testing.T) {
+	if _, err := PerceptualHash([]byte("This is not an image")); err == nil {
+		t.Error("Expected an error but hashing was successful")
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	distance, err := HammingDistance("00000000000000ff", "000000000000000f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance != 4 {
+		t.Errorf("Expected distance 4, got: %d", distance)
+	}
+
+	if _, err := HammingDistance("not hex", "0"); err == nil {
+		t.Error("Expected an error for an invalid hash")
+	}
+}
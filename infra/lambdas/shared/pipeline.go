@@ -0,0 +1,345 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Largest width or height a pipeline may resize to
+const maxDimension = 8192
+
+// ErrCropOutsideImage is returned by ApplyPipeline when a crop rectangle lies
+// entirely outside the image, leaving nothing to encode. Whether a crop fits
+// is only known once the image is decoded, so Validate can't catch it.
+var ErrCropOutsideImage = errors.New("crop lies outside the image")
+
+// Operation is a single named step of a Pipeline, e.g. {"op": "resize", "params": {"width": "200"}}.
+type Operation struct {
+	Op     string            `json:"op"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Pipeline is a list of operations applied to an image in order.
+type Pipeline []Operation
+
+// An operation parses its parameters and returns the transform to apply
+type operation func(params map[string]string) (func(image.Image) image.Image, error)
+
+var operations = map[string]operation{
+	"resize":     resizeOperation,
+	"fit":        fitOperation,
+	"fill":       fillOperation,
+	"crop":       cropOperation,
+	"rotate":     rotateOperation,
+	"flip":       flipOperation,
+	"transpose":  transposeOperation,
+	"transverse": transverseOperation,
+	"grayscale":  grayscaleOperation,
+	"sepia":      sepiaOperation,
+	"brightness": amountOperation(-100, 100, imaging.AdjustBrightness),
+	"contrast":   amountOperation(-100, 100, imaging.AdjustContrast),
+	"saturation": amountOperation(-100, 100, imaging.AdjustSaturation),
+	"gamma":      amountOperation(0.1, 10, imaging.AdjustGamma),
+	"hue":        hueOperation,
+	"blur":       amountOperation(0.1, 50, imaging.Blur),
+	"sharpen":    amountOperation(0.1, 50, imaging.Sharpen),
+	"invert":     invertOperation,
+	"watermark":  watermarkOperation,
+	"text":       textOperation,
+}
+
+var resampleFilters = map[string]imaging.ResampleFilter{
+	"nearest":  imaging.NearestNeighbor,
+	"box":      imaging.Box,
+	"linear":   imaging.Linear,
+	"catmull":  imaging.CatmullRom,
+	"lanczos":  imaging.Lanczos,
+	"gaussian": imaging.Gaussian,
+}
+
+// Check that every operation is known and has valid parameters. Watermark
+// logos are fetched just before a pipeline runs, so need not be loaded yet.
+func (p Pipeline) Validate() error {
+	for i, step := range p {
+		if _, err := step.transform(); err != nil && !errors.Is(err, errWatermarkNotLoaded) {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (p Pipeline) transforms() ([]func(image.Image) image.Image, error) {
+	var transforms []func(image.Image) image.Image
+	for i, step := range p {
+		transform, err := step.transform()
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i, err)
+		}
+		transforms = append(transforms, transform)
+	}
+
+	return transforms, nil
+}
+
+func (o Operation) transform() (func(image.Image) image.Image, error) {
+	op, ok := operations[o.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", o.Op)
+	}
+
+	transform, err := op(o.Params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.Op, err)
+	}
+
+	return transform, nil
+}
+
+// Decode the image, apply each operation of the pipeline, and encode the result
+// as JPEG, or PNG if the options ask for it. Animations have every frame
+// transformed and stay GIFs.
+func ApplyPipeline(body []byte, pipeline Pipeline, options EncodeOptions) ([]byte, error) {
+	if animation := decodeAnimation(body); animation != nil {
+		transforms, err := pipeline.forAnimation().transforms()
+		if err != nil {
+			return nil, err
+		}
+		return transformAnimation(animation, transforms)
+	}
+
+	transforms, err := pipeline.transforms()
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeSRGB(body)
+	if err != nil {
+		log.Printf("Error decoding image: %v", err)
+		return nil, errors.New("error decoding image")
+	}
+
+	return encodeTransformed(img, transforms, options)
+}
+
+func encodeTransformed(img image.Image, transforms []func(image.Image) image.Image, options EncodeOptions) ([]byte, error) {
+	for _, transform := range transforms {
+		img = transform(img)
+	}
+	if img.Bounds().Empty() {
+		return nil, ErrCropOutsideImage
+	}
+
+	encoded, err := encodeFormat(img, options, imagingQuality)
+	if err != nil {
+		log.Printf("Error encoding transformed image: %v", err)
+		return nil, errors.New("error encoding transformed image")
+	}
+
+	return encoded, nil
+}
+
+// Resize to width x height. A zero width or height preserves the aspect ratio.
+func resizeOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	width, err := intParam(params, "width", 0, 0, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+	height, err := intParam(params, "height", 0, 0, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+	if width == 0 && height == 0 {
+		return nil, errors.New("width or height is required")
+	}
+
+	filter, err := filterParam(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image) image.Image {
+		return imaging.Resize(img, width, height, filter)
+	}, nil
+}
+
+// Scale down to fit within width x height, preserving the aspect ratio
+func fitOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	width, height, filter, err := boxParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image) image.Image {
+		return imaging.Fit(img, width, height, filter)
+	}, nil
+}
+
+// Scale and crop to exactly width x height, keeping the part picked by gravity
+func fillOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	width, height, filter, err := boxParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	gravity, ok := params["gravity"]
+	if !ok {
+		gravity = "center"
+	}
+	if anchor, ok := gravities[gravity]; ok {
+		return func(img image.Image) image.Image {
+			return imaging.Fill(img, width, height, anchor, filter)
+		}, nil
+	}
+
+	crop, err := gravityParam(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image) image.Image {
+		// Cut the largest region with the target aspect ratio, then scale it
+		size := img.Bounds().Size()
+		cropWidth, cropHeight := size.X, size.X*height/width
+		if cropHeight > size.Y {
+			cropWidth, cropHeight = size.Y*width/height, size.Y
+		}
+
+		return imaging.Resize(crop(img, max(1, cropWidth), max(1, cropHeight)), width, height, filter)
+	}, nil
+}
+
+// Read the required width and height and the optional filter of fit and fill
+func boxParams(params map[string]string) (int, int, imaging.ResampleFilter, error) {
+	width, err := intParam(params, "width", 0, 1, maxDimension)
+	if err != nil {
+		return 0, 0, imaging.ResampleFilter{}, err
+	}
+	height, err := intParam(params, "height", 0, 1, maxDimension)
+	if err != nil {
+		return 0, 0, imaging.ResampleFilter{}, err
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, imaging.ResampleFilter{}, errors.New("width and height are required")
+	}
+
+	filter, err := filterParam(params)
+
+	return width, height, filter, err
+}
+
+// Rotate counter-clockwise by angle degrees. Any angle other than a multiple
+// of 90 grows the canvas, and the corners are filled with the background colour.
+func rotateOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	angle, err := floatParam(params, "angle", 0, -360, 360)
+	if err != nil {
+		return nil, err
+	}
+	background, err := colorParam(params, "background", color.White)
+	if err != nil {
+		return nil, err
+	}
+
+	switch math.Mod(angle+360, 360) {
+	case 0:
+		return func(img image.Image) image.Image { return img }, nil
+	case 90:
+		return func(img image.Image) image.Image { return imaging.Rotate90(img) }, nil
+	case 180:
+		return func(img image.Image) image.Image { return imaging.Rotate180(img) }, nil
+	case 270:
+		return func(img image.Image) image.Image { return imaging.Rotate270(img) }, nil
+	default:
+		return func(img image.Image) image.Image { return imaging.Rotate(img, angle, background) }, nil
+	}
+}
+
+// Mirror the image horizontally (left to right) or vertically (top to bottom)
+func flipOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	switch params["direction"] {
+	case "horizontal":
+		return func(img image.Image) image.Image { return imaging.FlipH(img) }, nil
+	case "vertical":
+		return func(img image.Image) image.Image { return imaging.FlipV(img) }, nil
+	default:
+		return nil, fmt.Errorf("direction must be horizontal or vertical, not %q", params["direction"])
+	}
+}
+
+// Mirror along the top left to bottom right diagonal
+func transposeOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	return func(img image.Image) image.Image { return imaging.Transpose(img) }, nil
+}
+
+// Mirror along the top right to bottom left diagonal
+func transverseOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	return func(img image.Image) image.Image { return imaging.Transverse(img) }, nil
+}
+
+// Read the resampling filter, defaulting to Lanczos
+func filterParam(params map[string]string) (imaging.ResampleFilter, error) {
+	name, ok := params["filter"]
+	if !ok {
+		return imaging.Lanczos, nil
+	}
+
+	filter, ok := resampleFilters[name]
+	if !ok {
+		return imaging.ResampleFilter{}, fmt.Errorf("unknown filter %q", name)
+	}
+
+	return filter, nil
+}
+
+// Read a hex colour parameter, falling back to def when it is absent
+func colorParam(params map[string]string, name string, def color.Color) (color.Color, error) {
+	value, ok := params[name]
+	if !ok {
+		return def, nil
+	}
+
+	c, err := ParseHexColor(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return c, nil
+}
+
+// Read a decimal parameter, falling back to def when it is absent. NaN fails
+// every comparison, so it is rejected explicitly rather than by the range check.
+func floatParam(params map[string]string, name string, def float64, min float64, max float64) (float64, error) {
+	value, ok := params[name]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < min || n > max {
+		return 0, fmt.Errorf("%s must be a number between %g and %g", name, min, max)
+	}
+
+	return n, nil
+}
+
+// Read an integer parameter, falling back to def when it is absent
+func intParam(params map[string]string, name string, def int, min int, max int) (int, error) {
+	value, ok := params[name]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", name, min, max)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,161 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestApplyPipeline(t *testing.T) {
+	source := encode(t, generateGradient(640, 480, false), imaging.PNG)
+
+	testCases := []struct {
+		name          string
+		pipeline      Pipeline
+		expectSuccess bool
+		expectSize    image.Point
+	}{
+		{
+			name:          "Empty",
+			pipeline:      Pipeline{},
+			expectSuccess: true,
+			expectSize:    image.Pt(640, 480),
+		},
+		{
+			name: "RotateThenResize",
+			pipeline: Pipeline{
+				{Op: "rotate", Params: map[string]string{"angle": "90"}},
+				{Op: "resize", Params: map[string]string{"width": "240"}},
+			},
+			expectSuccess: true,
+			expectSize:    image.Pt(240, 320),
+		},
+		{
+			name:          "ResizeWithFilter",
+			pipeline:      Pipeline{{Op: "resize", Params: map[string]string{"width": "64", "height": "64", "filter": "nearest"}}},
+			expectSuccess: true,
+			expectSize:    image.Pt(64, 64),
+		},
+		{
+			name:          "Fit",
+			pipeline:      Pipeline{{Op: "fit", Params: map[string]string{"width": "320", "height": "320"}}},
+			expectSuccess: true,
+			expectSize:    image.Pt(320, 240),
+		},
+		{
+			name:          "Fill",
+			pipeline:      Pipeline{{Op: "fill", Params: map[string]string{"width": "200", "height": "200"}}},
+			expectSuccess: true,
+			expectSize:    image.Pt(200, 200),
+		},
+		{
+			name:     "FillMissingHeight",
+			pipeline: Pipeline{{Op: "fill", Params: map[string]string{"width": "200"}}},
+		},
+		{
+			name:          "RotateArbitraryAngle",
+			pipeline:      Pipeline{{Op: "rotate", Params: map[string]string{"angle": "45", "background": "ff0000"}}},
+			expectSuccess: true,
+			expectSize:    image.Pt(792, 792),
+		},
+		{
+			name:          "RotateNegativeRightAngle",
+			pipeline:      Pipeline{{Op: "rotate", Params: map[string]string{"angle": "-90"}}},
+			expectSuccess: true,
+			expectSize:    image.Pt(480, 640),
+		},
+		{
+			name:          "FlipThenTranspose",
+			pipeline:      Pipeline{{Op: "flip", Params: map[string]string{"direction": "vertical"}}, {Op: "transpose"}},
+			expectSuccess: true,
+			expectSize:    image.Pt(480, 640),
+		},
+		{
+			name:     "InvalidBackground",
+			pipeline: Pipeline{{Op: "rotate", Params: map[string]string{"angle": "45", "background": "red"}}},
+		},
+		{
+			name:     "InvalidFlip",
+			pipeline: Pipeline{{Op: "flip", Params: map[string]string{"direction": "diagonal"}}},
+		},
+		{
+			name:     "UnknownOperation",
+			pipeline: Pipeline{{Op: "explode"}},
+		},
+		{
+			name:     "MissingDimensions",
+			pipeline: Pipeline{{Op: "resize"}},
+		},
+		{
+			name:     "DimensionTooLarge",
+			pipeline: Pipeline{{Op: "resize", Params: map[string]string{"width": "100000"}}},
+		},
+		{
+			name:     "UnknownFilter",
+			pipeline: Pipeline{{Op: "resize", Params: map[string]string{"width": "10", "filter": "blurry"}}},
+		},
+		{
+			name:     "InvalidAngle",
+			pipeline: Pipeline{{Op: "rotate", Params: map[string]string{"angle": "sideways"}}},
+		},
+		{
+			name:     "NaNAngle",
+			pipeline: Pipeline{{Op: "rotate", Params: map[string]string{"angle": "NaN"}}},
+		},
+		{
+			name:     "InfiniteAngle",
+			pipeline: Pipeline{{Op: "rotate", Params: map[string]string{"angle": "-Inf"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.pipeline.Validate(); (err == nil) != tc.expectSuccess {
+				t.Errorf("Expected valid: %v, got: %v", tc.expectSuccess, err)
+			}
+
+			output, err := ApplyPipeline(source, tc.pipeline, EncodeOptions{})
+			if !tc.expectSuccess {
+				if err == nil {
+					t.Error("Expected an error but the pipeline was applied")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := imaging.Decode(bytes.NewReader(output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds().Size() != tc.expectSize {
+				t.Errorf("Expected size %v, got: %v", tc.expectSize, img.Bounds().Size())
+			}
+		})
+	}
+}
+
+func TestApplyPipelineInvalidImage(t *testing.T) {
+	if _, err := ApplyPipeline([]byte("This is not an image"), Pipeline{}, EncodeOptions{}); err == nil {
+		t.Error("Expected an error but the pipeline was applied")
+	}
+}
+
+func TestApplyPipelineCropOutsideImage(t *testing.T) {
+	crop := Pipeline{{Op: "crop", Params: map[string]string{"x": "5000", "y": "5000", "width": "100", "height": "100"}}}
+	if err := crop.Validate(); err != nil {
+		t.Fatalf("Expected the crop to be valid until it meets an image, got: %v", err)
+	}
+
+	for name, source := range map[string][]byte{"Still": GenerateJPG(t), "Animation": GenerateAnimatedGIF(t)} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ApplyPipeline(source, crop, EncodeOptions{}); !errors.Is(err, ErrCropOutsideImage) {
+				t.Errorf("Expected ErrCropOutsideImage, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+//go:embed presets.json
+var defaultPresets []byte
+
+// Preset is a server-side transformation: a pipeline, and optionally the
+// format and JPEG quality its output is encoded with.
+type Preset struct {
+	Pipeline Pipeline `json:"pipeline"`
+	// "jpeg" or "png", JPEG when empty
+	Format string `json:"format,omitempty"`
+	// JPEG quality from 1 to 100, the request's or the server default when zero
+	Quality int `json:"quality,omitempty"`
+}
+
+// Presets maps the name of a server-side transformation to its preset, so
+// that clients can ask for e.g. "avatar" instead of passing raw parameters.
+type Presets map[string]Preset
+
+// UnmarshalJSON also accepts a bare list of operations, for presets with no
+// encoding settings of their own
+func (p *Preset) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		*p = Preset{}
+		return json.Unmarshal(data, &p.Pipeline)
+	}
+
+	type preset Preset
+	return json.Unmarshal(data, (*preset)(p))
+}
+
+// Check the pipeline and encoding settings of a preset
+func (p Preset) Validate() error {
+	if err := p.Pipeline.Validate(); err != nil {
+		return err
+	}
+
+	switch p.Format {
+	case "", "jpeg":
+	case "png":
+		if p.Quality != 0 {
+			return errors.New("quality only applies to jpeg")
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected jpeg or png", p.Format)
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return errors.New("quality must be from 1 to 100")
+	}
+
+	return nil
+}
+
+// Load presets from a JSON config file, or the built-in presets.json when path is empty
+func LoadPresets(path string) (Presets, error) {
+	data := defaultPresets
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var presets Presets
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("loading presets: %w", err)
+	}
+	for name, preset := range presets {
+		if !derivativeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("loading presets: invalid preset name %q", name)
+		}
+		if err := preset.Validate(); err != nil {
+			return nil, fmt.Errorf("loading presets: preset %s: %w", name, err)
+		}
+	}
+
+	return presets, nil
+}
+
+// RequestOptions applies the format and quality of the preset named in the
+// query parameters to the request's encoding options. A quality asked for in
+// the request wins over the preset's.
+func (p Presets) RequestOptions(params map[string]string, options EncodeOptions) EncodeOptions {
+	preset, ok := p[params["preset"]]
+	if !ok {
+		return options
+	}
+
+	options.Format = preset.Format
+	if _, asked := params["quality"]; !asked && preset.Quality != 0 {
+		options.Quality = preset.Quality
+	}
+
+	return options
+}
@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPresets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	testCases := []struct {
+		name          string
+		path          string
+		expectPreset  string
+		expectFormat  string
+		expectQuality int
+		expectErr     bool
+	}{
+		{
+			name:          "BuiltIn",
+			path:          "",
+			expectPreset:  "avatar",
+			expectQuality: 80,
+		},
+		{
+			name:         "ConfigFile",
+			path:         write("custom.json", `{"banner": [{"op": "fill", "params": {"width": "1200", "height": "300"}}]}`),
+			expectPreset: "banner",
+		},
+		{
+			name:         "EncodingSettings",
+			path:         write("encoding.json", `{"icon": {"pipeline": [{"op": "fit", "params": {"width": "64", "height": "64"}}], "format": "png"}}`),
+			expectPreset: "icon",
+			expectFormat: "png",
+		},
+		{
+			name:          "Quality",
+			path:          write("quality.json", `{"thumb": {"pipeline": [{"op": "fit", "params": {"width": "64", "height": "64"}}], "quality": 60}}`),
+			expectPreset:  "thumb",
+			expectQuality: 60,
+		},
+		{
+			name:      "UnknownFormat",
+			path:      write("format.json", `{"icon": {"pipeline": [], "format": "bmp"}}`),
+			expectErr: true,
+		},
+		{
+			name:      "QualityForPNG",
+			path:      write("png.json", `{"icon": {"pipeline": [], "format": "png", "quality": 80}}`),
+			expectErr: true,
+		},
+		{
+			name:      "QualityOutOfRange",
+			path:      write("range.json", `{"icon": {"pipeline": [], "quality": 101}}`),
+			expectErr: true,
+		},
+		{
+			name:      "MissingFile",
+			path:      filepath.Join(dir, "missing.json"),
+			expectErr: true,
+		},
+		{
+			name:      "InvalidPipeline",
+			path:      write("invalid.json", `{"banner": [{"op": "explode"}]}`),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			presets, err := LoadPresets(tc.path)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+			preset, ok := presets[tc.expectPreset]
+			if !ok {
+				t.Fatalf("Expected preset %s, got: %v", tc.expectPreset, presets)
+			}
+			if preset.Format != tc.expectFormat || preset.Quality != tc.expectQuality {
+				t.Errorf("Expected format %q and quality %d, got: %+v", tc.expectFormat, tc.expectQuality, preset)
+			}
+		})
+	}
+}
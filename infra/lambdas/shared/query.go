@@ -0,0 +1,124 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Colour filters that may be asked for in the query, in the order they are applied.
+// Each takes an amount, except the flags which are switched on with "true".
+var queryFilters = []string{"grayscale", "sepia", "brightness", "contrast", "saturation", "gamma", "hue", "blur", "sharpen", "invert"}
+
+var queryFlags = map[string]bool{"transpose": true, "transverse": true, "grayscale": true, "invert": true}
+
+// Query parameters that style a caption, and the text operation parameter each sets
+var captionParams = map[string]string{
+	"textFont":        "font",
+	"textSize":        "size",
+	"textColor":       "color",
+	"textStroke":      "stroke",
+	"textStrokeColor": "strokeColor",
+	"textAlign":       "align",
+	"textPosition":    "position",
+	"textBox":         "box",
+}
+
+// QueryPipeline builds the pipeline asked for by the query parameters of a GET,
+// or the params of an archive. Steps run in a fixed order: rotate, flip,
+// transpose or transverse, resize, colour filters, then a caption. A preset
+// and rotate=true are whole transformations of their own, so only a caption
+// may be asked for alongside them.
+func QueryPipeline(params map[string]string) (Pipeline, error) {
+	var pipeline Pipeline
+	var err error
+
+	// rotate=true is the original fixed rotate and resize, handled separately
+	if angle, ok := params["rotate"]; ok && angle != "true" {
+		step := Operation{Op: "rotate", Params: map[string]string{"angle": angle}}
+		if background, ok := params["background"]; ok {
+			step.Params["background"] = background
+		}
+		pipeline = append(pipeline, step)
+	}
+
+	switch flip := params["flip"]; flip {
+	case "":
+	case "both":
+		pipeline = append(pipeline,
+			Operation{Op: "flip", Params: map[string]string{"direction": "horizontal"}},
+			Operation{Op: "flip", Params: map[string]string{"direction": "vertical"}},
+		)
+	default:
+		pipeline = append(pipeline, Operation{Op: "flip", Params: map[string]string{"direction": flip}})
+	}
+
+	for _, op := range []string{"transpose", "transverse"} {
+		if pipeline, err = appendQueryOperation(pipeline, params, op); err != nil {
+			return nil, err
+		}
+	}
+
+	resize := map[string]string{}
+	for _, param := range []string{"width", "height"} {
+		if value, ok := params[param]; ok {
+			resize[param] = value
+		}
+	}
+	if len(resize) > 0 {
+		pipeline = append(pipeline, Operation{Op: "resize", Params: resize})
+	}
+
+	for _, op := range queryFilters {
+		if pipeline, err = appendQueryOperation(pipeline, params, op); err != nil {
+			return nil, err
+		}
+	}
+
+	_, preset := params["preset"]
+	switch {
+	case preset && params["rotate"] == "true":
+		return nil, errors.New("preset and rotate=true can't be combined")
+	case preset && len(pipeline) > 0:
+		return nil, errors.New("preset can only be combined with a caption")
+	case params["rotate"] == "true" && len(pipeline) > 0:
+		return nil, errors.New("rotate=true can only be combined with a caption")
+	}
+
+	pipeline = append(pipeline, CaptionPipeline(params)...)
+
+	return pipeline, pipeline.Validate()
+}
+
+// CaptionPipeline is the caption asked for with the "text" query parameter, if any
+func CaptionPipeline(params map[string]string) Pipeline {
+	text, ok := params["text"]
+	if !ok {
+		return nil
+	}
+
+	step := Operation{Op: "text", Params: map[string]string{"text": text}}
+	for param, textParam := range captionParams {
+		if value, ok := params[param]; ok {
+			step.Params[textParam] = value
+		}
+	}
+
+	return Pipeline{step}
+}
+
+// Add the operation named by a query parameter, if present. Flags are
+// switched on with "true"; anything else is the operation's amount, and
+// sepia=true asks for the full effect.
+func appendQueryOperation(pipeline Pipeline, params map[string]string, op string) (Pipeline, error) {
+	value, ok := params[op]
+	switch {
+	case !ok, value == "false":
+		return pipeline, nil
+	case value == "true" && (queryFlags[op] || op == "sepia"):
+		return append(pipeline, Operation{Op: op}), nil
+	case queryFlags[op]:
+		return nil, fmt.Errorf("%s must be true or false", op)
+	default:
+		return append(pipeline, Operation{Op: op, Params: map[string]string{"amount": value}}), nil
+	}
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryPipeline(t *testing.T) {
+	testCases := []struct {
+		name        string
+		params      map[string]string
+		expectOps   []string
+		expectError bool
+	}{
+		{name: "Nothing", params: map[string]string{"name": "a.jpg"}},
+		{name: "FixedRotateLeftOut", params: map[string]string{"rotate": "true"}},
+		{
+			name:      "FixedOrder",
+			params:    map[string]string{"text": "Hi", "blur": "2", "width": "10", "flip": "both", "rotate": "90"},
+			expectOps: []string{"rotate", "flip", "flip", "resize", "blur", "text"},
+		},
+		{name: "Flag", params: map[string]string{"grayscale": "true", "invert": "false"}, expectOps: []string{"grayscale"}},
+		{name: "FlagWithAmount", params: map[string]string{"transpose": "2"}, expectError: true},
+		{name: "InvalidAmount", params: map[string]string{"gamma": "NaN"}, expectError: true},
+		{name: "FixedRotateWithCaption", params: map[string]string{"rotate": "true", "text": "Hi"}, expectOps: []string{"text"}},
+		{name: "FixedRotateWithFlip", params: map[string]string{"rotate": "true", "flip": "vertical"}, expectError: true},
+		{name: "PresetWithCaption", params: map[string]string{"preset": "card", "text": "Hi"}, expectOps: []string{"text"}},
+		{name: "PresetWithFilter", params: map[string]string{"preset": "card", "sepia": "true"}, expectError: true},
+		{name: "PresetWithFixedRotate", params: map[string]string{"preset": "card", "rotate": "true"}, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline, err := QueryPipeline(tc.params)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+
+			var ops []string
+			for _, step := range pipeline {
+				ops = append(ops, step.Op)
+			}
+			if !tc.expectError && !reflect.DeepEqual(ops, tc.expectOps) {
+				t.Errorf("Expected steps %v, got: %v", tc.expectOps, ops)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const recordPrefix = "records/"
+
+const perceptualIndexPrefix = "phash/"
+
+// ImageRecord describes an uploaded image. It is stored as JSON next to the image.
+type ImageRecord struct {
+	Name           string      `json:"name"`
+	ContentHash    string      `json:"contentHash,omitempty"`
+	PerceptualHash string      `json:"perceptualHash,omitempty"`
+	FocalPoint     *FocalPoint `json:"focalPoint,omitempty"`
+	UploadedAt     time.Time   `json:"uploadedAt"`
+	// Size, palette and BlurHash, for placeholders shown while the image loads
+	ImageColors
+}
+
+// The key holding the record for an image name
+func RecordKey(name string) string {
+	return recordPrefix + name + ".json"
+}
+
+// PerceptualIndexEntry is an image listed in the perceptual hash index.
+type PerceptualIndexEntry struct {
+	Name           string
+	PerceptualHash string
+}
+
+// The empty object indexing an image under its perceptual hash. Both are in
+// the key, so the index is read by listing keys without fetching any object.
+func perceptualIndexKey(hash string, name string) string {
+	return perceptualIndexPrefix + hash + "/" + name
+}
+
+// Store the record for an image, replacing any earlier record for the name,
+// and index it under its perceptual hash
+func PutImageRecord(ctx context.Context, s3Client S3ObjectAPI, bucketName string, record ImageRecord) error {
+	if err := putJSON(ctx, s3Client, bucketName, RecordKey(record.Name), record); err != nil {
+		return err
+	}
+	if record.PerceptualHash == "" {
+		return nil
+	}
+
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(perceptualIndexKey(record.PerceptualHash, record.Name)),
+		Body:   bytes.NewReader(nil),
+	})
+
+	return err
+}
+
+// Fetch the record for an image name
+func GetImageRecord(ctx context.Context, s3Client S3ObjectAPI, bucketName string, name string) (*ImageRecord, error) {
+	var record ImageRecord
+	if err := getJSON(ctx, s3Client, bucketName, RecordKey(name), &record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// List the perceptual hash index, a page of up to 1000 images per request,
+// limited to hashes starting with hashPrefix. Entries are never removed, so
+// an image stored again with different content keeps its old entry as well;
+// check a match against the image's record before trusting it.
+func ListPerceptualIndex(ctx context.Context, s3Client S3ObjectAPI, bucketName string, hashPrefix string) ([]PerceptualIndexEntry, error) {
+	var entries []PerceptualIndexEntry
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(perceptualIndexPrefix + hashPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			hash, name, ok := strings.Cut(strings.TrimPrefix(aws.ToString(object.Key), perceptualIndexPrefix), "/")
+			if !ok || name == "" {
+				continue
+			}
+			entries = append(entries, PerceptualIndexEntry{Name: name, PerceptualHash: hash})
+		}
+	}
+
+	return entries, nil
+}
+
+// Add the images missing from the perceptual hash index to it, such as those
+// stored before the index existed, and return their new entries. indexed holds
+// the names the index already lists. Only the keys of the records are listed,
+// so once every image is indexed no record is fetched. Records without a
+// perceptual hash have nothing to be indexed under and are left out.
+func IndexUnindexedRecords(ctx context.Context, s3Client S3ObjectAPI, bucketName string, indexed map[string]bool) ([]PerceptualIndexEntry, error) {
+	var entries []PerceptualIndexEntry
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(recordPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(object.Key), recordPrefix), ".json")
+			if indexed[name] {
+				continue
+			}
+
+			record, err := GetImageRecord(ctx, s3Client, bucketName, name)
+			if IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if record.PerceptualHash == "" {
+				continue
+			}
+
+			if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket: aws.String(bucketName),
+				Key:    aws.String(perceptualIndexKey(record.PerceptualHash, name)),
+				Body:   bytes.NewReader(nil),
+			}); err != nil {
+				return nil, err
+			}
+			entries = append(entries, PerceptualIndexEntry{Name: name, PerceptualHash: record.PerceptualHash})
+		}
+	}
+
+	return entries, nil
+}
+
+// Fill in the stored focal point of an image for any step of the pipeline
+// cropping around it. Images without a record are cropped around their centre.
+func FocusPipeline(ctx context.Context, s3Client S3ObjectAPI, bucketName string, name string, pipeline Pipeline) (Pipeline, error) {
+	if !pipeline.UsesFocalPoint() {
+		return pipeline, nil
+	}
+
+	record, err := GetImageRecord(ctx, s3Client, bucketName, name)
+	if IsNotFound(err) {
+		return pipeline, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeline.WithFocalPoint(record.FocalPoint), nil
+}
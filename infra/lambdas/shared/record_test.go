@@ -0,0 +1,117 @@
+package shared
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImageRecords(t *testing.T) {
+	client := NewMockS3Client()
+	uploadedAt := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, name := range []string{"b.jpg", "a.jpg", "nested/c.jpg"} {
+		record := ImageRecord{Name: name, PerceptualHash: "00000000000000ff", UploadedAt: uploadedAt}
+		if err := PutImageRecord(context.TODO(), client, "bucket", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record, err := GetImageRecord(context.TODO(), client, "bucket", "nested/c.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Name != "nested/c.jpg" || !record.UploadedAt.Equal(uploadedAt) {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+
+	if _, err := GetImageRecord(context.TODO(), client, "bucket", "missing.jpg"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got: %v", err)
+	}
+
+	// Stored again with different content, so indexed under both hashes
+	if err := PutImageRecord(context.TODO(), client, "bucket", ImageRecord{Name: "a.jpg", PerceptualHash: "ff00000000000000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListPerceptualIndex(context.TODO(), client, "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PerceptualIndexEntry{
+		{Name: "a.jpg", PerceptualHash: "00000000000000ff"},
+		{Name: "b.jpg", PerceptualHash: "00000000000000ff"},
+		{Name: "nested/c.jpg", PerceptualHash: "00000000000000ff"},
+		{Name: "a.jpg", PerceptualHash: "ff00000000000000"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected index %+v, got: %+v", expected, entries)
+	}
+
+	entries, err = ListPerceptualIndex(context.TODO(), client, "bucket", "ff00000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "a.jpg" {
+		t.Errorf("Expected only a.jpg under its new hash, got: %+v", entries)
+	}
+}
+
+func TestIndexUnindexedRecords(t *testing.T) {
+	client := NewMockS3Client()
+	if err := PutImageRecord(context.TODO(), client, "bucket", ImageRecord{Name: "indexed.jpg", PerceptualHash: "00000000000000ff"}); err != nil {
+		t.Fatal(err)
+	}
+	// Stored before the index existed, and before images were hashed at all
+	if err := putJSON(context.TODO(), client, "bucket", RecordKey("nested/legacy.jpg"), ImageRecord{Name: "nested/legacy.jpg", PerceptualHash: "ff00000000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := putJSON(context.TODO(), client, "bucket", RecordKey("unhashed.jpg"), ImageRecord{Name: "unhashed.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed := map[string]bool{"indexed.jpg": true}
+	entries, err := IndexUnindexedRecords(context.TODO(), client, "bucket", indexed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PerceptualIndexEntry{{Name: "nested/legacy.jpg", PerceptualHash: "ff00000000000000"}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected new entries %+v, got: %+v", expected, entries)
+	}
+
+	entries, err = ListPerceptualIndex(context.TODO(), client, "bucket", "ff00000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected the legacy record to be indexed, got: %+v", entries)
+	}
+}
+
+func TestFocusPipeline(t *testing.T) {
+	client := NewMockS3Client()
+	record := ImageRecord{Name: "image.jpg", FocalPoint: &FocalPoint{X: 0.2, Y: 0.3}}
+	if err := PutImageRecord(context.TODO(), client, "bucket", record); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := Pipeline{{Op: "crop", Params: map[string]string{"width": "10", "height": "10", "gravity": "focal"}}}
+
+	focused, err := FocusPipeline(context.TODO(), client, "bucket", "image.jpg", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if focused[0].Params["focusX"] != "0.2" || focused[0].Params["focusY"] != "0.3" {
+		t.Errorf("Expected the stored focal point, got: %v", focused[0].Params)
+	}
+
+	focused, err = FocusPipeline(context.TODO(), client, "bucket", "missing.jpg", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := focused[0].Params["focusX"]; ok {
+		t.Errorf("Expected no focal point for an unrecorded image, got: %v", focused[0].Params)
+	}
+}
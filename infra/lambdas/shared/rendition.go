@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+)
+
+const renditionPrefix = "renditions/"
+
+// Names of renditions and presets
+var derivativeNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Renditions maps the name of each derivative generated at upload to the
+// pipeline producing it, e.g. {"thumb": [{"op": "fill", "params": {"width": "200", "height": "200"}}]}.
+type Renditions map[string]Pipeline
+
+// Parse a JSON rendition config. An empty config means no renditions.
+func ParseRenditions(config string) (Renditions, error) {
+	if config == "" {
+		return Renditions{}, nil
+	}
+
+	renditions, err := parseNamedPipelines([]byte(config), "rendition")
+
+	return Renditions(renditions), err
+}
+
+// Report whether a name can be used for a rendition
+func IsValidRenditionName(name string) bool {
+	return derivativeNamePattern.MatchString(name)
+}
+
+// Parse a JSON object of name to pipeline, checking every name and pipeline
+func parseNamedPipelines(data []byte, kind string) (map[string]Pipeline, error) {
+	var pipelines map[string]Pipeline
+	if err := json.Unmarshal(data, &pipelines); err != nil {
+		return nil, err
+	}
+
+	for name, pipeline := range pipelines {
+		if !derivativeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s name %q", kind, name)
+		}
+		if err := pipeline.Validate(); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", kind, name, err)
+		}
+	}
+
+	return pipelines, nil
+}
+
+// The rendition names in a stable order
+func (r Renditions) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// The key a rendition of an image is stored under
+func RenditionKey(name string, rendition string) string {
+	return renditionPrefix + rendition + "/" + name
+}
+
+// Produce every rendition of an image as JPEG, decoding the source only once.
+// Renditions of an animation are animations too.
+func GenerateRenditions(body []byte, renditions Renditions, options EncodeOptions) (map[string][]byte, error) {
+	if animation := decodeAnimation(body); animation != nil {
+		results := map[string][]byte{}
+		for name, pipeline := range renditions {
+			transforms, err := pipeline.forAnimation().transforms()
+			if err != nil {
+				return nil, fmt.Errorf("rendition %s: %w", name, err)
+			}
+			if results[name], err = transformAnimation(animation, transforms); err != nil {
+				return nil, err
+			}
+		}
+		return results, nil
+	}
+
+	img, err := decodeSRGB(body)
+	if err != nil {
+		log.Printf("Error decoding image: %v", err)
+		return nil, errors.New("error decoding image")
+	}
+
+	results := map[string][]byte{}
+	for name, pipeline := range renditions {
+		transforms, err := pipeline.transforms()
+		if err != nil {
+			return nil, fmt.Errorf("rendition %s: %w", name, err)
+		}
+
+		if results[name], err = encodeTransformed(img, transforms, options); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,93 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestParseRenditions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      string
+		expectNames []string
+		expectErr   bool
+	}{
+		{
+			name:        "Empty",
+			config:      "",
+			expectNames: []string{},
+		},
+		{
+			name:        "Renditions",
+			config:      `{"thumb": [{"op": "fill", "params": {"width": "200", "height": "200"}}], "preview": [{"op": "fit", "params": {"width": "1280", "height": "720"}}]}`,
+			expectNames: []string{"preview", "thumb"},
+		},
+		{
+			name:      "InvalidJSON",
+			config:    `{"thumb":`,
+			expectErr: true,
+		},
+		{
+			name:      "InvalidName",
+			config:    `{"../thumb": []}`,
+			expectErr: true,
+		},
+		{
+			name:      "InvalidPipeline",
+			config:    `{"thumb": [{"op": "explode"}]}`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			renditions, err := ParseRenditions(tc.config)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			names := renditions.Names()
+			if len(names) != len(tc.expectNames) {
+				t.Fatalf("Expected renditions %v, got: %v", tc.expectNames, names)
+			}
+			for i := range names {
+				if names[i] != tc.expectNames[i] {
+					t.Errorf("Expected renditions %v, got: %v", tc.expectNames, names)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateRenditions(t *testing.T) {
+	renditions, err := ParseRenditions(`{"thumb": [{"op": "fill", "params": {"width": "200", "height": "200"}}], "preview": [{"op": "fit", "params": {"width": "320", "height": "320"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := GenerateRenditions(encode(t, generateGradient(640, 480, false), imaging.PNG), renditions, EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectSizes := map[string]image.Point{"thumb": image.Pt(200, 200), "preview": image.Pt(320, 240)}
+	for name, size := range expectSizes {
+		img, err := imaging.Decode(bytes.NewReader(results[name]))
+		if err != nil {
+			t.Fatalf("Rendition %s: %v", name, err)
+		}
+		if img.Bounds().Size() != size {
+			t.Errorf("Expected %s to be %v, got: %v", name, size, img.Bounds().Size())
+		}
+	}
+
+	if _, err := GenerateRenditions([]byte("This is not an image"), renditions, EncodeOptions{}); err == nil {
+		t.Error("Expected an error for an invalid image")
+	}
+}
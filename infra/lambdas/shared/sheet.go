@@ -0,0 +1,282 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/font"
+)
+
+// Most images one contact sheet or sprite may hold
+const MaxSheetImages = 200
+
+// Room above and below a label's text
+const labelPadding = 4
+
+// SheetOptions lays out a contact sheet of images. Zero values take the defaults.
+type SheetOptions struct {
+	// Size of the box each image is scaled into
+	CellWidth  int `json:"cellWidth,omitempty"`
+	CellHeight int `json:"cellHeight,omitempty"`
+	// Gap between cells and around the edge, 10 pixels unless set
+	Spacing *int `json:"spacing,omitempty"`
+	// Cells per row, as near a square grid as possible unless set
+	Columns int `json:"columns,omitempty"`
+	// Crop images to fill their cell instead of fitting inside it
+	Fill bool `json:"fill,omitempty"`
+	// Write each image's name below it, in LabelSize pixel text
+	Labels     bool    `json:"labels,omitempty"`
+	LabelSize  float64 `json:"labelSize,omitempty"`
+	LabelColor string  `json:"labelColor,omitempty"`
+	// Colour between the images, white for JPEG and transparent for PNG unless set
+	Background string `json:"background,omitempty"`
+	// "jpeg" or "png"
+	Format string `json:"format,omitempty"`
+	// JPEG quality from 1 to 100
+	Quality int `json:"quality,omitempty"`
+}
+
+// SheetImage is one image to place on a contact sheet.
+type SheetImage struct {
+	Name string
+	Data []byte
+}
+
+// SheetCell is where an image was drawn on a contact sheet, for use as a CSS sprite.
+type SheetCell struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Sheet is an encoded contact sheet and where each image is on it.
+type Sheet struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	Cells       map[string]SheetCell
+}
+
+// How a sheet of some number of images is laid out
+type sheetLayout struct {
+	options     SheetOptions
+	background  color.Color
+	labelColor  color.Color
+	face        font.Face
+	labelHeight int
+	width       int
+	height      int
+}
+
+// Validate checks the options can lay out a sheet of the named images
+func (o SheetOptions) Validate(names []string) error {
+	_, err := o.layout(names)
+	return err
+}
+
+// Fill in the defaults and work out the size of a sheet of the named images.
+// Names must be unique, as each one keys its cell in the sprite map.
+func (o SheetOptions) layout(names []string) (*sheetLayout, error) {
+	count := len(names)
+	if count == 0 {
+		return nil, errors.New("no images to place")
+	}
+	if count > MaxSheetImages {
+		return nil, fmt.Errorf("a sheet holds at most %d images", MaxSheetImages)
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("image %s is listed more than once", name)
+		}
+		seen[name] = true
+	}
+
+	if o.CellWidth == 0 {
+		o.CellWidth = 200
+	}
+	if o.CellHeight == 0 {
+		o.CellHeight = 200
+	}
+	if o.Spacing == nil {
+		spacing := 10
+		o.Spacing = &spacing
+	}
+	if o.Columns == 0 {
+		o.Columns = int(math.Ceil(math.Sqrt(float64(count))))
+	}
+	o.Columns = min(o.Columns, count)
+	if o.LabelSize == 0 {
+		o.LabelSize = 14
+	}
+	if o.Format == "" {
+		o.Format = "jpeg"
+	}
+
+	switch {
+	case o.CellWidth < 1 || o.CellWidth > maxDimension || o.CellHeight < 1 || o.CellHeight > maxDimension:
+		return nil, fmt.Errorf("cell width and height must be from 1 to %d", maxDimension)
+	case *o.Spacing < 0 || *o.Spacing > 1000:
+		return nil, errors.New("spacing must be from 0 to 1000")
+	case o.Columns < 0:
+		return nil, errors.New("columns must not be negative")
+	case o.LabelSize < 4 || o.LabelSize > 128:
+		return nil, errors.New("labelSize must be from 4 to 128")
+	case o.Quality < 0 || o.Quality > 100:
+		return nil, errors.New("quality must be from 1 to 100")
+	case o.Format != "jpeg" && o.Format != "png":
+		return nil, fmt.Errorf("unknown format %q, expected jpeg or png", o.Format)
+	}
+
+	colors := map[string]string{}
+	if o.Background != "" {
+		colors["background"] = o.Background
+	}
+	if o.LabelColor != "" {
+		colors["labelColor"] = o.LabelColor
+	}
+	background := color.Color(color.Transparent)
+	if o.Format == "jpeg" {
+		background = DefaultBackground
+	}
+	background, err := colorParam(colors, "background", background)
+	if err != nil {
+		return nil, err
+	}
+	labelColor, err := colorParam(colors, "labelColor", color.Black)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := &sheetLayout{options: o, background: background, labelColor: labelColor}
+	if o.Labels {
+		if layout.face, err = fontFace("regular", o.LabelSize); err != nil {
+			return nil, err
+		}
+		layout.labelHeight = layout.face.Metrics().Height.Ceil() + 2*labelPadding
+	}
+
+	spacing := *o.Spacing
+	rows := (count + o.Columns - 1) / o.Columns
+	layout.width = spacing + o.Columns*(o.CellWidth+spacing)
+	layout.height = spacing + rows*(o.CellHeight+layout.labelHeight+spacing)
+	if layout.width > maxDimension || layout.height > maxDimension {
+		return nil, fmt.Errorf("a sheet of %dx%d is larger than %d pixels across", layout.width, layout.height, maxDimension)
+	}
+
+	return layout, nil
+}
+
+// Where the cell for image i starts
+func (l *sheetLayout) cell(i int) image.Point {
+	o := l.options
+	return image.Pt(
+		*o.Spacing+i%o.Columns*(o.CellWidth+*o.Spacing),
+		*o.Spacing+i/o.Columns*(o.CellHeight+l.labelHeight+*o.Spacing),
+	)
+}
+
+// The resize step that scales an image into its cell
+func (o SheetOptions) cellStep() Operation {
+	op := "fit"
+	if o.Fill {
+		op = "fill"
+	}
+
+	return Operation{Op: op, Params: map[string]string{
+		"width":  strconv.Itoa(o.CellWidth),
+		"height": strconv.Itoa(o.CellHeight),
+	}}
+}
+
+// ComposeSheet scales each image into a cell of a grid, in order, and encodes
+// the grid. The steps are applied to every image after it is scaled, such as
+// a watermark each one must carry.
+func ComposeSheet(images []SheetImage, options SheetOptions, steps Pipeline) (*Sheet, error) {
+	names := make([]string, 0, len(images))
+	for _, source := range images {
+		names = append(names, source.Name)
+	}
+	layout, err := options.layout(names)
+	if err != nil {
+		return nil, err
+	}
+	options = layout.options
+	transforms, err := append(Pipeline{options.cellStep()}, steps...).transforms()
+	if err != nil {
+		return nil, err
+	}
+
+	background, labelColor := layout.background, layout.labelColor
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, layout.width, layout.height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	face := layout.face
+	sheet := &Sheet{Width: layout.width, Height: layout.height, Cells: map[string]SheetCell{}}
+	for i, source := range images {
+		img, err := decodeSRGB(source.Data)
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %w", source.Name, err)
+		}
+		for _, transform := range transforms {
+			img = transform(img)
+		}
+
+		// Images smaller than their cell after fitting sit in the middle of it
+		cell := layout.cell(i)
+		size := img.Bounds().Size()
+		at := image.Pt(cell.X+(options.CellWidth-size.X)/2, cell.Y+(options.CellHeight-size.Y)/2)
+		draw.Draw(canvas, image.Rectangle{Min: at, Max: at.Add(size)}, img, img.Bounds().Min, draw.Over)
+		sheet.Cells[source.Name] = SheetCell{X: at.X, Y: at.Y, Width: size.X, Height: size.Y}
+
+		if face != nil {
+			label := fitLabel(face, source.Name, options.CellWidth)
+			x := cell.X + (options.CellWidth-font.MeasureString(face, label).Ceil())/2
+			y := cell.Y + options.CellHeight + labelPadding + face.Metrics().Ascent.Ceil()
+			drawString(canvas, face, labelColor, label, x, y)
+		}
+	}
+
+	if options.Format == "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, canvas); err != nil {
+			return nil, err
+		}
+		sheet.Data, sheet.ContentType = buf.Bytes(), "image/png"
+		return sheet, nil
+	}
+
+	if sheet.Data, err = encodeJPEG(canvas, EncodeOptions{Quality: options.Quality, Background: background}, imagingQuality); err != nil {
+		return nil, err
+	}
+	sheet.ContentType = "image/jpeg"
+
+	return sheet, nil
+}
+
+// Shorten a label with an ellipsis until it is no wider than width
+func fitLabel(face font.Face, label string, width int) string {
+	if font.MeasureString(face, label).Ceil() <= width {
+		return label
+	}
+
+	runes := []rune(label)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if shortened := string(runes) + "..."; font.MeasureString(face, shortened).Ceil() <= width {
+			return shortened
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,199 @@
+package shared
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// Encode a flat colour image as PNG
+func flatPNG(t *testing.T, width int, height int, c color.Color) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestComposeSheet(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	images := []SheetImage{
+		{Name: "square.jpg", Data: GenerateJPG(t)},
+		{Name: "wide.png", Data: flatPNG(t, 400, 200, red)},
+		{Name: "small.png", Data: flatPNG(t, 50, 50, red)},
+		{Name: "tall.png", Data: flatPNG(t, 100, 400, red)},
+		{Name: "grey.webp", Data: GenerateImage(t, "webp")},
+	}
+	noSpacing := 0
+
+	testCases := []struct {
+		name              string
+		options           SheetOptions
+		steps             Pipeline
+		expectContentType string
+		expectSize        image.Point
+		expectCells       map[string]SheetCell
+	}{
+		{
+			name:              "Defaults",
+			expectContentType: "image/jpeg",
+			expectSize:        image.Pt(640, 430),
+			expectCells: map[string]SheetCell{
+				"square.jpg": {X: 10, Y: 10, Width: 200, Height: 200},
+				"wide.png":   {X: 220, Y: 60, Width: 200, Height: 100},
+				"small.png":  {X: 505, Y: 85, Width: 50, Height: 50},
+				"tall.png":   {X: 85, Y: 220, Width: 50, Height: 200},
+			},
+		},
+		{
+			name:              "FillSpriteRow",
+			options:           SheetOptions{CellWidth: 100, CellHeight: 100, Spacing: &noSpacing, Columns: 5, Fill: true, Format: "png"},
+			expectContentType: "image/png",
+			expectSize:        image.Pt(500, 100),
+			expectCells: map[string]SheetCell{
+				"wide.png":  {X: 100, Y: 0, Width: 100, Height: 100},
+				"grey.webp": {X: 400, Y: 0, Width: 100, Height: 100},
+			},
+		},
+		{
+			name:              "Labels",
+			options:           SheetOptions{CellWidth: 100, CellHeight: 80, Columns: 2, Labels: true},
+			expectContentType: "image/jpeg",
+			expectSize:        image.Pt(230, 10+3*(80+25+10)),
+		},
+		{
+			name:              "Steps",
+			options:           SheetOptions{Columns: 1, Format: "png"},
+			steps:             Pipeline{{Op: "invert"}},
+			expectContentType: "image/png",
+			expectSize:        image.Pt(220, 10+5*210),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sheet, err := ComposeSheet(images, tc.options, tc.steps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sheet.ContentType != tc.expectContentType {
+				t.Errorf("Expected %s, got %s", tc.expectContentType, sheet.ContentType)
+			}
+
+			img, format, err := image.Decode(bytes.NewReader(sheet.Data))
+			if err != nil || "image/"+format != tc.expectContentType {
+				t.Fatalf("Expected the sheet to decode as %s, got %s %v", tc.expectContentType, format, err)
+			}
+			if size := img.Bounds().Size(); size != tc.expectSize || sheet.Width != size.X || sheet.Height != size.Y {
+				t.Errorf("Expected a %v sheet, got %v (reported %dx%d)", tc.expectSize, size, sheet.Width, sheet.Height)
+			}
+			if len(sheet.Cells) != len(images) {
+				t.Errorf("Expected a cell for every image, got %v", sheet.Cells)
+			}
+			for name, expect := range tc.expectCells {
+				if got := sheet.Cells[name]; got != expect {
+					t.Errorf("Expected %s at %+v, got %+v", name, expect, got)
+				}
+			}
+
+			// The middle of each drawn image is the image's colour
+			for name, cell := range sheet.Cells {
+				c := color.NRGBAModel.Convert(img.At(cell.X+cell.Width/2, cell.Y+cell.Height/2)).(color.NRGBA)
+				if strings.HasSuffix(name, ".png") && !closeColor(c, red) && tc.steps == nil {
+					t.Errorf("Expected %s to be red, got %v", name, c)
+				}
+				if name == "square.jpg" && tc.steps != nil && !closeColor(c, color.NRGBA{255, 255, 255, 255}) {
+					t.Errorf("Expected the steps to invert %s, got %v", name, c)
+				}
+			}
+
+			// PNG sheets are transparent between the images
+			if tc.options.Format == "png" && tc.options.Spacing == nil {
+				if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+					t.Error("Expected a transparent background")
+				}
+			}
+		})
+	}
+}
+
+func TestSheetLabels(t *testing.T) {
+	images := []SheetImage{{Name: "a-very-long-image-name-that-cannot-fit.png", Data: flatPNG(t, 10, 10, color.White)}}
+	sheet, err := ComposeSheet(images, SheetOptions{CellWidth: 60, CellHeight: 60, Labels: true, Format: "png", Background: "ffffff"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, _ := png.Decode(bytes.NewReader(sheet.Data))
+	dark := 0
+	for y := 70; y < sheet.Height; y++ {
+		for x := 0; x < sheet.Width; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+				dark++
+				if x < 10 || x >= 70 {
+					t.Fatalf("Expected the label to stay within its cell, found text at %d,%d", x, y)
+				}
+			}
+		}
+	}
+	if dark == 0 {
+		t.Error("Expected a label below the image")
+	}
+}
+
+// Distinct names for count images
+func sheetNames(count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%d.jpg", i)
+	}
+
+	return names
+}
+
+func TestSheetOptionsValidate(t *testing.T) {
+	negative := -1
+
+	testCases := []struct {
+		name    string
+		options SheetOptions
+		names   []string
+	}{
+		{name: "NoImages", names: nil},
+		{name: "TooManyImages", names: sheetNames(MaxSheetImages + 1)},
+		{name: "DuplicateName", names: []string{"a.jpg", "b.jpg", "a.jpg"}},
+		{name: "UnknownFormat", options: SheetOptions{Format: "gif"}, names: sheetNames(1)},
+		{name: "NegativeSpacing", options: SheetOptions{Spacing: &negative}, names: sheetNames(1)},
+		{name: "TooLarge", options: SheetOptions{CellWidth: 4000, Columns: 3}, names: sheetNames(3)},
+		{name: "BadQuality", options: SheetOptions{Quality: 101}, names: sheetNames(1)},
+		{name: "BadBackground", options: SheetOptions{Background: "nope"}, names: sheetNames(1)},
+		{name: "BadLabelColor", options: SheetOptions{Labels: true, LabelColor: "#12"}, names: sheetNames(1)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.options.Validate(tc.names); err == nil {
+				t.Error("Expected the options to be rejected")
+			}
+		})
+	}
+
+	if _, err := ComposeSheet([]SheetImage{{Name: "a", Data: GenerateJPG(t)}}, SheetOptions{Background: "nope"}, nil); err == nil {
+		t.Error("Expected an invalid background colour to be rejected")
+	}
+	if err := (SheetOptions{}).Validate(sheetNames(MaxSheetImages)); err != nil {
+		t.Errorf("Expected the defaults to hold a full sheet: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters added to a signed URL
+const (
+	SignatureParam = "signature"
+	ExpiresParam   = "expires"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("signature is invalid")
+	ErrSignatureExpired = errors.New("signed URL has expired")
+)
+
+// Build a URL for an image and its transform parameters that is only valid
+// until expires. baseURL is the endpoint of image_get_lambda, e.g.
+// https://example.execute-api.eu-west-2.amazonaws.com/dev/images
+func SignURL(baseURL string, secret string, name string, params map[string]string, expires time.Time) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	query.Set("name", name)
+	query.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(SignatureParam, signQuery(secret, query))
+
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Check the signature and expiry of the query parameters of a signed URL
+func VerifySignedParams(secret string, params map[string]string, now time.Time) error {
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+
+	signature := query.Get(SignatureParam)
+	query.Del(SignatureParam)
+	if signature == "" || !hmac.Equal([]byte(signature), []byte(signQuery(secret, query))) {
+		return ErrSignatureInvalid
+	}
+
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
+
+// HMAC the encoded query, which url.Values sorts by key so the order parameters arrive in doesn't matter
+func signQuery(secret string, query url.Values) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(query.Encode()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
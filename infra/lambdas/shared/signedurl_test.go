@@ -0,0 +1,91 @@
+package shared
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	now := time.Now()
+
+	signed, err := SignURL("https://example.com/dev/images", "secret", "image.jpg", map[string]string{"preset": "avatar"}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name      string
+		tamper    func(params map[string]string)
+		secret    string
+		now       time.Time
+		expectErr error
+	}{
+		{
+			name:   "Valid",
+			tamper: func(params map[string]string) {},
+			secret: "secret",
+			now:    now,
+		},
+		{
+			name:      "ChangedParameter",
+			tamper:    func(params map[string]string) { params["preset"] = "hero" },
+			secret:    "secret",
+			now:       now,
+			expectErr: ErrSignatureInvalid,
+		},
+		{
+			name:      "AddedParameter",
+			tamper:    func(params map[string]string) { params["rotate"] = "true" },
+			secret:    "secret",
+			now:       now,
+			expectErr: ErrSignatureInvalid,
+		},
+		{
+			name:      "ExtendedExpiry",
+			tamper:    func(params map[string]string) { params[ExpiresParam] = "99999999999" },
+			secret:    "secret",
+			now:       now,
+			expectErr: ErrSignatureInvalid,
+		},
+		{
+			name:      "MissingSignature",
+			tamper:    func(params map[string]string) { delete(params, SignatureParam) },
+			secret:    "secret",
+			now:       now,
+			expectErr: ErrSignatureInvalid,
+		},
+		{
+			name:      "WrongSecret",
+			tamper:    func(params map[string]string) {},
+			secret:    "other",
+			now:       now,
+			expectErr: ErrSignatureInvalid,
+		},
+		{
+			name:      "Expired",
+			tamper:    func(params map[string]string) {},
+			secret:    "secret",
+			now:       now.Add(2 * time.Hour),
+			expectErr: ErrSignatureExpired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := map[string]string{}
+			for key := range u.Query() {
+				params[key] = u.Query().Get(key)
+			}
+			tc.tamper(params)
+
+			if err := VerifySignedParams(tc.secret, params, tc.now); err != tc.expectErr {
+				t.Errorf("Expected error: %v, got: %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+)
+
+var errTruncated = errors.New("image data ends early")
+var errBadTIFF = errors.New("malformed TIFF structure")
+
+// The leading bytes each format starts with. WebP is a RIFF file, told apart by its form type.
+var formatSignatures = []struct {
+	format    string
+	signature []byte
+}{
+	{"jpeg", []byte{0xFF, 0xD8, 0xFF}},
+	{"png", pngSignature},
+	{"gif", []byte("GIF87a")},
+	{"gif", []byte("GIF89a")},
+	{"tiff", []byte("II*\x00")},
+	{"tiff", []byte("MM\x00*")},
+	{"bmp", []byte("BM")},
+}
+
+// Extensions and content types that declare a format
+var formatExtensions = map[string]string{
+	".jpg": "jpeg", ".jpeg": "jpeg", ".jpe": "jpeg", ".png": "png", ".gif": "gif",
+	".webp": "webp", ".tif": "tiff", ".tiff": "tiff", ".bmp": "bmp",
+}
+
+var formatContentTypes = map[string]string{
+	"image/jpeg": "jpeg", "image/jpg": "jpeg", "image/pjpeg": "jpeg", "image/png": "png", "image/gif": "gif",
+	"image/webp": "webp", "image/tiff": "tiff", "image/bmp": "bmp", "image/x-ms-bmp": "bmp",
+}
+
+// SniffFormat returns the format the data's magic bytes say it is in, or "" when they match none
+func SniffFormat(data []byte) string {
+	if len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+		return "webp"
+	}
+	for _, s := range formatSignatures {
+		if bytes.HasPrefix(data, s.signature) {
+			return s.format
+		}
+	}
+
+	return ""
+}
+
+// CheckDeclaredFormat checks that the name's extension and the declared content
+// type, where they name an image format, agree with the format of the data.
+// Anything else, like no extension or application/octet-stream, declares nothing.
+func CheckDeclaredFormat(format string, name string, contentType string) error {
+	if declared, ok := formatExtensions[strings.ToLower(path.Ext(name))]; ok && declared != format {
+		return fmt.Errorf("the name %s says %s but the data is %s", name, declared, format)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if declared, ok := formatContentTypes[strings.ToLower(mediaType)]; ok && declared != format {
+		return fmt.Errorf("the content type %s says %s but the data is %s", contentType, declared, format)
+	}
+
+	return nil
+}
+
+// TrimTrailingData cuts the data off where the image ends, so that what is
+// stored can't also be read as another kind of file. Some JPEGs keep more after
+// the first image on purpose, like the gain map of an Ultra HDR photo or the
+// depth map described by an MPF segment; those are dropped along with anything
+// else, leaving the primary image. Only a truncated or malformed image is an error.
+func TrimTrailingData(data []byte, format string) ([]byte, error) {
+	var end int
+	var err error
+	switch format {
+	case "jpeg":
+		end, err = jpegEnd(data)
+	case "png":
+		end, err = pngEnd(data)
+	case "gif":
+		end, err = gifEnd(data)
+	case "webp":
+		end, err = riffEnd(data)
+	case "tiff":
+		end, err = tiffEnd(data)
+	case "bmp":
+		end, err = bmpEnd(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data[:end], nil
+}
+
+// Where the JPEG's end of image marker finishes. Entropy coded data after each
+// scan runs to the next marker, with 0xFF bytes in it followed by 0 or a restart marker.
+func jpegEnd(data []byte) (int, error) {
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return 0, errNotJPEG
+		}
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			break
+		}
+
+		marker := data[i]
+		i++
+		switch {
+		case marker == 0xD9:
+			return i, nil
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			continue
+		}
+
+		if i+2 > len(data) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 {
+			return 0, errNotJPEG
+		}
+		i += length
+		if marker != markerSOS {
+			continue
+		}
+
+		for i+1 < len(data) {
+			if next := data[i+1]; data[i] == 0xFF && next != 0 && next != 0xFF && !(next >= 0xD0 && next <= 0xD7) {
+				break
+			}
+			i++
+		}
+	}
+
+	return 0, errTruncated
+}
+
+// Where the PNG's IEND chunk finishes
+func pngEnd(data []byte) (int, error) {
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		i += 12 + length
+		if length < 0 || i > len(data) {
+			break
+		}
+		if chunkType == "IEND" {
+			return i, nil
+		}
+	}
+
+	return 0, errTruncated
+}
+
+// Where the GIF's trailer byte is, after walking its blocks
+func gifEnd(data []byte) (int, error) {
+	// Header and logical screen descriptor, then the global colour table
+	i := 13
+	if len(data) < i {
+		return 0, errTruncated
+	}
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	// Sub-blocks run until one of zero length
+	skipSubBlocks := func() {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		i++
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B:
+			return i + 1, nil
+		case 0x21:
+			i += 2
+			skipSubBlocks()
+		case 0x2C:
+			if i+10 > len(data) {
+				return 0, errTruncated
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// The LZW minimum code size, then the image data
+			i++
+			skipSubBlocks()
+		default:
+			return 0, fmt.Errorf("unknown GIF block 0x%02x", data[i])
+		}
+	}
+
+	return 0, errTruncated
+}
+
+// Where the RIFF container ends, by the size in its header
+func riffEnd(data []byte) (int, error) {
+	if len(data) < 8 {
+		return 0, errTruncated
+	}
+	end := 8 + int(binary.LittleEndian.Uint32(data[4:]))
+	// Chunks are padded to an even length
+	end += end % 2
+	if end > len(data) {
+		return 0, errTruncated
+	}
+
+	return end, nil
+}
+
+// Where the last of the TIFF's directories, values and image data ends. The
+// parts may be in any order, so everything they refer to is followed.
+func tiffEnd(data []byte) (int, error) {
+	r, offset, err := newTIFFReader(data)
+	if err != nil {
+		return 0, errBadTIFF
+	}
+
+	end := 8
+	seen := map[uint32]bool{}
+	pending := []uint32{offset}
+	for len(pending) > 0 {
+		offset, pending = pending[0], pending[1:]
+		// Offset 0 ends a chain of directories
+		if offset == 0 || seen[offset] {
+			continue
+		}
+		if len(seen) == 64 {
+			return 0, errors.New("too many TIFF directories")
+		}
+		seen[offset] = true
+
+		entries, err := r.readIFD(offset)
+		if err != nil {
+			return 0, errBadTIFF
+		}
+		next := int(offset) + 2 + 12*len(entries)
+		if next+4 > len(data) {
+			return 0, errTruncated
+		}
+		end = max(end, next+4)
+		pending = append(pending, r.order.Uint32(data[next:]))
+
+		var strips, stripSizes []uint32
+		for _, e := range entries {
+			start, length, err := r.valueRange(e)
+			if err != nil {
+				return 0, errBadTIFF
+			}
+			end = max(end, start+length)
+
+			switch e.tag {
+			case 0x0111, 0x0144: // StripOffsets, TileOffsets
+				strips = r.uints(e)
+			case 0x0117, 0x0145: // StripByteCounts, TileByteCounts
+				stripSizes = r.uints(e)
+			case 0x014A, tagExifIFD, tagGPSIFD: // SubIFDs and the EXIF directories
+				pending = append(pending, r.uints(e)...)
+			}
+		}
+
+		for i := 0; i < min(len(strips), len(stripSizes)); i++ {
+			stripEnd := uint64(strips[i]) + uint64(stripSizes[i])
+			if stripEnd > uint64(len(data)) {
+				return 0, errTruncated
+			}
+			end = max(end, int(stripEnd))
+		}
+	}
+
+	return end, nil
+}
+
+// Every value of a SHORT or LONG entry
+func (r *tiffReader) uints(e ifdEntry) []uint32 {
+	value := r.value(e)
+	var result []uint32
+	switch e.kind {
+	case 3:
+		for i := 0; i+2 <= len(value); i += 2 {
+			result = append(result, uint32(r.order.Uint16(value[i:])))
+		}
+	case 4, 13:
+		for i := 0; i+4 <= len(value); i += 4 {
+			result = append(result, r.order.Uint32(value[i:]))
+		}
+	}
+
+	return result
+}
+
+// Where the bitmap ends, by the file size in its header
+func bmpEnd(data []byte) (int, error) {
+	if len(data) < 6 {
+		return 0, errTruncated
+	}
+	size := int(binary.LittleEndian.Uint32(data[2:]))
+	if size == 0 {
+		// Some writers leave the size out, so the end can't be known
+		return len(data), nil
+	}
+	if size < 14 || size > len(data) {
+		return 0, errTruncated
+	}
+
+	return size, nil
+}
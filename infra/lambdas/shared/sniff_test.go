@@ -0,0 +1,84 @@
+package shared
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestSniffFormat(t *testing.T) {
+	for _, format := range SupportedFormats {
+		t.Run(format, func(t *testing.T) {
+			data := GenerateImage(t, format)
+			if got := SniffFormat(data); got != format {
+				t.Fatalf("Expected %s, got %q", format, got)
+			}
+			if _, decoded, err := image.Decode(bytes.NewReader(data)); err != nil || decoded != format {
+				t.Errorf("Expected the image to decode as %s, got %q %v", format, decoded, err)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{{0x01, 0x02, 0x03, 0x04, 0x05}, []byte("RIFF\x10\x00\x00\x00WAVEfmt "), nil} {
+		if got := SniffFormat(data); got != "" {
+			t.Errorf("Expected %q not to be recognised, got %s", data, got)
+		}
+	}
+}
+
+func TestCheckDeclaredFormat(t *testing.T) {
+	testCases := []struct {
+		name        string
+		format      string
+		imageName   string
+		contentType string
+		expectError bool
+	}{
+		{name: "Matching", format: "jpeg", imageName: "photo.JPG", contentType: "image/jpeg"},
+		{name: "NothingDeclared", format: "png", imageName: "photo", contentType: "application/octet-stream"},
+		{name: "OtherExtension", format: "png", imageName: "photo.final"},
+		{name: "ContentTypeParameters", format: "webp", imageName: "photo.webp", contentType: "image/webp; q=0.9"},
+		{name: "WrongExtension", format: "png", imageName: "photo.jpg", expectError: true},
+		{name: "WrongContentType", format: "png", imageName: "photo", contentType: "image/gif", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckDeclaredFormat(tc.format, tc.imageName, tc.contentType)
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected an error %v, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestTrimTrailingData(t *testing.T) {
+	images := map[string][]byte{
+		"metadata":  GenerateJPGWithMetadata(t),
+		"animation": GenerateAnimatedGIF(t),
+	}
+	for _, format := range SupportedFormats {
+		images[format] = GenerateImage(t, format)
+	}
+	// Ultra HDR and MPF photos keep a second JPEG after the first
+	secondImage := GenerateJPG(t)
+	script := []byte("<script>alert(1)</script>")
+
+	for name, data := range images {
+		t.Run(name, func(t *testing.T) {
+			format := SniffFormat(data)
+			for trailing, extra := range map[string][]byte{"nothing": nil, "padding": {0, 0, 0, 0}, "script": script, "second image": secondImage} {
+				trimmed, err := TrimTrailingData(append(bytes.Clone(data), extra...), format)
+				if err != nil {
+					t.Errorf("Expected the image with %s after it to be accepted: %v", trailing, err)
+				}
+				if !bytes.Equal(trimmed, data) {
+					t.Errorf("Expected %s after the image to be cut off, got %d bytes for %d", trailing, len(trimmed), len(data))
+				}
+			}
+			if _, err := TrimTrailingData(data[:len(data)/2], format); err == nil {
+				t.Error("Expected a truncated image to be rejected")
+			}
+		})
+	}
+}
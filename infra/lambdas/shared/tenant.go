@@ -0,0 +1,103 @@
+package shared
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// AnonymousTenant owns requests made without an API key, and any request
+// that reaches a handler without the authorizer's context.
+const AnonymousTenant = "public"
+
+// DefaultTenant's policies apply to tenants that have none of their own.
+const DefaultTenant = "default"
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Tenant identifies the tenant making a request from the tenantId the API
+// Gateway authorizer put in the request context. Callers can't set the
+// context themselves, unlike headers.
+func Tenant(authorizer map[string]interface{}) string {
+	tenant, _ := authorizer["tenantId"].(string)
+	if !tenantPattern.MatchString(tenant) {
+		return AnonymousTenant
+	}
+
+	return tenant
+}
+
+// TenantKeys map the hex SHA-256 hash of each API key to the tenant it belongs to.
+type TenantKeys map[string]string
+
+// Parse a JSON object of tenant to the hex SHA-256 hash of its API key, so
+// that the keys themselves never appear in configuration
+func ParseTenantKeys(config string) (TenantKeys, error) {
+	keys := TenantKeys{}
+	if config == "" {
+		return keys, nil
+	}
+
+	var hashes map[string]string
+	if err := json.Unmarshal([]byte(config), &hashes); err != nil {
+		return nil, fmt.Errorf("invalid tenant keys: %w", err)
+	}
+	for tenant, hash := range hashes {
+		if !tenantPattern.MatchString(tenant) || tenant == AnonymousTenant {
+			return nil, fmt.Errorf("invalid tenant name %q", tenant)
+		}
+		hash = strings.ToLower(hash)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("key hash for tenant %s is not a hex SHA-256 hash", tenant)
+		}
+		if other, ok := keys[hash]; ok {
+			return nil, fmt.Errorf("tenants %s and %s have the same key", other, tenant)
+		}
+		keys[hash] = tenant
+	}
+
+	return keys, nil
+}
+
+// The tenant an API key belongs to
+func (k TenantKeys) Tenant(key string) (string, bool) {
+	sum := sha256.Sum256([]byte(key))
+	tenant, ok := k[hex.EncodeToString(sum[:])]
+
+	return tenant, ok
+}
+
+// TenantListed reports whether the tenant is listed in a comma separated
+// environment variable, like PRESETS_ONLY_TENANTS
+func TenantListed(variable string, tenant string) bool {
+	for _, listed := range strings.Split(os.Getenv(variable), ",") {
+		if strings.TrimSpace(listed) == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TransformationsRestricted reports whether the tenant needs a preset or a
+// signed URL to transform images. A request body can't be signed, so such
+// tenants may not ask for transformations in one.
+func TransformationsRestricted(tenant string) bool {
+	return TenantListed("PRESETS_ONLY_TENANTS", tenant) || TenantListed("SIGNED_URLS_ONLY_TENANTS", tenant)
+}
+
+// HeaderValue looks up a request header by name. API Gateway passes headers
+// through with the client's casing.
+func HeaderValue(headers map[string]string, name string) (string, bool) {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,67 @@
+package shared
+
+import "testing"
+
+func TestTenant(t *testing.T) {
+	testCases := []struct {
+		name       string
+		authorizer map[string]interface{}
+		tenant     string
+	}{
+		{name: "Authorized", authorizer: map[string]interface{}{"tenantId": "acme"}, tenant: "acme"},
+		{name: "NoAuthorizer", authorizer: nil, tenant: AnonymousTenant},
+		{name: "NoTenant", authorizer: map[string]interface{}{"principalId": "acme"}, tenant: AnonymousTenant},
+		{name: "NotAString", authorizer: map[string]interface{}{"tenantId": 7}, tenant: AnonymousTenant},
+		{name: "InvalidTenant", authorizer: map[string]interface{}{"tenantId": "../other"}, tenant: AnonymousTenant},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tenant := Tenant(tc.authorizer); tenant != tc.tenant {
+				t.Errorf("Expected tenant %s, got: %s", tc.tenant, tenant)
+			}
+		})
+	}
+}
+
+func TestTenantListed(t *testing.T) {
+	t.Setenv("PRESETS_ONLY_TENANTS", "public, acme")
+
+	if !TenantListed("PRESETS_ONLY_TENANTS", "acme") || !TenantListed("PRESETS_ONLY_TENANTS", AnonymousTenant) {
+		t.Error("Expected listed tenants to be found")
+	}
+	if TenantListed("PRESETS_ONLY_TENANTS", "acm") || TenantListed("SIGNED_URLS_ONLY_TENANTS", "acme") {
+		t.Error("Expected only listed tenants to be found")
+	}
+	if !TransformationsRestricted("acme") || TransformationsRestricted("other") {
+		t.Error("Expected only listed tenants to be restricted")
+	}
+}
+
+func TestTenantKeys(t *testing.T) {
+	// The SHA-256 hash of "secret-key"
+	const hash = "85dbe15d75ef9308c7ae0f33c7a324cc6f4bf519a2ed2f3027bd33c140a4f9aa"
+
+	keys, err := ParseTenantKeys(`{"acme": "` + hash + `"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant, ok := keys.Tenant("secret-key"); !ok || tenant != "acme" {
+		t.Errorf("Expected the key to belong to acme, got: %s %v", tenant, ok)
+	}
+	if tenant, ok := keys.Tenant("other-key"); ok {
+		t.Errorf("Expected an unknown key to belong to no tenant, got: %s", tenant)
+	}
+
+	for _, config := range []string{
+		`not json`,
+		`{"acme": "secret-key"}`,
+		`{"../acme": "` + hash + `"}`,
+		`{"public": "` + hash + `"}`,
+		`{"acme": "` + hash + `", "other": "` + hash + `"}`,
+	} {
+		if _, err := ParseTenantKeys(config); err == nil {
+			t.Errorf("Expected %s to be rejected", config)
+		}
+	}
+}
@@ -2,9 +2,30 @@ package shared
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func GenerateJPG(t *testing.T) []byte {
@@ -20,3 +41,458 @@ func GenerateJPG(t *testing.T) []byte {
 	// Return the encoded JPEG as byte slices
 	return jpegBuf.Bytes()
 }
+
+// GenerateJPGWithMetadata makes a small JPEG carrying EXIF with a GPS
+// position, IPTC and XMP, as a camera and photo editor would leave it
+func GenerateJPGWithMetadata(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	segments, rest, err := splitJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ifd0 := []exifField{
+		asciiField(tagMake, "Canon"),
+		asciiField(tagModel, "EOS R5"),
+		shortField(tagOrientation, 6),
+		asciiField(tagDateTime, "2024:05:02 10:00:00"),
+		longField(tagExifIFD, 0),
+		longField(tagGPSIFD, 0),
+	}
+	exif := []exifField{
+		rationalField(tagExposureTime, [2]uint32{1, 125}),
+		rationalField(tagFNumber, [2]uint32{28, 10}),
+		shortField(tagISO, 400),
+		asciiField(tagDateTimeOriginal, "2024:05:01 18:30:15"),
+	}
+	gps := []exifField{
+		asciiField(tagGPSLatitudeRef, "N"),
+		rationalField(tagGPSLatitude, [2]uint32{51, 1}, [2]uint32{30, 1}, [2]uint32{264, 10}),
+		asciiField(tagGPSLongitudeRef, "W"),
+		rationalField(tagGPSLongitude, [2]uint32{0, 1}, [2]uint32{7, 1}, [2]uint32{396, 10}),
+		rationalField(tagGPSAltitude, [2]uint32{35, 1}),
+	}
+
+	// The directories follow each other after the header
+	exifAt := 8 + exifIFDSize(ifd0)
+	gpsAt := exifAt + exifIFDSize(exif)
+	ifd0[4] = longField(tagExifIFD, uint32(exifAt))
+	ifd0[5] = longField(tagGPSIFD, uint32(gpsAt))
+	tiff := make([]byte, gpsAt+exifIFDSize(gps))
+	copy(tiff, []byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+	writeEXIFIFD(tiff, 8, ifd0)
+	writeEXIFIFD(tiff, exifAt, exif)
+	writeEXIFIFD(tiff, gpsAt, gps)
+
+	var iptc []byte
+	for _, dataset := range []struct {
+		number byte
+		value  string
+	}{{5, "Tower Bridge"}, {25, "london"}, {25, "bridge"}, {80, "Jo Bloggs"}, {90, "London"}, {116, "(c) Jo Bloggs"}} {
+		iptc = append(iptc, 0x1C, 2, dataset.number, 0, byte(len(dataset.value)))
+		iptc = append(iptc, dataset.value...)
+	}
+	photoshop := append([]byte("8BIM\x04\x04\x00\x00"), byte(len(iptc)>>24), byte(len(iptc)>>16), byte(len(iptc)>>8), byte(len(iptc)))
+	photoshop = append(photoshop, iptc...)
+	if len(iptc)%2 == 1 {
+		photoshop = append(photoshop, 0)
+	}
+
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="51,30.44N"/></rdf:RDF></x:xmpmeta>`
+
+	metadata := []jpegSegment{
+		{marker: markerAPP1, data: append(append([]byte(nil), exifHeader...), tiff...)},
+		{marker: markerAPP1, data: append(append([]byte(nil), xmpHeader...), xmp...)},
+		{marker: markerAPPD, data: append(append([]byte(nil), photoshopHeader...), photoshop...)},
+	}
+
+	return joinJPEG(insertAfterHeader(segments, metadata), rest)
+}
+
+// GenerateJPGWithICCProfile makes a small JPEG of one colour, tagged with a
+// Display P3 profile so that the colour is in P3 rather than sRGB
+func GenerateJPGWithICCProfile(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := EmbedICCProfile(buf.Bytes(), displayP3Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// A matrix profile with Display P3's colourants and the sRGB tone curve
+func displayP3Profile() []byte {
+	return buildICCProfile([3][3]float64{
+		{0.5151, 0.2920, 0.1571},
+		{0.2412, 0.6922, 0.0666},
+		{-0.0011, 0.0419, 0.7841},
+	}, parametricCurve(2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045))
+}
+
+// Build an RGB matrix profile from the XYZ of each colourant, as columns, and
+// one tone curve shared by every channel
+func buildICCProfile(colorants [3][3]float64, curve []byte) []byte {
+	var tags [][]byte
+	for c := 0; c < 3; c++ {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			xyz = binary.BigEndian.AppendUint32(xyz, uint32(int32(math.Round(colorants[row][c]*65536))))
+		}
+		tags = append(tags, xyz)
+	}
+
+	names := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	profile := make([]byte, 132+12*len(names))
+	copy(profile[8:], []byte{4, 0x30, 0, 0})
+	copy(profile[12:], "mntrRGB XYZ ")
+	copy(profile[36:], "acsp")
+	binary.BigEndian.PutUint32(profile[128:], uint32(len(names)))
+	for i, name := range names {
+		data := curve
+		if i < 3 {
+			data = tags[i]
+		}
+		entry := profile[132+12*i:]
+		copy(entry, name)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(profile)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(data)))
+		profile = append(profile, data...)
+		for len(profile)%4 != 0 {
+			profile = append(profile, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+
+	return profile
+}
+
+// A "para" tone curve with up to seven parameters
+func parametricCurve(params ...float64) []byte {
+	kinds := map[int]uint16{1: 0, 3: 1, 4: 2, 5: 3, 7: 4}
+	curve := binary.BigEndian.AppendUint16([]byte("para\x00\x00\x00\x00"), kinds[len(params)])
+	curve = append(curve, 0, 0)
+	for _, param := range params {
+		curve = binary.BigEndian.AppendUint32(curve, uint32(int32(math.Round(param*65536))))
+	}
+
+	return curve
+}
+
+// A "curv" tone curve of a single gamma
+func gammaCurve(gamma float64) []byte {
+	curve := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), 1)
+	return binary.BigEndian.AppendUint16(curve, uint16(math.Round(gamma*256)))
+}
+
+// GenerateAnimatedGIF makes a 40x30 animation of three frames: a red
+// background, a blue square cleared after it is shown, then a green square
+func GenerateAnimatedGIF(t *testing.T) []byte {
+	palette := color.Palette{color.Transparent, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 255, 0, 255}}
+	frame := func(bounds image.Rectangle, index uint8) *image.Paletted {
+		img := image.NewPaletted(bounds, palette)
+		for i := range img.Pix {
+			img.Pix[i] = index
+		}
+		return img
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:    []*image.Paletted{frame(image.Rect(0, 0, 40, 30), 1), frame(image.Rect(10, 10, 20, 20), 2), frame(image.Rect(0, 0, 10, 10), 3)},
+		Delay:    []int{10, 20, 30},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// A 1x1 mid-grey lossy WebP. The x/image package can only decode WebP, so it can't be generated.
+const grayWebP = "UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA"
+
+// GenerateImage makes a small mid-grey image in the named format
+func GenerateImage(t *testing.T, format string) []byte {
+	if format == "webp" {
+		data, err := base64.StdEncoding.DecodeString(grayWebP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{128}), image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "tiff":
+		err = tiff.Encode(&buf, img, nil)
+	case "bmp":
+		err = bmp.Encode(&buf, img)
+	default:
+		t.Fatalf("Can't generate a %s image", format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// A big-endian TIFF directory entry for building EXIF in tests
+type exifField struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+func asciiField(tag uint16, value string) exifField {
+	return exifField{tag: tag, kind: 2, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+}
+
+func shortField(tag uint16, value uint16) exifField {
+	return exifField{tag: tag, kind: 3, count: 1, value: []byte{byte(value >> 8), byte(value)}}
+}
+
+func longField(tag uint16, value uint32) exifField {
+	return exifField{tag: tag, kind: 4, count: 1, value: []byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}}
+}
+
+func rationalField(tag uint16, values ...[2]uint32) exifField {
+	field := exifField{tag: tag, kind: 5, count: uint32(len(values))}
+	for _, value := range values {
+		for _, part := range value {
+			field.value = append(field.value, byte(part>>24), byte(part>>16), byte(part>>8), byte(part))
+		}
+	}
+
+	return field
+}
+
+// Bytes a directory takes, including values too long to hold in their entries
+func exifIFDSize(fields []exifField) int {
+	size := 2 + 12*len(fields) + 4
+	for _, field := range fields {
+		if len(field.value) > 4 {
+			size += len(field.value) + len(field.value)%2
+		}
+	}
+
+	return size
+}
+
+// Write a directory at offset, with its long values straight after it
+func writeEXIFIFD(tiff []byte, offset int, fields []exifField) {
+	tiff[offset], tiff[offset+1] = byte(len(fields)>>8), byte(len(fields))
+	data := offset + 2 + 12*len(fields) + 4
+	for i, field := range fields {
+		entry := tiff[offset+2+12*i:]
+		copy(entry, []byte{byte(field.tag >> 8), byte(field.tag), byte(field.kind >> 8), byte(field.kind)})
+		copy(entry[4:], []byte{byte(field.count >> 24), byte(field.count >> 16), byte(field.count >> 8), byte(field.count)})
+		if len(field.value) <= 4 {
+			copy(entry[8:], field.value)
+			continue
+		}
+		copy(entry[8:], []byte{byte(data >> 24), byte(data >> 16), byte(data >> 8), byte(data)})
+		copy(tiff[data:], field.value)
+		data += len(field.value) + len(field.value)%2
+	}
+}
+
+// MockS3Object is an object held by MockS3Client.
+type MockS3Object struct {
+	Body        []byte
+	ContentType string
+	ETag        string
+	Metadata    map[string]string
+}
+
+// MockS3Client is an in-memory S3ObjectAPI, S3DeleteObjectAPI and S3MultipartAPI for tests. Keys
+// are shared across buckets. Uploads holds the parts of multipart uploads that
+// are neither completed nor aborted, by upload ID.
+type MockS3Client struct {
+	mu      sync.Mutex
+	Objects map[string]*MockS3Object
+	Uploads map[string][][]byte
+}
+
+func NewMockS3Client() *MockS3Client {
+	return &MockS3Client{Objects: map[string]*MockS3Object{}, Uploads: map[string][][]byte{}}
+}
+
+// PutObject implements S3ObjectAPI.
+func (m *MockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(body)
+	object := &MockS3Object{
+		Body:        body,
+		ContentType: aws.ToString(params.ContentType),
+		ETag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		Metadata:    params.Metadata,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Objects[aws.ToString(params.Key)] = object
+
+	return &s3.PutObjectOutput{ETag: aws.String(object.ETag)}, nil
+}
+
+// GetObject implements S3ObjectAPI.
+func (m *MockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	object, err := m.object(aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(object.Body)),
+		ContentLength: int64(len(object.Body)),
+		ContentType:   aws.String(object.ContentType),
+		ETag:          aws.String(object.ETag),
+		Metadata:      object.Metadata,
+	}, nil
+}
+
+// HeadObject implements S3ObjectAPI.
+func (m *MockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	object, err := m.object(aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength: int64(len(object.Body)),
+		ContentType:   aws.String(object.ContentType),
+		ETag:          aws.String(object.ETag),
+		Metadata:      object.Metadata,
+	}, nil
+}
+
+// ListObjectsV2 implements S3ObjectAPI. Everything is returned in a single page.
+func (m *MockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var contents []types.Object
+	for key, object := range m.Objects {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			contents = append(contents, types.Object{
+				Key:  aws.String(key),
+				ETag: aws.String(object.ETag),
+				Size: int64(len(object.Body)),
+			})
+		}
+	}
+	sort.Slice(contents, func(i, j int) bool {
+		return aws.ToString(contents[i].Key) < aws.ToString(contents[j].Key)
+	})
+
+	return &s3.ListObjectsV2Output{
+		Contents: contents,
+		KeyCount: int32(len(contents)),
+	}, nil
+}
+
+// DeleteObject implements S3DeleteObjectAPI. Like S3, deleting a missing key succeeds.
+func (m *MockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.Objects, aws.ToString(params.Key))
+
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// CreateMultipartUpload implements S3MultipartAPI.
+func (m *MockS3Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := fmt.Sprintf("upload-%d", len(m.Uploads)+1)
+	m.Uploads[id] = nil
+
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+// UploadPart implements S3MultipartAPI. Parts must arrive in order.
+func (m *MockS3Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := aws.ToString(params.UploadId)
+	parts, ok := m.Uploads[id]
+	if !ok || int(params.PartNumber) != len(parts)+1 {
+		return nil, fmt.Errorf("unexpected part %d of upload %s", params.PartNumber, id)
+	}
+	m.Uploads[id] = append(parts, body)
+
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"part-%d"`, params.PartNumber))}, nil
+}
+
+// CompleteMultipartUpload implements S3MultipartAPI, storing the parts as one object.
+func (m *MockS3Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	m.mu.Lock()
+	id := aws.ToString(params.UploadId)
+	parts, ok := m.Uploads[id]
+	delete(m.Uploads, id)
+	m.mu.Unlock()
+
+	if !ok || len(parts) != len(params.MultipartUpload.Parts) {
+		return nil, fmt.Errorf("upload %s does not match the parts given", id)
+	}
+
+	_, err := m.PutObject(ctx, &s3.PutObjectInput{Key: params.Key, Body: bytes.NewReader(bytes.Join(parts, nil))})
+
+	return &s3.CompleteMultipartUploadOutput{}, err
+}
+
+// AbortMultipartUpload implements S3MultipartAPI.
+func (m *MockS3Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.Uploads, aws.ToString(params.UploadId))
+
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *MockS3Client) object(key string) (*MockS3Object, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	object, ok := m.Objects[key]
+	if !ok {
+		return nil, NotFoundError()
+	}
+
+	return object, nil
+}
@@ -0,0 +1,202 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Longest caption the text operation will draw
+const maxTextLength = 1000
+
+// Fonts built into the binary, by the name the "font" parameter uses
+var bundledFonts = map[string][]byte{
+	"regular": goregular.TTF,
+	"bold":    gobold.TTF,
+	"italic":  goitalic.TTF,
+	"mono":    gomono.TTF,
+}
+
+var alignments = map[string]bool{"left": true, "center": true, "right": true}
+
+// Load a bundled font at size pixels
+func fontFace(name string, size float64) (font.Face, error) {
+	data, ok := bundledFonts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown font %q", name)
+	}
+
+	parsed, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Draw a caption onto the image. The text is wrapped to fit the image and
+// placed at the "position" gravity, optionally on a "box" colour and with an
+// outline "stroke" pixels wide.
+func textOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	text := params["text"]
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("text is required")
+	}
+	if len(text) > maxTextLength {
+		return nil, fmt.Errorf("text must be at most %d bytes", maxTextLength)
+	}
+
+	fontName := "regular"
+	if value, ok := params["font"]; ok {
+		fontName = value
+	}
+	size, err := floatParam(params, "size", 32, 4, 512)
+	if err != nil {
+		return nil, err
+	}
+	face, err := fontFace(fontName, size)
+	if err != nil {
+		return nil, err
+	}
+
+	textColor, err := colorParam(params, "color", color.White)
+	if err != nil {
+		return nil, err
+	}
+	stroke, err := intParam(params, "stroke", 0, 0, 8)
+	if err != nil {
+		return nil, err
+	}
+	strokeColor, err := colorParam(params, "strokeColor", color.Black)
+	if err != nil {
+		return nil, err
+	}
+	box, err := colorParam(params, "box", color.Transparent)
+	if err != nil {
+		return nil, err
+	}
+	padding, err := intParam(params, "padding", 10, 0, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+	margin, err := intParam(params, "margin", 10, 0, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+
+	align := "center"
+	if value, ok := params["align"]; ok {
+		align = value
+	}
+	if !alignments[align] {
+		return nil, fmt.Errorf("unknown alignment %q", align)
+	}
+
+	position := "south"
+	if value, ok := params["position"]; ok {
+		position = value
+	}
+	anchor, ok := gravities[position]
+	if !ok {
+		return nil, fmt.Errorf("unknown position %q", position)
+	}
+
+	return func(img image.Image) image.Image {
+		bounds := img.Bounds()
+		lines := wrapText(face, text, bounds.Dx()-2*(margin+padding+stroke))
+
+		// Size the box around the widest line
+		lineHeight := face.Metrics().Height.Ceil()
+		widths := make([]int, len(lines))
+		textWidth := 0
+		for i, line := range lines {
+			widths[i] = font.MeasureString(face, line).Ceil()
+			textWidth = max(textWidth, widths[i])
+		}
+		boxSize := image.Pt(textWidth+2*(padding+stroke), len(lines)*lineHeight+2*(padding+stroke))
+		boxAt := anchorPoint(anchor, bounds.Size(), boxSize, margin)
+
+		result := imaging.Clone(img)
+		draw.Draw(result, image.Rectangle{Min: boxAt, Max: boxAt.Add(boxSize)}, image.NewUniform(box), image.Point{}, draw.Over)
+
+		for i, line := range lines {
+			x := boxAt.X + padding + stroke
+			switch align {
+			case "center":
+				x += (textWidth - widths[i]) / 2
+			case "right":
+				x += textWidth - widths[i]
+			}
+			y := boxAt.Y + padding + stroke + i*lineHeight + face.Metrics().Ascent.Ceil()
+
+			// The outline is the text drawn at every offset within the stroke width
+			for dy := -stroke; dy <= stroke; dy++ {
+				for dx := -stroke; dx <= stroke; dx++ {
+					if dx*dx+dy*dy <= stroke*stroke && (dx != 0 || dy != 0) {
+						drawString(result, face, strokeColor, line, x+dx, y+dy)
+					}
+				}
+			}
+			drawString(result, face, textColor, line, x, y)
+		}
+
+		return result
+	}, nil
+}
+
+// Split text into lines no wider than width, breaking at spaces and newlines.
+// A single word wider than width gets a line of its own.
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Draw a line of text with its baseline starting at x, y
+func drawString(dst draw.Image, face font.Face, c color.Color, text string, x int, y int) {
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
+
+// Draw a line of text on a transparent background just large enough to hold it
+func renderText(face font.Face, c color.Color, text string) image.Image {
+	width := max(1, font.MeasureString(face, text).Ceil())
+	height := face.Metrics().Height.Ceil()
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	drawString(canvas, face, c, text, 0, face.Metrics().Ascent.Ceil())
+
+	return canvas
+}
@@ -0,0 +1,145 @@
+package shared
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+)
+
+// Count the pixels in a region whose colour matches
+func countPixels(img image.Image, region image.Rectangle, match func(c color.NRGBA) bool) int {
+	count := 0
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			if match(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)) {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func TestTextOperation(t *testing.T) {
+	white := func(c color.NRGBA) bool { return c.R > 200 && c.G > 200 && c.B > 200 }
+	red := func(c color.NRGBA) bool { return c.R > 200 && c.G < 50 && c.B < 50 }
+	black := func(c color.NRGBA) bool { return c.R < 50 && c.G < 50 && c.B < 50 }
+
+	testCases := []struct {
+		name       string
+		params     map[string]string
+		background color.Color
+		// Regions of the 400x300 result expected to have, or lack, matching pixels
+		expectIn  map[image.Rectangle]func(color.NRGBA) bool
+		expectOut map[image.Rectangle]func(color.NRGBA) bool
+	}{
+		{
+			name:       "CaptionAtBottom",
+			params:     map[string]string{"text": "Hello world"},
+			background: color.Black,
+			expectIn:   map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 200, 400, 300): white},
+			expectOut:  map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 0, 400, 200): white},
+		},
+		{
+			name:       "CaptionAtTop",
+			params:     map[string]string{"text": "Hello world", "position": "north", "font": "bold", "size": "48"},
+			background: color.Black,
+			expectIn:   map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 0, 400, 100): white},
+			expectOut:  map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 100, 400, 300): white},
+		},
+		{
+			name:       "LeftAligned",
+			params:     map[string]string{"text": "Hi", "position": "west", "align": "left"},
+			background: color.Black,
+			expectIn:   map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 100, 100, 200): white},
+			expectOut:  map[image.Rectangle]func(color.NRGBA) bool{image.Rect(100, 0, 400, 300): white},
+		},
+		{
+			name:       "Box",
+			params:     map[string]string{"text": "Hello", "box": "ff0000", "color": "000000"},
+			background: color.White,
+			expectIn: map[image.Rectangle]func(color.NRGBA) bool{
+				image.Rect(0, 200, 400, 300): red,
+			},
+			expectOut: map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 0, 400, 200): red},
+		},
+		{
+			name:       "Stroke",
+			params:     map[string]string{"text": "Hello", "stroke": "3", "strokeColor": "000000"},
+			background: color.NRGBA{255, 0, 0, 255},
+			expectIn: map[image.Rectangle]func(color.NRGBA) bool{
+				image.Rect(0, 200, 400, 300): black,
+			},
+			expectOut: map[image.Rectangle]func(color.NRGBA) bool{image.Rect(0, 0, 400, 200): black},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transform, err := textOperation(tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := transform(imaging.New(400, 300, tc.background))
+			if result.Bounds().Size() != image.Pt(400, 300) {
+				t.Fatalf("Expected the image size to be kept, got: %v", result.Bounds().Size())
+			}
+			for region, match := range tc.expectIn {
+				if countPixels(result, region, match) == 0 {
+					t.Errorf("Expected matching pixels in %v", region)
+				}
+			}
+			for region, match := range tc.expectOut {
+				if count := countPixels(result, region, match); count != 0 {
+					t.Errorf("Expected no matching pixels in %v, got: %d", region, count)
+				}
+			}
+		})
+	}
+}
+
+func TestTextValidation(t *testing.T) {
+	for name, params := range map[string]map[string]string{
+		"MissingText":  {"size": "20"},
+		"BlankText":    {"text": "  "},
+		"UnknownFont":  {"text": "Hi", "font": "comic"},
+		"TooSmall":     {"text": "Hi", "size": "2"},
+		"WideStroke":   {"text": "Hi", "stroke": "9"},
+		"UnknownAlign": {"text": "Hi", "align": "justify"},
+		"BadColor":     {"text": "Hi", "color": "white"},
+		"BadPosition":  {"text": "Hi", "position": "up"},
+	} {
+		if _, err := textOperation(params); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	face, err := fontFace("regular", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := "the quick brown fox jumps over the lazy dog\nagain"
+	lines := wrapText(face, text, 120)
+	if len(lines) < 4 {
+		t.Errorf("Expected the text to wrap, got: %q", lines)
+	}
+	for _, line := range lines {
+		if width := font.MeasureString(face, line).Ceil(); width > 120 {
+			t.Errorf("Line %q is %d pixels wide", line, width)
+		}
+	}
+	if lines[len(lines)-1] != "again" {
+		t.Errorf("Expected a newline to start a new line, got: %q", lines)
+	}
+	if strings.Join(strings.Fields(strings.Join(lines, " ")), " ") != strings.Join(strings.Fields(text), " ") {
+		t.Errorf("Expected every word to be kept in order, got: %q", lines)
+	}
+}
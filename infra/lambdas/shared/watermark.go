@@ -0,0 +1,244 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+)
+
+// Logos for watermarks are stored as images under this prefix
+const watermarkPrefix = "watermarks/"
+
+// Logos are fetched from storage into a step's "logoData" just before it runs
+var errWatermarkNotLoaded = errors.New("watermark logo has not been loaded")
+
+// The key a watermark logo is stored under
+func WatermarkKey(logo string) string {
+	return watermarkPrefix + logo
+}
+
+// Overlay a logo, or a line of text in a bundled "font", onto the image. The mark is scaled to
+// "scale" of the image's width and drawn at "opacity", either once at the
+// "position" gravity or tiled across the whole image when "tile" is true.
+func watermarkOperation(params map[string]string) (func(image.Image) image.Image, error) {
+	mark, err := watermarkMark(params)
+	if err != nil {
+		return nil, err
+	}
+
+	scale, err := floatParam(params, "scale", 0.2, 0.01, 1)
+	if err != nil {
+		return nil, err
+	}
+	opacity, err := floatParam(params, "opacity", 0.5, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	margin, err := intParam(params, "margin", 10, 0, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+
+	tile := params["tile"] == "true"
+	if value, ok := params["tile"]; ok && value != "true" && value != "false" {
+		return nil, errors.New("tile must be true or false")
+	}
+
+	position := "southeast"
+	if value, ok := params["position"]; ok {
+		position = value
+	}
+	anchor, ok := gravities[position]
+	if !ok {
+		return nil, fmt.Errorf("unknown position %q", position)
+	}
+
+	return func(img image.Image) image.Image {
+		bounds := img.Bounds()
+		scaled := imaging.Resize(mark, max(1, int(float64(bounds.Dx())*scale)), 0, imaging.Lanczos)
+		size := scaled.Bounds().Size()
+
+		if !tile {
+			return imaging.Overlay(img, scaled, anchorPoint(anchor, bounds.Size(), size, margin), opacity)
+		}
+
+		// Lay the tiles out on one transparent layer so the image is only blended once
+		layer := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		for y := 0; y < bounds.Dy(); y += size.Y + margin {
+			for x := 0; x < bounds.Dx(); x += size.X + margin {
+				draw.Draw(layer, scaled.Bounds().Add(image.Pt(x, y)), scaled, image.Point{}, draw.Src)
+			}
+		}
+		return imaging.Overlay(img, layer, image.Point{}, opacity)
+	}, nil
+}
+
+// The image a watermark step draws: its loaded logo, or its text rendered in "color"
+func watermarkMark(params map[string]string) (image.Image, error) {
+	_, hasLogo := params["logo"]
+	text, hasText := params["text"]
+	if hasLogo == hasText {
+		return nil, errors.New("exactly one of logo or text is required")
+	}
+
+	if hasText {
+		if text == "" {
+			return nil, errors.New("text must not be empty")
+		}
+		c, err := colorParam(params, "color", DefaultBackground)
+		if err != nil {
+			return nil, err
+		}
+		fontName := "bold"
+		if value, ok := params["font"]; ok {
+			fontName = value
+		}
+		// Drawn large, to be scaled down to the image
+		face, err := fontFace(fontName, 96)
+		if err != nil {
+			return nil, err
+		}
+		return renderText(face, c, text), nil
+	}
+
+	if !IsValidRenditionName(params["logo"]) {
+		return nil, fmt.Errorf("invalid logo name %q", params["logo"])
+	}
+	data, ok := params["logoData"]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errWatermarkNotLoaded, params["logo"])
+	}
+
+	logo, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("logo %s: %w", params["logo"], err)
+	}
+	mark, err := imaging.Decode(bytes.NewReader(logo))
+	if err != nil {
+		return nil, fmt.Errorf("logo %s: %w", params["logo"], err)
+	}
+
+	return mark, nil
+}
+
+// Where the top left of an inner rectangle goes to sit at anchor within outer, inset by margin
+func anchorPoint(anchor imaging.Anchor, outer image.Point, inner image.Point, margin int) image.Point {
+	left, centerX, right := margin, (outer.X-inner.X)/2, outer.X-inner.X-margin
+	top, centerY, bottom := margin, (outer.Y-inner.Y)/2, outer.Y-inner.Y-margin
+
+	switch anchor {
+	case imaging.TopLeft:
+		return image.Pt(left, top)
+	case imaging.Top:
+		return image.Pt(centerX, top)
+	case imaging.TopRight:
+		return image.Pt(right, top)
+	case imaging.Left:
+		return image.Pt(left, centerY)
+	case imaging.Right:
+		return image.Pt(right, centerY)
+	case imaging.BottomLeft:
+		return image.Pt(left, bottom)
+	case imaging.Bottom:
+		return image.Pt(centerX, bottom)
+	case imaging.BottomRight:
+		return image.Pt(right, bottom)
+	default:
+		return image.Pt(centerX, centerY)
+	}
+}
+
+// Whether any step draws a stored logo
+func (p Pipeline) UsesWatermarkLogo() bool {
+	for _, step := range p {
+		if _, ok := step.Params["logo"]; ok && step.Op == "watermark" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fetch the logo of every watermark step from storage and pass it to the step
+func LoadWatermarkLogos(ctx context.Context, s3Client S3ObjectAPI, bucketName string, pipeline Pipeline) (Pipeline, error) {
+	if !pipeline.UsesWatermarkLogo() {
+		return pipeline, nil
+	}
+
+	result := make(Pipeline, len(pipeline))
+	for i, step := range pipeline {
+		result[i] = step
+		logo, ok := step.Params["logo"]
+		if step.Op != "watermark" || !ok {
+			continue
+		}
+		if !IsValidRenditionName(logo) {
+			return nil, fmt.Errorf("invalid logo name %q", logo)
+		}
+
+		output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(WatermarkKey(logo)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("watermark logo %s: %w", logo, err)
+		}
+		data, err := io.ReadAll(output.Body)
+		output.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		params := map[string]string{}
+		for key, value := range step.Params {
+			params[key] = value
+		}
+		params["logoData"] = base64.StdEncoding.EncodeToString(data)
+		result[i].Params = params
+	}
+
+	return result, nil
+}
+
+// WatermarkPolicy maps tenants to the watermark parameters that must be
+// applied to every image they are sent.
+type WatermarkPolicy map[string]map[string]string
+
+// Parse a JSON object of tenant to watermark parameters, where an empty config means none
+func ParseWatermarkPolicy(config string) (WatermarkPolicy, error) {
+	policy := WatermarkPolicy{}
+	if config == "" {
+		return policy, nil
+	}
+
+	if err := json.Unmarshal([]byte(config), &policy); err != nil {
+		return nil, fmt.Errorf("invalid watermark policy: %w", err)
+	}
+	for tenant, params := range policy {
+		if err := (Pipeline{{Op: "watermark", Params: params}}).Validate(); err != nil {
+			return nil, fmt.Errorf("watermark for tenant %s: %w", tenant, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// The watermark step the tenant must have, if any
+func (w WatermarkPolicy) For(tenant string) (Operation, bool) {
+	params, ok := w[tenant]
+	if !ok {
+		return Operation{}, false
+	}
+
+	return Operation{Op: "watermark", Params: params}, true
+}
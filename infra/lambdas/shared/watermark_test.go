@@ -0,0 +1,184 @@
+package shared
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestWatermarkOperation(t *testing.T) {
+	client := NewMockS3Client()
+	client.Objects[WatermarkKey("acme")] = &MockS3Object{Body: encode(t, generateFlat(color.NRGBA{255, 0, 0, 255}), imaging.PNG)}
+	black := color.NRGBA{0, 0, 0, 255}
+	red := color.NRGBA{255, 0, 0, 255}
+
+	testCases := []struct {
+		name   string
+		params map[string]string
+		// Colours expected at points of a black 100x100 image after the watermark
+		expectColors map[image.Point]color.NRGBA
+	}{
+		{
+			name:   "LogoAtPosition",
+			params: map[string]string{"logo": "acme", "position": "northwest", "scale": "0.5", "opacity": "1", "margin": "0"},
+			expectColors: map[image.Point]color.NRGBA{
+				image.Pt(10, 10): red,
+				image.Pt(60, 60): black,
+			},
+		},
+		{
+			name:   "LogoDefaultsToSoutheast",
+			params: map[string]string{"logo": "acme", "opacity": "1"},
+			expectColors: map[image.Point]color.NRGBA{
+				image.Pt(85, 85): red,
+				image.Pt(95, 95): black,
+				image.Pt(10, 10): black,
+			},
+		},
+		{
+			name:   "HalfOpacity",
+			params: map[string]string{"logo": "acme", "position": "center", "scale": "0.5"},
+			expectColors: map[image.Point]color.NRGBA{
+				image.Pt(50, 50): {128, 0, 0, 255},
+			},
+		},
+		{
+			name:   "Tiled",
+			params: map[string]string{"logo": "acme", "scale": "0.3", "opacity": "1", "margin": "0", "tile": "true"},
+			expectColors: map[image.Point]color.NRGBA{
+				image.Pt(0, 0):   red,
+				image.Pt(50, 50): red,
+				image.Pt(99, 99): red,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline, err := LoadWatermarkLogos(context.TODO(), client, "bucket", Pipeline{{Op: "watermark", Params: tc.params}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			transforms, err := pipeline.transforms()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := transforms[0](imaging.New(100, 100, black))
+			for point, expect := range tc.expectColors {
+				got := color.NRGBAModel.Convert(result.At(point.X, point.Y)).(color.NRGBA)
+				if !closeColor(got, expect) {
+					t.Errorf("Expected %v at %v, got: %v", expect, point, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWatermarkText(t *testing.T) {
+	transform, err := watermarkOperation(map[string]string{"text": "CONFIDENTIAL", "color": "ff0000", "opacity": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the pixels the red text was drawn on
+	result := transform(imaging.New(400, 300, color.Black))
+	drawnOn := func(region image.Rectangle) int {
+		drawn := 0
+		for y := region.Min.Y; y < region.Max.Y; y++ {
+			for x := region.Min.X; x < region.Max.X; x++ {
+				if c := color.NRGBAModel.Convert(result.At(x, y)).(color.NRGBA); c.R > 64 {
+					drawn++
+				}
+			}
+		}
+		return drawn
+	}
+
+	if drawnOn(image.Rect(300, 250, 400, 300)) == 0 {
+		t.Error("Expected text in the bottom right corner")
+	}
+	if drawn := drawnOn(image.Rect(0, 0, 400, 250)) + drawnOn(image.Rect(0, 250, 300, 300)); drawn != 0 {
+		t.Errorf("Expected no text outside the bottom right corner, got %d pixels", drawn)
+	}
+}
+
+func TestWatermarkValidation(t *testing.T) {
+	testCases := []struct {
+		name           string
+		params         map[string]string
+		expectValid    bool
+		expectRunnable bool
+	}{
+		{
+			name:           "Text",
+			params:         map[string]string{"text": "Sample"},
+			expectValid:    true,
+			expectRunnable: true,
+		},
+		{
+			name:        "LogoNotLoaded",
+			params:      map[string]string{"logo": "acme"},
+			expectValid: true,
+		},
+		{
+			name:   "NeitherLogoNorText",
+			params: map[string]string{"position": "north"},
+		},
+		{
+			name:   "LogoAndText",
+			params: map[string]string{"logo": "acme", "text": "Sample"},
+		},
+		{
+			name:   "InvalidLogoName",
+			params: map[string]string{"logo": "../acme"},
+		},
+		{
+			name:   "UnknownPosition",
+			params: map[string]string{"text": "Sample", "position": "middle"},
+		},
+		{
+			name:   "InvalidTile",
+			params: map[string]string{"text": "Sample", "tile": "yes"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := Pipeline{{Op: "watermark", Params: tc.params}}
+			if err := pipeline.Validate(); (err == nil) != tc.expectValid {
+				t.Errorf("Expected valid: %v, got: %v", tc.expectValid, err)
+			}
+			if _, err := pipeline.transforms(); (err == nil) != tc.expectRunnable {
+				t.Errorf("Expected runnable: %v, got: %v", tc.expectRunnable, err)
+			}
+		})
+	}
+}
+
+func TestLoadWatermarkLogosMissing(t *testing.T) {
+	_, err := LoadWatermarkLogos(context.TODO(), NewMockS3Client(), "bucket", Pipeline{{Op: "watermark", Params: map[string]string{"logo": "acme"}}})
+	if !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got: %v", err)
+	}
+}
+
+func TestParseWatermarkPolicy(t *testing.T) {
+	policy, err := ParseWatermarkPolicy(`{"partner": {"logo": "acme", "position": "south"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := policy.For("partner"); !ok || step.Params["logo"] != "acme" {
+		t.Errorf("Expected a watermark for partner, got: %v", step)
+	}
+	if _, ok := policy.For("internal"); ok {
+		t.Error("Expected no watermark for internal")
+	}
+
+	if _, err := ParseWatermarkPolicy(`{"partner": {"position": "south"}}`); err == nil {
+		t.Error("Expected a watermark without a logo or text to be rejected")
+	}
+}
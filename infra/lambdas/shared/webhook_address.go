@@ -0,0 +1,110 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookAddress is returned for webhook URLs that reach private, loopback,
+// link-local or other internal addresses, like the instance metadata service.
+var ErrWebhookAddress = errors.New("webhook URL must resolve to a public address")
+
+// Ranges that are reachable from inside AWS but not from the internet, on top
+// of those the net package already knows about
+var internalNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+// HostResolver looks up the addresses of a host name, like net.Resolver does.
+type HostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+// IsPublicAddress reports whether webhooks may be delivered to the address
+func IsPublicAddress(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckWebhookURL checks that the URL is an absolute http or https URL whose
+// host only resolves to public addresses. The address is checked again on
+// every delivery, since DNS can change after the webhook is registered.
+func CheckWebhookURL(ctx context.Context, resolver HostResolver, rawURL string) error {
+	callback, err := url.Parse(rawURL)
+	if err != nil || (callback.Scheme != "https" && callback.Scheme != "http") || callback.Hostname() == "" {
+		return errors.New("webhook URL must be an absolute http or https URL")
+	}
+
+	if ip := net.ParseIP(callback.Hostname()); ip != nil {
+		if !IsPublicAddress(ip) {
+			return ErrWebhookAddress
+		}
+		return nil
+	}
+
+	addresses, err := resolver.LookupIPAddr(ctx, callback.Hostname())
+	if err != nil {
+		return fmt.Errorf("webhook URL host could not be resolved: %w", err)
+	}
+	for _, address := range addresses {
+		if !IsPublicAddress(address.IP) {
+			return ErrWebhookAddress
+		}
+	}
+
+	return nil
+}
+
+// An HTTP client that refuses to connect to anything but public addresses,
+// whatever the URL's host resolves to when the request is made
+func newWebhookHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublicAddress(net.ParseIP(host)) {
+				return ErrWebhookAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	testCases := []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.215.14", public: true},
+		{address: "2606:2800:220:1:248:1893:25c8:1946", public: true},
+		{address: "127.0.0.1", public: false},
+		{address: "10.1.2.3", public: false},
+		{address: "172.16.0.1", public: false},
+		{address: "192.168.1.1", public: false},
+		{address: "169.254.169.254", public: false},
+		{address: "100.64.0.1", public: false},
+		{address: "0.0.0.0", public: false},
+		{address: "::1", public: false},
+		{address: "fd00:ec2::254", public: false},
+		{address: "fe80::1", public: false},
+		{address: "::ffff:169.254.169.254", public: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.address, func(t *testing.T) {
+			if public := IsPublicAddress(net.ParseIP(tc.address)); public != tc.public {
+				t.Errorf("Expected public: %v, got: %v", tc.public, public)
+			}
+		})
+	}
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	response, err := newWebhookHTTPClient(time.Second).Post(server.URL, "application/json", nil)
+	if err == nil {
+		response.Body.Close()
+	}
+	if !errors.Is(err, ErrWebhookAddress) {
+		t.Errorf("Expected the loopback address to be refused, got: %v", err)
+	}
+}
+
+func TestCheckWebhookURLUnresolvable(t *testing.T) {
+	resolver := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return nil, errors.New("no DNS in tests")
+	}}
+
+	if err := CheckWebhookURL(context.TODO(), resolver, "https://example.invalid/hook"); err == nil {
+		t.Error("Expected an unresolvable host to be rejected")
+	}
+}
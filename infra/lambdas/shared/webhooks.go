@@ -0,0 +1,404 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const webhookPrefix = "webhooks/"
+
+// Event types a webhook can subscribe to
+const (
+	EventImageUploaded  = "image.uploaded"
+	EventImageProcessed = "image.processed"
+	EventImageFailed    = "image.failed"
+)
+
+// WebhookEventTypes lists every event type a webhook can subscribe to.
+var WebhookEventTypes = []string{EventImageUploaded, EventImageProcessed, EventImageFailed}
+
+// WebhookSubscription is a callback URL registered by a tenant.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookEvent is the JSON payload delivered to a subscription.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// ImageEventData is the data carried by image events.
+type ImageEventData struct {
+	Name        string `json:"name"`
+	ContentHash string `json:"contentHash,omitempty"`
+	JobID       string `json:"jobId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// WebhookAttempt is a single try at delivering an event.
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// WebhookDelivery records every attempt to deliver an event to a subscription.
+type WebhookDelivery struct {
+	ID             string           `json:"id"`
+	SubscriptionID string           `json:"subscriptionId"`
+	EventID        string           `json:"eventId"`
+	EventType      string           `json:"eventType"`
+	Delivered      bool             `json:"delivered"`
+	Attempts       []WebhookAttempt `json:"attempts"`
+}
+
+// WebhookDispatcher delivers events to the subscriptions of a tenant, retrying
+// with exponential backoff. The webhook worker runs it off the request path, and
+// MaxAttempts and BaseBackoff bound how long a failing receiver can hold it up.
+type WebhookDispatcher struct {
+	HTTPClient  *http.Client
+	MaxAttempts int
+	BaseBackoff time.Duration
+}
+
+func NewWebhookDispatcher() *WebhookDispatcher {
+	return &WebhookDispatcher{
+		HTTPClient:  newWebhookHTTPClient(5 * time.Second),
+		MaxAttempts: 4,
+		BaseBackoff: 500 * time.Millisecond,
+	}
+}
+
+// Generate a random identifier for subscriptions, events and secrets
+func NewWebhookID() (string, error) {
+	return newRandomID()
+}
+
+// Compute the X-Webhook-Signature header value for a payload. Receivers should
+// recompute it from the X-Webhook-Timestamp header and the raw body.
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Each subscription is stored as its own object, so registering or removing
+// one never rewrites the others
+func webhookSubscriptionsPrefix(tenant string) string {
+	return webhookPrefix + tenant + "/subscriptions/"
+}
+
+func webhookSubscriptionKey(tenant string, id string) string {
+	return webhookSubscriptionsPrefix(tenant) + id + ".json"
+}
+
+// The prefix holding delivery records for a subscription
+func WebhookDeliveriesPrefix(tenant string, subscriptionID string) string {
+	return webhookPrefix + tenant + "/deliveries/" + subscriptionID + "/"
+}
+
+// Fetch the webhook subscriptions of a tenant, oldest first
+func GetWebhookSubscriptions(ctx context.Context, s3Client S3ObjectAPI, bucketName string, tenant string) ([]WebhookSubscription, error) {
+	subscriptions := []WebhookSubscription{}
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(webhookSubscriptionsPrefix(tenant)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			var subscription WebhookSubscription
+			err := getJSON(ctx, s3Client, bucketName, aws.ToString(object.Key), &subscription)
+			// Removed since it was listed
+			if IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+
+	return subscriptions, nil
+}
+
+// Fetch a single webhook subscription of a tenant. A missing one is reported
+// as an S3 "Not Found" error.
+func GetWebhookSubscription(ctx context.Context, s3Client S3ObjectAPI, bucketName string, tenant string, id string) (WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	err := getJSON(ctx, s3Client, bucketName, webhookSubscriptionKey(tenant, id), &subscription)
+
+	return subscription, err
+}
+
+// Store a webhook subscription of a tenant
+func PutWebhookSubscription(ctx context.Context, s3Client S3ObjectAPI, bucketName string, tenant string, subscription WebhookSubscription) error {
+	return putJSON(ctx, s3Client, bucketName, webhookSubscriptionKey(tenant, subscription.ID), subscription)
+}
+
+// Remove a webhook subscription of a tenant
+func DeleteWebhookSubscription(ctx context.Context, s3Client S3DeleteObjectAPI, bucketName string, tenant string, id string) error {
+	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(webhookSubscriptionKey(tenant, id)),
+	})
+
+	return err
+}
+
+// Fetch the delivery records of a subscription, oldest first
+func ListWebhookDeliveries(ctx context.Context, s3Client S3ObjectAPI, bucketName string, tenant string, subscriptionID string) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(WebhookDeliveriesPrefix(tenant, subscriptionID)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			var delivery WebhookDelivery
+			if err := getJSON(ctx, s3Client, bucketName, aws.ToString(object.Key), &delivery); err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Attempts[0].At.Before(deliveries[j].Attempts[0].At)
+	})
+
+	return deliveries, nil
+}
+
+// NewWebhookEvent stamps an event for the tenant with a fresh ID and the current time
+func NewWebhookEvent(tenant string, eventType string, data any) (WebhookEvent, error) {
+	id, err := NewWebhookID()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	return WebhookEvent{ID: id, Type: eventType, Tenant: tenant, CreatedAt: time.Now().UTC(), Data: data}, nil
+}
+
+// QueueWebhookEvent queues an event for the webhook worker to deliver. Callers
+// without an API key can't have subscriptions, so their events are dropped.
+func QueueWebhookEvent(ctx context.Context, queue WebhookQueue, tenant string, eventType string, data any) error {
+	if tenant == AnonymousTenant {
+		return nil
+	}
+
+	event, err := NewWebhookEvent(tenant, eventType, data)
+	if err != nil {
+		return err
+	}
+
+	return queue.Enqueue(ctx, event)
+}
+
+// Deliver the event to every subscription of its tenant that wants it, all at
+// once, recording each delivery
+func (d *WebhookDispatcher) Deliver(ctx context.Context, s3Client S3ObjectAPI, bucketName string, event WebhookEvent) error {
+	subscriptions, err := GetWebhookSubscriptions(ctx, s3Client, bucketName, event.Tenant)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(subscriptions))
+	for i, subscription := range subscriptions {
+		if !subscribesTo(subscription, event.Type) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, subscription WebhookSubscription) {
+			defer wg.Done()
+
+			delivery := d.deliver(ctx, subscription, body)
+			delivery.EventID = event.ID
+			delivery.EventType = event.Type
+
+			key := WebhookDeliveriesPrefix(event.Tenant, subscription.ID) + delivery.ID + ".json"
+			errs[i] = putJSON(ctx, s3Client, bucketName, key, delivery)
+		}(i, subscription)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// WebhookQueue hands events to the webhook worker.
+type WebhookQueue interface {
+	Enqueue(ctx context.Context, event WebhookEvent) error
+}
+
+type sqsWebhookQueue struct {
+	sqsClient SQSSendMessageAPI
+	queueURL  string
+}
+
+// Create a WebhookQueue that sends events to an SQS queue as JSON
+func NewSQSWebhookQueue(sqsClient SQSSendMessageAPI, queueURL string) WebhookQueue {
+	return &sqsWebhookQueue{sqsClient: sqsClient, queueURL: queueURL}
+}
+
+func (q *sqsWebhookQueue) Enqueue(ctx context.Context, event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+
+	return err
+}
+
+// MemoryWebhookQueue is a WebhookQueue held in memory, for tests and local runs.
+type MemoryWebhookQueue struct {
+	mu     sync.Mutex
+	events []WebhookEvent
+}
+
+func NewMemoryWebhookQueue() *MemoryWebhookQueue {
+	return &MemoryWebhookQueue{}
+}
+
+func (q *MemoryWebhookQueue) Enqueue(ctx context.Context, event WebhookEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.events = append(q.events, event)
+
+	return nil
+}
+
+// Take every queued event, oldest first
+func (q *MemoryWebhookQueue) Drain() []WebhookEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events := q.events
+	q.events = nil
+
+	return events
+}
+
+func subscribesTo(subscription WebhookSubscription, eventType string) bool {
+	for _, event := range subscription.Events {
+		if event == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// POST the payload until the receiver answers with a 2xx or we run out of attempts
+func (d *WebhookDispatcher) deliver(ctx context.Context, subscription WebhookSubscription, body []byte) WebhookDelivery {
+	id, _ := NewWebhookID()
+	delivery := WebhookDelivery{ID: id, SubscriptionID: subscription.ID}
+
+	backoff := d.BaseBackoff
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		result := d.post(ctx, subscription, body)
+		delivery.Attempts = append(delivery.Attempts, result)
+
+		if result.Error == "" && result.StatusCode >= 200 && result.StatusCode < 300 {
+			delivery.Delivered = true
+			return delivery
+		}
+
+		log.Printf("Webhook %s attempt %d failed: %d %s", subscription.ID, attempt, result.StatusCode, result.Error)
+		if attempt < d.MaxAttempts {
+			select {
+			case <-ctx.Done():
+				return delivery
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+
+	return delivery
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, subscription WebhookSubscription, body []byte) WebhookAttempt {
+	attempt := WebhookAttempt{At: time.Now().UTC()}
+	timestamp := strconv.FormatInt(attempt.At.Unix(), 10)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Id", subscription.ID)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", SignWebhookPayload(subscription.Secret, timestamp, body))
+
+	response, err := d.HTTPClient.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected status %s", response.Status)
+	}
+
+	return attempt
+}
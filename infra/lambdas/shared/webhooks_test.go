@@ -0,0 +1,195 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Records the requests a webhook receiver gets, answering with the given status codes in turn
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	tests := []struct {
+		name            string
+		events          []string
+		eventType       string
+		statuses        []int
+		expectRequests  int
+		expectDelivered bool
+	}{
+		{
+			name:            "Delivered first time",
+			events:          []string{EventImageUploaded},
+			eventType:       EventImageUploaded,
+			expectRequests:  1,
+			expectDelivered: true,
+		},
+		{
+			name:            "Retried after server errors",
+			events:          []string{EventImageUploaded},
+			eventType:       EventImageUploaded,
+			statuses:        []int{500, 503},
+			expectRequests:  3,
+			expectDelivered: true,
+		},
+		{
+			name:            "Gives up after max attempts",
+			events:          []string{EventImageUploaded},
+			eventType:       EventImageUploaded,
+			statuses:        []int{500, 500, 500, 500},
+			expectRequests:  3,
+			expectDelivered: false,
+		},
+		{
+			name:           "Not subscribed to event",
+			events:         []string{EventImageFailed},
+			eventType:      EventImageUploaded,
+			expectRequests: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver := &webhookReceiver{statuses: tc.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			client := NewMockS3Client()
+			subscription := WebhookSubscription{ID: "sub", URL: server.URL, Events: tc.events, Secret: "secret"}
+			if err := PutWebhookSubscription(context.TODO(), client, "bucket", "tenant", subscription); err != nil {
+				t.Fatal(err)
+			}
+
+			dispatcher := &WebhookDispatcher{HTTPClient: server.Client(), MaxAttempts: 3, BaseBackoff: time.Millisecond}
+			event, err := NewWebhookEvent("tenant", tc.eventType, ImageEventData{Name: "image.jpg"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := dispatcher.Deliver(context.TODO(), client, "bucket", event); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(receiver.bodies) != tc.expectRequests {
+				t.Fatalf("Expected %d requests, got: %d", tc.expectRequests, len(receiver.bodies))
+			}
+			for i, body := range receiver.bodies {
+				header := receiver.headers[i]
+				signature := SignWebhookPayload("secret", header.Get("X-Webhook-Timestamp"), body)
+				if header.Get("X-Webhook-Signature") != signature {
+					t.Errorf("Request %d has signature %s, expected %s", i, header.Get("X-Webhook-Signature"), signature)
+				}
+
+				var event WebhookEvent
+				if err := json.Unmarshal(body, &event); err != nil {
+					t.Fatal(err)
+				}
+				if event.Type != tc.eventType || event.Tenant != "tenant" {
+					t.Errorf("Unexpected event: %+v", event)
+				}
+			}
+
+			deliveries, _ := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+				Prefix: aws.String(WebhookDeliveriesPrefix("tenant", "sub")),
+			})
+			if tc.expectRequests == 0 {
+				if len(deliveries.Contents) != 0 {
+					t.Errorf("Expected no deliveries, got: %d", len(deliveries.Contents))
+				}
+				return
+			}
+			if len(deliveries.Contents) != 1 {
+				t.Fatalf("Expected one delivery, got: %d", len(deliveries.Contents))
+			}
+
+			object, _ := client.GetObject(context.TODO(), &s3.GetObjectInput{Key: deliveries.Contents[0].Key})
+			var delivery WebhookDelivery
+			if err := json.NewDecoder(object.Body).Decode(&delivery); err != nil {
+				t.Fatal(err)
+			}
+			if delivery.Delivered != tc.expectDelivered || len(delivery.Attempts) != tc.expectRequests {
+				t.Errorf("Unexpected delivery: %+v", delivery)
+			}
+		})
+	}
+}
+
+func TestGetWebhookSubscriptionsEmpty(t *testing.T) {
+	subscriptions, err := GetWebhookSubscriptions(context.TODO(), NewMockS3Client(), "bucket", "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 0 {
+		t.Errorf("Expected no subscriptions, got: %v", subscriptions)
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	client := NewMockS3Client()
+	created := time.Now().UTC()
+	for i, id := range []string{"second", "first", "third"} {
+		subscription := WebhookSubscription{ID: id, URL: "https://example.com/" + id, CreatedAt: created.Add(time.Duration([]int{1, 0, 2}[i]) * time.Second)}
+		if err := PutWebhookSubscription(context.TODO(), client, "bucket", "tenant", subscription); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := DeleteWebhookSubscription(context.TODO(), client, "bucket", "tenant", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetWebhookSubscription(context.TODO(), client, "bucket", "tenant", "second"); !IsNotFound(err) {
+		t.Errorf("Expected the deleted subscription to be missing, got: %v", err)
+	}
+
+	subscriptions, err := GetWebhookSubscriptions(context.TODO(), client, "bucket", "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 2 || subscriptions[0].ID != "first" || subscriptions[1].ID != "third" {
+		t.Errorf("Expected the remaining subscriptions oldest first, got: %+v", subscriptions)
+	}
+}
+
+func TestQueueWebhookEvent(t *testing.T) {
+	queue := NewMemoryWebhookQueue()
+
+	if err := QueueWebhookEvent(context.TODO(), queue, "tenant", EventImageUploaded, ImageEventData{Name: "image.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := QueueWebhookEvent(context.TODO(), queue, AnonymousTenant, EventImageUploaded, ImageEventData{Name: "image.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := queue.Drain()
+	if len(queued) != 1 || queued[0].Tenant != "tenant" || queued[0].Type != EventImageUploaded || queued[0].ID == "" {
+		t.Errorf("Expected one event for the tenant, got: %+v", queued)
+	}
+}
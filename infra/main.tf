@@ -6,10 +6,49 @@ resource "random_id" "bucket_suffix" {
   byte_length = 4
 }
 
+resource "random_password" "url_signing_secret" {
+  length  = 32
+  special = false
+}
+
 resource "aws_s3_bucket" "image-storage-bucket" {
   bucket = "image-storage-bucket-${random_id.bucket_suffix.hex}"
 }
 
+resource "aws_s3_bucket_lifecycle_configuration" "image-storage-bucket-lifecycle" {
+  bucket = aws_s3_bucket.image-storage-bucket.id
+
+  rule {
+    id     = "expire-idempotency-records"
+    status = "Enabled"
+
+    filter {
+      prefix = "idempotency/"
+    }
+
+    expiration {
+      days = 2
+    }
+  }
+
+  rule {
+    id     = "expire-archives"
+    status = "Enabled"
+
+    filter {
+      prefix = "archives/"
+    }
+
+    expiration {
+      days = 1
+    }
+
+    abort_incomplete_multipart_upload {
+      days_after_initiation = 1
+    }
+  }
+}
+
 resource "aws_iam_role" "post_image_lambda_role" {
   name               = "post_image_lambda_role"
   assume_role_policy = <<EOF
@@ -37,10 +76,17 @@ resource "aws_iam_policy" "post_image_lambda_policy" {
       {
         Action = [
           "s3:PutObject",
+          "s3:GetObject",
+          "s3:ListBucket",
         ]
         Resource = "arn:aws:s3:::*"
         Effect   = "Allow"
       },
+      {
+        Action   = ["sqs:SendMessage"]
+        Resource = aws_sqs_queue.image_webhooks_queue.arn
+        Effect   = "Allow"
+      },
       {
         Action = [
           "logs:CreateLogGroup",
@@ -73,10 +119,33 @@ resource "aws_lambda_function" "post_image_lambda_func" {
   runtime          = "go1.x"
   depends_on       = [aws_iam_role_policy_attachment.post_image_iam_role_policy_attachment]
   source_code_hash = data.archive_file.zip_the_go_bin_post.output_base64sha256
+  timeout          = 60   # Batch uploads process many images per request
+  memory_size      = 1024
 
   environment {
     variables = {
-      S3_BUCKET_NAME = aws_s3_bucket.image-storage-bucket.bucket
+      S3_BUCKET_NAME       = aws_s3_bucket.image-storage-bucket.bucket
+      WEBHOOK_QUEUE_URL    = aws_sqs_queue.image_webhooks_queue.url
+      NAME_CONFLICT_POLICY = "overwrite"
+      DEDUPLICATE_UPLOADS  = "false"
+      IDEMPOTENCY_TTL      = "24h"
+      BATCH_CONCURRENCY    = "8"
+      JPEG_MIN_QUALITY     = "30" # Bounds on the quality callers may ask for
+      JPEG_MAX_QUALITY     = "95"
+      ALPHA_POLICY         = "keep"   # Or "flatten" onto ALPHA_BACKGROUND
+      ALPHA_BACKGROUND     = "ffffff"
+      EMBED_ICC_PROFILES   = "false" # Store originals in their own colour space; derivatives are always sRGB
+      ACCEPTED_FORMATS     = "jpeg,png,gif,webp,tiff,bmp" # Checked against the data itself, not the name
+      # Tenant => "strip-all", "strip-gps" or "preserve", the default tenant's applying to any not listed
+      METADATA_POLICIES = jsonencode({ default = "strip-gps" })
+      RENDITIONS = jsonencode({
+        thumb   = [{ op = "fill", params = { width = "200", height = "200" } }]
+        preview = [{ op = "fit", params = { width = "1280", height = "720" } }]
+        rotated = [
+          { op = "rotate", params = { angle = "180" } },
+          { op = "resize", params = { width = "1280", height = "720" } },
+        ]
+      })
     }
   }
 }
@@ -149,7 +218,15 @@ resource "aws_lambda_function" "get_image_lambda_func" {
 
   environment {
     variables = {
-      S3_BUCKET_NAME = aws_s3_bucket.image-storage-bucket.bucket
+      S3_BUCKET_NAME           = aws_s3_bucket.image-storage-bucket.bucket
+      PRESETS_ONLY_TENANTS     = ""   # Comma separated, "public" being callers without an API key; presets come from shared/presets.json unless PRESETS_FILE is set
+      URL_SIGNING_SECRET       = random_password.url_signing_secret.result
+      SIGNED_URLS_ONLY_TENANTS = ""   # Comma separated tenants whose transformations need a signed URL; set to "public" to stop anonymous callers using the service as an open proxy
+      JPEG_MIN_QUALITY         = "30" # Bounds on the quality callers may ask for
+      JPEG_MAX_QUALITY         = "95"
+      # Tenant => watermark params, e.g. { partner = { logo = "partner" } } for a logo stored at watermarks/partner
+      MANDATORY_WATERMARKS = jsonencode({})
+      METADATA_POLICIES    = jsonencode({ default = "strip-gps" })
     }
   }
 }
@@ -187,14 +264,16 @@ resource "aws_api_gateway_method" "post_images_method" {
   rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
   resource_id   = aws_api_gateway_resource.images_resource.id
   http_method   = "POST"
-  authorization = "NONE" # Change this to COGNITO_USER_POOLS
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
 }
 
 resource "aws_api_gateway_method" "get_images_method" {
   rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
   resource_id   = aws_api_gateway_resource.images_resource.id
   http_method   = "GET"
-  authorization = "NONE" # Change this to COGNITO_USER_POOLS
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
 }
 
 resource "aws_api_gateway_integration" "post_integration" {
@@ -229,6 +308,942 @@ resource "aws_api_gateway_method_response" "get_method_response" {
   status_code = "200"
 }
 
+resource "aws_iam_role" "search_image_lambda_role" {
+  name = "search_image_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "search_image_lambda_policy" {
+  name = "search_image_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:PutObject",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "search_image_iam_role_policy_attachment" {
+  role       = aws_iam_role.search_image_lambda_role.name
+  policy_arn = aws_iam_policy.search_image_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_search" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_search"
+  output_path = "${path.module}/lambdas/image_search/image_search.zip"
+}
+
+resource "aws_lambda_function" "search_image_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_search.output_path
+  function_name    = "Search-Image-Lambda"
+  role             = aws_iam_role.search_image_lambda_role.arn
+  handler          = "image_search"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.search_image_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_search.output_base64sha256
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME = aws_s3_bucket.image-storage-bucket.bucket
+    }
+  }
+}
+
+resource "aws_lambda_permission" "search_image_lambda_permissions" {
+  statement_id  = "AllowExecutionFromAPIGateway"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.search_image_lambda_func.function_name
+  principal     = "apigateway.amazonaws.com"
+
+  source_arn = "arn:aws:execute-api:eu-west-2:823841155913:${aws_api_gateway_rest_api.image_processing_api.id}/*/*${aws_api_gateway_resource.similar_images_resource.path}"
+}
+
+resource "aws_api_gateway_resource" "similar_images_resource" {
+  rest_api_id = aws_api_gateway_rest_api.image_processing_api.id
+  parent_id   = aws_api_gateway_resource.images_resource.id
+  path_part   = "similar"
+}
+
+resource "aws_api_gateway_method" "get_similar_images_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.similar_images_resource.id
+  http_method   = "GET"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_method" "post_similar_images_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.similar_images_resource.id
+  http_method   = "POST"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "get_similar_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.similar_images_resource.id
+  http_method             = aws_api_gateway_method.get_similar_images_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.search_image_lambda_func.invoke_arn
+}
+
+resource "aws_api_gateway_integration" "post_similar_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.similar_images_resource.id
+  http_method             = aws_api_gateway_method.post_similar_images_method.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.search_image_lambda_func.invoke_arn
+}
+
+resource "aws_iam_role" "archive_image_lambda_role" {
+  name = "archive_image_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "archive_image_lambda_policy" {
+  name = "archive_image_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:PutObject",
+          "s3:AbortMultipartUpload",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "archive_image_iam_role_policy_attachment" {
+  role       = aws_iam_role.archive_image_lambda_role.name
+  policy_arn = aws_iam_policy.archive_image_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_archive_image" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_archive"
+  output_path = "${path.module}/lambdas/image_archive/image_archive.zip"
+}
+
+resource "aws_lambda_function" "archive_image_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_archive_image.output_path
+  function_name    = "Archive-Image-Lambda"
+  role             = aws_iam_role.archive_image_lambda_role.arn
+  handler          = "image_archive"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.archive_image_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_archive_image.output_base64sha256
+  timeout          = 60
+  memory_size      = 1024
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME           = aws_s3_bucket.image-storage-bucket.bucket
+      ARCHIVE_INLINE_LIMIT     = "4194304"
+      MANDATORY_WATERMARKS     = aws_lambda_function.get_image_lambda_func.environment[0].variables.MANDATORY_WATERMARKS
+      PRESETS_ONLY_TENANTS     = aws_lambda_function.get_image_lambda_func.environment[0].variables.PRESETS_ONLY_TENANTS
+      SIGNED_URLS_ONLY_TENANTS = aws_lambda_function.get_image_lambda_func.environment[0].variables.SIGNED_URLS_ONLY_TENANTS
+      # Params are checked against the same quality bounds as a GET, and images keep only the metadata a GET would serve
+      JPEG_MIN_QUALITY  = aws_lambda_function.get_image_lambda_func.environment[0].variables.JPEG_MIN_QUALITY
+      JPEG_MAX_QUALITY  = aws_lambda_function.get_image_lambda_func.environment[0].variables.JPEG_MAX_QUALITY
+      METADATA_POLICIES = aws_lambda_function.get_image_lambda_func.environment[0].variables.METADATA_POLICIES
+    }
+  }
+}
+
+resource "aws_api_gateway_resource" "archive_images_resource" {
+  rest_api_id = aws_api_gateway_rest_api.image_processing_api.id
+  parent_id   = aws_api_gateway_resource.images_resource.id
+  path_part   = "archive"
+}
+
+resource "aws_lambda_permission" "archive_image_lambda_permissions" {
+  statement_id  = "AllowExecutionFromAPIGateway"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.archive_image_lambda_func.function_name
+  principal     = "apigateway.amazonaws.com"
+
+  source_arn = "arn:aws:execute-api:eu-west-2:823841155913:${aws_api_gateway_rest_api.image_processing_api.id}/*/*${aws_api_gateway_resource.archive_images_resource.path}"
+}
+
+resource "aws_api_gateway_method" "post_archive_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.archive_images_resource.id
+  http_method   = "POST"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "post_archive_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.archive_images_resource.id
+  http_method             = aws_api_gateway_method.post_archive_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.archive_image_lambda_func.invoke_arn
+}
+
+resource "aws_sqs_queue" "image_jobs_dead_letter_queue" {
+  name = "image-jobs-dlq"
+}
+
+resource "aws_sqs_queue" "image_jobs_queue" {
+  name                       = "image-jobs"
+  visibility_timeout_seconds = 360 # Six times the worker timeout, as AWS recommends
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.image_jobs_dead_letter_queue.arn
+    maxReceiveCount     = 5
+  })
+}
+
+resource "aws_iam_role" "jobs_image_lambda_role" {
+  name = "jobs_image_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "jobs_image_lambda_policy" {
+  name = "jobs_image_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:PutObject",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action   = ["sqs:SendMessage"]
+        Resource = aws_sqs_queue.image_jobs_queue.arn
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "jobs_image_iam_role_policy_attachment" {
+  role       = aws_iam_role.jobs_image_lambda_role.name
+  policy_arn = aws_iam_policy.jobs_image_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_jobs_image" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_jobs"
+  output_path = "${path.module}/lambdas/image_jobs/image_jobs.zip"
+}
+
+resource "aws_lambda_function" "jobs_image_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_jobs_image.output_path
+  function_name    = "Jobs-Image-Lambda"
+  role             = aws_iam_role.jobs_image_lambda_role.arn
+  handler          = "image_jobs"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.jobs_image_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_jobs_image.output_base64sha256
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME           = aws_s3_bucket.image-storage-bucket.bucket
+      JOB_QUEUE_URL            = aws_sqs_queue.image_jobs_queue.url
+      PRESETS_ONLY_TENANTS     = aws_lambda_function.get_image_lambda_func.environment[0].variables.PRESETS_ONLY_TENANTS
+      SIGNED_URLS_ONLY_TENANTS = aws_lambda_function.get_image_lambda_func.environment[0].variables.SIGNED_URLS_ONLY_TENANTS
+    }
+  }
+}
+
+resource "aws_api_gateway_resource" "jobs_images_resource" {
+  rest_api_id = aws_api_gateway_rest_api.image_processing_api.id
+  parent_id   = aws_api_gateway_resource.images_resource.id
+  path_part   = "jobs"
+}
+
+resource "aws_lambda_permission" "jobs_image_lambda_permissions" {
+  statement_id  = "AllowExecutionFromAPIGateway"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.jobs_image_lambda_func.function_name
+  principal     = "apigateway.amazonaws.com"
+
+  source_arn = "arn:aws:execute-api:eu-west-2:823841155913:${aws_api_gateway_rest_api.image_processing_api.id}/*/*${aws_api_gateway_resource.jobs_images_resource.path}"
+}
+
+resource "aws_api_gateway_method" "get_jobs_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.jobs_images_resource.id
+  http_method   = "GET"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "get_jobs_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.jobs_images_resource.id
+  http_method             = aws_api_gateway_method.get_jobs_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.jobs_image_lambda_func.invoke_arn
+}
+
+resource "aws_api_gateway_method" "post_jobs_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.jobs_images_resource.id
+  http_method   = "POST"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "post_jobs_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.jobs_images_resource.id
+  http_method             = aws_api_gateway_method.post_jobs_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.jobs_image_lambda_func.invoke_arn
+}
+
+resource "aws_iam_role" "job_worker_lambda_role" {
+  name = "job_worker_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "job_worker_lambda_policy" {
+  name = "job_worker_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:PutObject",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes"
+        ]
+        Resource = aws_sqs_queue.image_jobs_queue.arn
+        Effect   = "Allow"
+      },
+      {
+        Action   = ["sqs:SendMessage"]
+        Resource = aws_sqs_queue.image_webhooks_queue.arn
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "job_worker_iam_role_policy_attachment" {
+  role       = aws_iam_role.job_worker_lambda_role.name
+  policy_arn = aws_iam_policy.job_worker_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_job_worker" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_job_worker"
+  output_path = "${path.module}/lambdas/image_job_worker/image_job_worker.zip"
+}
+
+resource "aws_lambda_function" "job_worker_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_job_worker.output_path
+  function_name    = "Job-Worker-Lambda"
+  role             = aws_iam_role.job_worker_lambda_role.arn
+  handler          = "image_job_worker"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.job_worker_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_job_worker.output_base64sha256
+  timeout          = 60
+  memory_size      = 2048
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME       = aws_s3_bucket.image-storage-bucket.bucket
+      WEBHOOK_QUEUE_URL    = aws_sqs_queue.image_webhooks_queue.url
+      METADATA_POLICIES    = jsonencode({ default = "strip-gps" })
+      MANDATORY_WATERMARKS = aws_lambda_function.get_image_lambda_func.environment[0].variables.MANDATORY_WATERMARKS
+    }
+  }
+}
+
+resource "aws_lambda_event_source_mapping" "job_worker_queue_mapping" {
+  event_source_arn        = aws_sqs_queue.image_jobs_queue.arn
+  function_name           = aws_lambda_function.job_worker_lambda_func.arn
+  batch_size              = 1
+  function_response_types = ["ReportBatchItemFailures"]
+}
+
+resource "aws_sqs_queue" "image_webhooks_dead_letter_queue" {
+  name = "image-webhooks-dlq"
+}
+
+# Uploads and jobs queue webhook events here, so a slow receiver never holds up a request
+resource "aws_sqs_queue" "image_webhooks_queue" {
+  name                       = "image-webhooks"
+  visibility_timeout_seconds = 360 # Six times the worker timeout, as AWS recommends
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.image_webhooks_dead_letter_queue.arn
+    maxReceiveCount     = 5
+  })
+}
+
+resource "aws_iam_role" "webhook_worker_lambda_role" {
+  name = "webhook_worker_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "webhook_worker_lambda_policy" {
+  name = "webhook_worker_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:PutObject",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes"
+        ]
+        Resource = aws_sqs_queue.image_webhooks_queue.arn
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "webhook_worker_iam_role_policy_attachment" {
+  role       = aws_iam_role.webhook_worker_lambda_role.name
+  policy_arn = aws_iam_policy.webhook_worker_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_webhook_worker" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_webhook_worker"
+  output_path = "${path.module}/lambdas/image_webhook_worker/image_webhook_worker.zip"
+}
+
+resource "aws_lambda_function" "webhook_worker_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_webhook_worker.output_path
+  function_name    = "Webhook-Worker-Lambda"
+  role             = aws_iam_role.webhook_worker_lambda_role.arn
+  handler          = "image_webhook_worker"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.webhook_worker_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_webhook_worker.output_base64sha256
+  timeout          = 60 # Room for every attempt and backoff of a delivery to a failing receiver
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME = aws_s3_bucket.image-storage-bucket.bucket
+    }
+  }
+}
+
+resource "aws_lambda_event_source_mapping" "webhook_worker_queue_mapping" {
+  event_source_arn        = aws_sqs_queue.image_webhooks_queue.arn
+  function_name           = aws_lambda_function.webhook_worker_lambda_func.arn
+  batch_size              = 1
+  function_response_types = ["ReportBatchItemFailures"]
+}
+
+resource "aws_iam_role" "webhooks_image_lambda_role" {
+  name = "webhooks_image_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "webhooks_image_lambda_policy" {
+  name = "webhooks_image_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:PutObject",
+          "s3:DeleteObject",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "webhooks_image_iam_role_policy_attachment" {
+  role       = aws_iam_role.webhooks_image_lambda_role.name
+  policy_arn = aws_iam_policy.webhooks_image_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_webhooks_image" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_webhooks"
+  output_path = "${path.module}/lambdas/image_webhooks/image_webhooks.zip"
+}
+
+resource "aws_lambda_function" "webhooks_image_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_webhooks_image.output_path
+  function_name    = "Webhooks-Image-Lambda"
+  role             = aws_iam_role.webhooks_image_lambda_role.arn
+  handler          = "image_webhooks"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.webhooks_image_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_webhooks_image.output_base64sha256
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME = aws_s3_bucket.image-storage-bucket.bucket
+    }
+  }
+}
+
+resource "aws_api_gateway_resource" "webhooks_images_resource" {
+  rest_api_id = aws_api_gateway_rest_api.image_processing_api.id
+  parent_id   = aws_api_gateway_resource.images_resource.id
+  path_part   = "webhooks"
+}
+
+resource "aws_lambda_permission" "webhooks_image_lambda_permissions" {
+  statement_id  = "AllowExecutionFromAPIGateway"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.webhooks_image_lambda_func.function_name
+  principal     = "apigateway.amazonaws.com"
+
+  source_arn = "arn:aws:execute-api:eu-west-2:823841155913:${aws_api_gateway_rest_api.image_processing_api.id}/*/*${aws_api_gateway_resource.webhooks_images_resource.path}"
+}
+
+resource "aws_api_gateway_method" "get_webhooks_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.webhooks_images_resource.id
+  http_method   = "GET"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "get_webhooks_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.webhooks_images_resource.id
+  http_method             = aws_api_gateway_method.get_webhooks_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.webhooks_image_lambda_func.invoke_arn
+}
+
+resource "aws_api_gateway_method" "post_webhooks_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.webhooks_images_resource.id
+  http_method   = "POST"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "post_webhooks_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.webhooks_images_resource.id
+  http_method             = aws_api_gateway_method.post_webhooks_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.webhooks_image_lambda_func.invoke_arn
+}
+
+resource "aws_api_gateway_method" "delete_webhooks_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.webhooks_images_resource.id
+  http_method   = "DELETE"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "delete_webhooks_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.webhooks_images_resource.id
+  http_method             = aws_api_gateway_method.delete_webhooks_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.webhooks_image_lambda_func.invoke_arn
+}
+
+resource "aws_iam_role" "sheets_image_lambda_role" {
+  name = "sheets_image_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "sheets_image_lambda_policy" {
+  name = "sheets_image_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "s3:GetObject",
+          "s3:ListBucket"
+        ]
+        Resource = "arn:aws:s3:::*"
+        Effect   = "Allow"
+      },
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "sheets_image_iam_role_policy_attachment" {
+  role       = aws_iam_role.sheets_image_lambda_role.name
+  policy_arn = aws_iam_policy.sheets_image_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_sheets_image" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_sheets"
+  output_path = "${path.module}/lambdas/image_sheets/image_sheets.zip"
+}
+
+resource "aws_lambda_function" "sheets_image_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_sheets_image.output_path
+  function_name    = "Sheets-Image-Lambda"
+  role             = aws_iam_role.sheets_image_lambda_role.arn
+  handler          = "image_sheets"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.sheets_image_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_sheets_image.output_base64sha256
+  timeout          = 60 # Up to 200 images are fetched and scaled per sheet
+  memory_size      = 1024
+
+  environment {
+    variables = {
+      S3_BUCKET_NAME = aws_s3_bucket.image-storage-bucket.bucket
+      # Every cell of a sheet carries the mandatory watermark a single image would
+      MANDATORY_WATERMARKS     = aws_lambda_function.get_image_lambda_func.environment[0].variables.MANDATORY_WATERMARKS
+      PRESETS_ONLY_TENANTS     = aws_lambda_function.get_image_lambda_func.environment[0].variables.PRESETS_ONLY_TENANTS
+      SIGNED_URLS_ONLY_TENANTS = aws_lambda_function.get_image_lambda_func.environment[0].variables.SIGNED_URLS_ONLY_TENANTS
+    }
+  }
+}
+
+resource "aws_api_gateway_resource" "sheets_images_resource" {
+  rest_api_id = aws_api_gateway_rest_api.image_processing_api.id
+  parent_id   = aws_api_gateway_resource.images_resource.id
+  path_part   = "sheet"
+}
+
+resource "aws_lambda_permission" "sheets_image_lambda_permissions" {
+  statement_id  = "AllowExecutionFromAPIGateway"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.sheets_image_lambda_func.function_name
+  principal     = "apigateway.amazonaws.com"
+
+  source_arn = "arn:aws:execute-api:eu-west-2:823841155913:${aws_api_gateway_rest_api.image_processing_api.id}/*/*${aws_api_gateway_resource.sheets_images_resource.path}"
+}
+
+resource "aws_api_gateway_method" "post_sheets_image_method" {
+  rest_api_id   = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id   = aws_api_gateway_resource.sheets_images_resource.id
+  http_method   = "POST"
+  authorization = "CUSTOM"
+  authorizer_id = aws_api_gateway_authorizer.tenant_authorizer.id
+}
+
+resource "aws_api_gateway_integration" "post_sheets_image_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.image_processing_api.id
+  resource_id             = aws_api_gateway_resource.sheets_images_resource.id
+  http_method             = aws_api_gateway_method.post_sheets_image_method.http_method
+  integration_http_method = "POST" # To invoke a lambda method must always be POST
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.sheets_image_lambda_func.invoke_arn
+}
+
+variable "tenant_api_key_hashes" {
+  description = "Tenant => hex SHA-256 hash of the API key it sends as \"Authorization: Bearer <key>\""
+  type        = map(string)
+  default     = {}
+  sensitive   = true
+}
+
+resource "aws_iam_role" "authorizer_lambda_role" {
+  name = "authorizer_lambda_role"
+
+  assume_role_policy = <<EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+      {
+        "Action": "sts:AssumeRole",
+        "Principal": {
+          "Service": "lambda.amazonaws.com"
+        },
+        "Effect": "Allow"
+      }
+    ]
+}
+EOF
+}
+
+resource "aws_iam_policy" "authorizer_lambda_policy" {
+  name = "authorizer_lambda_policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = "arn:aws:logs:*:*:*"
+        Effect   = "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "authorizer_iam_role_policy_attachment" {
+  role       = aws_iam_role.authorizer_lambda_role.name
+  policy_arn = aws_iam_policy.authorizer_lambda_policy.arn
+}
+
+data "archive_file" "zip_the_go_bin_authorizer" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambdas/image_authorizer"
+  output_path = "${path.module}/lambdas/image_authorizer/image_authorizer.zip"
+}
+
+resource "aws_lambda_function" "authorizer_lambda_func" {
+  filename         = data.archive_file.zip_the_go_bin_authorizer.output_path
+  function_name    = "Authorizer-Lambda"
+  role             = aws_iam_role.authorizer_lambda_role.arn
+  handler          = "image_authorizer"
+  runtime          = "go1.x"
+  depends_on       = [aws_iam_role_policy_attachment.authorizer_iam_role_policy_attachment]
+  source_code_hash = data.archive_file.zip_the_go_bin_authorizer.output_base64sha256
+
+  environment {
+    variables = {
+      TENANT_API_KEYS = jsonencode(var.tenant_api_key_hashes)
+    }
+  }
+}
+
+resource "aws_lambda_permission" "authorizer_lambda_permissions" {
+  statement_id  = "AllowExecutionFromAPIGateway"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.authorizer_lambda_func.function_name
+  principal     = "apigateway.amazonaws.com"
+
+  source_arn = "arn:aws:execute-api:eu-west-2:823841155913:${aws_api_gateway_rest_api.image_processing_api.id}/authorizers/${aws_api_gateway_authorizer.tenant_authorizer.id}"
+}
+
+# Every method is behind this authorizer, which hands handlers the caller's tenant
+# in the request context. Requests without a key are let through as the "public"
+# tenant, so there is no identity source and nothing to cache on.
+resource "aws_api_gateway_authorizer" "tenant_authorizer" {
+  name                             = "tenant-authorizer"
+  rest_api_id                      = aws_api_gateway_rest_api.image_processing_api.id
+  type                             = "REQUEST"
+  authorizer_uri                   = aws_lambda_function.authorizer_lambda_func.invoke_arn
+  identity_source                  = ""
+  authorizer_result_ttl_in_seconds = 0
+}
+
 resource "aws_api_gateway_deployment" "dev_deployment" {
   rest_api_id = aws_api_gateway_rest_api.image_processing_api.id
   stage_name  = "dev"
@@ -240,6 +1255,27 @@ resource "aws_api_gateway_deployment" "dev_deployment" {
       aws_api_gateway_method.get_images_method.id,
       aws_lambda_function.post_image_lambda_func.id,
       aws_lambda_function.get_image_lambda_func.id,
+      aws_api_gateway_resource.similar_images_resource.id,
+      aws_api_gateway_method.get_similar_images_method.id,
+      aws_api_gateway_method.post_similar_images_method.id,
+      aws_lambda_function.search_image_lambda_func.id,
+      aws_api_gateway_resource.archive_images_resource.id,
+      aws_api_gateway_method.post_archive_image_method.id,
+      aws_lambda_function.archive_image_lambda_func.id,
+      aws_api_gateway_resource.jobs_images_resource.id,
+      aws_api_gateway_method.get_jobs_image_method.id,
+      aws_api_gateway_method.post_jobs_image_method.id,
+      aws_lambda_function.jobs_image_lambda_func.id,
+      aws_api_gateway_resource.webhooks_images_resource.id,
+      aws_api_gateway_method.get_webhooks_image_method.id,
+      aws_api_gateway_method.post_webhooks_image_method.id,
+      aws_api_gateway_method.delete_webhooks_image_method.id,
+      aws_lambda_function.webhooks_image_lambda_func.id,
+      aws_api_gateway_resource.sheets_images_resource.id,
+      aws_api_gateway_method.post_sheets_image_method.id,
+      aws_lambda_function.sheets_image_lambda_func.id,
+      aws_api_gateway_authorizer.tenant_authorizer.id,
+      aws_lambda_function.authorizer_lambda_func.id,
     ]))
   }
 
@@ -8,18 +8,34 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"shared"
 	"testing"
 )
 
 var api_gateway_url = "https://suez8r5h95.execute-api.eu-west-2.amazonaws.com/dev/images/"
 
+// The tenant API key to send, from IMAGE_API_KEY. Without one requests are made as the public tenant.
+var apiKey = os.Getenv("IMAGE_API_KEY")
+
+// Send a request with the API key, if there is one
+func send(request *http.Request) (*http.Response, error) {
+	if apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	return http.DefaultClient.Do(request)
+}
+
 func TestPostImageHandler(t *testing.T) {
 	testCases := []struct {
 		name             string
 		request          any
 		expectedStatus   int
 		expectedResponse string
+		// Successful uploads also report renditions and the like, depending on
+		// how the stack is configured, so only these fields are compared
+		expectedUpload *image_put_lambda.ImageResponse
 	}{
 		{
 			name: "Valid JPEG Image",
@@ -27,8 +43,12 @@ func TestPostImageHandler(t *testing.T) {
 				ImageData: shared.GenerateJPG(t),
 				ImageName: "image.jpg",
 			},
-			expectedStatus:   200,
-			expectedResponse: `{"message": "Image received, is valid, and has been uploaded to S3."}`,
+			expectedStatus: 200,
+			expectedUpload: &image_put_lambda.ImageResponse{
+				Message: "Image received, is valid, and has been uploaded to S3.",
+				Name:    "image.jpg",
+				Format:  "jpeg",
+			},
 		},
 		{
 			name: "Invalid Image Format",
@@ -37,7 +57,7 @@ func TestPostImageHandler(t *testing.T) {
 				ImageName: "invalid.png",
 			},
 			expectedStatus:   400,
-			expectedResponse: `{"message": "Invalid image"}`,
+			expectedResponse: `{"message":"Invalid image, accepted formats are jpeg, png, gif, webp, tiff, bmp","acceptedFormats":["jpeg","png","gif","webp","tiff","bmp"]}`,
 		},
 		{
 			name:             "Invalid Request Format",
@@ -53,7 +73,9 @@ func TestPostImageHandler(t *testing.T) {
 			bodyJSON, _ := json.Marshal(tc.request)
 
 			// Make a POST request to the URL
-			resp, err := http.Post(api_gateway_url, "application/json", bytes.NewBuffer(bodyJSON))
+			request, _ := http.NewRequest("POST", api_gateway_url, bytes.NewBuffer(bodyJSON))
+			request.Header.Set("Content-Type", "application/json")
+			resp, err := send(request)
 			if err != nil {
 				t.Fatalf("Failed to make the POST request: %v", err)
 			}
@@ -71,6 +93,17 @@ func TestPostImageHandler(t *testing.T) {
 				t.Fatalf("Failed to read the response body: %v", err)
 			}
 
+			if tc.expectedUpload != nil {
+				var upload image_put_lambda.ImageResponse
+				if err := json.Unmarshal(buffer.Bytes(), &upload); err != nil {
+					t.Fatalf("Failed to decode the response body: %v", err)
+				}
+				if upload.Message != tc.expectedUpload.Message || upload.Name != tc.expectedUpload.Name || upload.Format != tc.expectedUpload.Format {
+					t.Errorf("Expected upload %+v, got: %s", *tc.expectedUpload, buffer.String())
+				}
+				return
+			}
+
 			// Check the response message
 			if buffer.String() != tc.expectedResponse {
 				t.Errorf("Expected response: %s, got: %s", tc.expectedResponse, buffer.String())
@@ -80,7 +113,7 @@ func TestPostImageHandler(t *testing.T) {
 }
 
 func TestGetImageHandler(t *testing.T) {
-	rotatedResponse, _ := shared.RotateAndResize(shared.GenerateJPG(t))
+	rotatedResponse, _ := shared.RotateAndResize(shared.GenerateJPG(t), shared.EncodeOptions{})
 
 	testCases := []struct {
 		name             string
@@ -123,7 +156,8 @@ func TestGetImageHandler(t *testing.T) {
 			url.RawQuery = tc.queryParams.Encode()
 
 			// Make a GET request to the URL
-			resp, err := http.Get(url.String())
+			request, _ := http.NewRequest("GET", url.String(), nil)
+			resp, err := send(request)
 			if err != nil {
 				t.Fatalf("Failed to make the GET request: %v", err)
 			}